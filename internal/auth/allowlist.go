@@ -2,16 +2,41 @@ package auth
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 )
 
+// adminPrefix marks an allowlist line as an admin, e.g. "admin:alice@example.com".
+// Admins are allowed in like any other listed email.
+const adminPrefix = "admin:"
+
+// canonicalizePrefix marks a domain whose addresses ignore dots and anything
+// after a plus in the local part, as Gmail's do, e.g. "canonicalize:@gmail.com".
+// It allows no one by itself.
+const canonicalizePrefix = "canonicalize:"
+
+// regexPrefix marks a regular expression matched against the whole email,
+// case-insensitively, e.g. `re:s\d+@school\.edu`. An expression that doesn't
+// compile is logged and skipped, not fatal.
+const regexPrefix = "re:"
+
 // Allowlist manages email access control
 type Allowlist struct {
-	patterns []string
+	path string // The file it was loaded from, for Reload
+
+	mu        sync.RWMutex // Guards the rest, which Reload replaces
+	patterns  []string
+	regexes   []*regexp.Regexp // Compiled regexPrefix patterns
+	invalid   []string         // regexPrefix lines that didn't compile, with why
+	admins    map[string]bool  // Canonical emails listed with adminPrefix
+	canonical map[string]bool  // Lowercased domains listed with canonicalizePrefix
 }
 
 // defaultAllowlist contains the default allowed patterns if file doesn't exist
@@ -39,19 +64,24 @@ func NewAllowlist(filePath string) (*Allowlist, error) {
 	}
 
 	// Load patterns from file
-	patterns, err := loadAllowlist(filePath)
+	allowlist, err := loadAllowlist(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load allowlist: %w", err)
 	}
 
-	slog.Info("Allowlist loaded", "patterns", len(patterns), "path", filePath)
-	for _, pattern := range patterns {
-		slog.Info("  Allowed pattern", "pattern", pattern)
+	allowlist.path = filePath
+	slog.Info("Allowlist loaded", "patterns", len(allowlist.patterns), "admins", len(allowlist.admins), "path", filePath)
+	for _, pattern := range allowlist.patterns {
+		slog.Info("  Allowed pattern", "pattern", pattern, "admin", allowlist.IsAdmin(pattern))
+	}
+	for _, re := range allowlist.regexes {
+		slog.Info("  Allowed regex", "regex", re.String())
+	}
+	for domain := range allowlist.canonical {
+		slog.Info("  Ignoring dots and plus tags", "domain", domain)
 	}
 
-	return &Allowlist{
-		patterns: patterns,
-	}, nil
+	return allowlist, nil
 }
 
 // createDefaultAllowlist creates a new allowlist file with default patterns
@@ -71,15 +101,17 @@ func createDefaultAllowlist(filePath string) error {
 	return writer.Flush()
 }
 
-// loadAllowlist reads patterns from a file
-func loadAllowlist(filePath string) ([]string, error) {
+// loadAllowlist reads patterns from a file, the emails marked as admins and
+// the domains to canonicalize
+func loadAllowlist(filePath string) (*Allowlist, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	var patterns []string
+	a := &Allowlist{canonical: make(map[string]bool)}
+	var admins []string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -87,19 +119,143 @@ func loadAllowlist(filePath string) ([]string, error) {
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		patterns = append(patterns, line)
+		if domain, ok := strings.CutPrefix(line, canonicalizePrefix); ok {
+			domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+			if domain == "" || strings.Contains(domain, "@") {
+				slog.Warn("Ignoring invalid canonicalize domain", "line", line)
+			} else {
+				a.canonical[domain] = true
+			}
+			continue
+		}
+		if email, ok := strings.CutPrefix(line, adminPrefix); ok {
+			line = strings.TrimSpace(email)
+			// Admin rights go to individuals, never a whole domain or pattern
+			if strings.HasPrefix(line, "@") || strings.HasPrefix(line, regexPrefix) {
+				slog.Warn("Ignoring admin marker on domain or regex pattern", "pattern", line)
+			} else {
+				admins = append(admins, line)
+			}
+		}
+		if expr, ok := strings.CutPrefix(line, regexPrefix); ok {
+			re, err := regexp.Compile(`(?i)^(?:` + strings.TrimSpace(expr) + `)$`)
+			if err != nil {
+				slog.Warn("Ignoring invalid regex pattern", "pattern", line, "error", err)
+				a.invalid = append(a.invalid, line+": "+err.Error())
+			} else {
+				a.regexes = append(a.regexes, re)
+			}
+			continue
+		}
+		a.patterns = append(a.patterns, line)
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
 
-	return patterns, nil
+	// Canonicalize only once every canonicalize: line has been read, as
+	// they may come after the emails they apply to
+	a.admins = make(map[string]bool, len(admins))
+	for _, email := range admins {
+		a.admins[a.canonicalize(email)] = true
+	}
+	return a, nil
 }
 
-// IsAllowed checks if an email is allowed by the allowlist
-func (a *Allowlist) IsAllowed(email string) bool {
+// Reload re-reads the allowlist file and swaps in its contents at once, so
+// no check sees a mix of old and new. If the file can't be read, or lists no
+// one (as a half-written file might), the current contents are kept and the
+// error says why.
+func (a *Allowlist) Reload() error {
+	fresh, err := loadAllowlist(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to reload allowlist: %w", err)
+	}
+	if len(fresh.patterns)+len(fresh.regexes) == 0 {
+		return fmt.Errorf("failed to reload allowlist: %s lists no one", a.path)
+	}
+
+	a.mu.Lock()
+	a.patterns, a.regexes, a.invalid, a.admins, a.canonical = fresh.patterns, fresh.regexes, fresh.invalid, fresh.admins, fresh.canonical
+	a.mu.Unlock()
+	return nil
+}
+
+// Counts returns how many patterns, regexes included, and admins the
+// allowlist holds
+func (a *Allowlist) Counts() (patterns, admins int) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.patterns) + len(a.regexes), len(a.admins)
+}
+
+// Invalid returns the regex lines skipped at the last load because they
+// didn't compile, each followed by the compiler's error
+func (a *Allowlist) Invalid() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.invalid
+}
+
+// allowlistCounts is the body of POST /admin/reload-allowlist
+type allowlistCounts struct {
+	Patterns int      `json:"patterns"`
+	Admins   int      `json:"admins"`
+	Invalid  []string `json:"invalid,omitempty"` // Skipped regex lines and why
+}
+
+// HandleReload re-reads the allowlist file on an admin's request
+// (POST /admin/reload-allowlist), for hosts where editing it isn't enough to
+// notice the change, and returns the new counts
+func (a *Allowlist) HandleReload(sm *SessionManager, admins *Admins) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, ok := admins.authorize(sm, w, r)
+		if !ok {
+			return
+		}
+
+		oldPatterns, oldAdmins := a.Counts()
+		if err := a.Reload(); err != nil {
+			slog.ErrorContext(r.Context(), "Allowlist reload failed", "audit", true, "admin", admin, "patterns", oldPatterns, "admins", oldAdmins, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		patterns, newAdmins := a.Counts()
+		invalid := a.Invalid()
+		slog.InfoContext(r.Context(), "Allowlist reloaded", "audit", true, "admin", admin,
+			"old_patterns", oldPatterns, "patterns", patterns, "old_admins", oldAdmins, "admins", newAdmins, "invalid", len(invalid))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(allowlistCounts{Patterns: patterns, Admins: newAdmins, Invalid: invalid})
+	}
+}
+
+// canonicalize lowercases email and, for domains listed with
+// canonicalizePrefix, drops dots and any +tag from the local part, so
+// J.Doe+school@gmail.com becomes jdoe@gmail.com. Other domains' dots are
+// significant and are kept. Callers hold a.mu, unless a isn't shared yet.
+func (a *Allowlist) canonicalize(email string) string {
 	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 || !a.canonical[email[at+1:]] {
+		return email
+	}
+	local, _, _ := strings.Cut(email[:at], "+")
+	return strings.ReplaceAll(local, ".", "") + email[at:]
+}
+
+// IsAllowed checks if an email is allowed by the allowlist. Matching is
+// case-insensitive, and ignores dots and +tags in canonicalized domains.
+// Exact and domain patterns are tried before regexes, but any match allows.
+func (a *Allowlist) IsAllowed(email string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	email = a.canonicalize(email)
 
 	for _, pattern := range a.patterns {
 		pattern = strings.ToLower(strings.TrimSpace(pattern))
@@ -112,11 +268,25 @@ func (a *Allowlist) IsAllowed(email string) bool {
 			}
 		} else {
 			// Exact email match
-			if email == pattern {
+			if email == a.canonicalize(pattern) {
 				return true
 			}
 		}
 	}
 
+	// Regexes see the email canonicalized, like everything else
+	for _, re := range a.regexes {
+		if re.MatchString(email) {
+			return true
+		}
+	}
+
 	return false
 }
+
+// IsAdmin checks if an email is marked as an admin in the allowlist
+func (a *Allowlist) IsAdmin(email string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.admins[a.canonicalize(email)]
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAllowlist_Admins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	content := "# Staff\nadmin:Teacher@School.edu\nadmin: @school.edu\nstudent@example.com\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write allowlist: %v", err)
+	}
+	allowlist, err := NewAllowlist(path)
+	if err != nil {
+		t.Fatalf("NewAllowlist failed: %v", err)
+	}
+
+	tests := []struct {
+		email          string
+		allowed, admin bool
+	}{
+		{"teacher@school.edu", true, true},
+		{"TEACHER@school.edu", true, true},
+		{"student@example.com", true, false},
+		// A domain can be allowed but never made admin wholesale
+		{"pupil@school.edu", true, false},
+		{"stranger@example.com", false, false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.IsAllowed(tt.email); got != tt.allowed {
+			t.Errorf("IsAllowed(%q) = %v, want %v", tt.email, got, tt.allowed)
+		}
+		if got := allowlist.IsAdmin(tt.email); got != tt.admin {
+			t.Errorf("IsAdmin(%q) = %v, want %v", tt.email, got, tt.admin)
+		}
+	}
+}
+
+func TestAllowlist_Canonicalize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	content := "admin:J.Doe@gmail.com\nkid@example.com\nfirst.last@example.com\ncanonicalize:@Gmail.com\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write allowlist: %v", err)
+	}
+	allowlist, err := NewAllowlist(path)
+	if err != nil {
+		t.Fatalf("NewAllowlist failed: %v", err)
+	}
+
+	tests := []struct {
+		email          string
+		allowed, admin bool
+	}{
+		{"jdoe@gmail.com", true, true},
+		{"JDoe@GMail.com", true, true},
+		{"jdoe+school@gmail.com", true, true},
+		{"j.d.o.e@gmail.com", true, true},
+		{"J.Doe+a+b@gmail.com", true, true},
+		{"jdoe@googlemail.com", false, false},
+		{"KID@Example.com", true, false},
+		// Dots and tags still count outside canonicalized domains
+		{"firstlast@example.com", false, false},
+		{"first.last@example.com", true, false},
+		{"kid+tag@example.com", false, false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.IsAllowed(tt.email); got != tt.allowed {
+			t.Errorf("IsAllowed(%q) = %v, want %v", tt.email, got, tt.allowed)
+		}
+		if got := allowlist.IsAdmin(tt.email); got != tt.admin {
+			t.Errorf("IsAdmin(%q) = %v, want %v", tt.email, got, tt.admin)
+		}
+	}
+}
+
+func TestAllowlist_Regex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	content := strings.Join([]string{
+		`re:s\d+@school\.edu`,
+		`re:(unclosed@example\.com`,
+		`admin:re:s1@school\.edu`,
+		`teacher@school.edu`,
+		`@example.com`,
+		`re:[a-z]+\.[a-z]+@gmail\.com`,
+		`canonicalize:@gmail.com`,
+	}, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write allowlist: %v", err)
+	}
+	allowlist, err := NewAllowlist(path)
+	if err != nil {
+		t.Fatalf("NewAllowlist with a malformed regex failed: %v", err)
+	}
+
+	tests := []struct {
+		email          string
+		allowed, admin bool
+	}{
+		{"s12345@school.edu", true, false},
+		{"S12345@School.EDU", true, false},
+		// The whole email must match, not just part of it
+		{"xs12345@school.edu", false, false},
+		{"s12345@school.edu.evil.com", false, false},
+		{"sam@school.edu", false, false},
+		// Exact and domain entries still work alongside regexes
+		{"teacher@school.edu", true, false},
+		{"anyone@example.com", true, false},
+		// The malformed line is skipped, not read as an exact address
+		{"(unclosed@example.com", true, false},
+		{"unclosed@example.com", true, false},
+		// A regex can't make anyone an admin, though an admin: line still allows
+		{"s1@school.edu", true, false},
+		// Regexes match the canonicalized email, so j.doe became jdoe
+		{"j.doe@gmail.com", false, false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.IsAllowed(tt.email); got != tt.allowed {
+			t.Errorf("IsAllowed(%q) = %v, want %v", tt.email, got, tt.allowed)
+		}
+		if got := allowlist.IsAdmin(tt.email); got != tt.admin {
+			t.Errorf("IsAdmin(%q) = %v, want %v", tt.email, got, tt.admin)
+		}
+	}
+
+	if patterns, admins := allowlist.Counts(); patterns != 5 || admins != 0 {
+		t.Errorf("Counts() = %d, %d; want 5, 0", patterns, admins)
+	}
+	if invalid := allowlist.Invalid(); len(invalid) != 1 || !strings.HasPrefix(invalid[0], `re:(unclosed@example\.com: `) {
+		t.Errorf("Invalid() = %q, want the unclosed regex and its error", invalid)
+	}
+}
+
+func TestAllowlist_HandleReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	if err := os.WriteFile(path, []byte("admin:teacher@school.edu\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	allowlist, err := NewAllowlist(path)
+	if err != nil {
+		t.Fatalf("NewAllowlist failed: %v", err)
+	}
+	sm := NewSessionManager(false)
+	admins := NewAdmins(nil)
+	admins.SetAllowlist(allowlist)
+	handler := allowlist.HandleReload(sm, admins)
+
+	reload := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/reload-allowlist", nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if err := os.WriteFile(path, []byte("admin:teacher@school.edu\n@school.edu\nkid@example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := reload(signIn(t, sm, "kid@example.com")); w.Code != http.StatusForbidden {
+		t.Errorf("non-admin reload status = %d, want 403", w.Code)
+	}
+	if allowlist.IsAllowed("kid@example.com") {
+		t.Error("non-admin reload changed the allowlist")
+	}
+
+	w := reload(signIn(t, sm, "teacher@school.edu"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin reload status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if got, want := strings.TrimSpace(w.Body.String()), `{"patterns":3,"admins":1}`; got != want {
+		t.Errorf("admin reload body = %s, want %s", got, want)
+	}
+	if !allowlist.IsAllowed("kid@example.com") || !allowlist.IsAllowed("pupil@school.edu") {
+		t.Error("admin reload didn't pick up the new entries")
+	}
+
+	// A file that lists no one is refused, and the last good contents kept
+	if err := os.WriteFile(path, []byte("# Being rewritten\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := reload(signIn(t, sm, "teacher@school.edu")); w.Code != http.StatusInternalServerError {
+		t.Errorf("empty reload status = %d, want 500", w.Code)
+	}
+	if patterns, admins := allowlist.Counts(); patterns != 3 || admins != 1 {
+		t.Errorf("after a failed reload Counts() = %d, %d; want 3, 1", patterns, admins)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := allowlist.Reload(); err == nil {
+		t.Error("Reload of a missing file succeeded")
+	}
+	if !allowlist.IsAllowed("kid@example.com") {
+		t.Error("failed Reload dropped the allowlist")
+	}
+}
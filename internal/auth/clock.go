@@ -0,0 +1,27 @@
+package auth
+
+import "time"
+
+// DefaultClockSkewLeeway is how far our clock may disagree with the browser's
+// or Google's before expiry and issued-at checks start failing
+const DefaultClockSkewLeeway = 60 * time.Second
+
+// Clock returns the current time. Tests substitute a fixed clock.
+type Clock func() time.Time
+
+// skewClock does all expiry math for the auth package, tolerating a bounded
+// amount of clock skew in both directions
+type skewClock struct {
+	now    Clock
+	leeway time.Duration
+}
+
+// expired reports whether expiresAt has passed, allowing for skew
+func (c skewClock) expired(expiresAt time.Time) bool {
+	return c.now().After(expiresAt.Add(c.leeway))
+}
+
+// issuedInFuture reports whether issuedAt is later than now, allowing for skew
+func (c skewClock) issuedInFuture(issuedAt time.Time) bool {
+	return issuedAt.After(c.now().Add(c.leeway))
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// impersonationDuration is how long an impersonation session lasts.
+// It is deliberately short; start a new one if support takes longer.
+const impersonationDuration = 15 * time.Minute
+
+// adminSessionCookieName holds an admin's own session ID while their browser
+// uses an impersonation session, so HandleStopImpersonate can switch back
+const adminSessionCookieName = "trifle_admin_session"
+
+// Admins is the set of users allowed to use admin endpoints
+type Admins struct {
+	emails    map[string]bool
+	allowlist *Allowlist // Also consulted for admin: entries, if set
+}
+
+// NewAdmins creates an admin set from a list of email addresses.
+// Matching is case-insensitive.
+func NewAdmins(emails []string) *Admins {
+	admins := &Admins{emails: make(map[string]bool)}
+	for _, email := range emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" {
+			admins.emails[email] = true
+		}
+	}
+	return admins
+}
+
+// SetAllowlist makes emails marked admin: in the allowlist admins too
+func (a *Admins) SetAllowlist(allowlist *Allowlist) {
+	a.allowlist = allowlist
+}
+
+// IsAdmin reports whether email belongs to an admin
+func (a *Admins) IsAdmin(email string) bool {
+	if a.allowlist != nil && a.allowlist.IsAdmin(email) {
+		return true
+	}
+	return a.emails[strings.ToLower(email)]
+}
+
+// Configured reports whether anyone is an admin at all, through the list
+// given to NewAdmins or admin: entries in the allowlist
+func (a *Admins) Configured() bool {
+	if len(a.emails) > 0 {
+		return true
+	}
+	if a.allowlist != nil {
+		_, admins := a.allowlist.Counts()
+		return admins > 0
+	}
+	return false
+}
+
+// authorize returns the email of the admin making the request, or writes an
+// error response and returns false. An impersonation session never confers
+// its target's admin rights.
+func (a *Admins) authorize(sm *SessionManager, w http.ResponseWriter, r *http.Request) (string, bool) {
+	session, err := sm.GetSession(r)
+	if err != nil || !session.Authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if session.ImpersonatedBy != "" || !a.IsAdmin(session.Email) {
+		slog.WarnContext(r.Context(), "Non-admin attempted admin action", "audit", true, "user", session.Email, "path", r.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return strings.ToLower(session.Email), true
+}
+
+// RequireAdmin wraps a handler so only admins can reach it, as kv.RequireAuth
+// does for signed-in users. Others get 401 or 403.
+func RequireAdmin(sm *SessionManager, admins *Admins) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := admins.authorize(sm, w, r); !ok {
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// RequireAdminPage is RequireAdmin for pages that shouldn't exist without
+// admins, such as the dashboard: while none are configured, everyone gets 404.
+func RequireAdminPage(sm *SessionManager, admins *Admins) func(http.HandlerFunc) http.HandlerFunc {
+	requireAdmin := RequireAdmin(sm, admins)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		gated := requireAdmin(next)
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !admins.Configured() {
+				http.NotFound(w, r)
+				return
+			}
+			gated(w, r)
+		}
+	}
+}
+
+// StartImpersonation creates a short-lived, read-only session acting as
+// target on behalf of admin. The session is not tied to a cookie; its ID is
+// presented as an `Authorization: Bearer` token, so the admin's own session
+// is unaffected.
+func (sm *SessionManager) StartImpersonation(admin, target string) (*Session, error) {
+	sessionID, err := generateRandomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	now := sm.clock.now()
+	session := &Session{
+		ID:             sessionID,
+		Email:          target,
+		Authenticated:  true,
+		ImpersonatedBy: admin,
+		CreatedAt:      now,
+		LastAccessed:   now,
+		ExpiresAt:      now.Add(impersonationDuration),
+	}
+
+	sm.mu.Lock()
+	sm.addLocked(session)
+	sm.mu.Unlock()
+
+	return session, nil
+}
+
+// EndImpersonation revokes an impersonation session, returning it if it existed
+func (sm *SessionManager) EndImpersonation(token string) (*Session, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, exists := sm.sessions[token]
+	if !exists || session.ImpersonatedBy == "" {
+		return nil, false
+	}
+	sm.removeLocked(token)
+	return session, true
+}
+
+// GetAPISession returns the impersonation session named by an
+// `Authorization: Bearer` token if there is one, and otherwise the
+// cookie session, as GetSession does
+func (sm *SessionManager) GetAPISession(r *http.Request) (*Session, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return sm.GetSession(r)
+	}
+
+	sm.mu.RLock()
+	session, exists := sm.sessions[token]
+	sm.mu.RUnlock()
+
+	// Only impersonation sessions may be used as bearer tokens
+	if !exists || session.ImpersonatedBy == "" {
+		return nil, fmt.Errorf("session not found")
+	}
+
+	if sm.clock.expired(session.ExpiresAt) {
+		sm.mu.Lock()
+		sm.removeLocked(token)
+		sm.mu.Unlock()
+		return nil, fmt.Errorf("session expired")
+	}
+
+	sm.mu.Lock()
+	session.LastAccessed = sm.clock.now()
+	sm.mu.Unlock()
+
+	return session, nil
+}
+
+// bearerToken extracts the token from an `Authorization: Bearer` header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return strings.TrimSpace(token), ok && strings.TrimSpace(token) != ""
+}
+
+// ImpersonationHandler serves /admin/impersonate
+type ImpersonationHandler struct {
+	SessionMgr *SessionManager
+	Admins     *Admins
+}
+
+// ImpersonationResponse describes a newly started impersonation session
+type ImpersonationResponse struct {
+	Token     string    `json:"token"` // Send as Authorization: Bearer <token>
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HandleImpersonate starts (POST {"email": ...}) or revokes (DELETE with the
+// token as a bearer token) a read-only impersonation session. Only admins
+// signed in with their own session may use it, and every use is audited.
+//
+// With "browser": true in the POST, the admin's browser switches to the
+// impersonation session too, so they can use the site as the user until
+// POST /admin/stop-impersonate switches it back.
+func (h *ImpersonationHandler) HandleImpersonate(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.Admins.authorize(h.SessionMgr, w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Email   string `json:"email"`
+			Browser bool   `json:"browser"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		target := strings.ToLower(strings.TrimSpace(req.Email))
+		if !strings.Contains(target, "@") {
+			http.Error(w, "A target email is required", http.StatusBadRequest)
+			return
+		}
+
+		impersonation, err := h.SessionMgr.StartImpersonation(admin, target)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to start impersonation", "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "Impersonation started", "audit", true, "admin", admin, "user", target, "expires_at", impersonation.ExpiresAt, "browser", req.Browser)
+
+		if req.Browser {
+			// authorize has already checked this is the admin's own session
+			own, _ := r.Cookie(sessionCookieName)
+			h.SessionMgr.setNamedCookie(w, adminSessionCookieName, own.Value)
+			h.SessionMgr.setCookie(w, impersonation.ID)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ImpersonationResponse{
+			Token:     impersonation.ID,
+			Email:     target,
+			ExpiresAt: impersonation.ExpiresAt,
+		})
+
+	case http.MethodDelete:
+		token, ok := bearerToken(r)
+		if !ok {
+			http.Error(w, "Bearer token required", http.StatusBadRequest)
+			return
+		}
+		impersonation, ok := h.SessionMgr.EndImpersonation(token)
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "Impersonation revoked", "audit", true, "admin", admin, "started_by", impersonation.ImpersonatedBy, "user", impersonation.Email)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleStopImpersonate ends the impersonation session a browser switched to
+// with "browser": true and switches it back to the admin's own session
+// (POST /admin/stop-impersonate). It works after the impersonation has
+// expired too.
+func (h *ImpersonationHandler) HandleStopImpersonate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sm := h.SessionMgr
+	own, err := r.Cookie(adminSessionCookieName)
+	if err != nil {
+		http.Error(w, "Not impersonating", http.StatusBadRequest)
+		return
+	}
+
+	sm.mu.RLock()
+	adminSession, exists := sm.sessions[own.Value]
+	sm.mu.RUnlock()
+	if !exists || !adminSession.Authenticated || adminSession.ImpersonatedBy != "" || sm.clock.expired(adminSession.ExpiresAt) || !h.Admins.IsAdmin(adminSession.Email) {
+		// The admin's own session has gone, so there's nothing to go back to
+		sm.clearNamedCookie(w, adminSessionCookieName)
+		sm.Destroy(w, r)
+		http.Error(w, "Admin session expired, sign in again", http.StatusUnauthorized)
+		return
+	}
+	admin := strings.ToLower(adminSession.Email)
+
+	if current, err := r.Cookie(sessionCookieName); err == nil {
+		sm.mu.Lock()
+		impersonation, ok := sm.sessions[current.Value]
+		ok = ok && strings.EqualFold(impersonation.ImpersonatedBy, admin)
+		if ok {
+			sm.removeLocked(current.Value)
+		}
+		sm.mu.Unlock()
+		if ok {
+			slog.InfoContext(r.Context(), "Impersonation stopped", "audit", true, "admin", admin, "user", impersonation.Email)
+		}
+	}
+
+	sm.clearNamedCookie(w, adminSessionCookieName)
+	sm.setCookie(w, adminSession.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forbidImpersonated refuses an action a support session must never take on
+// its user's behalf, such as revoking their sessions, writing a 403 and
+// returning true if session is an impersonation
+func forbidImpersonated(w http.ResponseWriter, r *http.Request, session *Session) bool {
+	if session.ImpersonatedBy == "" {
+		return false
+	}
+	slog.WarnContext(r.Context(), "Impersonated action blocked", "audit", true, "admin", session.ImpersonatedBy, "user", session.Email, "method", r.Method, "path", r.URL.Path)
+	http.Error(w, "Not allowed while impersonating", http.StatusForbidden)
+	return true
+}
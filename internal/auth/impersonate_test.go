@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signIn creates an authenticated cookie session for email
+func signIn(t *testing.T, sm *SessionManager, email string) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	session, err := sm.GetOrCreateSession(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	if err != nil {
+		t.Fatalf("GetOrCreateSession failed: %v", err)
+	}
+	session.Email = email
+	session.Authenticated = true
+	sm.Save(rec, session) // As HandleCallback does
+	return &http.Cookie{Name: sessionCookieName, Value: session.ID}
+}
+
+func TestHandleImpersonate(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	sm := NewSessionManager(false)
+	sm.SetClock(clock.now)
+	handler := &ImpersonationHandler{SessionMgr: sm, Admins: NewAdmins([]string{" Admin@Example.com "})}
+
+	start := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/impersonate", strings.NewReader(`{"email":"Alice@Example.com"}`))
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		handler.HandleImpersonate(w, req)
+		return w
+	}
+
+	// Non-admins are refused
+	if w := start(signIn(t, sm, "bob@example.com")); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for non-admin, got %d", w.Code)
+	}
+
+	// Admins get a token for a read-only session as the target
+	w := start(signIn(t, sm, "admin@example.com"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp ImpersonationResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Email != "alice@example.com" || resp.Token == "" {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+
+	bearer := httptest.NewRequest(http.MethodGet, "/kv/", nil)
+	bearer.Header.Set("Authorization", "Bearer "+resp.Token)
+	session, err := sm.GetAPISession(bearer)
+	if err != nil {
+		t.Fatalf("Expected bearer session, got %v", err)
+	}
+	if session.Email != "alice@example.com" || session.ImpersonatedBy != "admin@example.com" {
+		t.Errorf("Unexpected session: %+v", session)
+	}
+
+	// The impersonation can't be used to reach admin endpoints as the target
+	impersonated := httptest.NewRequest(http.MethodPost, "/admin/impersonate", strings.NewReader(`{"email":"carol@example.com"}`))
+	impersonated.AddCookie(&http.Cookie{Name: sessionCookieName, Value: resp.Token})
+	w = httptest.NewRecorder()
+	handler.HandleImpersonate(w, impersonated)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 when using an impersonation session, got %d", w.Code)
+	}
+
+	// Ordinary session IDs can't be used as bearer tokens
+	plain := httptest.NewRequest(http.MethodGet, "/kv/", nil)
+	plain.Header.Set("Authorization", "Bearer "+signIn(t, sm, "bob@example.com").Value)
+	if _, err := sm.GetAPISession(plain); err == nil {
+		t.Error("Expected ordinary session to be rejected as a bearer token")
+	}
+
+	// Revocation ends it immediately
+	revoke := httptest.NewRequest(http.MethodDelete, "/admin/impersonate", nil)
+	revoke.AddCookie(signIn(t, sm, "admin@example.com"))
+	revoke.Header.Set("Authorization", "Bearer "+resp.Token)
+	w = httptest.NewRecorder()
+	handler.HandleImpersonate(w, revoke)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 on revoke, got %d", w.Code)
+	}
+	if _, err := sm.GetAPISession(bearer); err == nil {
+		t.Error("Expected revoked session to be gone")
+	}
+}
+
+func TestImpersonation_Expires(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	sm := NewSessionManager(false)
+	sm.SetClock(clock.now)
+
+	session, err := sm.StartImpersonation("admin@example.com", "alice@example.com")
+	if err != nil {
+		t.Fatalf("StartImpersonation failed: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/kv/", nil)
+	req.Header.Set("Authorization", "Bearer "+session.ID)
+
+	clock.t = clock.t.Add(impersonationDuration - time.Minute)
+	if _, err := sm.GetAPISession(req); err != nil {
+		t.Fatalf("Expected session to be valid before expiry, got %v", err)
+	}
+
+	clock.t = clock.t.Add(time.Minute + DefaultClockSkewLeeway + time.Second)
+	if _, err := sm.GetAPISession(req); err == nil {
+		t.Error("Expected impersonation to expire")
+	}
+}
+
+func TestStopImpersonate(t *testing.T) {
+	sm := NewSessionManager(false)
+	handler := &ImpersonationHandler{SessionMgr: sm, Admins: NewAdmins([]string{"admin@example.com"})}
+	adminCookie := signIn(t, sm, "admin@example.com")
+	signIn(t, sm, "alice@example.com")
+
+	// Switch the browser to an impersonation of alice
+	req := httptest.NewRequest(http.MethodPost, "/admin/impersonate", strings.NewReader(`{"email":"alice@example.com","browser":true}`))
+	req.AddCookie(adminCookie)
+	w := httptest.NewRecorder()
+	handler.HandleImpersonate(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	cookies := map[string]string{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	if cookies[adminSessionCookieName] != adminCookie.Value {
+		t.Fatalf("Expected admin session kept in %s, got %q", adminSessionCookieName, cookies[adminSessionCookieName])
+	}
+	impersonationCookie := &http.Cookie{Name: sessionCookieName, Value: cookies[sessionCookieName]}
+	savedCookie := &http.Cookie{Name: adminSessionCookieName, Value: adminCookie.Value}
+
+	// The browser is now alice, but can't revoke her sessions
+	revoke := httptest.NewRequest(http.MethodDelete, "/auth/sessions/x", nil)
+	revoke.AddCookie(impersonationCookie)
+	w = httptest.NewRecorder()
+	HandleSessions(sm)(w, revoke)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 revoking sessions while impersonating, got %d", w.Code)
+	}
+	if got := len(sm.UserSessions("alice@example.com")); got != 1 {
+		t.Errorf("Expected alice's session untouched, got %d sessions", got)
+	}
+
+	// Without the admin's session cookie there's nothing to go back to
+	req = httptest.NewRequest(http.MethodPost, "/admin/stop-impersonate", nil)
+	req.AddCookie(impersonationCookie)
+	w = httptest.NewRecorder()
+	handler.HandleStopImpersonate(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without %s, got %d", adminSessionCookieName, w.Code)
+	}
+
+	// Stopping ends the impersonation and restores the admin's session
+	req = httptest.NewRequest(http.MethodPost, "/admin/stop-impersonate", nil)
+	req.AddCookie(impersonationCookie)
+	req.AddCookie(savedCookie)
+	w = httptest.NewRecorder()
+	handler.HandleStopImpersonate(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	cookies = map[string]string{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	if cookies[sessionCookieName] != adminCookie.Value || cookies[adminSessionCookieName] != "" {
+		t.Errorf("Expected admin session restored and saved cookie cleared, got %v", cookies)
+	}
+	check := httptest.NewRequest(http.MethodGet, "/", nil)
+	check.AddCookie(impersonationCookie)
+	if _, err := sm.GetSession(check); err == nil {
+		t.Error("Expected impersonation session to be gone")
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	if err := os.WriteFile(path, []byte("admin:teacher@school.edu\nstudent@school.edu\n"), 0644); err != nil {
+		t.Fatalf("Failed to write allowlist: %v", err)
+	}
+	allowlist, err := NewAllowlist(path)
+	if err != nil {
+		t.Fatalf("NewAllowlist failed: %v", err)
+	}
+	admins := NewAdmins([]string{"ops@example.com"})
+	admins.SetAllowlist(allowlist)
+
+	sm := NewSessionManager(false)
+	handler := RequireAdmin(sm, admins)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	serve := func(cookie *http.Cookie) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	if code := serve(nil); code != http.StatusUnauthorized {
+		t.Errorf("Signed out status = %d, want 401", code)
+	}
+	if code := serve(signIn(t, sm, "student@school.edu")); code != http.StatusForbidden {
+		t.Errorf("Allowlisted non-admin status = %d, want 403", code)
+	}
+	if code := serve(signIn(t, sm, "teacher@school.edu")); code != http.StatusNoContent {
+		t.Errorf("Allowlist admin status = %d, want 204", code)
+	}
+	if code := serve(signIn(t, sm, "ops@example.com")); code != http.StatusNoContent {
+		t.Errorf("ADMIN_EMAILS admin status = %d, want 204", code)
+	}
+}
+
+func TestRequireAdminPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	if err := os.WriteFile(path, []byte("student@school.edu\n"), 0644); err != nil {
+		t.Fatalf("Failed to write allowlist: %v", err)
+	}
+	allowlist, err := NewAllowlist(path)
+	if err != nil {
+		t.Fatalf("NewAllowlist failed: %v", err)
+	}
+	admins := NewAdmins(nil)
+	admins.SetAllowlist(allowlist)
+
+	sm := NewSessionManager(false)
+	handler := RequireAdminPage(sm, admins)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	serve := func(cookie *http.Cookie) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	// With no admins the page isn't there, even for signed-in users
+	if admins.Configured() {
+		t.Fatal("Configured() with no admins = true")
+	}
+	if code := serve(nil); code != http.StatusNotFound {
+		t.Errorf("Signed out status with no admins = %d, want 404", code)
+	}
+	if code := serve(signIn(t, sm, "student@school.edu")); code != http.StatusNotFound {
+		t.Errorf("Signed in status with no admins = %d, want 404", code)
+	}
+
+	// Once the allowlist names one, it's gated like any admin route
+	if err := os.WriteFile(path, []byte("admin:teacher@school.edu\nstudent@school.edu\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := allowlist.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if code := serve(nil); code != http.StatusUnauthorized {
+		t.Errorf("Signed out status = %d, want 401", code)
+	}
+	if code := serve(signIn(t, sm, "student@school.edu")); code != http.StatusForbidden {
+		t.Errorf("Non-admin status = %d, want 403", code)
+	}
+	if code := serve(signIn(t, sm, "teacher@school.edu")); code != http.StatusNoContent {
+		t.Errorf("Admin status = %d, want 204", code)
+	}
+}
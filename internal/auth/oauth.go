@@ -9,17 +9,49 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"unicode"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
+// DefaultOAuthScopes are requested when NewOAuthConfig is given none: enough
+// to learn the user's email address and name
+var DefaultOAuthScopes = []string{
+	"https://www.googleapis.com/auth/userinfo.email",
+	"https://www.googleapis.com/auth/userinfo.profile",
+}
+
+// OfflineAccessScope asks for a refresh token, so the server can keep the
+// user's access token fresh for as long as their session lasts. Google
+// doesn't accept it as a scope, so it's sent as access_type=offline instead.
+const OfflineAccessScope = "offline_access"
+
 // OAuthConfig holds OAuth configuration
 type OAuthConfig struct {
-	Config      *oauth2.Config
 	SessionMgr  *SessionManager
 	RedirectURL string
 	Allowlist   *Allowlist
+	TOTP        *TOTPStore // Nil if two-factor authentication is disabled
+
+	// LogoutRedirect is where HandleLogout sends users who don't ask for
+	// somewhere else with ?redirect=. It must pass SafeRedirectPath; empty
+	// means "/".
+	LogoutRedirect string
+
+	// CredentialSource supplies the client ID and secret on Reload.
+	// NewOAuthConfig sets it to GetOAuthCredentials.
+	CredentialSource func() (clientID, clientSecret string, err error)
+
+	scopes  []string // Without OfflineAccessScope
+	offline bool     // Whether OfflineAccessScope was requested
+
+	mu       sync.RWMutex
+	current  *oauth2.Config
+	previous *oauth2.Config // Kept so logins started before a Reload can finish
+	endpoint oauth2.Endpoint
 }
 
 // GoogleUser represents user info from Google
@@ -31,23 +63,86 @@ type GoogleUser struct {
 	Picture       string `json:"picture"`
 }
 
-// NewOAuthConfig creates a new OAuth configuration
-func NewOAuthConfig(clientID, clientSecret, redirectURL string, sessMgr *SessionManager, allowlist *Allowlist) *OAuthConfig {
-	return &OAuthConfig{
-		Config: &oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			RedirectURL:  redirectURL,
-			Scopes: []string{
-				"https://www.googleapis.com/auth/userinfo.email",
-				"https://www.googleapis.com/auth/userinfo.profile",
-			},
-			Endpoint: google.Endpoint,
-		},
-		SessionMgr:  sessMgr,
-		RedirectURL: redirectURL,
-		Allowlist:   allowlist,
+// NewOAuthConfig creates a new OAuth configuration requesting scopes, or
+// DefaultOAuthScopes if there are none. Include OfflineAccessScope to keep
+// refresh tokens on sessions; see RefreshTokens.
+func NewOAuthConfig(clientID, clientSecret, redirectURL string, sessMgr *SessionManager, allowlist *Allowlist, scopes []string) *OAuthConfig {
+	oc := &OAuthConfig{
+		SessionMgr:       sessMgr,
+		RedirectURL:      redirectURL,
+		Allowlist:        allowlist,
+		CredentialSource: GetOAuthCredentials,
+		endpoint:         google.Endpoint,
+	}
+	for _, scope := range scopes {
+		if scope == OfflineAccessScope {
+			oc.offline = true
+		} else {
+			oc.scopes = append(oc.scopes, scope)
+		}
+	}
+	if len(oc.scopes) == 0 {
+		oc.scopes = DefaultOAuthScopes
+	}
+	oc.current = oc.newConfig(clientID, clientSecret)
+	return oc
+}
+
+func (oc *OAuthConfig) newConfig(clientID, clientSecret string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  oc.RedirectURL,
+		Scopes:       oc.scopes,
+		Endpoint:     oc.endpoint,
+	}
+}
+
+// Offline reports whether logins ask for refresh tokens
+func (oc *OAuthConfig) Offline() bool {
+	return oc.offline
+}
+
+// Config returns the OAuth client configuration new logins use
+func (oc *OAuthConfig) Config() *oauth2.Config {
+	oc.mu.RLock()
+	defer oc.mu.RUnlock()
+	return oc.current
+}
+
+// configFor returns the configuration a login started with, identified by
+// its client ID, so a login in flight across a Reload completes with the
+// credentials Google issued its code for
+func (oc *OAuthConfig) configFor(clientID string) *oauth2.Config {
+	oc.mu.RLock()
+	defer oc.mu.RUnlock()
+	if oc.previous != nil && clientID == oc.previous.ClientID && clientID != oc.current.ClientID {
+		return oc.previous
 	}
+	return oc.current
+}
+
+// Reload fetches credentials from CredentialSource and swaps them in for new
+// logins, allowing the client secret (and ID) to be rotated without a
+// restart. Logins already in progress keep using the credentials they
+// started with.
+func (oc *OAuthConfig) Reload() error {
+	clientID, clientSecret, err := oc.CredentialSource()
+	if err != nil {
+		return fmt.Errorf("failed to reload OAuth credentials: %w", err)
+	}
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if oc.current.ClientID == clientID && oc.current.ClientSecret == clientSecret {
+		slog.Info("OAuth credentials unchanged on reload")
+		return nil
+	}
+	idChanged := oc.current.ClientID != clientID
+	oc.previous = oc.current
+	oc.current = oc.newConfig(clientID, clientSecret)
+	slog.Info("OAuth credentials reloaded", "client_id_changed", idChanged)
+	return nil
 }
 
 // HandleLogin redirects the user to Google's OAuth consent page
@@ -65,14 +160,25 @@ func (oc *OAuthConfig) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
+	if forbidImpersonated(w, r, session) {
+		return
+	}
+	config := oc.Config()
 	session.OAuthState = state
+	session.OAuthClientID = config.ClientID
 	if err := oc.SessionMgr.Save(w, session); err != nil {
 		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
-	// Redirect to Google's consent page
-	url := oc.Config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	// Redirect to Google's consent page. Google only issues a refresh token
+	// when the user is shown the consent screen, so force it for offline
+	// access; otherwise a user who has signed in before would get none.
+	var opts []oauth2.AuthCodeOption
+	if oc.offline {
+		opts = append(opts, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+	}
+	url := config.AuthCodeURL(state, opts...)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
@@ -87,7 +193,7 @@ func (oc *OAuthConfig) HandleCallback(w http.ResponseWriter, r *http.Request) {
 
 	// Check for error from Google
 	if errMsg := r.URL.Query().Get("error"); errMsg != "" {
-		slog.Error("OAuth error from Google", "error", errMsg)
+		slog.ErrorContext(ctx, "OAuth error from Google", "error", errMsg)
 		redirectWithError("OAuth login failed. Please try again.")
 		return
 	}
@@ -95,7 +201,7 @@ func (oc *OAuthConfig) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	// Get the session to verify state
 	session, err := oc.SessionMgr.GetSession(r)
 	if err != nil || session == nil {
-		slog.Warn("Invalid session in callback", "error", err)
+		slog.WarnContext(ctx, "Invalid session in callback", "error", err)
 		redirectWithError("Invalid session. Please try logging in again.")
 		return
 	}
@@ -103,7 +209,7 @@ func (oc *OAuthConfig) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	// Verify state token (CSRF protection)
 	state := r.URL.Query().Get("state")
 	if state == "" || state != session.OAuthState {
-		slog.Warn("State mismatch", "got", state, "expected", session.OAuthState)
+		slog.WarnContext(ctx, "State mismatch", "got", state, "expected", session.OAuthState)
 		redirectWithError("Security check failed. Please try logging in again.")
 		return
 	}
@@ -111,64 +217,104 @@ func (oc *OAuthConfig) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	// Exchange code for token
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		slog.Warn("No code in callback")
+		slog.WarnContext(ctx, "No code in callback")
 		redirectWithError("No authorization code received. Please try again.")
 		return
 	}
 
-	token, err := oc.Config.Exchange(ctx, code)
+	config := oc.configFor(session.OAuthClientID)
+	token, err := config.Exchange(ctx, code)
 	if err != nil {
-		slog.Error("Failed to exchange token", "error", err)
+		slog.ErrorContext(ctx, "Failed to exchange token", "error", err)
 		redirectWithError("Failed to complete login. Please try again.")
 		return
 	}
 
+	// Sanity-check the ID token's validity window, tolerating minor clock skew
+	if rawIDToken, ok := token.Extra("id_token").(string); ok && rawIDToken != "" {
+		claims, err := parseIDTokenClaims(rawIDToken)
+		if err == nil {
+			err = oc.SessionMgr.clock.checkIDTokenTimes(claims)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid ID token", "error", err)
+			redirectWithError("Failed to complete login. Please check your computer's clock and try again.")
+			return
+		}
+	}
+
 	// Get user info from Google
-	userInfo, err := oc.getUserInfo(ctx, token)
+	userInfo, err := oc.getUserInfo(ctx, config, token)
 	if err != nil {
-		slog.Error("Failed to get user info", "error", err)
+		slog.ErrorContext(ctx, "Failed to get user info", "error", err)
 		redirectWithError("Failed to get user information. Please try again.")
 		return
 	}
 
-	slog.Info("User attempting to log in", "email", userInfo.Email, "name", userInfo.Name)
+	slog.InfoContext(ctx, "User attempting to log in", "email", userInfo.Email, "name", userInfo.Name)
 
 	// Check if email is verified
 	if !userInfo.VerifiedEmail {
-		slog.Warn("Email not verified", "email", userInfo.Email)
+		slog.WarnContext(ctx, "Email not verified", "email", userInfo.Email)
 		redirectWithError("Email not verified with Google. Please verify your email.")
 		return
 	}
 
 	// Check if email is in allowlist
 	if !oc.Allowlist.IsAllowed(userInfo.Email) {
-		slog.Warn("Email not in allowlist", "email", userInfo.Email)
+		slog.WarnContext(ctx, "Email not in allowlist", "email", userInfo.Email)
 		redirectWithError("Your email (" + userInfo.Email + ") is not authorized for sync. The site works fine without logging in! Contact zellyn@gmail.com if you need sync access.")
 		return
 	}
 
-	slog.Info("Login successful", "email", userInfo.Email)
+	// Users with TOTP enabled aren't signed in until they enter a code
+	twoFactor := oc.TOTP != nil && oc.TOTP.Enrolled(userInfo.Email)
+	if twoFactor {
+		slog.InfoContext(ctx, "Login awaiting TOTP code", "email", userInfo.Email)
+	} else {
+		slog.InfoContext(ctx, "Login successful", "email", userInfo.Email)
+	}
 
 	// Update session with user info
 	// Note: We no longer use separate user IDs - the email IS the user identifier
 	session.UserID = "" // Deprecated, keeping for compatibility
 	session.Email = userInfo.Email
-	session.Authenticated = true
+	session.Authenticated = !twoFactor
+	session.TwoFactorPending = twoFactor
+	session.TwoFactorVerified = false
 	session.OAuthState = "" // Clear the state token
+	session.CreatedAt = oc.SessionMgr.clock.now()
+	session.UserAgent = r.UserAgent()
+	session.IPAddress = remoteIP(r)
+	session.OAuthToken = nil
+	if oc.offline {
+		// Providers may decline offline access; the session then works as
+		// usual but nothing is refreshed
+		if token.RefreshToken != "" {
+			session.OAuthToken = token
+		} else {
+			slog.InfoContext(ctx, "No refresh token issued", "email", userInfo.Email)
+		}
+	}
 
 	if err := oc.SessionMgr.Save(w, session); err != nil {
-		slog.Error("Failed to save session", "error", err)
+		slog.ErrorContext(ctx, "Failed to save session", "error", err)
 		redirectWithError("Failed to save login session. Please try again.")
 		return
 	}
 
+	if twoFactor {
+		http.Redirect(w, r, "/profile.html?totp=required", http.StatusSeeOther)
+		return
+	}
+
 	// Redirect to profile page with logged_in flag to trigger auto-sync
 	http.Redirect(w, r, "/profile.html?logged_in=true", http.StatusSeeOther)
 }
 
 // getUserInfo fetches user information from Google
-func (oc *OAuthConfig) getUserInfo(ctx context.Context, token *oauth2.Token) (*GoogleUser, error) {
-	client := oc.Config.Client(ctx, token)
+func (oc *OAuthConfig) getUserInfo(ctx context.Context, config *oauth2.Config, token *oauth2.Token) (*GoogleUser, error) {
+	client := config.Client(ctx, token)
 	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user info: %w", err)
@@ -188,19 +334,73 @@ func (oc *OAuthConfig) getUserInfo(ctx context.Context, token *oauth2.Token) (*G
 	return &userInfo, nil
 }
 
-// HandleLogout logs the user out
+// HandleReload reloads the OAuth credentials (POST, admins only), as SIGHUP does
+func (oc *OAuthConfig) HandleReload(admins *Admins) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, ok := admins.authorize(oc.SessionMgr, w, r)
+		if !ok {
+			return
+		}
+
+		if err := oc.Reload(); err != nil {
+			slog.ErrorContext(r.Context(), "OAuth credential reload failed", "audit", true, "admin", admin, "error", err)
+			http.Error(w, "Failed to reload credentials", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "OAuth credentials reload requested", "audit", true, "admin", admin)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleLogout logs the user out, then redirects to the ?redirect= path if
+// it is safe, or LogoutRedirect otherwise
 func (oc *OAuthConfig) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	// Clear the session
 	oc.SessionMgr.Destroy(w, r)
 
-	// Redirect to home page
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	target := oc.LogoutRedirect
+	if target == "" {
+		target = "/"
+	}
+	if requested := r.URL.Query().Get("redirect"); requested != "" {
+		if SafeRedirectPath(requested) {
+			target = requested
+		} else {
+			slog.WarnContext(r.Context(), "Ignoring unsafe logout redirect", "redirect", requested)
+		}
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// SafeRedirectPath reports whether target is a path on this site, so
+// redirecting to it can't send users to another host. Scheme-relative URLs
+// like //evil.example are rejected, as are backslashes and control
+// characters, which some browsers treat as slashes or strip.
+func SafeRedirectPath(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		return false
+	}
+	if strings.ContainsFunc(target, func(r rune) bool { return r == '\\' || unicode.IsControl(r) }) {
+		return false
+	}
+	u, err := url.Parse(target)
+	return err == nil && u.Scheme == "" && u.Host == ""
 }
 
-// GetOAuthCredentials retrieves OAuth credentials from environment
+// GetOAuthCredentials retrieves OAuth credentials from the environment.
+// GOOGLE_CLIENT_ID_FILE and GOOGLE_CLIENT_SECRET_FILE name files to read them
+// from instead, so a rotated secret can be picked up by Reload.
 func GetOAuthCredentials() (clientID, clientSecret string, err error) {
-	clientID = os.Getenv("GOOGLE_CLIENT_ID")
-	clientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
+	if clientID, err = credential("GOOGLE_CLIENT_ID"); err != nil {
+		return "", "", err
+	}
+	if clientSecret, err = credential("GOOGLE_CLIENT_SECRET"); err != nil {
+		return "", "", err
+	}
 
 	if clientID == "" {
 		return "", "", fmt.Errorf("GOOGLE_CLIENT_ID not set")
@@ -211,3 +411,17 @@ func GetOAuthCredentials() (clientID, clientSecret string, err error) {
 
 	return clientID, clientSecret, nil
 }
+
+// credential returns the environment variable name, or the trimmed contents
+// of the file named by name_FILE if that is set
+func credential(name string) (string, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return os.Getenv(name), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenServer is a fake OAuth token endpoint that records the client
+// credentials each exchange used
+func tokenServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	var secrets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			r.ParseForm()
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		secrets = append(secrets, id+":"+secret)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &secrets
+}
+
+// startLogin runs HandleLogin and returns the session cookie and the client ID
+// Google was sent
+func startLogin(t *testing.T, oc *OAuthConfig) (*http.Cookie, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	oc.HandleLogin(w, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("bad redirect: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("login set no session cookie")
+	}
+	return cookies[0], location.Query().Get("client_id")
+}
+
+func TestOAuthConfig_ReloadSwapsCredentials(t *testing.T) {
+	server, exchanges := tokenServer(t)
+	sm := NewSessionManager(false)
+	oc := NewOAuthConfig("old-id", "old-secret", "http://localhost/auth/callback", sm, nil, nil)
+	oc.endpoint = oauth2.Endpoint{AuthURL: server.URL + "/auth", TokenURL: server.URL + "/token"}
+	oc.current = oc.newConfig("old-id", "old-secret")
+
+	// A login starts before the secret is rotated
+	inFlight, clientID := startLogin(t, oc)
+	if clientID != "old-id" {
+		t.Fatalf("login sent client_id %q, want old-id", clientID)
+	}
+
+	oc.CredentialSource = func() (string, string, error) { return "new-id", "new-secret", nil }
+	if err := oc.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got := oc.Config().ClientSecret; got != "new-secret" {
+		t.Errorf("ClientSecret after reload = %q, want new-secret", got)
+	}
+
+	// New logins use the new credentials
+	fresh, clientID := startLogin(t, oc)
+	if clientID != "new-id" {
+		t.Errorf("login after reload sent client_id %q, want new-id", clientID)
+	}
+
+	exchange := func(cookie *http.Cookie) {
+		req := httptest.NewRequest(http.MethodGet, "/auth/callback", nil)
+		req.AddCookie(cookie)
+		session, err := sm.GetSession(req)
+		if err != nil {
+			t.Fatalf("GetSession failed: %v", err)
+		}
+		if _, err := oc.configFor(session.OAuthClientID).Exchange(context.Background(), "code"); err != nil {
+			t.Fatalf("Exchange failed: %v", err)
+		}
+	}
+	exchange(fresh)
+	exchange(inFlight)
+
+	want := []string{"new-id:new-secret", "old-id:old-secret"}
+	if len(*exchanges) != len(want) {
+		t.Fatalf("exchanges = %v, want %v", *exchanges, want)
+	}
+	for i := range want {
+		if (*exchanges)[i] != want[i] {
+			t.Errorf("exchange %d used %q, want %q", i, (*exchanges)[i], want[i])
+		}
+	}
+}
+
+func TestHandleReload_AdminsOnly(t *testing.T) {
+	sm := NewSessionManager(false)
+	oc := NewOAuthConfig("id", "secret", "http://localhost/auth/callback", sm, nil, nil)
+	oc.CredentialSource = func() (string, string, error) { return "id", "rotated", nil }
+	handler := oc.HandleReload(NewAdmins([]string{"admin@example.com"}))
+
+	reload := func(cookie *http.Cookie) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/reload-oauth", nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	if code := reload(signIn(t, sm, "bob@example.com")); code != http.StatusForbidden {
+		t.Errorf("non-admin reload status = %d, want 403", code)
+	}
+	if oc.Config().ClientSecret != "secret" {
+		t.Error("non-admin reload changed the credentials")
+	}
+	if code := reload(signIn(t, sm, "admin@example.com")); code != http.StatusNoContent {
+		t.Errorf("admin reload status = %d, want 204", code)
+	}
+	if oc.Config().ClientSecret != "rotated" {
+		t.Error("admin reload did not change the credentials")
+	}
+}
+
+func TestGetOAuthCredentials_FromFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretPath, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_CLIENT_ID", "env-id")
+	t.Setenv("GOOGLE_CLIENT_SECRET", "env-secret")
+	t.Setenv("GOOGLE_CLIENT_SECRET_FILE", secretPath)
+
+	id, secret, err := GetOAuthCredentials()
+	if err != nil {
+		t.Fatalf("GetOAuthCredentials failed: %v", err)
+	}
+	if id != "env-id" || secret != "file-secret" {
+		t.Errorf("got %q, %q; want env-id, file-secret", id, secret)
+	}
+}
+
+func TestHandleLogin_OfflineAccess(t *testing.T) {
+	sm := NewSessionManager(false)
+	login := func(scopes []string) url.Values {
+		oc := NewOAuthConfig("id", "secret", "http://localhost/auth/callback", sm, nil, scopes)
+		w := httptest.NewRecorder()
+		oc.HandleLogin(w, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("bad redirect: %v", err)
+		}
+		return location.Query()
+	}
+
+	online := login(nil)
+	if got, want := online.Get("scope"), strings.Join(DefaultOAuthScopes, " "); got != want {
+		t.Errorf("default scope = %q, want %q", got, want)
+	}
+	if online.Get("access_type") != "" || online.Get("prompt") != "" {
+		t.Errorf("online login asked for offline access: %v", online)
+	}
+
+	offline := login([]string{"email", OfflineAccessScope})
+	if got := offline.Get("scope"); got != "email" {
+		t.Errorf("offline scope = %q, want email", got)
+	}
+	if offline.Get("access_type") != "offline" || offline.Get("prompt") != "consent" {
+		t.Errorf("offline login = %v, want access_type=offline and prompt=consent", offline)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	revoked := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("refresh_token") == "revoked" {
+			revoked = true
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"fresh","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	sm := NewSessionManager(false)
+	sm.SetClock(func() time.Time { return now })
+	oc := NewOAuthConfig("id", "secret", "http://localhost/auth/callback", sm, nil, []string{OfflineAccessScope})
+	oc.endpoint = oauth2.Endpoint{AuthURL: server.URL + "/auth", TokenURL: server.URL + "/token"}
+	oc.current = oc.newConfig("id", "secret")
+
+	session := func(id, refreshToken string, expiry time.Time) *Session {
+		s := &Session{ID: id, Email: id + "@example.com", Authenticated: true, ExpiresAt: now.Add(time.Hour),
+			OAuthToken: &oauth2.Token{AccessToken: "stale", RefreshToken: refreshToken, Expiry: expiry}}
+		sm.mu.Lock()
+		sm.addLocked(s)
+		sm.mu.Unlock()
+		return s
+	}
+	expiring := session("expiring", "refresh", now.Add(time.Minute))
+	fresh := session("fresh", "refresh", now.Add(time.Hour))
+	gone := session("gone", "revoked", now.Add(time.Minute))
+
+	if err := oc.RefreshTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshTokens failed: %v", err)
+	}
+	if expiring.OAuthToken.AccessToken != "fresh" || expiring.OAuthToken.RefreshToken != "refresh" {
+		t.Errorf("expiring token = %+v, want fresh access token and the same refresh token", expiring.OAuthToken)
+	}
+	if fresh.OAuthToken.AccessToken != "stale" {
+		t.Error("token far from expiry was refreshed")
+	}
+	if !revoked || gone.OAuthToken != nil {
+		t.Errorf("revoked refresh token was kept: %+v", gone.OAuthToken)
+	}
+}
+
+func TestHandleLogout_Redirect(t *testing.T) {
+	sm := NewSessionManager(false)
+	oc := NewOAuthConfig("id", "secret", "http://localhost/auth/callback", sm, nil, nil)
+	oc.LogoutRedirect = "/goodbye.html"
+
+	tests := []struct {
+		query, want string
+	}{
+		{"", "/goodbye.html"},
+		{"?redirect=/learn.html", "/learn.html"},
+		{"?redirect=" + url.QueryEscape("/learn.html#turtle"), "/learn.html#turtle"},
+		{"?redirect=" + url.QueryEscape("https://evil.example"), "/goodbye.html"},
+		{"?redirect=" + url.QueryEscape("//evil.example/path"), "/goodbye.html"},
+		{"?redirect=" + url.QueryEscape(`/\evil.example`), "/goodbye.html"},
+		{"?redirect=" + url.QueryEscape("/\t/evil.example"), "/goodbye.html"},
+		{"?redirect=learn.html", "/goodbye.html"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/auth/logout"+tt.query, nil)
+		req.AddCookie(signIn(t, sm, "alice@example.com"))
+		w := httptest.NewRecorder()
+		oc.HandleLogout(w, req)
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("logout%s status = %d, want 303", tt.query, w.Code)
+		}
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("logout%s redirected to %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package auth
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/oauth2"
 )
 
 const (
@@ -18,9 +23,34 @@ type Session struct {
 	UserID        string // User ID from storage
 	Email         string
 	Authenticated bool
-	OAuthState    string    // Temporary state for OAuth flow
+	OAuthState    string // Temporary state for OAuth flow
+	OAuthClientID string // Client ID the OAuth flow started with
 	CreatedAt     time.Time
 	LastAccessed  time.Time
+	ExpiresAt     time.Time // Matches the cookie's MaxAge, refreshed on Save
+
+	// ImpersonatedBy is the admin acting as Email, for read-only support
+	// sessions created by StartImpersonation. Empty for ordinary sessions.
+	ImpersonatedBy string
+
+	// TwoFactorPending is set once OAuth has identified a user with TOTP
+	// enabled, who isn't Authenticated until they enter a code.
+	// TwoFactorVerified is set once they have (or have just enrolled).
+	TwoFactorPending  bool
+	TwoFactorVerified bool
+	TOTPEnrollment    []byte // Secret being enrolled, until confirmed
+
+	// Where the user signed in from, recorded by HandleCallback so they can
+	// recognize their sessions
+	UserAgent string
+	IPAddress string
+
+	// OAuthToken holds the access and refresh tokens from sign-in when
+	// offline access was requested and granted, kept fresh by
+	// OAuthConfig.RefreshTokens. Never sent to the browser.
+	OAuthToken *oauth2.Token
+
+	indexedAs string // Lowercased email in SessionManager.byUser, if any
 }
 
 // GetUserID returns the user ID for this session (implements sync.Session interface)
@@ -36,18 +66,32 @@ func (s *Session) IsAuthenticated() bool {
 // SessionManager manages user sessions (in-memory)
 type SessionManager struct {
 	sessions map[string]*Session
+	byUser   map[string]map[string]*Session // By lowercased email, then ID
 	mu       sync.RWMutex
-	secure   bool  // Use secure cookies (set to true in production)
+	secure   bool // Use secure cookies (set to true in production)
+	clock    skewClock
 }
 
 // NewSessionManager creates a new session manager
 func NewSessionManager(secure bool) *SessionManager {
 	return &SessionManager{
 		sessions: make(map[string]*Session),
+		byUser:   make(map[string]map[string]*Session),
 		secure:   secure,
+		clock:    skewClock{now: time.Now, leeway: DefaultClockSkewLeeway},
 	}
 }
 
+// SetClock replaces the clock used for all session and token expiry checks
+func (sm *SessionManager) SetClock(clock Clock) {
+	sm.clock.now = clock
+}
+
+// SetClockSkewLeeway sets how much clock skew expiry checks tolerate
+func (sm *SessionManager) SetClockSkewLeeway(leeway time.Duration) {
+	sm.clock.leeway = leeway
+}
+
 // GetSession retrieves a session from a request
 func (sm *SessionManager) GetSession(r *http.Request) (*Session, error) {
 	cookie, err := r.Cookie(sessionCookieName)
@@ -63,9 +107,16 @@ func (sm *SessionManager) GetSession(r *http.Request) (*Session, error) {
 		return nil, fmt.Errorf("session not found")
 	}
 
+	if sm.clock.expired(session.ExpiresAt) {
+		sm.mu.Lock()
+		sm.removeLocked(cookie.Value)
+		sm.mu.Unlock()
+		return nil, fmt.Errorf("session expired")
+	}
+
 	// Update last accessed time
 	sm.mu.Lock()
-	session.LastAccessed = time.Now()
+	session.LastAccessed = sm.clock.now()
 	sm.mu.Unlock()
 
 	return session, nil
@@ -85,17 +136,18 @@ func (sm *SessionManager) GetOrCreateSession(r *http.Request, w http.ResponseWri
 		return nil, fmt.Errorf("failed to generate session ID: %w", err)
 	}
 
-	now := time.Now()
+	now := sm.clock.now()
 	session = &Session{
 		ID:            sessionID,
 		Authenticated: false,
 		CreatedAt:     now,
 		LastAccessed:  now,
+		ExpiresAt:     now.Add(sessionDuration),
 	}
 
 	// Cache in memory
 	sm.mu.Lock()
-	sm.sessions[sessionID] = session
+	sm.addLocked(session)
 	sm.mu.Unlock()
 
 	// Set cookie
@@ -106,9 +158,10 @@ func (sm *SessionManager) GetOrCreateSession(r *http.Request, w http.ResponseWri
 
 // Save saves a session (updates it in memory and refreshes the cookie)
 func (sm *SessionManager) Save(w http.ResponseWriter, session *Session) error {
-	// Update in memory cache
+	// Update in memory cache (the refreshed cookie restarts the expiry window)
 	sm.mu.Lock()
-	sm.sessions[session.ID] = session
+	session.ExpiresAt = sm.clock.now().Add(sessionDuration)
+	sm.addLocked(session)
 	sm.mu.Unlock()
 
 	sm.setCookie(w, session.ID)
@@ -121,13 +174,18 @@ func (sm *SessionManager) Destroy(w http.ResponseWriter, r *http.Request) {
 	if err == nil {
 		// Delete from memory cache
 		sm.mu.Lock()
-		delete(sm.sessions, cookie.Value)
+		sm.removeLocked(cookie.Value)
 		sm.mu.Unlock()
 	}
 
 	// Clear the cookie
+	sm.clearNamedCookie(w, sessionCookieName)
+}
+
+// clearNamedCookie tells the browser to delete a session ID cookie
+func (sm *SessionManager) clearNamedCookie(w http.ResponseWriter, name string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
+		Name:     name,
 		Value:    "",
 		Path:     "/",
 		MaxAge:   -1,
@@ -139,8 +197,13 @@ func (sm *SessionManager) Destroy(w http.ResponseWriter, r *http.Request) {
 
 // setCookie sets the session cookie
 func (sm *SessionManager) setCookie(w http.ResponseWriter, sessionID string) {
+	sm.setNamedCookie(w, sessionCookieName, sessionID)
+}
+
+// setNamedCookie sets a cookie holding a session ID
+func (sm *SessionManager) setNamedCookie(w http.ResponseWriter, name, sessionID string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
+		Name:     name,
 		Value:    sessionID,
 		Path:     "/",
 		MaxAge:   int(sessionDuration.Seconds()),
@@ -149,3 +212,76 @@ func (sm *SessionManager) setCookie(w http.ResponseWriter, sessionID string) {
 		SameSite: http.SameSiteLaxMode, // Lax allows OAuth callback redirects
 	})
 }
+
+// DestroyUser ends every session for email, including impersonations of
+// them, and returns how many there were
+func (sm *SessionManager) DestroyUser(email string) int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	ended := 0
+	for id := range sm.byUser[strings.ToLower(email)] {
+		sm.removeLocked(id)
+		ended++
+	}
+	return ended
+}
+
+// SweepSessions drops expired sessions, which are otherwise only removed when
+// their cookie is next presented, so abandoned ones don't pile up. Run it
+// periodically.
+func (sm *SessionManager) SweepSessions(ctx context.Context) error {
+	sm.mu.Lock()
+	swept := 0
+	for id, session := range sm.sessions {
+		if sm.clock.expired(session.ExpiresAt) {
+			sm.removeLocked(id)
+			swept++
+		}
+	}
+	remaining := len(sm.sessions)
+	sm.mu.Unlock()
+
+	if swept > 0 {
+		slog.DebugContext(ctx, "Swept expired sessions", "swept", swept, "remaining", remaining)
+	}
+	return nil
+}
+
+// addLocked stores session, indexing it by user once it has an email.
+// Callers hold sm.mu.
+func (sm *SessionManager) addLocked(session *Session) {
+	email := strings.ToLower(session.Email)
+	if session.indexedAs != email {
+		sm.unindexLocked(session)
+	}
+	sm.sessions[session.ID] = session
+	if email == "" {
+		return
+	}
+	if sm.byUser[email] == nil {
+		sm.byUser[email] = make(map[string]*Session)
+	}
+	sm.byUser[email][session.ID] = session
+	session.indexedAs = email
+}
+
+// removeLocked deletes the session with id. Callers hold sm.mu.
+func (sm *SessionManager) removeLocked(id string) {
+	if session, ok := sm.sessions[id]; ok {
+		sm.unindexLocked(session)
+		delete(sm.sessions, id)
+	}
+}
+
+// unindexLocked removes session from byUser. Callers hold sm.mu.
+func (sm *SessionManager) unindexLocked(session *Session) {
+	if session.indexedAs == "" {
+		return
+	}
+	delete(sm.byUser[session.indexedAs], session.ID)
+	if len(sm.byUser[session.indexedAs]) == 0 {
+		delete(sm.byUser, session.indexedAs)
+	}
+	session.indexedAs = ""
+}
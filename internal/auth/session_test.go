@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for expiry tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestGetSession_ExpiryLeeway(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiry := start.Add(sessionDuration)
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		wantValid bool
+	}{
+		{name: "before expiry", now: expiry.Add(-time.Second), wantValid: true},
+		{name: "just past expiry, within leeway", now: expiry.Add(DefaultClockSkewLeeway - time.Second), wantValid: true},
+		{name: "exactly at leeway boundary", now: expiry.Add(DefaultClockSkewLeeway), wantValid: true},
+		{name: "past leeway", now: expiry.Add(DefaultClockSkewLeeway + time.Second), wantValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: start}
+			sm := NewSessionManager(false)
+			sm.SetClock(clock.now)
+
+			rec := httptest.NewRecorder()
+			session, err := sm.GetOrCreateSession(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+			if err != nil {
+				t.Fatalf("GetOrCreateSession failed: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.ID})
+
+			clock.t = tt.now
+			_, err = sm.GetSession(req)
+			if tt.wantValid && err != nil {
+				t.Errorf("Expected valid session, got error: %v", err)
+			}
+			if !tt.wantValid && err == nil {
+				t.Error("Expected expired session, got success")
+			}
+		})
+	}
+}
+
+func TestSweepSessions(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	sm := NewSessionManager(false)
+	sm.SetClock(clock.now)
+
+	signIn(t, sm, "old@example.com")
+	if _, err := sm.StartImpersonation("admin@example.com", "old@example.com"); err != nil {
+		t.Fatalf("StartImpersonation failed: %v", err)
+	}
+	clock.t = clock.t.Add(sessionDuration - time.Hour)
+	fresh := signIn(t, sm, "new@example.com")
+
+	clock.t = clock.t.Add(time.Hour + DefaultClockSkewLeeway + time.Second)
+	if err := sm.SweepSessions(context.Background()); err != nil {
+		t.Fatalf("SweepSessions failed: %v", err)
+	}
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if len(sm.sessions) != 1 || sm.sessions[fresh.Value] == nil {
+		t.Errorf("Expected only the fresh session to remain, got %d sessions", len(sm.sessions))
+	}
+	if _, ok := sm.byUser["old@example.com"]; ok {
+		t.Error("Expected swept sessions to be unindexed")
+	}
+}
+
+func TestCheckIDTokenTimes_Leeway(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := skewClock{now: func() time.Time { return now }, leeway: DefaultClockSkewLeeway}
+
+	tests := []struct {
+		name      string
+		iat       time.Time
+		exp       time.Time
+		wantValid bool
+	}{
+		{name: "valid", iat: now.Add(-time.Minute), exp: now.Add(time.Hour), wantValid: true},
+		{name: "expired within leeway", iat: now.Add(-time.Hour), exp: now.Add(-DefaultClockSkewLeeway), wantValid: true},
+		{name: "expired beyond leeway", iat: now.Add(-time.Hour), exp: now.Add(-DefaultClockSkewLeeway - time.Second), wantValid: false},
+		{name: "issued in future within leeway", iat: now.Add(DefaultClockSkewLeeway), exp: now.Add(time.Hour), wantValid: true},
+		{name: "issued in future beyond leeway", iat: now.Add(DefaultClockSkewLeeway + time.Second), exp: now.Add(time.Hour), wantValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(map[string]int64{"iat": tt.iat.Unix(), "exp": tt.exp.Unix()})
+			rawToken := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+
+			claims, err := parseIDTokenClaims(rawToken)
+			if err != nil {
+				t.Fatalf("parseIDTokenClaims failed: %v", err)
+			}
+
+			err = clock.checkIDTokenTimes(claims)
+			if tt.wantValid && err != nil {
+				t.Errorf("Expected valid token, got error: %v", err)
+			}
+			if !tt.wantValid && err == nil {
+				t.Error("Expected invalid token, got success")
+			}
+		})
+	}
+}
+
+func TestDestroyUser(t *testing.T) {
+	sm := NewSessionManager(false)
+	alice := signIn(t, sm, "alice@example.com")
+	signIn(t, sm, "Alice@Example.com")
+	bob := signIn(t, sm, "bob@example.com")
+	if _, err := sm.StartImpersonation("admin@example.com", "alice@example.com"); err != nil {
+		t.Fatalf("StartImpersonation failed: %v", err)
+	}
+
+	if ended := sm.DestroyUser("alice@example.com"); ended != 3 {
+		t.Errorf("DestroyUser ended %d sessions, want 3", ended)
+	}
+
+	get := func(cookie *http.Cookie) error {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		_, err := sm.GetSession(req)
+		return err
+	}
+	if get(alice) == nil {
+		t.Error("Alice's session survived DestroyUser")
+	}
+	if err := get(bob); err != nil {
+		t.Errorf("Bob's session was destroyed: %v", err)
+	}
+}
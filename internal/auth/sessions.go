@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zellyn/trifle/internal/middleware"
+)
+
+// SessionInfo describes one of a user's sessions for GET /auth/sessions
+type SessionInfo struct {
+	ID        string    `json:"id"` // Not the session ID itself, which is a credential
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	Current   bool      `json:"current"` // The session making the request
+}
+
+// publicSessionID identifies a session to its user without revealing the
+// cookie value
+func publicSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:12])
+}
+
+// remoteIP returns the address a request came from, without its port. Behind
+// middleware.ClientIP that is the client's, even through trusted proxies.
+func remoteIP(r *http.Request) string {
+	if ip := middleware.ClientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// UserSessions lists email's signed-in sessions, newest first.
+// Impersonations of them aren't included.
+func (sm *SessionManager) UserSessions(email string) []*Session {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	var sessions []*Session
+	for _, session := range sm.byUser[strings.ToLower(email)] {
+		if session.Authenticated && session.ImpersonatedBy == "" && !sm.clock.expired(session.ExpiresAt) {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.After(sessions[j].CreatedAt) })
+	return sessions
+}
+
+// HandleSessions lists the signed-in user's sessions (GET /auth/sessions)
+// and revokes one of them (DELETE /auth/sessions/{id}). Revoking the current
+// session also clears its cookie.
+func HandleSessions(sm *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, err := sm.GetSession(r)
+		if err != nil || !current.Authenticated {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+		sessions := sm.UserSessions(current.Email)
+
+		switch r.Method {
+		case http.MethodGet:
+			infos := []SessionInfo{}
+			for _, session := range sessions {
+				infos = append(infos, SessionInfo{
+					ID:        publicSessionID(session.ID),
+					CreatedAt: session.CreatedAt,
+					LastSeen:  session.LastAccessed,
+					UserAgent: session.UserAgent,
+					IPAddress: session.IPAddress,
+					Current:   session.ID == current.ID,
+				})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(infos)
+
+		case http.MethodDelete:
+			if forbidImpersonated(w, r, current) {
+				return
+			}
+			id := strings.TrimPrefix(r.URL.Path, "/auth/sessions/")
+			for _, session := range sessions {
+				if publicSessionID(session.ID) != id {
+					continue
+				}
+				if session.ID == current.ID {
+					sm.Destroy(w, r)
+				} else {
+					sm.mu.Lock()
+					sm.removeLocked(session.ID)
+					sm.mu.Unlock()
+				}
+				slog.InfoContext(r.Context(), "Session revoked", "audit", true, "user", strings.ToLower(current.Email), "current", session.ID == current.ID)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			http.Error(w, "Session not found", http.StatusNotFound)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
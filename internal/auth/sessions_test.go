@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleSessions(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	sm := NewSessionManager(false)
+	sm.SetClock(clock.now)
+
+	signInFrom := func(email, userAgent string) *Session {
+		session, _ := sm.GetOrCreateSession(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		session.Email = email
+		session.Authenticated = true
+		session.UserAgent = userAgent
+		session.IPAddress = "192.0.2.1"
+		sm.Save(httptest.NewRecorder(), session)
+		clock.t = clock.t.Add(time.Minute)
+		return session
+	}
+	laptop := signInFrom("alice@example.com", "Laptop")
+	signInFrom("Alice@Example.com", "Phone")
+	signInFrom("bob@example.com", "Bob's")
+	sm.StartImpersonation("admin@example.com", "alice@example.com")
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: laptop.ID})
+		w := httptest.NewRecorder()
+		HandleSessions(sm)(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "/auth/sessions")
+	var infos []SessionInfo
+	if err := json.NewDecoder(w.Body).Decode(&infos); err != nil {
+		t.Fatalf("Failed to decode sessions: %v", err)
+	}
+	if len(infos) != 2 || infos[0].UserAgent != "Phone" || infos[1].UserAgent != "Laptop" {
+		t.Fatalf("Sessions = %+v, want Alice's phone then laptop", infos)
+	}
+	if infos[0].Current || !infos[1].Current || infos[1].IPAddress != "192.0.2.1" {
+		t.Errorf("Sessions = %+v, want the laptop flagged current", infos)
+	}
+	if infos[1].ID == laptop.ID {
+		t.Error("Listing exposes the session ID")
+	}
+
+	// Revoke the phone, then the current session, which clears the cookie
+	if w := request(http.MethodDelete, "/auth/sessions/"+infos[0].ID); w.Code != http.StatusNoContent {
+		t.Fatalf("Revoking phone: status %d", w.Code)
+	}
+	if sessions := sm.UserSessions("alice@example.com"); len(sessions) != 1 || sessions[0].ID != laptop.ID {
+		t.Errorf("After revoking phone: %d sessions", len(sessions))
+	}
+	if w := request(http.MethodDelete, "/auth/sessions/"+infos[0].ID); w.Code != http.StatusNotFound {
+		t.Errorf("Revoking phone twice: status %d", w.Code)
+	}
+	w = request(http.MethodDelete, "/auth/sessions/"+infos[1].ID)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Revoking current session: status %d", w.Code)
+	}
+	if cookie := w.Result().Cookies(); len(cookie) != 1 || cookie[0].MaxAge >= 0 {
+		t.Errorf("Cookie not cleared: %v", cookie)
+	}
+	if w := request(http.MethodGet, "/auth/sessions"); w.Code != http.StatusUnauthorized {
+		t.Errorf("After revoking current session: status %d", w.Code)
+	}
+
+	// Bob's session and the impersonation are untouched
+	if n := sm.DestroyUser("bob@example.com"); n != 1 {
+		t.Errorf("DestroyUser(bob) = %d, want 1", n)
+	}
+	if n := sm.DestroyUser("alice@example.com"); n != 1 {
+		t.Errorf("DestroyUser(alice) = %d, want the impersonation only", n)
+	}
+}
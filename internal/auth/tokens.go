@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenRefreshWindow is how long before expiry RefreshTokens renews an access
+// token. It should be longer than the interval RefreshTokens runs at, so
+// tokens never lapse between runs.
+const tokenRefreshWindow = 10 * time.Minute
+
+// RefreshTokens renews the access token of every session whose token expires
+// within tokenRefreshWindow, using its refresh token. A refresh token the
+// provider has revoked is dropped, and the session carries on without offline
+// access; other failures are retried on the next run. Run it periodically.
+func (oc *OAuthConfig) RefreshTokens(ctx context.Context) error {
+	type due struct {
+		sessionID, clientID string
+		refreshToken        string
+	}
+	sm := oc.SessionMgr
+	soon := sm.clock.now().Add(tokenRefreshWindow)
+
+	sm.mu.RLock()
+	var pending []due
+	for id, session := range sm.sessions {
+		if token := session.OAuthToken; token != nil && token.RefreshToken != "" && token.Expiry.Before(soon) {
+			pending = append(pending, due{id, session.OAuthClientID, token.RefreshToken})
+		}
+	}
+	sm.mu.RUnlock()
+
+	refreshed, revoked := 0, 0
+	for _, d := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Without an access token the source always refreshes, and it keeps
+		// the old refresh token if the provider doesn't issue a new one
+		token, err := oc.configFor(d.clientID).TokenSource(ctx, &oauth2.Token{RefreshToken: d.refreshToken}).Token()
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			token, err = nil, nil
+			revoked++
+		} else if err != nil {
+			slog.WarnContext(ctx, "Failed to refresh OAuth token", "error", err)
+			continue
+		} else {
+			refreshed++
+		}
+
+		sm.mu.Lock()
+		if session, ok := sm.sessions[d.sessionID]; ok && session.OAuthToken != nil {
+			session.OAuthToken = token
+		}
+		sm.mu.Unlock()
+	}
+	if len(pending) > 0 {
+		slog.InfoContext(ctx, "Refreshed OAuth tokens", "refreshed", refreshed, "revoked", revoked, "failed", len(pending)-refreshed-revoked)
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpStep       = 30 * time.Second
+	totpDigits     = 6
+	totpSkewSteps  = 1 // Codes from one step either side are accepted, for clock drift
+	totpSecretSize = 20
+
+	// totpMaxFailures wrong codes for one user within totpLockout lock them
+	// out of further attempts until it has passed. With a million codes and
+	// three valid at a time, that makes guessing hopeless.
+	totpMaxFailures = 5
+	totpLockout     = 15 * time.Minute
+)
+
+// ErrTOTPRateLimited is returned when a user has entered too many wrong codes
+var ErrTOTPRateLimited = errors.New("too many incorrect codes, try again later")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPStore holds users' TOTP secrets, encrypted with AES-GCM, in a JSON file.
+// It also counts failed attempts and remembers the last code each user
+// used, so a code can't be replayed.
+type TOTPStore struct {
+	path   string
+	aead   cipher.AEAD
+	issuer string // Shown in authenticator apps
+	now    func() time.Time
+
+	mu       sync.Mutex
+	secrets  map[string]string // By lowercased email: base64 nonce and ciphertext
+	failures map[string]*totpFailures
+	lastStep map[string]int64
+}
+
+// totpFailures counts a user's wrong codes since the first in the window
+type totpFailures struct {
+	count int
+	since time.Time
+}
+
+// OpenTOTPStore loads the secrets at path, if it exists, decrypting them with
+// key, which must be 32 bytes (AES-256)
+func OpenTOTPStore(path string, key []byte, issuer string) (*TOTPStore, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("TOTP encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TOTPStore{
+		path:     path,
+		aead:     aead,
+		issuer:   issuer,
+		now:      time.Now,
+		secrets:  make(map[string]string),
+		failures: make(map[string]*totpFailures),
+		lastStep: make(map[string]int64),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read TOTP secrets: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &t.secrets); err != nil {
+			return nil, fmt.Errorf("failed to parse TOTP secrets: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// Enrolled reports whether email has TOTP enabled
+func (t *TOTPStore) Enrolled(email string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.secrets[strings.ToLower(email)]
+	return ok
+}
+
+// Verify checks a code from email's authenticator, counting failures
+// towards the lockout
+func (t *TOTPStore) Verify(email, code string) (bool, error) {
+	email = strings.ToLower(email)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sealed, ok := t.secrets[email]
+	if !ok {
+		return false, fmt.Errorf("TOTP not enabled for %s", email)
+	}
+	secret, err := t.open(email, sealed)
+	if err != nil {
+		return false, err
+	}
+	return t.check(email, secret, code)
+}
+
+// enable stores secret for email once a code from it has been confirmed
+func (t *TOTPStore) enable(email string, secret []byte) error {
+	email = strings.ToLower(email)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sealed, err := t.seal(email, secret)
+	if err != nil {
+		return err
+	}
+	t.secrets[email] = sealed
+	if err := t.save(); err != nil {
+		delete(t.secrets, email)
+		return err
+	}
+	return nil
+}
+
+// disable forgets email's secret
+func (t *TOTPStore) disable(email string) error {
+	email = strings.ToLower(email)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sealed, ok := t.secrets[email]
+	if !ok {
+		return nil
+	}
+	delete(t.secrets, email)
+	if err := t.save(); err != nil {
+		t.secrets[email] = sealed
+		return err
+	}
+	return nil
+}
+
+// check compares code with the codes secret generates around now, within
+// the drift window and after the last code email used. Callers hold t.mu.
+func (t *TOTPStore) check(email string, secret []byte, code string) (bool, error) {
+	now := t.now()
+	if f := t.failures[email]; f != nil {
+		if now.Sub(f.since) >= totpLockout {
+			delete(t.failures, email)
+		} else if f.count >= totpMaxFailures {
+			return false, ErrTOTPRateLimited
+		}
+	}
+
+	step := now.Unix() / int64(totpStep.Seconds())
+	for s := step - totpSkewSteps; s <= step+totpSkewSteps; s++ {
+		if s > t.lastStep[email] && subtle.ConstantTimeCompare([]byte(totpCode(secret, s)), []byte(code)) == 1 {
+			t.lastStep[email] = s
+			delete(t.failures, email)
+			return true, nil
+		}
+	}
+
+	if t.failures[email] == nil {
+		t.failures[email] = &totpFailures{since: now}
+	}
+	t.failures[email].count++
+	return false, nil
+}
+
+// seal encrypts secret, binding it to email so it can't be moved to another
+// user's entry
+func (t *TOTPStore) seal(email string, secret []byte) (string, error) {
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(t.aead.Seal(nonce, nonce, secret, []byte(email))), nil
+}
+
+// open decrypts a secret sealed for email
+func (t *TOTPStore) open(email, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < t.aead.NonceSize() {
+		return nil, fmt.Errorf("corrupt TOTP secret for %s", email)
+	}
+	nonce, ciphertext := data[:t.aead.NonceSize()], data[t.aead.NonceSize():]
+	secret, err := t.aead.Open(nil, nonce, ciphertext, []byte(email))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt TOTP secret for %s (wrong key?): %w", email, err)
+	}
+	return secret, nil
+}
+
+// save writes the secrets atomically. Callers hold t.mu.
+func (t *TOTPStore) save() error {
+	data, err := json.MarshalIndent(t.secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return fmt.Errorf("failed to create TOTP directory: %w", err)
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write TOTP secrets: %w", err)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write TOTP secrets: %w", err)
+	}
+	return nil
+}
+
+// provisioningURI is the otpauth:// URI authenticator apps import, usually
+// from a QR code
+func (t *TOTPStore) provisioningURI(email string, secret []byte) string {
+	query := url.Values{
+		"secret":    {totpEncoding.EncodeToString(secret)},
+		"issuer":    {t.issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(int(totpStep.Seconds()))},
+	}
+	label := url.PathEscape(t.issuer + ":" + email)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// totpCode is the RFC 6238 code for secret at time step counter
+func totpCode(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// TOTPHandler serves /auth/totp/: enrollment, confirmation and the second
+// step of signing in
+type TOTPHandler struct {
+	SessionMgr *SessionManager
+	Store      *TOTPStore
+}
+
+// TOTPEnrollment is a newly generated secret, to be confirmed with a code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"` // Base32, for typing in by hand
+	URI    string `json:"uri"`    // otpauth://, to show as a QR code
+}
+
+// readCode decodes a {"code": "123456"} request body
+func readCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return "", false
+	}
+	return strings.TrimSpace(req.Code), true
+}
+
+// signedIn returns the request's own (not impersonated) signed-in session,
+// or writes a 401
+func (h *TOTPHandler) signedIn(w http.ResponseWriter, r *http.Request) (*Session, bool) {
+	session, err := h.SessionMgr.GetSession(r)
+	if err != nil || !session.Authenticated || session.ImpersonatedBy != "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return session, true
+}
+
+// HandleEnroll generates a new secret for the signed-in user (POST). It
+// takes effect once confirmed with a code from it.
+func (h *TOTPHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := h.signedIn(w, r)
+	if !ok {
+		return
+	}
+	if h.Store.Enrolled(session.Email) {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	h.SessionMgr.mu.Lock()
+	session.TOTPEnrollment = secret
+	h.SessionMgr.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TOTPEnrollment{
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    h.Store.provisioningURI(session.Email, secret),
+	})
+}
+
+// HandleConfirm enables TOTP for the signed-in user (POST {"code": ...}),
+// given a code from the secret HandleEnroll generated
+func (h *TOTPHandler) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := h.signedIn(w, r)
+	if !ok {
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+	h.SessionMgr.mu.RLock()
+	secret := session.TOTPEnrollment
+	h.SessionMgr.mu.RUnlock()
+	if secret == nil {
+		http.Error(w, "No enrollment in progress", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(session.Email)
+	h.Store.mu.Lock()
+	valid, err := h.Store.check(email, secret, code)
+	h.Store.mu.Unlock()
+	if !h.codeAccepted(w, r, email, valid, err) {
+		return
+	}
+	if err := h.Store.enable(email, secret); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save TOTP secret", "error", err, "user", email)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.SessionMgr.mu.Lock()
+	session.TOTPEnrollment = nil
+	session.TwoFactorVerified = true
+	h.SessionMgr.mu.Unlock()
+	slog.InfoContext(r.Context(), "TOTP enabled", "audit", true, "user", email)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleVerify completes a sign-in that is waiting for a code (POST
+// {"code": ...}), as HandleCallback leaves those of users with TOTP enabled
+func (h *TOTPHandler) HandleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, err := h.SessionMgr.GetSession(r)
+	if err != nil || !session.TwoFactorPending {
+		http.Error(w, "No sign-in is waiting for a code", http.StatusUnauthorized)
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	email := strings.ToLower(session.Email)
+	valid, err := h.Store.Verify(email, code)
+	if !h.codeAccepted(w, r, email, valid, err) {
+		return
+	}
+
+	h.SessionMgr.mu.Lock()
+	session.TwoFactorPending = false
+	session.TwoFactorVerified = true
+	session.Authenticated = true
+	h.SessionMgr.mu.Unlock()
+	if err := h.SessionMgr.Save(w, session); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Login successful", "email", email, "two_factor", true)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDisable turns TOTP off for the signed-in user (DELETE with
+// {"code": ...}), so a stolen session alone can't remove it
+func (h *TOTPHandler) HandleDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := h.signedIn(w, r)
+	if !ok {
+		return
+	}
+	email := strings.ToLower(session.Email)
+	if !h.Store.Enrolled(email) {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusNotFound)
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+	valid, err := h.Store.Verify(email, code)
+	if !h.codeAccepted(w, r, email, valid, err) {
+		return
+	}
+	if err := h.Store.disable(email); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to remove TOTP secret", "error", err, "user", email)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "TOTP disabled", "audit", true, "user", email)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// codeAccepted writes the error response for a rejected code, reporting
+// whether the code was accepted
+func (h *TOTPHandler) codeAccepted(w http.ResponseWriter, r *http.Request, email string, valid bool, err error) bool {
+	switch {
+	case errors.Is(err, ErrTOTPRateLimited):
+		slog.WarnContext(r.Context(), "TOTP attempts rate limited", "audit", true, "user", email)
+		w.Header().Set("Retry-After", strconv.Itoa(int(totpLockout.Seconds())))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to check TOTP code", "error", err, "user", email)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	case !valid:
+		slog.WarnContext(r.Context(), "Incorrect TOTP code", "audit", true, "user", email)
+		http.Error(w, "Incorrect code", http.StatusUnauthorized)
+	default:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1), truncated to six digits
+	secret := []byte("12345678901234567890")
+	for seconds, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		if got := totpCode(secret, seconds/30); got != want {
+			t.Errorf("totpCode at %d = %s, want %s", seconds, got, want)
+		}
+	}
+}
+
+func newTestTOTPStore(t *testing.T, path string, clock *fakeClock) *TOTPStore {
+	t.Helper()
+	store, err := OpenTOTPStore(path, bytes.Repeat([]byte{7}, 32), "Trifle")
+	if err != nil {
+		t.Fatalf("OpenTOTPStore failed: %v", err)
+	}
+	store.now = clock.now
+	return store
+}
+
+func TestTOTPStore_Verify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "totp", "secrets.json")
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := newTestTOTPStore(t, path, clock)
+	secret := []byte("12345678901234567890")
+	if err := store.enable("Alice@Example.com", secret); err != nil {
+		t.Fatalf("enable failed: %v", err)
+	}
+	step := clock.t.Unix() / 30
+
+	// One step of drift either way is fine, but each code works once
+	for _, s := range []int64{step - 1, step + 1} {
+		if ok, err := store.Verify("alice@example.com", totpCode(secret, s)); !ok || err != nil {
+			t.Errorf("Code for step %+d: ok=%v err=%v", s-step, ok, err)
+		}
+	}
+	if ok, _ := store.Verify("alice@example.com", totpCode(secret, step+1)); ok {
+		t.Error("Replayed code accepted")
+	}
+	if ok, _ := store.Verify("alice@example.com", totpCode(secret, step+3)); ok {
+		t.Error("Code three steps ahead accepted")
+	}
+
+	// Persisted, encrypted, and only readable with the same key
+	reopened := newTestTOTPStore(t, path, clock)
+	if !reopened.Enrolled("alice@example.com") {
+		t.Fatal("Enrollment not persisted")
+	}
+	clock.t = clock.t.Add(time.Minute)
+	if ok, err := reopened.Verify("alice@example.com", totpCode(secret, step+2)); !ok || err != nil {
+		t.Errorf("Reopened store: ok=%v err=%v", ok, err)
+	}
+	wrongKey, err := OpenTOTPStore(path, bytes.Repeat([]byte{8}, 32), "Trifle")
+	if err != nil {
+		t.Fatalf("OpenTOTPStore failed: %v", err)
+	}
+	if _, err := wrongKey.Verify("alice@example.com", "000000"); err == nil {
+		t.Error("Secret decrypted with the wrong key")
+	}
+}
+
+func TestTOTPStore_Lockout(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := newTestTOTPStore(t, filepath.Join(t.TempDir(), "secrets.json"), clock)
+	secret := []byte("12345678901234567890")
+	store.enable("alice@example.com", secret)
+
+	for range totpMaxFailures {
+		if ok, err := store.Verify("alice@example.com", "000000"); ok || err != nil {
+			t.Fatalf("Wrong code: ok=%v err=%v", ok, err)
+		}
+	}
+	valid := totpCode(secret, clock.t.Unix()/30)
+	if _, err := store.Verify("alice@example.com", valid); !errors.Is(err, ErrTOTPRateLimited) {
+		t.Errorf("Locked out user: err = %v, want ErrTOTPRateLimited", err)
+	}
+
+	clock.t = clock.t.Add(totpLockout)
+	if ok, err := store.Verify("alice@example.com", totpCode(secret, clock.t.Unix()/30)); !ok || err != nil {
+		t.Errorf("After lockout: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestTOTPHandler_EnrollAndVerify(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	sm := NewSessionManager(false)
+	sm.SetClock(clock.now)
+	store := newTestTOTPStore(t, filepath.Join(t.TempDir(), "secrets.json"), clock)
+	h := &TOTPHandler{SessionMgr: sm, Store: store}
+
+	session, _ := sm.GetOrCreateSession(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	session.Email = "alice@example.com"
+	session.Authenticated = true
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/totp", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.ID})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if w := post(h.HandleEnroll, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "otpauth://totp/Trifle:alice@example.com?") {
+		t.Fatalf("Enroll: status %d, body %s", w.Code, w.Body.String())
+	}
+	if store.Enrolled("alice@example.com") {
+		t.Fatal("Enrolled before confirming")
+	}
+	secret := session.TOTPEnrollment
+	code := totpCode(secret, clock.t.Unix()/30)
+	if w := post(h.HandleConfirm, `{"code": "`+code+`"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("Confirm: status %d, body %s", w.Code, w.Body.String())
+	}
+	if !store.Enrolled("alice@example.com") || !session.TwoFactorVerified || session.TOTPEnrollment != nil {
+		t.Fatal("Not enrolled after confirming")
+	}
+
+	// A later sign-in, as HandleCallback leaves it
+	session.Authenticated = false
+	session.TwoFactorPending = true
+	session.TwoFactorVerified = false
+	clock.t = clock.t.Add(time.Minute)
+	if w := post(h.HandleVerify, `{"code": "000000"}`); w.Code != http.StatusUnauthorized || session.Authenticated {
+		t.Errorf("Wrong code: status %d, authenticated %v", w.Code, session.Authenticated)
+	}
+	code = totpCode(secret, clock.t.Unix()/30)
+	if w := post(h.HandleVerify, `{"code": "`+code+`"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("Verify: status %d, body %s", w.Code, w.Body.String())
+	}
+	if !session.Authenticated || session.TwoFactorPending || !session.TwoFactorVerified {
+		t.Errorf("After verifying: %+v", session)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"net/http"
 )
 
-// HandleWhoAmI returns the current user's email if authenticated
+// HandleWhoAmI returns the current user's email if authenticated, whether
+// the server holds a refresh token for them, and the admin impersonating
+// them, if any
 func HandleWhoAmI(sessionMgr *SessionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		session, err := sessionMgr.GetSession(r)
@@ -15,8 +17,13 @@ func HandleWhoAmI(sessionMgr *SessionManager) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"email": session.Email,
-		})
+		info := map[string]any{
+			"email":          session.Email,
+			"offline_access": session.OAuthToken != nil,
+		}
+		if session.ImpersonatedBy != "" {
+			info["impersonated_by"] = session.ImpersonatedBy
+		}
+		json.NewEncoder(w).Encode(info)
 	}
 }
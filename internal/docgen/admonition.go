@@ -0,0 +1,137 @@
+package docgen
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindAdmonition is the node kind for callout boxes
+var KindAdmonition = ast.NewNodeKind("Admonition")
+
+// admonitionStyle is the icon and default title for one admonition type
+type admonitionStyle struct {
+	Icon  string
+	Title string
+}
+
+// admonitionStyles maps `> [!TYPE]` markers (lowercased) to their appearance
+var admonitionStyles = map[string]admonitionStyle{
+	"note":    {Icon: "ℹ️", Title: "Note"},
+	"tip":     {Icon: "💡", Title: "Tip"},
+	"warning": {Icon: "⚠️", Title: "Warning"},
+	"danger":  {Icon: "🛑", Title: "Danger"},
+}
+
+// admonitionMarker matches the `[!TYPE]` line that opens an admonition blockquote
+var admonitionMarker = regexp.MustCompile(`^\[!([A-Za-z]+)\]\s*$`)
+
+// Admonition is a callout box. Its children are ordinary markdown blocks.
+type Admonition struct {
+	ast.BaseBlock
+	AdmonitionType string // Lowercased marker, e.g. "warning"
+}
+
+// Dump implements ast.Node
+func (n *Admonition) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"AdmonitionType": n.AdmonitionType}, nil)
+}
+
+// Kind implements ast.Node
+func (n *Admonition) Kind() ast.NodeKind {
+	return KindAdmonition
+}
+
+// AdmonitionTransformer turns blockquotes starting with `[!TYPE]` into Admonition nodes
+type AdmonitionTransformer struct{}
+
+func (t *AdmonitionTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	// Collect first; we can't modify the tree while walking it
+	var quotes []*ast.Blockquote
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if quote, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, quote)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, quote := range quotes {
+		para, ok := quote.FirstChild().(*ast.Paragraph)
+		if !ok || para.Lines().Len() == 0 {
+			continue
+		}
+
+		firstLine := para.Lines().At(0)
+		match := admonitionMarker.FindSubmatch(firstLine.Value(source))
+		if match == nil {
+			continue
+		}
+
+		// Drop the inline nodes that make up the marker line
+		for child := para.FirstChild(); child != nil; {
+			next := child.NextSibling()
+			if t, ok := child.(*ast.Text); ok && t.Segment.Stop > firstLine.Stop {
+				break
+			}
+			para.RemoveChild(para, child)
+			child = next
+		}
+		if para.ChildCount() == 0 {
+			quote.RemoveChild(quote, para)
+		} else {
+			rest := text.NewSegments()
+			for i := 1; i < para.Lines().Len(); i++ {
+				rest.Append(para.Lines().At(i))
+			}
+			para.SetLines(rest)
+		}
+
+		admonition := &Admonition{AdmonitionType: strings.ToLower(string(match[1]))}
+		for child := quote.FirstChild(); child != nil; {
+			next := child.NextSibling()
+			admonition.AppendChild(admonition, child)
+			child = next
+		}
+		quote.Parent().ReplaceChild(quote.Parent(), quote, admonition)
+	}
+}
+
+// AdmonitionRenderer renders Admonition nodes
+type AdmonitionRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer
+func (r *AdmonitionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindAdmonition, r.renderAdmonition)
+}
+
+func (r *AdmonitionRenderer) renderAdmonition(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Admonition)
+	if !entering {
+		w.WriteString("</div>\n")
+		return ast.WalkContinue, nil
+	}
+
+	// Unknown types get a generic style titled with the marker itself
+	style, ok := admonitionStyles[n.AdmonitionType]
+	class := "admonition-" + n.AdmonitionType
+	if !ok {
+		style = admonitionStyle{Icon: "📌", Title: strings.ToUpper(n.AdmonitionType[:1]) + n.AdmonitionType[1:]}
+		class = "admonition-default"
+	}
+
+	w.WriteString(`<div class="admonition ` + class + `">` + "\n")
+	w.WriteString(`<p class="admonition-title"><span class="admonition-icon" aria-hidden="true">`)
+	w.WriteString(style.Icon)
+	w.WriteString(`</span> `)
+	w.WriteString(html.EscapeString(style.Title))
+	w.WriteString("</p>\n")
+	return ast.WalkContinue, nil
+}
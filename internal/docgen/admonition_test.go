@@ -0,0 +1,42 @@
+package docgen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAdmonition_RendersNestedMarkdown(t *testing.T) {
+	source := []byte("> [!WARNING]\n> Be **careful**:\n>\n> - one\n> - two\n\n> plain quote\n")
+
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert(source, &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`<div class="admonition admonition-warning">`,
+		`Warning</p>`,
+		`<p>Be <strong>careful</strong>:</p>`,
+		`<li>one</li>`,
+		"<blockquote>\n<p>plain quote</p>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "[!WARNING]") {
+		t.Error("Marker line should not be rendered")
+	}
+}
+
+func TestAdmonition_UnknownTypeIsGeneric(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert([]byte("> [!Aside]\n> hi\n"), &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `<div class="admonition admonition-default">`) {
+		t.Errorf("Expected generic admonition, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,68 @@
+package docgen
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DefaultBreadcrumbRoot and DefaultBreadcrumbSeparator are the first crumb,
+// which links to the landing page, and what goes between crumbs
+const (
+	DefaultBreadcrumbRoot      = "Docs"
+	DefaultBreadcrumbSeparator = "/"
+)
+
+// landingURL is where the root crumb links, and whose category sections
+// category crumbs link to
+const landingURL = "/learn.html"
+
+// DocCategory is one category's section of the landing page
+type DocCategory struct {
+	Name string
+	ID   string // Anchor of its section, see categoryID
+	Docs []NavLink
+}
+
+// categoryID returns the landing page anchor for category, e.g.
+// "category-getting-started"
+func categoryID(category string) string {
+	var b strings.Builder
+	b.WriteString("category")
+	dash := true
+	for _, r := range strings.ToLower(category) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// breadcrumbs returns the trail from the landing page to a doc: the root, the
+// doc's category if it has one, and the doc itself, which has no URL
+func breadcrumbs(metadata DocMetadata, opts Options) []NavLink {
+	crumbs := []NavLink{{Title: opts.breadcrumbRoot(), URL: landingURL}}
+	if metadata.Category != "" {
+		crumbs = append(crumbs, NavLink{Title: metadata.Category, URL: landingURL + "#" + categoryID(metadata.Category)})
+	}
+	return append(crumbs, NavLink{Title: metadata.Title})
+}
+
+// landingCategories lists every doc by category, in sidebar order
+func landingCategories(entries []docEntry) []DocCategory {
+	names, groups := groupDocs(entries)
+	categories := make([]DocCategory, 0, len(names))
+	for _, name := range names {
+		category := DocCategory{Name: name, ID: categoryID(name)}
+		for _, entry := range groups[name] {
+			category.Docs = append(category.Docs, NavLink{Title: entry.Metadata.Title, URL: entry.URL})
+		}
+		categories = append(categories, category)
+	}
+	return categories
+}
@@ -0,0 +1,110 @@
+package docgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// checkTimeout bounds how long a single snippet may run during -check
+const checkTimeout = 10 * time.Second
+
+// CollectRunnableBlocks returns every runnable snippet in a markdown document, in document order
+func CollectRunnableBlocks(markdown []byte) []RunnableCodeBlock {
+	doc := newMarkdown(Options{}).Parser().Parse(text.NewReader(markdown))
+
+	var blocks []RunnableCodeBlock
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if block, ok := n.(*RunnableCodeBlock); ok && entering {
+			blocks = append(blocks, *block)
+		}
+		return ast.WalkContinue, nil
+	})
+	return blocks
+}
+
+// CheckDocs runs every Python snippet that declares expected output with the
+// given interpreter and reports any whose actual output differs. Snippets
+// without expected output, non-Python snippets, and snippets flagged
+// "nocheck" are skipped.
+func CheckDocs(docsDir, python string) error {
+	checked, skipped := 0, 0
+	var failures []string
+
+	err := filepath.Walk(docsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".md" {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+
+		prior := "" // Earlier Python snippets, run first on cumulative pages
+		for _, block := range CollectRunnableBlocks(content) {
+			if block.Cumulative && block.Language == "Python" {
+				block.Setup = prior + block.Setup
+				prior = block.Setup + block.Code
+			}
+
+			_, noCheck := block.Flags[noCheckFlag]
+			if !block.HasExpected || noCheck || block.Language != "Python" {
+				skipped++
+				continue
+			}
+
+			checked++
+			if err := checkBlock(python, block); err != nil {
+				failures = append(failures, fmt.Sprintf("%s:%d: %v", path, block.Line, err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, failure := range failures {
+		fmt.Println("FAIL", failure)
+	}
+	fmt.Printf("Checked %d snippets, %d failed, %d skipped\n", checked, len(failures), skipped)
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d snippet(s) did not produce their expected output", len(failures))
+	}
+	return nil
+}
+
+// checkBlock runs one snippet and compares its output to the expected output.
+// Trailing newlines are ignored on both sides.
+func checkBlock(python string, block RunnableCodeBlock) error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, python, "-c", block.Setup+block.Code)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("snippet failed: %v\n%s", err, stderr.String())
+	}
+
+	actual := strings.TrimRight(stdout.String(), "\n")
+	expected := strings.TrimRight(block.Expected, "\n")
+	if actual != expected {
+		return fmt.Errorf("output mismatch\n--- expected\n%s\n--- actual\n%s", expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package docgen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectRunnableBlocks(t *testing.T) {
+	source := []byte("# Title\n\n```python-editor-text\nprint(1)\n# --- expected output ---\n# 1\n```\n\n```python\nnot runnable\n```\n\n```python-editor-graphics nocheck\nimport turtle\n```\n")
+
+	blocks := CollectRunnableBlocks(source)
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 runnable blocks, got %d", len(blocks))
+	}
+
+	if blocks[0].Code != "print(1)\n" || !blocks[0].HasExpected || blocks[0].Expected != "1\n" {
+		t.Errorf("Unexpected first block: code %q, expected %q (%v)", blocks[0].Code, blocks[0].Expected, blocks[0].HasExpected)
+	}
+	if blocks[0].Line != 3 {
+		t.Errorf("Expected first block on line 3, got %d", blocks[0].Line)
+	}
+	if _, ok := blocks[1].Flags[noCheckFlag]; !ok || blocks[1].Mode != "graphics" {
+		t.Errorf("Expected second block to be a nocheck graphics block, got %+v", blocks[1].Flags)
+	}
+}
+
+func TestCheckDocs(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not available")
+	}
+
+	docsDir := t.TempDir()
+	good := "```python-editor-text\nprint('hi')\n# --- expected output ---\n# hi\n```\n"
+	if err := os.WriteFile(filepath.Join(docsDir, "good.md"), []byte(good), 0644); err != nil {
+		t.Fatalf("Failed to write doc: %v", err)
+	}
+	if err := CheckDocs(docsDir, python); err != nil {
+		t.Errorf("Expected matching output to pass, got: %v", err)
+	}
+
+	bad := "```python-editor-text\nprint('bye')\n# --- expected output ---\n# hi\n```\n"
+	if err := os.WriteFile(filepath.Join(docsDir, "bad.md"), []byte(bad), 0644); err != nil {
+		t.Fatalf("Failed to write doc: %v", err)
+	}
+	if err := CheckDocs(docsDir, python); err == nil {
+		t.Error("Expected mismatched output to fail")
+	}
+}
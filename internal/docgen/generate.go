@@ -3,31 +3,111 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/zellyn/trifle/internal/docgen"
 )
 
 func main() {
-	// Paths are relative to project root
-	docsDir := "../../docs"
-	outputDir := "../../static/docs"
-	learnPage := "../../web/learn.html"
+	theme := flag.String("theme", docgen.DefaultHighlightStyle, "chroma style for syntax highlighting of ordinary code blocks")
+	force := flag.Bool("force", false, "regenerate every doc even if its output is up to date")
+	baseURL := flag.String("base-url", os.Getenv("DOCS_BASE_URL"), "absolute site URL for sitemap.xml and robots.txt, e.g. https://trifling.org (defaults to $DOCS_BASE_URL; neither file is written if empty)")
+	includeDrafts := flag.Bool("include-drafts", false, "generate and list docs marked draft: true, for previewing them")
+	strict := flag.Bool("strict", false, "fail if any doc links to a missing page or heading")
+	breadcrumbRoot := flag.String("breadcrumb-root", docgen.DefaultBreadcrumbRoot, "label of the first breadcrumb on each page, which links to the landing page")
+	breadcrumbSeparator := flag.String("breadcrumb-separator", docgen.DefaultBreadcrumbSeparator, "text shown between breadcrumbs")
+	themeConfig := flag.String("theme-config", "", "JSON file of colors and font for the docs, e.g. {\"primary\": \"#1b4332\", \"accent\": \"#2d6a4f\", \"font\": \"Georgia, serif\"}")
+	jobs := flag.Int("jobs", runtime.GOMAXPROCS(0), "how many docs to parse and render at once")
+	wpm := flag.Int("wpm", docgen.DefaultWordsPerMinute, "reading speed, in words per minute, for reading time estimates")
+	check := flag.Bool("check", false, "run snippets with expected output and fail if it differs, instead of generating")
+	python := flag.String("python", "python3", "Python interpreter used by -check")
+	watch := flag.Bool("watch", false, "after generating, keep regenerating whenever a markdown file changes")
+	// Defaults are relative to internal/docgen, where go generate runs
+	docsDir := flag.String("docs", "../../docs", "directory of markdown sources")
+	outputDir := flag.String("out", "../../static/docs", "directory for generated HTML")
+	learnPage := flag.String("learn", "../../web/learn.html", "path of the generated landing page")
+	webDir := flag.String("web", "../../web", "directory of the site's scripts and stylesheets, hashed in asset-manifest.json")
+	flag.Parse()
+
+	// A wrong -docs path would otherwise quietly generate nothing
+	if info, err := os.Stat(*docsDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: docs directory %s: %v\n", *docsDir, err)
+		os.Exit(1)
+	} else if !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: docs directory %s is not a directory\n", *docsDir)
+		os.Exit(1)
+	}
+
+	if *check {
+		fmt.Println("Checking documentation snippets...")
+		if err := docgen.CheckDocs(*docsDir, *python); err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking docs: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var siteTheme docgen.Theme
+	if *themeConfig != "" {
+		var err error
+		if siteTheme, err = docgen.LoadTheme(*themeConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	fmt.Println("Generating documentation...")
 
-	// Generate all documentation pages
-	if err := docgen.GenerateAllDocs(docsDir, outputDir); err != nil {
-		fmt.Fprintf(os.Stderr, "Error generating docs: %v\n", err)
+	opts := docgen.Options{HighlightStyle: *theme, Force: *force, BaseURL: *baseURL, IncludeDrafts: *includeDrafts, Strict: *strict, WordsPerMinute: *wpm,
+		BreadcrumbRoot: *breadcrumbRoot, BreadcrumbSeparator: *breadcrumbSeparator, Theme: siteTheme, Jobs: *jobs, WebDir: *webDir}
+	if err := generate(*docsDir, *outputDir, *learnPage, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Generate landing page
-	if err := docgen.GenerateLandingPage(learnPage); err != nil {
-		fmt.Fprintf(os.Stderr, "Error generating landing page: %v\n", err)
+	fmt.Println("Documentation generation complete!")
+
+	if !*watch {
+		return
+	}
+
+	// Stop cleanly on Ctrl-C so no watcher is left behind
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Only the first build is forced; after that, incremental builds regenerate
+	// just the changed docs, or everything if the sidebar changed
+	opts.Force = false
+
+	fmt.Printf("Watching %s for changes (Ctrl-C to stop)...\n", *docsDir)
+	err := docgen.Watch(ctx, *docsDir, func(changed []string) error {
+		fmt.Printf("%s: regenerating after changes to %s\n", time.Now().Format("15:04:05"), strings.Join(changed, ", "))
+		return generate(*docsDir, *outputDir, *learnPage, opts)
+	}, func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error watching docs: %v\n", err)
 		os.Exit(1)
 	}
+	fmt.Println("Stopped watching.")
+}
 
-	fmt.Println("Documentation generation complete!")
+// generate builds every documentation page and the landing page
+func generate(docsDir, outputDir, learnPage string, opts docgen.Options) error {
+	if err := docgen.GenerateAllDocs(docsDir, outputDir, opts); err != nil {
+		return fmt.Errorf("generating docs: %w", err)
+	}
+	if err := docgen.GenerateLandingPage(learnPage, docsDir, opts); err != nil {
+		return fmt.Errorf("generating landing page: %w", err)
+	}
+	return nil
 }
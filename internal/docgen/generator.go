@@ -6,9 +6,13 @@ import (
 	"bytes"
 	"fmt"
 	"html"
+	"html/template"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"unicode"
 
 	"github.com/yuin/goldmark"
 	meta "github.com/yuin/goldmark-meta"
@@ -18,13 +22,169 @@ import (
 	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
 	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
+	"github.com/zellyn/trifle/internal/kv"
 )
 
-// RunnableCodeBlock represents a Python code block that can be executed
+// SnippetMode describes one language/output combination for runnable snippets
+type SnippetMode struct {
+	Mode     string // data-mode attribute value read by snippet-runner.js
+	Label    string // Header label shown above the editor
+	Language string // Human-readable language name for accessibility labels
+}
+
+// SnippetModes maps fenced code block languages to runnable snippet modes.
+// Add an entry here to support a new language.
+var SnippetModes = map[string]SnippetMode{
+	"python-editor-text":     {Mode: "text", Label: "▶ Interactive Python", Language: "Python"},
+	"python-editor-graphics": {Mode: "graphics", Label: "🐢 Interactive Graphics", Language: "Python"},
+	"js-editor":              {Mode: "js", Label: "▶ Interactive JavaScript", Language: "JavaScript"},
+}
+
+// Section markers split a runnable snippet into hidden setup, visible code,
+// and expected output (used by -check, never rendered):
+//
+//	# --- setup ---
+//	import turtle
+//	# --- end setup ---
+//	print("hi")
+//	# --- expected output ---
+//	# hi
+const (
+	setupStartMarker     = "# --- setup ---"
+	setupEndMarker       = "# --- end setup ---"
+	expectedOutputMarker = "# --- expected output ---"
+)
+
+// noCheckFlag in a fence's info string (e.g. "python-editor-graphics nocheck")
+// excludes the snippet from -check
+const noCheckFlag = "nocheck"
+
+// highlightFlag in a fence's info string (e.g. "python-editor-graphics
+// {highlight=3,5-7}") picks out lines of the visible code for the editor to
+// highlight
+const highlightFlag = "highlight"
+
+// sessionFlag in a fence's info string (e.g. "python-editor-text
+// session=shapes") puts the snippet in a named Python session: snippets on a
+// page with the same session name run in one shared namespace, so later ones
+// can use what earlier ones defined. Without it, a snippet runs on its own.
+const sessionFlag = "session"
+
+// readOnlyFlag in a fence's info string (e.g. "python-editor-text readonly")
+// makes the snippet runnable but not editable
+const readOnlyFlag = "readonly"
+
+// nameFlag in a fence's info string (e.g. "python-editor-graphics
+// name=Square-Spiral") suggests a name for a trifle made from the snippet
+const nameFlag = "name"
+
+// copySnippetLanguage is the fence language for code readers should copy
+// but not run in the browser, such as shell commands for local setup
+const copySnippetLanguage = "copy-snippet"
+
+// langFlag in a copy-snippet fence's info string (e.g. "copy-snippet
+// lang=bash") names the code's language, for highlighting and the label
+const langFlag = "lang"
+
+// RunnableCodeBlock represents a code block that can be executed
 type RunnableCodeBlock struct {
 	ast.BaseBlock
-	Mode string // "text" or "graphics"
-	Code string
+	SnippetMode
+	Code        string            // Learner-facing code shown in the editor
+	Setup       string            // Hidden code run before Code, may be empty
+	Expected    string            // Expected output, if HasExpected
+	HasExpected bool              // Whether an expected output section was given
+	Flags       map[string]string // Extra info-string words: "flag" or "key=value"
+	Highlight   []int             // 1-based lines of Code to highlight, in order
+	ReadOnly    bool              // The learner can run the code but not edit it
+	Session     string            // Shared Python namespace name, if any
+	TrifleName  string            // Suggested name for a trifle made from the snippet, if any
+	Line        int               // 1-based line of the opening fence, for error messages
+	Index       int               // Position among the page's runnable snippets, in document order
+	Cumulative  bool              // Running this snippet also runs every earlier one on the page
+}
+
+// splitSections separates hidden setup and expected output from the visible code.
+// An unterminated setup section runs to the end of the block; expected output
+// always runs to the end of the block, with a leading "# " stripped from each line.
+func splitSections(source string) (code, setup, expected string, hasExpected bool) {
+	var codeBuf, setupBuf, expectedBuf strings.Builder
+	inSetup := false
+	for _, line := range strings.SplitAfter(source, "\n") {
+		if hasExpected {
+			line = strings.TrimPrefix(line, "#")
+			line = strings.TrimPrefix(line, " ")
+			expectedBuf.WriteString(line)
+			continue
+		}
+
+		switch strings.TrimSpace(line) {
+		case setupStartMarker:
+			inSetup = true
+			continue
+		case setupEndMarker:
+			inSetup = false
+			continue
+		case expectedOutputMarker:
+			hasExpected = true
+			continue
+		}
+		if inSetup {
+			setupBuf.WriteString(line)
+		} else {
+			codeBuf.WriteString(line)
+		}
+	}
+	return codeBuf.String(), setupBuf.String(), expectedBuf.String(), hasExpected
+}
+
+// infoWords splits a fence's info string into the language and flags. Flags
+// may be wrapped in braces, so "python-editor-text {highlight=3 nocheck}"
+// means the same as "python-editor-text highlight=3 nocheck".
+func infoWords(info string) []string {
+	return strings.FieldsFunc(info, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '{' || r == '}'
+	})
+}
+
+// isSet reports whether a boolean flag was given, as "flag" or "flag=true"
+// but not "flag=false"
+func isSet(flags map[string]string, name string) bool {
+	value, ok := flags[name]
+	return ok && value != "false"
+}
+
+// parseInfoFlags splits the words after the language in a fence's info string
+func parseInfoFlags(words []string) map[string]string {
+	flags := make(map[string]string)
+	for _, word := range words {
+		key, value, _ := strings.Cut(word, "=")
+		flags[key] = value
+	}
+	return flags
+}
+
+// sanitizeTrifleName makes a name= flag safe to use as a single key segment:
+// slashes, backslashes and null bytes become "-", other control characters
+// and ".." sequences are dropped, and a name kv.ValidateKey still rejects
+// is dropped altogether
+func sanitizeTrifleName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == 0:
+			return '-'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, name)
+	for strings.Contains(name, "..") {
+		name = strings.ReplaceAll(name, "..", "")
+	}
+	if kv.ValidateKey(name) != nil {
+		return ""
+	}
+	return name
 }
 
 // Dump implements ast.Node
@@ -37,19 +197,53 @@ func (n *RunnableCodeBlock) Kind() ast.NodeKind {
 	return ast.KindCodeBlock
 }
 
-// ASTTransformer transforms fenced code blocks with python-editor-* languages into RunnableCodeBlock nodes
+// CopyCodeBlock represents a code block that can be copied but not run
+type CopyCodeBlock struct {
+	ast.BaseBlock
+	Lang  string // Language to highlight the code as, may be empty
+	Label string // Header label naming the language
+	Code  string
+}
+
+// Dump implements ast.Node
+func (n *CopyCodeBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Kind implements ast.Node
+func (n *CopyCodeBlock) Kind() ast.NodeKind {
+	return ast.KindCodeBlock
+}
+
+// fencedCode returns the contents of a fenced code block
+func fencedCode(block *ast.FencedCodeBlock, source []byte) string {
+	var code strings.Builder
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+	return code.String()
+}
+
+// ASTTransformer transforms fenced code blocks with SnippetModes languages
+// into RunnableCodeBlock nodes, and copy-snippet blocks into CopyCodeBlock nodes
 type ASTTransformer struct{}
 
 func (t *ASTTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
 	// First pass: collect all nodes to transform
 	// We can't modify the tree while walking it, so we collect first
 	type replacement struct {
-		parent      ast.Node
-		oldNode     ast.Node
-		newNode     ast.Node
+		parent  ast.Node
+		oldNode ast.Node
+		newNode ast.Node
 	}
 	var replacements []replacement
 
+	// Pages with `cumulative: true` build one program up across their snippets
+	cumulative := metadataFromContext(pc).Cumulative
+	index := 0
+
 	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
 		if !entering {
 			return ast.WalkContinue, nil
@@ -65,33 +259,51 @@ func (t *ASTTransformer) Transform(node *ast.Document, reader text.Reader, pc pa
 			return ast.WalkContinue, nil
 		}
 
-		lang := string(fencedBlock.Info.Text(reader.Source()))
-		lang = strings.TrimSpace(lang)
+		// The first word is the language; any others are flags
+		info := infoWords(string(fencedBlock.Info.Text(reader.Source())))
+		if len(info) == 0 {
+			return ast.WalkContinue, nil
+		}
 
-		// Check if it's one of our special types
-		var mode string
-		if lang == "python-editor-text" {
-			mode = "text"
-		} else if lang == "python-editor-graphics" {
-			mode = "graphics"
-		} else {
-			// Not our special code block
+		// Copy-only snippets aren't runnable, so they don't count toward the index
+		if info[0] == copySnippetLanguage {
+			lang := parseInfoFlags(info[1:])[langFlag]
+			if parent := fencedBlock.Parent(); parent != nil {
+				replacements = append(replacements, replacement{
+					parent:  parent,
+					oldNode: fencedBlock,
+					newNode: &CopyCodeBlock{Lang: lang, Label: languageLabel(lang), Code: fencedCode(fencedBlock, reader.Source())},
+				})
+			}
 			return ast.WalkContinue, nil
 		}
 
-		// Extract the code from the fenced block
-		var code strings.Builder
-		lines := fencedBlock.Lines()
-		for i := 0; i < lines.Len(); i++ {
-			line := lines.At(i)
-			code.Write(line.Value(reader.Source()))
+		// Check if it's one of our special types
+		mode, ok := SnippetModes[info[0]]
+		if !ok {
+			// Not our special code block
+			return ast.WalkContinue, nil
 		}
 
-		// Create our custom node
+		// Create our custom node, pulling hidden sections out of the visible code
+		visibleCode, setup, expected, hasExpected := splitSections(fencedCode(fencedBlock, reader.Source()))
+		flags := parseInfoFlags(info[1:])
 		customNode := &RunnableCodeBlock{
-			Mode: mode,
-			Code: code.String(),
+			SnippetMode: mode,
+			Code:        visibleCode,
+			Setup:       setup,
+			Expected:    expected,
+			HasExpected: hasExpected,
+			Flags:       flags,
+			ReadOnly:    isSet(flags, readOnlyFlag),
+			Session:     flags[sessionFlag],
+			TrifleName:  sanitizeTrifleName(flags[nameFlag]),
+			Highlight:   parseLineRanges(flags[highlightFlag], strings.Count(strings.TrimSuffix(visibleCode, "\n"), "\n")+1),
+			Line:        bytes.Count(reader.Source()[:fencedBlock.Info.Segment.Start], []byte("\n")) + 1,
+			Index:       index,
+			Cumulative:  cumulative,
 		}
+		index++
 
 		// Store the replacement to be done later
 		parent := fencedBlock.Parent()
@@ -113,11 +325,17 @@ func (t *ASTTransformer) Transform(node *ast.Document, reader text.Reader, pc pa
 }
 
 // RunnableCodeBlockRenderer renders RunnableCodeBlock nodes
-type RunnableCodeBlockRenderer struct{}
+type RunnableCodeBlockRenderer struct {
+	// Style is the chroma style name used to highlight non-runnable code blocks.
+	// Empty means DefaultHighlightStyle.
+	Style string
+}
 
 // RegisterFuncs implements renderer.NodeRenderer
 func (r *RunnableCodeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(ast.KindCodeBlock, r.renderRunnableCodeBlock)
+	// Ordinary fenced blocks go through the same function so they get highlighted
+	reg.Register(ast.KindFencedCodeBlock, r.renderRunnableCodeBlock)
 }
 
 func (r *RunnableCodeBlockRenderer) renderRunnableCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
@@ -125,53 +343,60 @@ func (r *RunnableCodeBlockRenderer) renderRunnableCodeBlock(w util.BufWriter, so
 		return ast.WalkContinue, nil
 	}
 
+	if n, ok := node.(*CopyCodeBlock); ok {
+		r.renderCopyCodeBlock(w, n)
+		return ast.WalkContinue, nil
+	}
+
 	// Check if this is our custom node
 	n, ok := node.(*RunnableCodeBlock)
 	if !ok {
 		// Not our custom block, render as regular code block
 		if cb, ok := node.(*ast.FencedCodeBlock); ok {
-			w.WriteString("<pre><code")
+			lang := ""
 			if cb.Info != nil {
-				lang := string(cb.Info.Text(source))
-				if lang != "" {
-					w.WriteString(` class="language-`)
-					w.WriteString(html.EscapeString(lang))
-					w.WriteString(`"`)
-				}
+				lang = string(cb.Info.Text(source))
 			}
-			w.WriteString(">")
 
-			lines := cb.Lines()
-			for i := 0; i < lines.Len(); i++ {
-				line := lines.At(i)
-				w.Write(util.EscapeHTML(line.Value(source)))
+			// Fall back to plain output for unknown languages
+			code := fencedCode(cb, source)
+			if !r.writeHighlighted(w, lang, code) {
+				writePlainCodeBlock(w, lang, code)
 			}
-
-			w.WriteString("</code></pre>\n")
 		}
 		return ast.WalkContinue, nil
 	}
 
 	// Render our custom runnable code block
-	// Escape HTML entities and also escape newlines for data attribute
-	escapedCode := html.EscapeString(n.Code)
-	// Replace newlines with &#10; for proper data attribute encoding
-	escapedCode = strings.ReplaceAll(escapedCode, "\n", "&#10;")
-	// Also escape any literal backslashes to prevent issues
-	escapedCode = strings.ReplaceAll(escapedCode, "\r", "&#13;")
+	escapedCode := escapeDataAttr(n.Code)
 
-	w.WriteString(fmt.Sprintf(`<div class="runnable-snippet" data-mode="%s">`, n.Mode))
+	w.WriteString(fmt.Sprintf(`<div class="runnable-snippet" data-mode="%s"`, n.Mode))
+	if n.Setup != "" {
+		w.WriteString(fmt.Sprintf(` data-setup="%s"`, escapeDataAttr(n.Setup)))
+	}
+	if n.Cumulative {
+		w.WriteString(fmt.Sprintf(` data-cumulative="true" data-index="%d"`, n.Index))
+	}
+	if n.ReadOnly {
+		w.WriteString(` data-readonly="true"`)
+	}
+	if n.Session != "" {
+		w.WriteString(fmt.Sprintf(` data-session="%s"`, html.EscapeString(n.Session)))
+	}
+	if n.TrifleName != "" {
+		w.WriteString(fmt.Sprintf(` data-trifle-name="%s"`, html.EscapeString(n.TrifleName)))
+	}
+	if len(n.Highlight) > 0 {
+		w.WriteString(fmt.Sprintf(` data-highlight="%s"`, formatLineRanges(n.Highlight)))
+	}
+	w.WriteString(">")
 	w.WriteString(`<div class="snippet-header">`)
 	w.WriteString(`<span class="snippet-label">`)
-	if n.Mode == "graphics" {
-		w.WriteString(`🐢 Interactive Graphics`)
-	} else {
-		w.WriteString(`▶ Interactive Python`)
-	}
+	w.WriteString(html.EscapeString(n.Label))
 	w.WriteString(`</span>`)
 	w.WriteString(`<div class="snippet-controls">`)
 	w.WriteString(`<button class="copy-btn" title="Copy code" aria-label="Copy code to clipboard">📋</button>`)
-	w.WriteString(`<button class="run-btn" title="Run code" aria-label="Run Python code">▶ Run</button>`)
+	w.WriteString(fmt.Sprintf(`<button class="run-btn" title="Run code" aria-label="Run %s code">▶ Run</button>`, html.EscapeString(n.Language)))
 	w.WriteString(`<button class="make-trifle-btn" title="Save as trifle" aria-label="Save code as new trifle">💾 Make Trifle</button>`)
 	w.WriteString(`</div>`)
 	w.WriteString(`</div>`)
@@ -183,152 +408,470 @@ func (r *RunnableCodeBlockRenderer) renderRunnableCodeBlock(w util.BufWriter, so
 	return ast.WalkContinue, nil
 }
 
+// renderCopyCodeBlock renders a copy-only snippet: the runnable snippet's
+// header with just the copy button, over the code highlighted like any
+// ordinary block. The code is also kept in data-code so it's copied exactly.
+func (r *RunnableCodeBlockRenderer) renderCopyCodeBlock(w util.BufWriter, n *CopyCodeBlock) {
+	w.WriteString(fmt.Sprintf(`<div class="copy-snippet" data-code="%s">`, escapeDataAttr(n.Code)))
+	w.WriteString(`<div class="snippet-header">`)
+	w.WriteString(`<span class="snippet-label">`)
+	w.WriteString(html.EscapeString(n.Label))
+	w.WriteString(`</span>`)
+	w.WriteString(`<div class="snippet-controls">`)
+	w.WriteString(fmt.Sprintf(`<button class="copy-btn" title="Copy code" aria-label="Copy %s code to clipboard">📋</button>`, html.EscapeString(n.Label)))
+	w.WriteString(`</div>`)
+	w.WriteString(`</div>`)
+	if !r.writeHighlighted(w, n.Lang, n.Code) {
+		writePlainCodeBlock(w, n.Lang, n.Code)
+	}
+	w.WriteString(`</div>`)
+	w.WriteString("\n")
+}
+
+// escapeDataAttr escapes code for a data-* attribute so the frontend gets it back byte-for-byte
+func escapeDataAttr(code string) string {
+	// Escape HTML entities and also escape newlines for data attribute
+	escaped := html.EscapeString(code)
+	// Replace newlines with &#10; for proper data attribute encoding
+	escaped = strings.ReplaceAll(escaped, "\n", "&#10;")
+	// Also escape carriage returns so they survive attribute normalization
+	return strings.ReplaceAll(escaped, "\r", "&#13;")
+}
+
 // DocMetadata contains metadata from markdown frontmatter
 type DocMetadata struct {
 	Title       string
 	Description string
 	Category    string
 	Order       int
+	Cumulative  bool // Snippets run with all the snippets before them
+	Draft       bool // Unfinished: not generated, listed or put in the sitemap
+
+	// Computed from the content rather than the frontmatter
+	WordCount      int // Words of prose, not counting code
+	ReadingMinutes int // WordCount at Options.WordsPerMinute, rounded up
 }
 
-// GenerateDoc converts a single markdown file to HTML
-func GenerateDoc(inputPath, outputPath string) error {
-	// Read markdown file
-	content, err := os.ReadFile(inputPath)
-	if err != nil {
-		return fmt.Errorf("reading input file: %w", err)
+// Options configures documentation generation
+type Options struct {
+	// HighlightStyle is the chroma style for ordinary code blocks.
+	// Empty means DefaultHighlightStyle.
+	HighlightStyle string
+
+	// Force regenerates every doc even if its output is newer than its source
+	Force bool
+
+	// BaseURL is the site's absolute URL, e.g. "https://trifling.org", for
+	// sitemap.xml and robots.txt. Empty means neither is written.
+	BaseURL string
+
+	// IncludeDrafts generates and lists drafts like any other doc, for
+	// previewing them locally. They're still left out of the sitemap.
+	IncludeDrafts bool
+
+	// Strict fails generation if any doc links to a page or heading that
+	// doesn't exist, rather than just warning
+	Strict bool
+
+	// WordsPerMinute is the reading speed for reading time estimates.
+	// Zero means DefaultWordsPerMinute.
+	WordsPerMinute int
+
+	// BreadcrumbRoot labels the first breadcrumb on each page, which links
+	// to the landing page. Empty means DefaultBreadcrumbRoot.
+	BreadcrumbRoot string
+
+	// BreadcrumbSeparator goes between breadcrumbs. Empty means
+	// DefaultBreadcrumbSeparator.
+	BreadcrumbSeparator string
+
+	// Theme overrides the docs' colors and font. The zero value keeps the
+	// defaults in docs.css.
+	Theme Theme
+
+	// Jobs is how many docs are parsed or rendered at once. Zero means
+	// runtime.GOMAXPROCS(0).
+	Jobs int
+
+	// WebDir is the directory the site serves at "/", holding the scripts
+	// and stylesheets pages load, for hashing in the asset manifest. Empty
+	// means they're listed there without hashes.
+	WebDir string
+
+	// glossary is the site glossary, loaded from glossaryFile
+	glossary []glossaryEntry
+}
+
+// highlightStyle returns the configured style name, falling back to the default
+func (o Options) highlightStyle() string {
+	if o.HighlightStyle == "" {
+		return DefaultHighlightStyle
+	}
+	return o.HighlightStyle
+}
+
+// wordsPerMinute returns the configured reading speed, falling back to the default
+func (o Options) wordsPerMinute() int {
+	if o.WordsPerMinute <= 0 {
+		return DefaultWordsPerMinute
+	}
+	return o.WordsPerMinute
+}
+
+// jobs returns the configured parallelism, falling back to GOMAXPROCS
+func (o Options) jobs() int {
+	if o.Jobs <= 0 {
+		return runtime.GOMAXPROCS(0)
 	}
+	return o.Jobs
+}
+
+// breadcrumbRoot returns the configured root label, falling back to the default
+func (o Options) breadcrumbRoot() string {
+	if o.BreadcrumbRoot == "" {
+		return DefaultBreadcrumbRoot
+	}
+	return o.BreadcrumbRoot
+}
+
+// breadcrumbSeparator returns the configured separator, falling back to the default
+func (o Options) breadcrumbSeparator() string {
+	if o.BreadcrumbSeparator == "" {
+		return DefaultBreadcrumbSeparator
+	}
+	return o.BreadcrumbSeparator
+}
 
-	// Set up goldmark with our custom extensions
-	md := goldmark.New(
+// newMarkdown creates a goldmark instance with our custom extensions
+func newMarkdown(opts Options) goldmark.Markdown {
+	return goldmark.New(
 		goldmark.WithExtensions(
 			meta.Meta,
 		),
 		goldmark.WithParserOptions(
+			parser.WithBlockParsers(
+				util.Prioritized(&mathBlockParser{}, 150),
+				util.Prioritized(&videoBlockParser{}, 150),
+			),
+			parser.WithInlineParsers(
+				util.Prioritized(&mathInlineParser{}, 150),
+			),
+			parser.WithAutoHeadingID(), // Anchors for links to sections, see checkLinks
 			parser.WithASTTransformers(
 				util.Prioritized(&ASTTransformer{}, 100),
+				util.Prioritized(&MermaidTransformer{}, 150),
+				util.Prioritized(&AdmonitionTransformer{}, 200),
+				util.Prioritized(&HeadingLevelTransformer{}, 300),
+				util.Prioritized(&GlossaryTransformer{Entries: opts.glossary}, 400),
 			),
 		),
 		goldmark.WithRendererOptions(
 			goldmarkhtml.WithUnsafe(), // Allow raw HTML in markdown
 			renderer.WithNodeRenderers(
-				util.Prioritized(&RunnableCodeBlockRenderer{}, 100),
+				util.Prioritized(&RunnableCodeBlockRenderer{Style: opts.highlightStyle()}, 100),
+				util.Prioritized(&AdmonitionRenderer{}, 100),
+				util.Prioritized(&MathRenderer{}, 100),
+				util.Prioritized(&GlossaryTermRenderer{}, 100),
+				util.Prioritized(&VideoRenderer{}, 100),
+				util.Prioritized(&MermaidRenderer{}, 100),
 			),
 		),
 	)
+}
 
-	// Parse markdown
-	var buf bytes.Buffer
-	ctx := parser.NewContext()
-	if err := md.Convert(content, &buf, parser.WithContext(ctx)); err != nil {
-		return fmt.Errorf("converting markdown: %w", err)
+// metadataFromContext extracts frontmatter fields from a parser context
+func metadataFromContext(ctx parser.Context) DocMetadata {
+	metadata := meta.Get(ctx)
+	doc := DocMetadata{Title: "Documentation"}
+
+	if titleStr, ok := metadata["title"].(string); ok {
+		doc.Title = titleStr
+	}
+	if descStr, ok := metadata["description"].(string); ok {
+		doc.Description = descStr
+	}
+	if categoryStr, ok := metadata["category"].(string); ok {
+		doc.Category = categoryStr
+	}
+	if order, ok := metadata["order"].(int); ok {
+		doc.Order = order
+	}
+	if cumulative, ok := metadata["cumulative"].(bool); ok {
+		doc.Cumulative = cumulative
+	}
+	if draft, ok := metadata["draft"].(bool); ok {
+		doc.Draft = draft
 	}
 
-	// Extract metadata
-	metadata := meta.Get(ctx)
-	title := "Documentation"
-	description := ""
+	return doc
+}
 
-	if titleVal, ok := metadata["title"]; ok {
-		if titleStr, ok := titleVal.(string); ok {
-			title = titleStr
-		}
+// docMetadata returns a parsed document's frontmatter along with its word
+// count and reading time
+func docMetadata(ctx parser.Context, doc ast.Node, source []byte, opts Options) DocMetadata {
+	metadata := metadataFromContext(ctx)
+	metadata.WordCount = countWords(doc, source)
+	metadata.ReadingMinutes = readingMinutes(metadata.WordCount, opts.wordsPerMinute())
+	return metadata
+}
+
+// ReadDocMetadata parses the frontmatter of a markdown file and estimates its
+// reading time at DefaultWordsPerMinute
+func ReadDocMetadata(inputPath string) (DocMetadata, error) {
+	entry, err := readDoc(inputPath, Options{})
+	return entry.Metadata, err
+}
+
+// readDoc parses a markdown file without rendering it, returning an entry
+// with its metadata, searchable plain text, links and anchors filled in
+func readDoc(inputPath string, opts Options) (docEntry, error) {
+	content, err := os.ReadFile(inputPath)
+	if err != nil {
+		return docEntry{}, fmt.Errorf("reading input file: %w", err)
 	}
 
-	if descVal, ok := metadata["description"]; ok {
-		if descStr, ok := descVal.(string); ok {
-			description = descStr
+	ctx := parser.NewContext()
+	doc := newMarkdown(opts).Parser().Parse(text.NewReader(content), parser.WithContext(ctx))
+	return docEntry{
+		Metadata:   docMetadata(ctx, doc, content, opts),
+		SearchText: extractSearchText(doc, content),
+		Links:      collectLinks(doc),
+		Anchors:    collectAnchors(doc),
+		InputPath:  inputPath,
+	}, nil
+}
+
+// GenerateDoc converts a single markdown file to HTML.
+// The sidebar lists only this document; use GenerateAllDocs for the full site.
+func GenerateDoc(inputPath, outputPath string, opts Options) error {
+	highlightCSS, err := HighlightCSS(opts.highlightStyle())
+	if err != nil {
+		return err
+	}
+	if opts.glossary, err = loadGlossary(filepath.Join(filepath.Dir(inputPath), glossaryFile)); err != nil {
+		return err
+	}
+	entry, err := readDoc(inputPath, opts)
+	if err != nil {
+		return err
+	}
+	metadata := entry.Metadata
+	sidebar := renderSidebar([]docEntry{{Metadata: metadata, URL: docURL(filepath.Base(inputPath))}})
+	return generateDoc(inputPath, outputPath, sidebar, highlightCSS, nil, nil, opts)
+}
+
+// containsKind reports whether doc contains a node of one of kinds, so pages
+// only load the scripts their content needs
+func containsKind(doc ast.Node, kinds ...ast.NodeKind) bool {
+	found := false
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && slices.Contains(kinds, n.Kind()) {
+			found = true
+			return ast.WalkStop, nil
 		}
+		return ast.WalkContinue, nil
+	})
+	return found
+}
+
+// generateDoc converts a single markdown file to HTML using a prebuilt sidebar
+// and stylesheet, linking to prev and next if they aren't nil
+func generateDoc(inputPath, outputPath, sidebar, highlightCSS string, prev, next *NavLink, opts Options) error {
+	// Read markdown file
+	content, err := os.ReadFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("reading input file: %w", err)
 	}
 
+	// The glossary page would otherwise define its terms in terms of each other
+	if filepath.Base(inputPath) == glossaryFile {
+		opts.glossary = nil
+	}
+
+	// Parse markdown
+	var buf bytes.Buffer
+	ctx := parser.NewContext()
+	md := newMarkdown(opts)
+	doc := md.Parser().Parse(text.NewReader(content), parser.WithContext(ctx))
+	if err := md.Renderer().Render(&buf, content, doc); err != nil {
+		return fmt.Errorf("converting markdown: %w", err)
+	}
+
+	// Extract metadata
+	metadata := docMetadata(ctx, doc, content, opts)
+
 	// Generate full HTML page
-	htmlContent := generateHTMLPage(title, description, buf.String())
+	htmlContent, err := renderPage(PageData{
+		Title:        metadata.Title,
+		Description:  metadata.Description,
+		ReadingTime:  metadata.ReadingTime(),
+		WordCount:    metadata.WordCount,
+		Draft:        metadata.Draft,
+		Breadcrumbs:  breadcrumbs(metadata, opts),
+		Separator:    opts.breadcrumbSeparator(),
+		Prev:         prev,
+		Next:         next,
+		Sidebar:      template.HTML(sidebar),
+		HighlightCSS: template.CSS(highlightCSS),
+		ThemeCSS:     themeCSS(opts.Theme),
+		Body:         template.HTML(buf.String()),
+		HasMath:      containsKind(doc, KindMath, KindMathBlock),
+		HasMermaid:   containsKind(doc, KindMermaid),
+	})
+	if err != nil {
+		return err
+	}
 
 	// Write output file
-	if err := os.WriteFile(outputPath, []byte(htmlContent), 0644); err != nil {
+	if err := os.WriteFile(outputPath, htmlContent, 0644); err != nil {
 		return fmt.Errorf("writing output file: %w", err)
 	}
 
 	return nil
 }
 
-// generateHTMLPage creates a complete HTML page with the converted content
-func generateHTMLPage(title, description, bodyContent string) string {
-	return fmt.Sprintf(`<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>%s - Trifling Documentation</title>
-    <meta name="description" content="%s">
-    <link rel="stylesheet" href="/css/app.css">
-    <link rel="stylesheet" href="/css/docs.css">
-</head>
-<body>
-    <header class="app-header">
-        <nav class="nav-container">
-            <a href="/" class="logo">Trifling</a>
-            <div class="nav-links">
-                <a href="/">Home</a>
-                <a href="/learn.html" class="active">Learn</a>
-                <a href="/about.html">About</a>
-            </div>
-            <div class="nav-auth" id="nav-auth"></div>
-        </nav>
-    </header>
-
-    <div class="docs-container">
-        <aside class="docs-sidebar">
-            <h2>Documentation</h2>
-            <nav class="docs-nav">
-                <div class="docs-category">
-                    <h3>Getting Started</h3>
-                    <a href="/static/docs/intro.html">Introduction</a>
-                </div>
-                <div class="docs-category">
-                    <h3>Graphics</h3>
-                    <a href="/static/docs/turtle.html">Turtle Graphics</a>
-                    <a href="/static/docs/canvas.html">Canvas API</a>
-                </div>
-                <div class="docs-category">
-                    <h3>Advanced</h3>
-                    <a href="/static/docs/imports.html">Trifle Imports</a>
-                </div>
-            </nav>
-        </aside>
-
-        <main class="docs-content">
-            <article class="doc-article">
-                %s
-            </article>
-        </main>
-    </div>
-
-    <script src="/js/terminal.js"></script>
-    <script type="module" src="/js/snippet-runner.js"></script>
-    <script>
-        // Register service worker for offline support
-        if ('serviceWorker' in navigator) {
-            window.addEventListener('load', () => {
-                navigator.serviceWorker.register('/sw.js')
-                    .then(reg => console.log('Service Worker registered'))
-                    .catch(err => console.error('Service Worker registration failed:', err));
-            });
-        }
-    </script>
-</body>
-</html>`, html.EscapeString(title), html.EscapeString(description), bodyContent)
-}
-
 // GenerateAllDocs processes all markdown files in docs/ directory
-func GenerateAllDocs(docsDir, outputDir string) error {
+func GenerateAllDocs(docsDir, outputDir string, opts Options) error {
+	// Validate the highlight style up front so a typo fails before any output is written
+	highlightCSS, err := HighlightCSS(opts.highlightStyle())
+	if err != nil {
+		return err
+	}
+
 	// Ensure output directory exists
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("creating output directory: %w", err)
 	}
 
-	// Walk through docs directory
-	return filepath.Walk(docsDir, func(path string, info os.FileInfo, err error) error {
+	if opts.glossary, err = loadGlossary(filepath.Join(docsDir, glossaryFile)); err != nil {
+		return err
+	}
+
+	// First pass: collect metadata from every document for the sidebar and
+	// prev/next links, which need the whole set
+	entries, err := collectDocs(docsDir, outputDir, opts)
+	if err != nil {
+		return err
+	}
+
+	// Drafts aren't published at all, so remove any output left from
+	// before a doc was marked as one
+	var drafts []docEntry
+	entries, drafts = publishedDocs(entries, opts)
+	for _, draft := range drafts {
+		fmt.Printf("Skipping draft %s\n", draft.InputPath)
+		if err := os.Remove(draft.OutputPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing draft output: %w", err)
+		}
+	}
+	entries = orderDocs(entries)
+
+	// Check links before writing anything, so a strict build fails cleanly
+	if broken := checkLinks(entries); len(broken) > 0 {
+		if opts.Strict {
+			return fmt.Errorf("%d broken links:\n  %s", len(broken), strings.Join(broken, "\n  "))
+		}
+		for _, link := range broken {
+			fmt.Fprintf(os.Stderr, "Warning: broken link: %s\n", link)
+		}
+	}
+
+	// The sidebar lists every page in order, so it changes whenever any
+	// page's neighbors do and the stamp below covers prev/next links too
+	sidebar := renderSidebar(entries)
+
+	// Anything shared by every page (template, sidebar, stylesheets,
+	// glossary, breadcrumb labels) invalidates all output
+	stampPath := filepath.Join(outputDir, buildStampFile)
+	stamp := buildStamp(sidebar, highlightCSS, string(themeCSS(opts.Theme)), glossaryStamp(opts.glossary), opts.breadcrumbRoot(), opts.breadcrumbSeparator())
+	fullRebuild := opts.Force || readBuildStamp(stampPath) != stamp
+
+	// Second pass: render each out of date document with the shared sidebar.
+	// Documents are independent now, so they're rendered in parallel.
+	searchEntries := []SearchEntry{}
+	var stale []int
+	for i, entry := range entries {
+		if searchable(entry) {
+			searchEntries = append(searchEntries, SearchEntry{
+				Title:    entry.Metadata.Title,
+				URL:      entry.URL,
+				Category: entry.Metadata.Category,
+				Content:  entry.SearchText,
+			})
+		}
+		if fullRebuild || !isUpToDate(entry.InputPath, entry.OutputPath) {
+			stale = append(stale, i)
+		}
+	}
+
+	written := make([]bool, len(stale))
+	err = forEach(len(stale), opts.jobs(), func(j int) error {
+		i := stale[j]
+		entry := entries[i]
+
+		// Ensure output subdirectory exists
+		outputSubdir := filepath.Dir(entry.OutputPath)
+		if err := os.MkdirAll(outputSubdir, 0755); err != nil {
+			return fmt.Errorf("creating output subdirectory: %w", err)
+		}
+
+		var prev, next *NavLink
+		if i > 0 {
+			prev = &NavLink{Title: entries[i-1].Metadata.Title, URL: entries[i-1].URL}
+		}
+		if i < len(entries)-1 {
+			next = &NavLink{Title: entries[i+1].Metadata.Title, URL: entries[i+1].URL}
+		}
+		if err := generateDoc(entry.InputPath, entry.OutputPath, sidebar, highlightCSS, prev, next, opts); err != nil {
+			return fmt.Errorf("generating %s: %w", entry.InputPath, err)
+		}
+		written[j] = true
+		return nil
+	})
+
+	// Log in reading order, whatever order the docs finished in
+	for j, i := range stale {
+		if written[j] {
+			fmt.Printf("Generated %s -> %s\n", entries[i].InputPath, entries[i].OutputPath)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	generated, skipped := len(stale), len(entries)-len(stale)
+
+	if err := writeSearchIndex(filepath.Join(outputDir, searchIndexFile), searchEntries); err != nil {
+		return err
+	}
+	if err := writeManifest(filepath.Join(outputDir, manifestFile), outputDir, entries); err != nil {
+		return err
+	}
+	if err := writeAssetManifest(filepath.Join(outputDir, assetManifestFile), opts.WebDir, entries); err != nil {
+		return err
+	}
+	if opts.BaseURL != "" {
+		listed, _ := publishedDocs(entries, Options{})
+		if err := writeSitemap(filepath.Join(outputDir, sitemapFile), opts.BaseURL, listed); err != nil {
+			return err
+		}
+		if err := writeRobots(filepath.Join(outputDir, robotsFile), opts.BaseURL); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(stampPath, []byte(stamp), 0644); err != nil {
+		return fmt.Errorf("writing build stamp: %w", err)
+	}
+
+	fmt.Printf("Generated %d docs, skipped %d unchanged\n", generated, skipped)
+	return nil
+}
+
+// collectDocs reads the metadata and search text of every markdown file under
+// docsDir, whose HTML goes under outputDir. Files are listed first and then
+// parsed in parallel; entries are in the order they were listed.
+func collectDocs(docsDir, outputDir string, opts Options) ([]docEntry, error) {
+	var entries []docEntry
+	err := filepath.Walk(docsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -349,79 +892,41 @@ func GenerateAllDocs(docsDir, outputDir string) error {
 			return fmt.Errorf("calculating relative path: %w", err)
 		}
 
-		outputPath := filepath.Join(outputDir, strings.TrimSuffix(relPath, ".md")+".html")
+		entries = append(entries, docEntry{
+			URL:        docURL(relPath),
+			InputPath:  path,
+			OutputPath: filepath.Join(outputDir, strings.TrimSuffix(relPath, ".md")+".html"),
+			ModTime:    info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// Ensure output subdirectory exists
-		outputSubdir := filepath.Dir(outputPath)
-		if err := os.MkdirAll(outputSubdir, 0755); err != nil {
-			return fmt.Errorf("creating output subdirectory: %w", err)
+	err = forEach(len(entries), opts.jobs(), func(i int) error {
+		parsed, err := readDoc(entries[i].InputPath, opts)
+		if err != nil {
+			return err
 		}
-
-		fmt.Printf("Generating %s -> %s\n", path, outputPath)
-		return GenerateDoc(path, outputPath)
+		entries[i].Metadata = parsed.Metadata
+		entries[i].SearchText = parsed.SearchText
+		entries[i].Links = parsed.Links
+		entries[i].Anchors = parsed.Anchors
+		return nil
 	})
+	return entries, err
 }
 
-// GenerateLandingPage creates the main /learn.html page
-func GenerateLandingPage(outputPath string) error {
-	content := `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Learn Python - Trifling Documentation</title>
-    <meta name="description" content="Interactive Python tutorials and documentation for Trifling">
-    <link rel="stylesheet" href="/css/app.css">
-    <link rel="stylesheet" href="/css/docs.css">
-</head>
-<body>
-    <header class="app-header">
-        <nav class="nav-container">
-            <a href="/" class="logo">Trifling</a>
-            <div class="nav-links">
-                <a href="/">Home</a>
-                <a href="/learn.html" class="active">Learn</a>
-                <a href="/about.html">About</a>
-            </div>
-            <div class="nav-auth" id="nav-auth"></div>
-        </nav>
-    </header>
-
-    <div class="docs-landing">
-        <div class="docs-hero">
-            <h1>Learn Python with Trifling</h1>
-            <p>Interactive tutorials with runnable code examples. No setup required.</p>
-        </div>
-
-        <div class="docs-grid">
-            <a href="/static/docs/intro.html" class="doc-card">
-                <h2>🚀 Getting Started</h2>
-                <p>Learn the basics of Python programming right in your browser.</p>
-            </a>
-
-            <a href="/static/docs/turtle.html" class="doc-card">
-                <h2>🐢 Turtle Graphics</h2>
-                <p>Create beautiful drawings and animations with turtle graphics.</p>
-            </a>
-
-            <a href="/static/docs/canvas.html" class="doc-card">
-                <h2>🎨 Canvas API</h2>
-                <p>Draw directly on the canvas with shapes, colors, and images.</p>
-            </a>
-
-            <a href="/static/docs/imports.html" class="doc-card">
-                <h2>🔗 Trifle Imports</h2>
-                <p>Share code between trifles with the import system.</p>
-            </a>
-        </div>
-    </div>
-
-    <script type="module">
-        import { initAuth } from '/js/app.js';
-        initAuth();
-    </script>
-</body>
-</html>`
-
-	return os.WriteFile(outputPath, []byte(content), 0644)
+// publishedDocs splits entries into those to publish and drafts to skip,
+// which is none of them if opts.IncludeDrafts is set
+func publishedDocs(entries []docEntry, opts Options) (published, skipped []docEntry) {
+	for _, entry := range entries {
+		if entry.Metadata.Draft && !opts.IncludeDrafts {
+			skipped = append(skipped, entry)
+		} else {
+			published = append(published, entry)
+		}
+	}
+	return published, skipped
 }
@@ -0,0 +1,335 @@
+package docgen
+
+import (
+	"bytes"
+	"html"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunnableCodeBlock_HiddenSetup(t *testing.T) {
+	source := []byte("```python-editor-graphics\n# --- setup ---\nimport turtle\nt = turtle.Turtle()\n# --- end setup ---\nt.forward(100)\n```\n")
+
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert(source, &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	setup := regexp.MustCompile(`data-setup="([^"]*)"`).FindStringSubmatch(buf.String())
+	code := regexp.MustCompile(`data-code="([^"]*)"`).FindStringSubmatch(buf.String())
+	if setup == nil || code == nil {
+		t.Fatalf("Expected data-setup and data-code attributes, got:\n%s", buf.String())
+	}
+
+	if got := html.UnescapeString(setup[1]); got != "import turtle\nt = turtle.Turtle()\n" {
+		t.Errorf("Unexpected setup: %q", got)
+	}
+	if got := html.UnescapeString(code[1]); got != "t.forward(100)\n" {
+		t.Errorf("Unexpected visible code: %q", got)
+	}
+}
+
+func TestRunnableCodeBlock_CumulativeIndices(t *testing.T) {
+	snippet := "```python-editor-text\nprint(1)\n```\n\nSome prose.\n\n"
+	source := []byte("---\ntitle: Building Up\ncumulative: true\n---\n\n" + snippet + snippet + "> quoted\n>\n> " + strings.ReplaceAll(snippet, "\n", "\n> ") + "\n" + snippet)
+
+	blocks := CollectRunnableBlocks(source)
+	if len(blocks) != 4 {
+		t.Fatalf("Expected 4 snippets, got %d", len(blocks))
+	}
+	for i, block := range blocks {
+		if !block.Cumulative {
+			t.Errorf("Snippet %d: expected cumulative", i)
+		}
+		if block.Index != i {
+			t.Errorf("Snippet %d: expected index %d, got %d", i, i, block.Index)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert(source, &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	indices := regexp.MustCompile(`data-cumulative="true" data-index="(\d+)"`).FindAllStringSubmatch(buf.String(), -1)
+	if len(indices) != 4 {
+		t.Fatalf("Expected 4 cumulative markers, got %d:\n%s", len(indices), buf.String())
+	}
+	for i, match := range indices {
+		if match[1] != strconv.Itoa(i) {
+			t.Errorf("Marker %d has index %s", i, match[1])
+		}
+	}
+}
+
+func TestRunnableCodeBlock_NotCumulativeByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert([]byte("```python-editor-text\nprint(1)\n```\n"), &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "data-cumulative") {
+		t.Errorf("Expected no cumulative marker, got:\n%s", buf.String())
+	}
+}
+
+func TestGenerateAllDocs_PrevNext(t *testing.T) {
+	docsDir, outputDir := t.TempDir(), t.TempDir()
+	docs := map[string]string{
+		// Categories are ordered by their lowest doc order, so Graphics comes first
+		"loops.md":  "---\ntitle: Loops\ncategory: Basics\norder: 2\n---\nLoops.\n",
+		"intro.md":  "---\ntitle: Intro\ncategory: Basics\norder: 1\n---\nHello.\n",
+		"turtle.md": "---\ntitle: Turtle\ncategory: Graphics\norder: 0\n---\nDraw.\n",
+	}
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(docsDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+
+	prev := regexp.MustCompile(`<a href="([^"]*)" class="doc-pager-prev"`)
+	next := regexp.MustCompile(`<a href="([^"]*)" class="doc-pager-next"`)
+	tests := []struct{ page, prev, next string }{
+		{"turtle.html", "", "/static/docs/intro.html"},
+		{"intro.html", "/static/docs/turtle.html", "/static/docs/loops.html"},
+		{"loops.html", "/static/docs/intro.html", ""},
+	}
+	for _, tt := range tests {
+		content, err := os.ReadFile(filepath.Join(outputDir, tt.page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		link := func(re *regexp.Regexp) string {
+			if m := re.FindSubmatch(content); m != nil {
+				return string(m[1])
+			}
+			return ""
+		}
+		if got := link(prev); got != tt.prev {
+			t.Errorf("%s: prev = %q, want %q", tt.page, got, tt.prev)
+		}
+		if got := link(next); got != tt.next {
+			t.Errorf("%s: next = %q, want %q", tt.page, got, tt.next)
+		}
+	}
+}
+
+func TestRunnableCodeBlock_Highlight(t *testing.T) {
+	code := "# --- setup ---\nimport turtle\n# --- end setup ---\nt = turtle.Turtle()\nt.forward(100)\nt.left(90)\nt.forward(100)\n"
+	tests := []struct{ info, want string }{
+		{"python-editor-graphics {highlight=1,3-4}", ` data-highlight="1,3-4"`},
+		{"python-editor-graphics{highlight=2 nocheck}", ` data-highlight="2"`},
+		{"python-editor-graphics highlight=4-9", ` data-highlight="4"`},
+		{"python-editor-graphics {highlight=oops}", ""},
+		{"python-editor-graphics", ""},
+	}
+	for _, tt := range tests {
+		source := []byte("```" + tt.info + "\n" + code + "```\n")
+		var buf bytes.Buffer
+		if err := newMarkdown(Options{}).Convert(source, &buf); err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		out := buf.String()
+		if !strings.Contains(out, `<div class="runnable-snippet" data-mode="graphics"`) {
+			t.Errorf("%q: expected a runnable snippet, got:\n%s", tt.info, out)
+		}
+		got := regexp.MustCompile(` data-highlight="[^"]*"`).FindString(out)
+		if got != tt.want {
+			t.Errorf("%q: highlight attribute = %q, want %q", tt.info, got, tt.want)
+		}
+	}
+}
+
+func TestRunnableCodeBlock_ReadOnly(t *testing.T) {
+	tests := []struct {
+		info     string
+		readOnly bool
+	}{
+		{"python-editor-text", false},
+		{"python-editor-text readonly", true},
+		{"python-editor-text {readonly highlight=1}", true},
+		{"python-editor-text readonly=false", false},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := newMarkdown(Options{}).Convert([]byte("```"+tt.info+"\nprint(1)\n```\n"), &buf); err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		out := buf.String()
+		if got := strings.Contains(out, ` data-readonly="true"`); got != tt.readOnly {
+			t.Errorf("%q: read-only = %v, want %v:\n%s", tt.info, got, tt.readOnly, out)
+		}
+		// Read-only snippets can still be run and copied
+		for _, button := range []string{`class="run-btn"`, `class="copy-btn"`} {
+			if !strings.Contains(out, button) {
+				t.Errorf("%q: expected %s", tt.info, button)
+			}
+		}
+	}
+}
+
+func TestCopyCodeBlock(t *testing.T) {
+	source := "```copy-snippet {lang=sh}\ngo run . <&2 \"$HOME\"\n```\n\n" +
+		"```copy-snippet lang=nosuchlang\nplain\n```\n\n" +
+		"```python-editor-text\nprint(\"<hi>\")\n```\n"
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert([]byte(source), &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	out := buf.String()
+
+	copySnippets := regexp.MustCompile(`(?s)<div class="copy-snippet".*?</pre>\s*</div>`).FindAllString(out, -1)
+	if len(copySnippets) != 2 {
+		t.Fatalf("Expected 2 copy snippets, got %d:\n%s", len(copySnippets), out)
+	}
+	for _, snippet := range copySnippets {
+		if !strings.Contains(snippet, `class="copy-btn"`) {
+			t.Errorf("Copy snippet has no copy button:\n%s", snippet)
+		}
+		for _, button := range []string{"run-btn", "make-trifle-btn"} {
+			if strings.Contains(snippet, button) {
+				t.Errorf("Copy snippet has a %s:\n%s", button, snippet)
+			}
+		}
+	}
+	if !strings.Contains(copySnippets[0], `data-code="go run . &lt;&amp;2 &#34;$HOME&#34;&#10;"`) {
+		t.Errorf("Expected the code byte-for-byte in data-code:\n%s", copySnippets[0])
+	}
+	if !strings.Contains(copySnippets[0], `<span class="snippet-label">Bash</span>`) || !strings.Contains(copySnippets[0], `class="chroma"`) {
+		t.Errorf("Expected a highlighted snippet labelled Bash:\n%s", copySnippets[0])
+	}
+	if !strings.Contains(copySnippets[1], `<span class="snippet-label">nosuchlang</span>`) || !strings.Contains(copySnippets[1], `<pre><code class="language-nosuchlang">plain`) {
+		t.Errorf("Expected a plain snippet labelled with its language:\n%s", copySnippets[1])
+	}
+
+	// Runnable snippets render exactly as before
+	want := `<div class="runnable-snippet" data-mode="text"><div class="snippet-header"><span class="snippet-label">▶ Interactive Python</span>` +
+		`<div class="snippet-controls"><button class="copy-btn" title="Copy code" aria-label="Copy code to clipboard">📋</button>` +
+		`<button class="run-btn" title="Run code" aria-label="Run Python code">▶ Run</button>` +
+		`<button class="make-trifle-btn" title="Save as trifle" aria-label="Save code as new trifle">💾 Make Trifle</button></div></div>` +
+		`<div class="snippet-code" data-code="print(&#34;&lt;hi&gt;&#34;)&#10;"></div><div class="snippet-output"></div></div>` + "\n"
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("Runnable snippet changed, got:\n%s", out)
+	}
+
+	// Nor are copy snippets collected for -check or counted in the index
+	if blocks := CollectRunnableBlocks([]byte(source)); len(blocks) != 1 || blocks[0].Index != 0 {
+		t.Errorf("Expected just the Python snippet at index 0, got %+v", blocks)
+	}
+}
+
+func TestRunnableCodeBlock_Sessions(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("testdata", "sessions.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert(source, &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	snippets := regexp.MustCompile(`<div class="runnable-snippet"[^>]*>`).FindAllString(buf.String(), -1)
+	want := []string{
+		`<div class="runnable-snippet" data-mode="text" data-session="intro">`,
+		`<div class="runnable-snippet" data-mode="text" data-readonly="true" data-session="intro">`,
+		`<div class="runnable-snippet" data-mode="text" data-session="a&amp;b">`,
+		`<div class="runnable-snippet" data-mode="text">`,
+	}
+	if len(snippets) != len(want) {
+		t.Fatalf("Expected %d snippets, got %d:\n%s", len(want), len(snippets), buf.String())
+	}
+	for i := range want {
+		if snippets[i] != want[i] {
+			t.Errorf("Snippet %d = %s, want %s", i, snippets[i], want[i])
+		}
+	}
+}
+
+func TestRunnableCodeBlock_TrifleNames(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("testdata", "trifle-names.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert(source, &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	snippets := regexp.MustCompile(`<div class="runnable-snippet"[^>]*>`).FindAllString(buf.String(), -1)
+	want := []string{"Square-Spiral", "Tom&Jerry's<1>", "-etc-passwd", "", ""}
+	if len(snippets) != len(want) {
+		t.Fatalf("Expected %d snippets, got %d:\n%s", len(want), len(snippets), buf.String())
+	}
+	nameAttr := regexp.MustCompile(` data-trifle-name="([^"]*)"`)
+	for i, snippet := range snippets {
+		got := ""
+		if match := nameAttr.FindStringSubmatch(snippet); match != nil {
+			got = html.UnescapeString(match[1])
+		}
+		if got != want[i] {
+			t.Errorf("Snippet %d has trifle name %q, want %q: %s", i, got, want[i], snippet)
+		}
+	}
+	if !strings.Contains(snippets[1], `data-trifle-name="Tom&amp;Jerry&#39;s&lt;1&gt;"`) {
+		t.Errorf("Expected the name HTML-escaped, got %s", snippets[1])
+	}
+}
+
+func TestGenerateAllDocs_Breadcrumbs(t *testing.T) {
+	docsDir, outputDir := t.TempDir(), t.TempDir()
+	docs := map[string]string{
+		"turtle.md": "---\ntitle: Turtle & Friends\ncategory: Graphics Fun\n---\nDraw.\n",
+		"misc.md":   "---\ntitle: Misc\n---\nHi.\n",
+	}
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(docsDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := GenerateAllDocs(docsDir, outputDir, Options{BreadcrumbRoot: "Learn", BreadcrumbSeparator: "›"}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+
+	separator := `<span class="doc-breadcrumb-separator" aria-hidden="true">›</span> `
+	tests := []struct {
+		page  string
+		items []string
+	}{
+		{"turtle.html", []string{
+			`<a href="/learn.html">Learn</a>`,
+			separator + `<a href="/learn.html#category-graphics-fun">Graphics Fun</a>`,
+			separator + `<span aria-current="page">Turtle &amp; Friends</span>`,
+		}},
+		{"misc.html", []string{
+			`<a href="/learn.html">Learn</a>`,
+			separator + `<span aria-current="page">Misc</span>`,
+		}},
+	}
+	item := regexp.MustCompile(`<li>(.*)</li>`)
+	for _, tt := range tests {
+		content, err := os.ReadFile(filepath.Join(outputDir, tt.page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := string(content)
+		start := strings.Index(page, `<nav class="doc-breadcrumbs" aria-label="Breadcrumb">`)
+		if start < 0 {
+			t.Fatalf("%s: no breadcrumbs", tt.page)
+		}
+		nav := page[start : start+strings.Index(page[start:], "</nav>")]
+
+		var got []string
+		for _, m := range item.FindAllStringSubmatch(nav, -1) {
+			got = append(got, m[1])
+		}
+		if !reflect.DeepEqual(got, tt.items) {
+			t.Errorf("%s: breadcrumbs = %q, want %q", tt.page, got, tt.items)
+		}
+	}
+}
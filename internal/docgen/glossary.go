@@ -0,0 +1,238 @@
+package docgen
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// glossaryFile is the doc, at the top of the docs directory, whose headings
+// are the terms defined on every page. It's an ordinary page too.
+const glossaryFile = "glossary.md"
+
+// KindGlossaryTerm is the node kind for glossary terms with tooltips
+var KindGlossaryTerm = ast.NewNodeKind("GlossaryTerm")
+
+// glossaryEntry is one term and its plain text definition
+type glossaryEntry struct {
+	Term       string
+	Definition string
+}
+
+// GlossaryTerm wraps the first use of a glossary term in a document. Its
+// children are the term as written.
+type GlossaryTerm struct {
+	ast.BaseInline
+	Definition string
+}
+
+// Dump implements ast.Node
+func (n *GlossaryTerm) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Definition": n.Definition}, nil)
+}
+
+// Kind implements ast.Node
+func (n *GlossaryTerm) Kind() ast.NodeKind {
+	return KindGlossaryTerm
+}
+
+// loadGlossary reads the glossary at path. Each heading is a term, defined
+// by the text up to the next heading; headings with nothing under them, such
+// as letter groups, are skipped. A missing glossary is empty.
+func loadGlossary(path string) ([]glossaryEntry, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading glossary: %w", err)
+	}
+
+	doc := newMarkdown(Options{}).Parser().Parse(text.NewReader(content))
+	var entries []glossaryEntry
+	var term string
+	var definition []string
+	flush := func() {
+		if term != "" && len(definition) > 0 {
+			entries = append(entries, glossaryEntry{Term: term, Definition: strings.Join(definition, " ")})
+		}
+		term, definition = "", nil
+	}
+	for block := doc.FirstChild(); block != nil; block = block.NextSibling() {
+		if _, ok := block.(*ast.Heading); ok {
+			flush()
+			term = plainText(block, content, false)
+		} else if para := plainText(block, content, false); term != "" && para != "" {
+			definition = append(definition, para)
+		}
+	}
+	flush()
+	return entries, nil
+}
+
+// glossaryStamp summarizes the glossary for the build stamp, so changing a
+// definition regenerates every page
+func glossaryStamp(entries []glossaryEntry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(entry.Term + "\x00" + entry.Definition + "\n")
+	}
+	return b.String()
+}
+
+// frontmatterGlossary returns a doc's own `glossary:` terms, which add to or
+// replace the site glossary's
+func frontmatterGlossary(pc parser.Context) []glossaryEntry {
+	var entries []glossaryEntry
+	add := func(term, definition any) {
+		t, ok1 := term.(string)
+		d, ok2 := definition.(string)
+		if ok1 && ok2 && strings.TrimSpace(t) != "" && strings.TrimSpace(d) != "" {
+			entries = append(entries, glossaryEntry{Term: strings.TrimSpace(t), Definition: strings.TrimSpace(d)})
+		}
+	}
+	switch terms := meta.Get(pc)["glossary"].(type) {
+	case map[any]any:
+		for term, definition := range terms {
+			add(term, definition)
+		}
+	case map[string]any:
+		for term, definition := range terms {
+			add(term, definition)
+		}
+	}
+	return entries
+}
+
+// GlossaryTransformer wraps the first use of each glossary term in a document
+// in a GlossaryTerm node. Terms match case-insensitively and as whole words,
+// but never inside code, links or headings.
+type GlossaryTransformer struct {
+	Entries []glossaryEntry
+}
+
+func (t *GlossaryTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	// Entries is shared by every doc, which may be parsed concurrently, so
+	// don't append the doc's own terms to it
+	definitions := make(map[string]string)
+	for _, entries := range [][]glossaryEntry{t.Entries, frontmatterGlossary(pc)} {
+		for _, entry := range entries {
+			definitions[strings.ToLower(entry.Term)] = entry.Definition
+		}
+	}
+	if len(definitions) == 0 {
+		return
+	}
+
+	// Longest first, so "for loop" wins over "for"
+	terms := make([]string, 0, len(definitions))
+	for term := range definitions {
+		terms = append(terms, regexp.QuoteMeta(term))
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if len(terms[i]) != len(terms[j]) {
+			return len(terms[i]) > len(terms[j])
+		}
+		return terms[i] < terms[j]
+	})
+	pattern := regexp.MustCompile(`(?i)\b(?:` + strings.Join(terms, "|") + `)\b`)
+
+	// Collect first; we can't modify the tree while walking it
+	var texts []*ast.Text
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading, *ast.Link, *ast.AutoLink, *ast.Image, *ast.CodeSpan, *ast.RawHTML,
+			*ast.FencedCodeBlock, *ast.CodeBlock, *ast.HTMLBlock, *RunnableCodeBlock, *Math, *MathBlock, *Video:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			if !n.IsRaw() {
+				texts = append(texts, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, t := range texts {
+		for len(definitions) > 0 {
+			loc := firstUnused(pattern, t.Segment.Value(source), definitions)
+			if loc == nil {
+				break
+			}
+			t = wrapGlossaryTerm(t, source, loc[0], loc[1], definitions)
+		}
+	}
+}
+
+// firstUnused returns the position of the first term in value that hasn't
+// been wrapped yet, or nil if there isn't one
+func firstUnused(pattern *regexp.Regexp, value []byte, definitions map[string]string) []int {
+	for _, loc := range pattern.FindAllIndex(value, -1) {
+		if _, ok := definitions[strings.ToLower(string(value[loc[0]:loc[1]]))]; ok {
+			return loc
+		}
+	}
+	return nil
+}
+
+// wrapGlossaryTerm splits t around the term at [start, end) of its text,
+// wraps the term in a GlossaryTerm and marks it used. It returns the text
+// after the term, which is left to search for more.
+func wrapGlossaryTerm(t *ast.Text, source []byte, start, end int, definitions map[string]string) *ast.Text {
+	parent := t.Parent()
+	segment := t.Segment
+	term := strings.ToLower(string(source[segment.Start+start : segment.Start+end]))
+	definition := definitions[term]
+	delete(definitions, term)
+
+	if start > 0 {
+		before := ast.NewTextSegment(segment.WithStop(segment.Start + start))
+		parent.InsertBefore(parent, t, before)
+	}
+
+	matched := ast.NewTextSegment(text.NewSegment(segment.Start+start, segment.Start+end))
+	wrapped := &GlossaryTerm{Definition: definition}
+	wrapped.AppendChild(wrapped, matched)
+	parent.InsertBefore(parent, t, wrapped)
+
+	after := ast.NewTextSegment(segment.WithStart(segment.Start + end))
+	after.SetSoftLineBreak(t.SoftLineBreak())
+	after.SetHardLineBreak(t.HardLineBreak())
+	parent.ReplaceChild(parent, t, after)
+	return after
+}
+
+// GlossaryTermRenderer renders GlossaryTerm nodes as spans carrying their
+// definition, which docs.css shows as a tooltip
+type GlossaryTermRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer
+func (r *GlossaryTermRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindGlossaryTerm, r.renderGlossaryTerm)
+}
+
+func (r *GlossaryTermRenderer) renderGlossaryTerm(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*GlossaryTerm)
+		fmt.Fprintf(w, `<span class="glossary-term" tabindex="0" data-definition="%s">`, html.EscapeString(n.Definition))
+	} else {
+		w.WriteString("</span>")
+	}
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,72 @@
+package docgen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadGlossary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), glossaryFile)
+	content := "---\ntitle: Glossary\n---\n\n## F\n\n### Function\n\nA named, reusable\nblock of code.\n\n### For loop\n\nRepeats code for each item.\n\nSee also *while*.\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := loadGlossary(path)
+	if err != nil {
+		t.Fatalf("loadGlossary failed: %v", err)
+	}
+	want := []glossaryEntry{
+		{Term: "Function", Definition: "A named, reusable block of code."},
+		{Term: "For loop", Definition: "Repeats code for each item. See also while."},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Expected %+v, got %+v", want, entries)
+	}
+
+	if entries, err := loadGlossary(filepath.Join(t.TempDir(), glossaryFile)); err != nil || entries != nil {
+		t.Errorf("Expected a missing glossary to be empty, got %+v, %v", entries, err)
+	}
+}
+
+func TestGlossaryTransformer(t *testing.T) {
+	opts := Options{glossary: []glossaryEntry{
+		{Term: "variable", Definition: `A name for a "value".`},
+		{Term: "for loop", Definition: "Repeats code."},
+		{Term: "for", Definition: "Not this one."},
+		{Term: "list", Definition: "An ordered collection."},
+	}}
+	source := []byte("---\nglossary:\n  turtle: A drawing robot.\n---\n" +
+		"## Variable basics\n\n" +
+		"Use `variable` or [a variable](/x.html). A Variable holds a value; another variable too.\n\n" +
+		"A for loop goes over a playlist, then a list, then a turtle.\n\n" +
+		"```python\nturtle = 1\n```\n")
+
+	var buf bytes.Buffer
+	if err := newMarkdown(opts).Convert(source, &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`id="variable-basics">Variable basics</h`,
+		"<code>variable</code>",
+		`<a href="/x.html">a variable</a>`,
+		`A <span class="glossary-term" tabindex="0" data-definition="A name for a &#34;value&#34;.">Variable</span> holds a value; another variable too.`,
+		`A <span class="glossary-term" tabindex="0" data-definition="Repeats code.">for loop</span> goes over a playlist, then a <span class="glossary-term" tabindex="0" data-definition="An ordered collection.">list</span>, then a <span class="glossary-term" tabindex="0" data-definition="A drawing robot.">turtle</span>.`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Not this one.") {
+		t.Errorf("Expected the longer term to win, got:\n%s", out)
+	}
+	if n := strings.Count(out, `class="glossary-term"`); n != 4 {
+		t.Errorf("Expected 4 glossary terms, got %d:\n%s", n, out)
+	}
+}
@@ -0,0 +1,31 @@
+package docgen
+
+import (
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// HeadingLevelTransformer keeps heading levels from skipping, so screen reader
+// users navigating by heading see a proper outline. A heading more than one
+// level deeper than the one before it is raised: `#`, `###`, `####` renders
+// as h1, h2, h3.
+type HeadingLevelTransformer struct{}
+
+func (t *HeadingLevelTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	previous := 0
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		heading, ok := n.(*ast.Heading)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		if heading.Level > previous+1 {
+			heading.Level = previous + 1
+		}
+		previous = heading.Level
+		return ast.WalkSkipChildren, nil
+	})
+}
@@ -0,0 +1,28 @@
+package docgen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHeadingLevelTransformer_NoSkippedLevels(t *testing.T) {
+	source := []byte("# Title\n\n### Skipped\n\n#### Deeper\n\n## Back\n\n> [!NOTE]\n> #### In a note\n")
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert(source, &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`<h1 id="title">Title</h1>`,
+		`<h2 id="skipped">Skipped</h2>`,
+		`<h3 id="deeper">Deeper</h3>`,
+		`<h2 id="back">Back</h2>`,
+		`<h3 id="in-a-note">In a note</h3>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output:\n%s", want, out)
+		}
+	}
+}
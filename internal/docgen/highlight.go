@@ -0,0 +1,108 @@
+package docgen
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark/util"
+)
+
+// DefaultHighlightStyle is the chroma style used for ordinary code blocks
+const DefaultHighlightStyle = "github"
+
+// highlightFormatter emits class names; the matching stylesheet goes in the page head
+var highlightFormatter = chromahtml.New(chromahtml.WithClasses(true))
+
+// lookupStyle returns the named chroma style, or an error listing the valid names
+func lookupStyle(name string) (*chroma.Style, error) {
+	style, ok := styles.Registry[name]
+	if !ok {
+		names := styles.Names()
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown highlight style %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return style, nil
+}
+
+// HighlightCSS returns the stylesheet for a chroma style
+func HighlightCSS(styleName string) (string, error) {
+	style, err := lookupStyle(styleName)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := highlightFormatter.WriteCSS(&buf, style); err != nil {
+		return "", fmt.Errorf("writing highlight CSS: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// languageLabel names a fence language for display: chroma's name for it,
+// e.g. "Bash" for "sh", or the language as given if chroma doesn't know it
+func languageLabel(lang string) string {
+	if lang == "" {
+		return "Code"
+	}
+	if lexer := lexers.Get(lang); lexer != nil {
+		return lexer.Config().Name
+	}
+	return lang
+}
+
+// writeHighlighted renders code with chroma using CSS classes.
+// Returns false (having written nothing) if the language is unknown or highlighting fails.
+func (r *RunnableCodeBlockRenderer) writeHighlighted(w util.BufWriter, lang, code string) bool {
+	if lang == "" {
+		return false
+	}
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		return false
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	styleName := r.Style
+	if styleName == "" {
+		styleName = DefaultHighlightStyle
+	}
+	style, err := lookupStyle(styleName)
+	if err != nil {
+		return false
+	}
+
+	iterator, err := lexer.Tokenise(nil, code)
+	if err != nil {
+		return false
+	}
+
+	// Format into a buffer first so a failure doesn't leave partial output
+	var buf bytes.Buffer
+	if err := highlightFormatter.Format(&buf, style, iterator); err != nil {
+		return false
+	}
+
+	w.Write(buf.Bytes())
+	w.WriteString("\n")
+	return true
+}
+
+// writePlainCodeBlock renders code as an unhighlighted <pre><code> block
+func writePlainCodeBlock(w util.BufWriter, lang, code string) {
+	w.WriteString("<pre><code")
+	if lang != "" {
+		w.WriteString(` class="language-`)
+		w.WriteString(html.EscapeString(lang))
+		w.WriteString(`"`)
+	}
+	w.WriteString(">")
+	w.Write(util.EscapeHTML([]byte(code)))
+	w.WriteString("</code></pre>\n")
+}
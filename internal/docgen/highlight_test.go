@@ -0,0 +1,52 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHighlightCSS_UnknownStyle(t *testing.T) {
+	_, err := HighlightCSS("no-such-theme")
+	if err == nil {
+		t.Fatal("Expected error for unknown style but got success")
+	}
+	if !strings.Contains(err.Error(), "no-such-theme") {
+		t.Errorf("Expected error to name the unknown style, got: %v", err)
+	}
+}
+
+func TestGenerateAllDocs_HighlightStyle(t *testing.T) {
+	docsDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	doc := "---\ntitle: Test\n---\n\n```go\nfunc main() {}\n```\n"
+	if err := os.WriteFile(filepath.Join(docsDir, "test.md"), []byte(doc), 0644); err != nil {
+		t.Fatalf("Failed to write doc: %v", err)
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{HighlightStyle: "no-such-theme"}); err == nil {
+		t.Error("Expected error for unknown style but got success")
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{HighlightStyle: "monokai"}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+
+	output, err := os.ReadFile(filepath.Join(outputDir, "test.html"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+
+	css, err := HighlightCSS("monokai")
+	if err != nil {
+		t.Fatalf("HighlightCSS failed: %v", err)
+	}
+	if !strings.Contains(string(output), css) {
+		t.Error("Expected page head to contain the monokai stylesheet")
+	}
+	if !strings.Contains(string(output), `class="chroma"`) {
+		t.Error("Expected highlighted code block with chroma classes")
+	}
+}
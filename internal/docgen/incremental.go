@@ -0,0 +1,47 @@
+package docgen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+)
+
+// templateVersion must be bumped whenever the templates in templates/ or the renderers
+// change their output, so incremental builds regenerate every page
+const templateVersion = "23"
+
+// buildStampFile records what the shared page parts looked like at the last build
+const buildStampFile = ".docgen-stamp"
+
+// buildStamp summarizes everything shared across pages. If it differs from the
+// previous build's stamp, every page must be regenerated.
+func buildStamp(shared ...string) string {
+	h := sha256.New()
+	for _, part := range append([]string{templateVersion}, shared...) {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readBuildStamp returns the stamp from the previous build, or "" if there is none
+func readBuildStamp(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// isUpToDate reports whether outputPath exists and is newer than inputPath
+func isUpToDate(inputPath, outputPath string) bool {
+	inputInfo, err := os.Stat(inputPath)
+	if err != nil {
+		return false
+	}
+	outputInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return false
+	}
+	return outputInfo.ModTime().After(inputInfo.ModTime())
+}
@@ -0,0 +1,64 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerateAllDocs_SkipsUnchanged(t *testing.T) {
+	docsDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	for _, name := range []string{"a.md", "b.md"} {
+		doc := "---\ntitle: " + name + "\n---\n\nHello\n"
+		if err := os.WriteFile(filepath.Join(docsDir, name), []byte(doc), 0644); err != nil {
+			t.Fatalf("Failed to write doc: %v", err)
+		}
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+
+	// Backdate outputs so a regeneration is detectable, keeping them newer than sources
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"a", "b"} {
+		os.Chtimes(filepath.Join(docsDir, name+".md"), old.Add(-time.Hour), old.Add(-time.Hour))
+		os.Chtimes(filepath.Join(outputDir, name+".html"), old, old)
+	}
+
+	// Edit b.md only (body change, so the sidebar is unaffected)
+	if err := os.WriteFile(filepath.Join(docsDir, "b.md"), []byte("---\ntitle: b.md\n---\n\nChanged\n"), 0644); err != nil {
+		t.Fatalf("Failed to edit doc: %v", err)
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+
+	if modTime(t, filepath.Join(outputDir, "a.html")).After(old) {
+		t.Error("Expected unchanged a.html to be skipped")
+	}
+	if !modTime(t, filepath.Join(outputDir, "b.html")).After(old) {
+		t.Error("Expected edited b.html to be regenerated")
+	}
+
+	// Force rebuilds everything
+	if err := GenerateAllDocs(docsDir, outputDir, Options{Force: true}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+	if !modTime(t, filepath.Join(outputDir, "a.html")).After(old) {
+		t.Error("Expected force to regenerate a.html")
+	}
+}
+
+func modTime(t *testing.T, path string) time.Time {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return info.ModTime()
+}
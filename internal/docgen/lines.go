@@ -0,0 +1,60 @@
+package docgen
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseLineRanges parses a comma-separated list of 1-based line numbers and
+// inclusive ranges, like "3,5-7", into sorted, distinct line numbers. Lines
+// past lineCount are dropped, and malformed or backwards parts are ignored
+// rather than failing the build.
+func parseLineRanges(spec string, lineCount int) []int {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		startStr, endStr, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		start, err := strconv.Atoi(startStr)
+		if err != nil {
+			continue
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(endStr); err != nil {
+				continue
+			}
+		}
+		if start < 1 || end < start {
+			continue
+		}
+		for line := start; line <= end && line <= lineCount; line++ {
+			seen[line] = true
+		}
+	}
+
+	var lines []int
+	for line := range seen {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// formatLineRanges formats sorted, distinct line numbers compactly, the way
+// parseLineRanges reads them: []int{3, 5, 6, 7} becomes "3,5-7"
+func formatLineRanges(lines []int) string {
+	var parts []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(lines[i]))
+		} else {
+			parts = append(parts, strconv.Itoa(lines[i])+"-"+strconv.Itoa(lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
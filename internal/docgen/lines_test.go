@@ -0,0 +1,30 @@
+package docgen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLineRanges(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int
+	}{
+		{"", nil},
+		{"3,5-7", []int{3, 5, 6, 7}},
+		{"7-5,2", []int{2}},                // Backwards range ignored
+		{"x,1-y,0,-2,4", []int{4}},         // Malformed parts ignored
+		{"9-12,5-6,6", []int{5, 6, 9, 10}}, // Clipped to the code, deduplicated
+	}
+	for _, tt := range tests {
+		if got := parseLineRanges(tt.spec, 10); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLineRanges(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLineRanges(t *testing.T) {
+	if got := formatLineRanges([]int{1, 3, 5, 6, 7, 9, 10}); got != "1,3,5-7,9-10" {
+		t.Errorf("formatLineRanges = %q", got)
+	}
+}
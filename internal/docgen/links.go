@@ -0,0 +1,90 @@
+package docgen
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// docsURLPrefix is where generated docs are served; only links under it are
+// checked
+const docsURLPrefix = "/static/docs/"
+
+// collectLinks returns the destinations of a document's markdown links.
+// Links in raw HTML aren't included.
+func collectLinks(doc ast.Node) []string {
+	var links []string
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := n.(*ast.Link); ok && entering {
+			links = append(links, string(link.Destination))
+		}
+		return ast.WalkContinue, nil
+	})
+	return links
+}
+
+// collectAnchors returns the IDs of a document's headings
+func collectAnchors(doc ast.Node) map[string]bool {
+	anchors := make(map[string]bool)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if heading, ok := n.(*ast.Heading); ok && entering {
+			if id, ok := heading.AttributeString("id"); ok {
+				if id, ok := id.([]byte); ok {
+					anchors[string(id)] = true
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return anchors
+}
+
+// checkLinks reports every link between docs that points to a page that
+// isn't generated or a heading that page doesn't have, as
+// "source.md: link to /static/docs/page.html#id: reason". Relative links are
+// resolved against the linking page's URL. Links outside the docs, including
+// external http(s) links, aren't checked.
+func checkLinks(entries []docEntry) []string {
+	pages := make(map[string]*docEntry, len(entries))
+	for i := range entries {
+		pages[entries[i].URL] = &entries[i]
+	}
+
+	var broken []string
+	for _, entry := range entries {
+		for _, link := range entry.Links {
+			u, err := url.Parse(link)
+			if err != nil {
+				broken = append(broken, fmt.Sprintf("%s: link to %s: %v", entry.InputPath, link, err))
+				continue
+			}
+			if u.Scheme != "" || u.Host != "" {
+				continue
+			}
+
+			target := entry.URL
+			if u.Path != "" {
+				target = u.Path
+				if !strings.HasPrefix(target, "/") {
+					target = path.Join(path.Dir(entry.URL), target)
+				}
+			}
+			if !strings.HasPrefix(target, docsURLPrefix) {
+				continue
+			}
+
+			page, ok := pages[target]
+			switch {
+			case !ok:
+				broken = append(broken, fmt.Sprintf("%s: link to %s: no such page", entry.InputPath, link))
+			case u.Fragment != "" && !page.Anchors[u.Fragment]:
+				broken = append(broken, fmt.Sprintf("%s: link to %s: no heading #%s in %s", entry.InputPath, link, u.Fragment, page.InputPath))
+			}
+		}
+	}
+	return broken
+}
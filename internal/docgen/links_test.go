@@ -0,0 +1,57 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckLinks(t *testing.T) {
+	docsDir := t.TempDir()
+	docs := map[string]string{
+		"intro.md": "# Intro\n\n## Getting Started\n\n" +
+			"[ok](/static/docs/guide/loops.html#while-loops) " +
+			"[self](#getting-started) " +
+			"[missing page](/static/docs/nope.html) " +
+			"[missing anchor](/static/docs/guide/loops.html#for-loops) " +
+			"[missing self](#nowhere) " +
+			"[external](https://example.com/static/docs/nope.html) " +
+			"[outside](/about.html)\n",
+		"guide/loops.md": "# Loops\n\n## While Loops\n\n" +
+			"[relative](../intro.html#intro) [relative markdown](../intro.md)\n",
+	}
+	for name, content := range docs {
+		path := filepath.Join(docsDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := collectDocs(docsDir, t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("collectDocs failed: %v", err)
+	}
+	broken := strings.Join(checkLinks(entries), "\n")
+	for _, want := range []string{
+		"link to /static/docs/nope.html: no such page",
+		"link to /static/docs/guide/loops.html#for-loops: no heading #for-loops",
+		"link to #nowhere: no heading #nowhere",
+		"link to ../intro.md: no such page",
+	} {
+		if !strings.Contains(broken, want) {
+			t.Errorf("Expected %q in broken links:\n%s", want, broken)
+		}
+	}
+	if n := len(strings.Split(broken, "\n")); n != 4 {
+		t.Errorf("Expected 4 broken links, got %d:\n%s", n, broken)
+	}
+
+	err = GenerateAllDocs(docsDir, t.TempDir(), Options{Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "4 broken links") {
+		t.Errorf("Expected strict generation to fail with 4 broken links, got %v", err)
+	}
+}
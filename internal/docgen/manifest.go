@@ -0,0 +1,70 @@
+package docgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// manifestFile lists every page in the output directory, for deploy tooling
+const manifestFile = "index.json"
+
+// Manifest is the contents of manifestFile
+type Manifest struct {
+	// GeneratorVersion is templateVersion, which changes whenever the
+	// output for the same sources does
+	GeneratorVersion string `json:"generator_version"`
+	// UpdatedAt is when the newest source was modified, rather than when
+	// the build ran, so the manifest only changes when the docs do
+	UpdatedAt time.Time       `json:"updated_at"`
+	Pages     []ManifestEntry `json:"pages"`
+}
+
+// ManifestEntry describes one generated page
+type ManifestEntry struct {
+	Path        string    `json:"path"` // Relative to the output directory, slash-separated
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Order       int       `json:"order"`
+	SourceMTime time.Time `json:"source_mtime"`
+}
+
+// writeManifest writes the manifest of entries, whose pages are under
+// outputDir. Pages are sorted by path and times are in UTC, so the output only
+// changes when the docs do, and the file is left untouched if its contents
+// wouldn't change.
+func writeManifest(path, outputDir string, entries []docEntry) error {
+	manifest := Manifest{GeneratorVersion: templateVersion, Pages: []ManifestEntry{}}
+	for _, entry := range entries {
+		rel, err := filepath.Rel(outputDir, entry.OutputPath)
+		if err != nil {
+			return fmt.Errorf("calculating manifest path: %w", err)
+		}
+		modTime := entry.ModTime.UTC().Truncate(time.Second)
+		if modTime.After(manifest.UpdatedAt) {
+			manifest.UpdatedAt = modTime
+		}
+		manifest.Pages = append(manifest.Pages, ManifestEntry{
+			Path:        filepath.ToSlash(rel),
+			URL:         entry.URL,
+			Title:       entry.Metadata.Title,
+			Description: entry.Metadata.Description,
+			Category:    entry.Metadata.Category,
+			Order:       entry.Metadata.Order,
+			SourceMTime: modTime,
+		})
+	}
+	sort.Slice(manifest.Pages, func(i, j int) bool {
+		return manifest.Pages[i].Path < manifest.Pages[j].Path
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	return writeIfChanged(path, append(data, '\n'), "manifest")
+}
@@ -0,0 +1,83 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerateAllDocs_Manifest(t *testing.T) {
+	docsDir, outputDir := t.TempDir(), t.TempDir()
+	docs := map[string]string{
+		"turtle.md":       "---\ntitle: Turtle\ndescription: Draw things\ncategory: Graphics\norder: 2\n---\nDraw.\n",
+		"basics/intro.md": "---\ntitle: Intro\n---\nHello.\n",
+		"draft.md":        "---\ntitle: Soon\ndraft: true\n---\nSoon.\n",
+	}
+	older := time.Date(2024, 3, 15, 23, 30, 0, 0, time.UTC)
+	newer := time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC)
+	for name, content := range docs {
+		path := filepath.Join(docsDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := older
+		if name == "turtle.md" {
+			modTime = newer
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+	manifest, err := os.ReadFile(filepath.Join(outputDir, manifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sorted by path, drafts left out, and no build time, so rebuilding
+	// unchanged docs leaves it byte-for-byte the same
+	want := `{
+  "generator_version": "` + templateVersion + `",
+  "updated_at": "2024-04-01T08:00:00Z",
+  "pages": [
+    {
+      "path": "basics/intro.html",
+      "url": "/static/docs/basics/intro.html",
+      "title": "Intro",
+      "order": 0,
+      "source_mtime": "2024-03-15T23:30:00Z"
+    },
+    {
+      "path": "turtle.html",
+      "url": "/static/docs/turtle.html",
+      "title": "Turtle",
+      "description": "Draw things",
+      "category": "Graphics",
+      "order": 2,
+      "source_mtime": "2024-04-01T08:00:00Z"
+    }
+  ]
+}
+`
+	if string(manifest) != want {
+		t.Errorf("Unexpected manifest:\n%s\nwant:\n%s", manifest, want)
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{Force: true}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+	again, err := os.ReadFile(filepath.Join(outputDir, manifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(manifest) {
+		t.Errorf("Expected a rebuild to leave the manifest unchanged, got:\n%s", again)
+	}
+}
@@ -0,0 +1,230 @@
+package docgen
+
+import (
+	"bytes"
+	"html"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindMath is the node kind for inline `$...$` math
+var KindMath = ast.NewNodeKind("Math")
+
+// KindMathBlock is the node kind for `$$ ... $$` display math blocks
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// mathDelimiter opens and closes display math
+var mathDelimiter = []byte("$$")
+
+// Math is TeX source found inline in a paragraph
+type Math struct {
+	ast.BaseInline
+	TeX     []byte
+	Display bool // Written as $$...$$ rather than $...$
+}
+
+// Dump implements ast.Node
+func (n *Math) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": string(n.TeX)}, nil)
+}
+
+// Kind implements ast.Node
+func (n *Math) Kind() ast.NodeKind {
+	return KindMath
+}
+
+// MathBlock is display math on lines of its own. Its lines hold the TeX source.
+type MathBlock struct {
+	ast.BaseBlock
+	closed bool // The closing $$ has been seen
+}
+
+// Dump implements ast.Node
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Kind implements ast.Node
+func (n *MathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+// IsRaw implements ast.Node; the contents are TeX, not markdown
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+// mathInlineParser recognizes `$...$` and `$$...$$` within a line.
+//
+// To avoid mistaking prices like "$5 and $10" for math, it follows pandoc's
+// rules: the opening $ must not be followed by a space, the closing $ must not
+// be preceded by a space or followed by a digit. A dollar preceded by a space
+// before any valid closer means the opener was prose. Write `\$` for a literal
+// dollar sign that would otherwise match. Code spans and code blocks are never parsed
+// for inlines, so dollars there are left alone.
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+
+	delim := 1
+	if bytes.HasPrefix(line, mathDelimiter) {
+		delim = 2
+	}
+	body := line[delim:]
+	if len(body) == 0 || util.IsSpace(body[0]) {
+		return nil
+	}
+
+	for i := 1; i < len(body); i++ {
+		if body[i] == '\\' {
+			i++ // Skip escaped characters, including \$
+			continue
+		}
+		if body[i] != '$' {
+			continue
+		}
+		if util.IsSpace(body[i-1]) {
+			// A bare dollar that can't close this span means it's prose, as in "$5 and $10"
+			return nil
+		}
+
+		after := i + 1
+		if delim == 2 {
+			if after >= len(body) || body[after] != '$' {
+				continue
+			}
+			after++
+		} else if after < len(body) && util.IsNumeric(body[after]) {
+			continue
+		}
+
+		node := &Math{
+			TeX:     append([]byte(nil), body[:i]...),
+			Display: delim == 2,
+		}
+		block.Advance(delim + after)
+		return node
+	}
+
+	return nil
+}
+
+// mathBlockParser recognizes display math delimited by `$$` lines:
+//
+//	$$
+//	x^2 + y^2 = r^2
+//	$$
+//
+// A single line of the form `$$ x^2 $$` is also a block.
+type mathBlockParser struct{}
+
+func (p *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], mathDelimiter) {
+		return nil, parser.NoChildren
+	}
+
+	node := &MathBlock{}
+	rest := util.TrimRightSpace(line[pos+len(mathDelimiter):])
+	if len(rest) == 0 {
+		return node, parser.NoChildren
+	}
+
+	// Everything on one line: $$ ... $$
+	if !bytes.HasSuffix(rest, mathDelimiter) {
+		return nil, parser.NoChildren
+	}
+	start := segment.Start + pos + len(mathDelimiter)
+	node.Lines().Append(text.NewSegment(start, start+len(rest)-len(mathDelimiter)))
+	node.closed = true
+	return node, parser.NoChildren
+}
+
+func (p *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	block := node.(*MathBlock)
+	if block.closed {
+		return parser.Close
+	}
+
+	// The parser moves past the newline itself after each call
+	line, segment := reader.PeekLine()
+	newline := 0
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		newline = 1
+	}
+
+	if bytes.Equal(util.TrimLeftSpace(util.TrimRightSpace(line)), mathDelimiter) {
+		reader.Advance(segment.Len() - newline)
+		return parser.Close
+	}
+
+	block.Lines().Append(segment)
+	reader.Advance(segment.Len() - newline)
+	return parser.Continue | parser.NoChildren
+}
+
+func (p *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// MathRenderer emits math as escaped TeX in elements the page's KaTeX script renders
+type MathRenderer struct{}
+
+func (r *MathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMath, r.renderMath)
+	reg.Register(KindMathBlock, r.renderMathBlock)
+}
+
+func (r *MathRenderer) renderMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*Math)
+	class := "math math-inline"
+	if n.Display {
+		class = "math math-display"
+	}
+	w.WriteString(`<span class="` + class + `">`)
+	w.WriteString(html.EscapeString(string(n.TeX)))
+	w.WriteString("</span>")
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *MathRenderer) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	var tex bytes.Buffer
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		tex.Write(segment.Value(source))
+	}
+
+	w.WriteString(`<div class="math math-display">`)
+	w.WriteString(html.EscapeString(string(bytes.TrimSpace(tex.Bytes()))))
+	w.WriteString("</div>\n")
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,82 @@
+package docgen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func renderMarkdown(t *testing.T, source string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := newMarkdown(Options{}).Convert([]byte(source), &buf); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestMath_InlineAndBlock(t *testing.T) {
+	out := renderMarkdown(t, "The area is $\\pi r^2$ here.\n\n$$\nx^2 + y^2 < r^2\n$$\n\nAfter.\n\n$$ e = mc^2 $$\n")
+
+	for _, want := range []string{
+		`<span class="math math-inline">\pi r^2</span>`,
+		`<div class="math math-display">x^2 + y^2 &lt; r^2</div>`,
+		`<p>After.</p>`,
+		`<div class="math math-display">e = mc^2</div>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestMath_IgnoresProseDollars(t *testing.T) {
+	for _, source := range []string{
+		"It costs $5 and $10 is too much.\n",
+		"Save $ 3 today $.\n",
+		"Between $3$4 and more.\n",
+	} {
+		if out := renderMarkdown(t, source); strings.Contains(out, `class="math`) {
+			t.Errorf("Expected no math in %q, got:\n%s", source, out)
+		}
+	}
+}
+
+func TestMath_IgnoresCode(t *testing.T) {
+	out := renderMarkdown(t, "Use `$x$` here.\n\n```\nprint('$y$')\n```\n")
+	if strings.Contains(out, `class="math`) {
+		t.Errorf("Expected math in code to be left alone, got:\n%s", out)
+	}
+	if !strings.Contains(out, "<code>$x$</code>") {
+		t.Errorf("Expected code span to keep its dollars, got:\n%s", out)
+	}
+}
+
+func TestGenerateAllDocs_LoadsKaTeXOnlyWithMath(t *testing.T) {
+	docsDir := t.TempDir()
+	outputDir := t.TempDir()
+	docs := map[string]string{
+		"math.md":  "---\ntitle: Math\n---\n\nThe area is $\\pi r^2$.\n",
+		"plain.md": "---\ntitle: Plain\n---\n\nIt costs $5 and $10.\n",
+	}
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(docsDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write doc: %v", err)
+		}
+	}
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+
+	for name, want := range map[string]bool{"math.html": true, "plain.html": false} {
+		out, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(out), "katex.min.js"); got != want {
+			t.Errorf("%s loads KaTeX = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package docgen
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindMermaid is the node kind for Mermaid diagrams
+var KindMermaid = ast.NewNodeKind("Mermaid")
+
+// mermaidLanguage is the fence language for diagrams Mermaid draws in the browser
+const mermaidLanguage = "mermaid"
+
+// mermaidEscaper escapes just enough for the browser to parse a diagram's
+// source as text. Mermaid reads it back from the element's innerHTML and
+// decodes entities itself, so quotes are left alone to keep the source as
+// written.
+var mermaidEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Mermaid is a diagram written in a ```mermaid fenced block
+type Mermaid struct {
+	ast.BaseBlock
+	Source string // The diagram, exactly as written
+}
+
+// Dump implements ast.Node
+func (n *Mermaid) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Source": n.Source}, nil)
+}
+
+// Kind implements ast.Node
+func (n *Mermaid) Kind() ast.NodeKind {
+	return KindMermaid
+}
+
+// MermaidTransformer turns ```mermaid fenced blocks into Mermaid nodes, so
+// they aren't highlighted as code
+type MermaidTransformer struct{}
+
+func (t *MermaidTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	// Collect first; we can't modify the tree while walking it
+	var blocks []*ast.FencedCodeBlock
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if block, ok := n.(*ast.FencedCodeBlock); ok && entering && block.Info != nil {
+			if info := infoWords(string(block.Info.Text(source))); len(info) > 0 && info[0] == mermaidLanguage {
+				blocks = append(blocks, block)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, block := range blocks {
+		if parent := block.Parent(); parent != nil {
+			parent.ReplaceChild(parent, block, &Mermaid{Source: fencedCode(block, source)})
+		}
+	}
+}
+
+// MermaidRenderer renders Mermaid nodes
+type MermaidRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer
+func (r *MermaidRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMermaid, r.renderMermaid)
+}
+
+func (r *MermaidRenderer) renderMermaid(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*Mermaid)
+	w.WriteString(`<pre class="mermaid">`)
+	w.WriteString(mermaidEscaper.Replace(n.Source))
+	w.WriteString("</pre>\n")
+	return ast.WalkSkipChildren, nil
+}
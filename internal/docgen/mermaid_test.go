@@ -0,0 +1,48 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAllDocs_Mermaid(t *testing.T) {
+	docsDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	diagram := "---\ntitle: Diagram\n---\n\n```mermaid\nflowchart LR\n    A[\"x < y & z\"] -->|**not bold**| B\n```\n"
+	plain := "---\ntitle: Plain\n---\n\n```python\nprint('mermaid')\n```\n"
+	for name, content := range map[string]string{"diagram.md": diagram, "plain.md": plain} {
+		if err := os.WriteFile(filepath.Join(docsDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write doc: %v", err)
+		}
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+	read := func(name string) string {
+		out, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		return string(out)
+	}
+
+	out := read("diagram.html")
+	want := "<pre class=\"mermaid\">flowchart LR\n    A[\"x &lt; y &amp; z\"] --&gt;|**not bold**| B\n</pre>"
+	if !strings.Contains(out, want) {
+		t.Errorf("Expected diagram source %q, got:\n%s", want, out)
+	}
+	if strings.Contains(out, "<strong>") || strings.Contains(out, `class="chroma"`) {
+		t.Error("Diagram source should not be processed as markdown or highlighted")
+	}
+	if !strings.Contains(out, "mermaid.esm.min.mjs") {
+		t.Error("Page with a diagram should load Mermaid")
+	}
+
+	if strings.Contains(read("plain.html"), "mermaid.esm.min.mjs") {
+		t.Error("Page without a diagram should not load Mermaid")
+	}
+}
@@ -0,0 +1,35 @@
+package docgen
+
+import (
+	"sync"
+)
+
+// forEach calls fn with each index from 0 to n-1 on up to jobs goroutines at
+// once. Every call is made even if some fail, and the error returned is that
+// of the lowest failing index, so it doesn't depend on scheduling.
+func forEach(n, jobs int, fn func(i int) error) error {
+	errs := make([]error, n)
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
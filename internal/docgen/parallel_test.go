@@ -0,0 +1,41 @@
+package docgen
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestForEach(t *testing.T) {
+	var calls, running, peak atomic.Int32
+	err := forEach(50, 4, func(i int) error {
+		calls.Add(1)
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		if i == 7 || i == 30 {
+			return fmt.Errorf("failed %d", i)
+		}
+		return nil
+	})
+
+	if calls.Load() != 50 {
+		t.Errorf("Expected every index to be called despite errors, got %d calls", calls.Load())
+	}
+	if peak.Load() > 4 {
+		t.Errorf("Expected at most 4 calls at once, got %d", peak.Load())
+	}
+	if err == nil || err.Error() != "failed 7" {
+		t.Errorf("Expected the lowest index's error, got %v", err)
+	}
+
+	if err := forEach(0, 4, func(int) error { return errors.New("unexpected") }); err != nil {
+		t.Errorf("Expected no calls for n=0, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package docgen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// assetManifestFile lists every page and the local assets it loads, for the
+// service worker to precache
+const assetManifestFile = "asset-manifest.json"
+
+// pageAssetRef matches a script or stylesheet a page loads from this site,
+// rather than from a CDN
+var pageAssetRef = regexp.MustCompile(`<(?:script|link)\b[^>]*?\s(?:src|href)="(/[^/"][^"]*)"`)
+
+// scriptImportRef matches a module a script imports, statically or as a
+// worker, so a page's scripts work offline along with it
+var scriptImportRef = regexp.MustCompile(`(?:\bfrom|\bimport|\bnew\s+Worker\()\s*['"]([^'"]+\.m?js)['"]`)
+
+// AssetManifest is the contents of assetManifestFile
+type AssetManifest struct {
+	// Version summarizes every URL and hash below, so the service worker can
+	// tell at a glance whether anything changed
+	Version string          `json:"version"`
+	Pages   []PrecachePage  `json:"pages"`
+	Assets  []PrecacheAsset `json:"assets"`
+}
+
+// PrecachePage is one generated page and the local assets it loads directly
+type PrecachePage struct {
+	URL    string   `json:"url"`
+	Hash   string   `json:"hash"`
+	Assets []string `json:"assets"`
+}
+
+// PrecacheAsset is a script or stylesheet some page needs, directly or by import
+type PrecacheAsset struct {
+	URL  string `json:"url"`
+	Hash string `json:"hash,omitempty"` // Empty if the asset wasn't hashed, see writeAssetManifest
+}
+
+// contentHash returns a short hash of data, enough to tell versions apart
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// writeAssetManifest writes the asset manifest for the generated pages of
+// entries. Like the search index, it leaves out transcripts and other docs
+// without a category, which would make every install of the service worker
+// download megabytes of them. Assets are hashed from webDir, which the site serves at "/", and
+// the modules they import are followed; if webDir is "", assets are listed
+// without hashes. Everything is sorted by URL, so the file is only rewritten
+// when a page or asset changes.
+func writeAssetManifest(manifestPath, webDir string, entries []docEntry) error {
+	manifest := AssetManifest{Pages: []PrecachePage{}, Assets: []PrecacheAsset{}}
+	var pending []string
+	for _, entry := range entries {
+		if !searchable(entry) {
+			continue
+		}
+		content, err := os.ReadFile(entry.OutputPath)
+		if err != nil {
+			return fmt.Errorf("reading %s for the asset manifest: %w", entry.OutputPath, err)
+		}
+		page := PrecachePage{URL: entry.URL, Hash: contentHash(content), Assets: []string{}}
+		seen := make(map[string]bool)
+		for _, match := range pageAssetRef.FindAllSubmatch(content, -1) {
+			url := stripQuery(string(match[1]))
+			if !seen[url] {
+				seen[url] = true
+				page.Assets = append(page.Assets, url)
+			}
+		}
+		sort.Strings(page.Assets)
+		manifest.Pages = append(manifest.Pages, page)
+		pending = append(pending, page.Assets...)
+	}
+	sort.Slice(manifest.Pages, func(i, j int) bool {
+		return manifest.Pages[i].URL < manifest.Pages[j].URL
+	})
+
+	// Hash each asset once, queueing the modules it imports
+	hashed := make(map[string]bool)
+	for len(pending) > 0 {
+		url := pending[0]
+		pending = pending[1:]
+		if hashed[url] {
+			continue
+		}
+		hashed[url] = true
+
+		asset := PrecacheAsset{URL: url}
+		if webDir != "" {
+			content, err := os.ReadFile(filepath.Join(webDir, filepath.FromSlash(path.Clean(url))))
+			if err != nil {
+				return fmt.Errorf("hashing asset %s: %w", url, err)
+			}
+			asset.Hash = contentHash(content)
+			if strings.HasSuffix(url, ".js") || strings.HasSuffix(url, ".mjs") {
+				for _, match := range scriptImportRef.FindAllSubmatch(content, -1) {
+					if imported, ok := resolveImport(url, string(match[1])); ok {
+						pending = append(pending, imported)
+					}
+				}
+			}
+		}
+		manifest.Assets = append(manifest.Assets, asset)
+	}
+	sort.Slice(manifest.Assets, func(i, j int) bool {
+		return manifest.Assets[i].URL < manifest.Assets[j].URL
+	})
+
+	h := sha256.New()
+	for _, page := range manifest.Pages {
+		fmt.Fprintf(h, "%s\x00%s\x00", page.URL, page.Hash)
+	}
+	for _, asset := range manifest.Assets {
+		fmt.Fprintf(h, "%s\x00%s\x00", asset.URL, asset.Hash)
+	}
+	manifest.Version = hex.EncodeToString(h.Sum(nil)[:8])
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding asset manifest: %w", err)
+	}
+	return writeIfChanged(manifestPath, append(data, '\n'), "asset manifest")
+}
+
+// resolveImport returns the URL of a module imported by the script at from,
+// and false if it isn't served by this site
+func resolveImport(from, spec string) (string, bool) {
+	switch {
+	case strings.HasPrefix(spec, "./"), strings.HasPrefix(spec, "../"):
+		return path.Join(path.Dir(from), spec), true
+	case strings.HasPrefix(spec, "/") && !strings.HasPrefix(spec, "//"):
+		return path.Clean(spec), true
+	}
+	return "", false
+}
+
+// stripQuery drops any query or fragment from a URL path
+func stripQuery(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
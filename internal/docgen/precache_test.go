@@ -0,0 +1,122 @@
+package docgen
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAllDocs_AssetManifest(t *testing.T) {
+	docsDir, outputDir, webDir := t.TempDir(), t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(docsDir, "intro.md"):            "---\ntitle: Intro\ncategory: Basics\n---\nHello.\n",
+		filepath.Join(docsDir, "basics/loops.md"):     "---\ntitle: Loops\ncategory: Basics\n---\nAgain.\n",
+		filepath.Join(docsDir, "sessions/log.md"):     "# Conversation Log\n\nA long transcript.\n",
+		filepath.Join(webDir, "css/app.css"):          "body {}\n",
+		filepath.Join(webDir, "css/docs.css"):         ".docs {}\n",
+		filepath.Join(webDir, "js/terminal.js"):       "// terminal\n",
+		filepath.Join(webDir, "js/snippet-runner.js"): "import { TrifleDB } from './db.js';\nconst w = new Worker('/js/worker.js', { type: 'module' });\n",
+		filepath.Join(webDir, "js/db.js"):             "export class TrifleDB {}\n",
+		filepath.Join(webDir, "js/worker.js"):         "import {\n    setup\n} from './python-env.js';\nawait import(`https://cdn.jsdelivr.net/pyodide/x/pyodide.mjs`);\n",
+		filepath.Join(webDir, "js/python-env.js"):     "const code = `\nimport sys\n`;\n",
+		filepath.Join(webDir, "js/unused-by-docs.js"): "// not loaded by any doc\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func() (AssetManifest, string) {
+		t.Helper()
+		if err := GenerateAllDocs(docsDir, outputDir, Options{WebDir: webDir}); err != nil {
+			t.Fatalf("GenerateAllDocs failed: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(outputDir, assetManifestFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest AssetManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatalf("Invalid manifest: %v\n%s", err, data)
+		}
+		return manifest, string(data)
+	}
+	manifest, first := read()
+
+	var pages []string
+	for _, page := range manifest.Pages {
+		pages = append(pages, page.URL)
+		want := []string{"/css/app.css", "/css/docs.css", "/js/snippet-runner.js", "/js/terminal.js"}
+		if !reflect.DeepEqual(page.Assets, want) {
+			t.Errorf("%s assets = %q, want %q", page.URL, page.Assets, want)
+		}
+		html, err := os.ReadFile(filepath.Join(outputDir, strings.TrimPrefix(page.URL, "/static/docs/")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Hash != contentHash(html) {
+			t.Errorf("%s hash = %s, want %s", page.URL, page.Hash, contentHash(html))
+		}
+	}
+	// Transcripts and other docs without a category aren't precached
+	if want := []string{"/static/docs/basics/loops.html", "/static/docs/intro.html"}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("Pages = %q, want %q", pages, want)
+	}
+
+	// Imported modules and workers are followed; CDN scripts aren't listed
+	var assets []string
+	for _, asset := range manifest.Assets {
+		assets = append(assets, asset.URL)
+		if want := contentHash([]byte(files[filepath.Join(webDir, filepath.FromSlash(asset.URL))])); asset.Hash != want {
+			t.Errorf("%s hash = %s, want %s", asset.URL, asset.Hash, want)
+		}
+	}
+	want := []string{"/css/app.css", "/css/docs.css", "/js/db.js", "/js/python-env.js", "/js/snippet-runner.js", "/js/terminal.js", "/js/worker.js"}
+	if !reflect.DeepEqual(assets, want) {
+		t.Errorf("Assets = %q, want %q", assets, want)
+	}
+
+	// Rebuilding unchanged docs gives the same bytes; changing an asset
+	// changes its hash and the version
+	if _, again := read(); again != first {
+		t.Errorf("Manifest changed between identical builds:\n%s\n%s", first, again)
+	}
+	if err := os.WriteFile(filepath.Join(webDir, "js/db.js"), []byte("export class TrifleDB { v2 }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, _ := read()
+	if changed.Version == manifest.Version || changed.Assets[2].Hash == manifest.Assets[2].Hash {
+		t.Errorf("Changing db.js kept version %s and hash %s", changed.Version, changed.Assets[2].Hash)
+	}
+
+	// Without a web directory, assets are listed unhashed
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(outputDir, assetManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unhashed AssetManifest
+	if err := json.Unmarshal(data, &unhashed); err != nil {
+		t.Fatal(err)
+	}
+	if len(unhashed.Assets) != 4 || unhashed.Assets[0].Hash != "" {
+		t.Errorf("Assets without a web directory = %+v", unhashed.Assets)
+	}
+
+	// A page loading a missing asset fails the build
+	if err := os.Remove(filepath.Join(webDir, "css/docs.css")); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateAllDocs(docsDir, outputDir, Options{WebDir: webDir}); err == nil || !strings.Contains(err.Error(), "/css/docs.css") {
+		t.Errorf("GenerateAllDocs with a missing asset = %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package docgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// DefaultWordsPerMinute is the reading speed used to estimate reading times
+// when Options doesn't set one
+const DefaultWordsPerMinute = 200
+
+// countWords counts the words of a document's headings and prose. Code is
+// read at a very different pace from prose, so code blocks aren't counted.
+func countWords(doc ast.Node, source []byte) int {
+	return len(strings.Fields(plainText(doc, source, false)))
+}
+
+// readingMinutes estimates how long words take to read at wordsPerMinute,
+// rounded up to whole minutes. Any non-empty document takes at least one.
+func readingMinutes(words, wordsPerMinute int) int {
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// ReadingTime formats the estimated reading time, e.g. "5 min read", or ""
+// for a document with no prose
+func (m DocMetadata) ReadingTime() string {
+	if m.ReadingMinutes == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d min read", m.ReadingMinutes)
+}
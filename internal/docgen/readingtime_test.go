@@ -0,0 +1,78 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+func TestCountWords_ExcludesCode(t *testing.T) {
+	source := []byte("---\ntitle: Loops\n---\n# Counting Loops\n\nUse a *for* loop to repeat.\n\n" +
+		"```python-editor-text\nfor i in range(3):\n    print(i)\n```\n\n" +
+		"```python\nwhile True:\n    pass\n```\n\n    indented code block\n\n" +
+		"- one item\n- two items\n")
+
+	ctx := parser.NewContext()
+	doc := newMarkdown(Options{}).Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+	// "Counting Loops", "Use a for loop to repeat.", "one item", "two items"
+	if got := countWords(doc, source); got != 12 {
+		t.Errorf("countWords = %d, want 12", got)
+	}
+}
+
+func TestReadingMinutes(t *testing.T) {
+	tests := []struct{ words, wpm, want int }{
+		{0, 200, 0},
+		{1, 200, 1},
+		{200, 200, 1},
+		{201, 200, 2},
+		{1000, 250, 4},
+	}
+	for _, tt := range tests {
+		if got := readingMinutes(tt.words, tt.wpm); got != tt.want {
+			t.Errorf("readingMinutes(%d, %d) = %d, want %d", tt.words, tt.wpm, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateAllDocs_ReadingTime(t *testing.T) {
+	docsDir, outputDir := t.TempDir(), t.TempDir()
+	prose := strings.Repeat("word ", 250)
+	code := "```python\n" + strings.Repeat("x = 1\n", 500) + "```\n"
+	if err := os.WriteFile(filepath.Join(docsDir, "intro.md"), []byte("---\ntitle: Long\n---\n"+prose+"\n\n"+code), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{WordsPerMinute: 100}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+	page, err := os.ReadFile(filepath.Join(outputDir, "intro.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<p class="doc-reading-time">3 min read · 250 words</p>`,
+		`<a href="/static/docs/intro.html">Long <span class="doc-reading-time">3 min read</span></a>`,
+	} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("Expected page to contain %q", want)
+		}
+	}
+
+	learn := filepath.Join(t.TempDir(), "learn.html")
+	if err := GenerateLandingPage(learn, docsDir, Options{}); err != nil {
+		t.Fatalf("GenerateLandingPage failed: %v", err)
+	}
+	landing, err := os.ReadFile(learn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the intro card has a doc behind it here, read at the default speed
+	if got := strings.Count(string(landing), `<span class="doc-reading-time">2 min read</span>`); got != 1 {
+		t.Errorf("Expected one card with a reading time, got %d:\n%s", got, landing)
+	}
+}
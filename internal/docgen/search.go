@@ -0,0 +1,96 @@
+package docgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// searchIndexFile is written to the output directory alongside the HTML
+const searchIndexFile = "search-index.json"
+
+// SearchEntry is one document in the offline search index
+type SearchEntry struct {
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Category string `json:"category"`
+	Content  string `json:"content"`
+}
+
+// searchable reports whether a doc belongs in the search index. Only docs
+// with a category are indexed: session transcripts and other pages without
+// frontmatter are megabytes of text that would swamp the real docs.
+func searchable(entry docEntry) bool {
+	return entry.Metadata.Category != ""
+}
+
+// extractSearchText collects the plain text of a document from its AST.
+// Runnable snippets are skipped (code is noise for search); headings,
+// prose and ordinary code blocks are kept.
+func extractSearchText(doc ast.Node, source []byte) string {
+	return plainText(doc, source, true)
+}
+
+// plainText collects the text of a document's headings and prose, with
+// whitespace collapsed. Ordinary code blocks are included if withCode is set;
+// runnable snippets, raw HTML and math never are.
+func plainText(doc ast.Node, source []byte, withCode bool) string {
+	var b strings.Builder
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			// Separate blocks so words from adjacent paragraphs don't run together
+			if n.Type() == ast.TypeBlock {
+				b.WriteString(" ")
+			}
+			return ast.WalkContinue, nil
+		}
+
+		switch node := n.(type) {
+		case *RunnableCodeBlock:
+			return ast.WalkSkipChildren, nil
+		case *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			if !withCode {
+				return ast.WalkSkipChildren, nil
+			}
+			lines := node.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				line := lines.At(i)
+				b.Write(line.Value(source))
+			}
+		case *ast.Text:
+			b.Write(node.Segment.Value(source))
+			if node.SoftLineBreak() || node.HardLineBreak() {
+				b.WriteString(" ")
+			}
+		case *ast.String:
+			b.Write(node.Value)
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	// Collapse whitespace so the index stays compact
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// writeSearchIndex writes the search index as JSON, sorted by URL for stable diffs.
+// The file is left untouched if its contents wouldn't change.
+func writeSearchIndex(path string, entries []SearchEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding search index: %w", err)
+	}
+
+	data = append(data, '\n')
+	return writeIfChanged(path, data, "search index")
+}
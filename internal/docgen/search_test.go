@@ -0,0 +1,61 @@
+package docgen
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+func TestExtractSearchText(t *testing.T) {
+	source := []byte("---\ntitle: Test\n---\n# Loops\n\nUse a *for* loop.\n\n```python-editor-text\nsecret_snippet_code()\n```\n\n## Ranges\n")
+
+	ctx := parser.NewContext()
+	doc := newMarkdown(Options{}).Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+	got := extractSearchText(doc, source)
+
+	if got != "Loops Use a for loop. Ranges" {
+		t.Errorf("Unexpected search text: %q", got)
+	}
+	if strings.Contains(got, "secret_snippet_code") {
+		t.Error("Runnable snippet code should not be indexed")
+	}
+}
+
+func TestGenerateAllDocs_IndexesOnlyCategorizedDocs(t *testing.T) {
+	docsDir := t.TempDir()
+	outputDir := t.TempDir()
+	docs := map[string]string{
+		"loops.md":            "---\ntitle: Loops\ncategory: Basics\n---\n\nUse a for loop.\n",
+		"sessions/session.md": "# Conversation Log\n\nA long transcript.\n",
+		"notes.md":            "---\ntitle: Notes\n---\n\nNo category here.\n",
+	}
+	for name, content := range docs {
+		path := filepath.Join(docsDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write doc: %v", err)
+		}
+	}
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, searchIndexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index []SearchEntry
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("Bad search index: %v", err)
+	}
+	if len(index) != 1 || index[0].URL != "/static/docs/loops.html" {
+		t.Errorf("Search index = %+v, want only loops.html", index)
+	}
+}
@@ -0,0 +1,106 @@
+package docgen
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// otherCategory is the sidebar group for documents without a category
+const otherCategory = "Other"
+
+// docEntry is a document discovered during generation
+type docEntry struct {
+	Metadata   DocMetadata
+	SearchText string
+	Links      []string        // Destinations of the doc's markdown links
+	Anchors    map[string]bool // IDs of the doc's headings
+	URL        string
+	InputPath  string
+	OutputPath string
+	ModTime    time.Time // Of the source
+}
+
+// docURL converts a markdown path relative to the docs directory into its served URL
+func docURL(relPath string) string {
+	return "/static/docs/" + filepath.ToSlash(strings.TrimSuffix(relPath, ".md")) + ".html"
+}
+
+// groupDocs groups entries by category, returning the categories in order
+// along with each one's documents in order. Categories are ordered by their
+// lowest document order ("Other" always last), and documents within a
+// category by order, then title, then URL.
+func groupDocs(entries []docEntry) ([]string, map[string][]docEntry) {
+	groups := make(map[string][]docEntry)
+	for _, entry := range entries {
+		category := entry.Metadata.Category
+		if category == "" {
+			category = otherCategory
+		}
+		groups[category] = append(groups[category], entry)
+	}
+
+	var categories []string
+	for category, docs := range groups {
+		sort.Slice(docs, func(i, j int) bool {
+			if docs[i].Metadata.Order != docs[j].Metadata.Order {
+				return docs[i].Metadata.Order < docs[j].Metadata.Order
+			}
+			if docs[i].Metadata.Title != docs[j].Metadata.Title {
+				return docs[i].Metadata.Title < docs[j].Metadata.Title
+			}
+			return docs[i].URL < docs[j].URL
+		})
+		categories = append(categories, category)
+	}
+
+	sort.Slice(categories, func(i, j int) bool {
+		ci, cj := categories[i], categories[j]
+		if (ci == otherCategory) != (cj == otherCategory) {
+			return cj == otherCategory
+		}
+		oi, oj := groups[ci][0].Metadata.Order, groups[cj][0].Metadata.Order
+		if oi != oj {
+			return oi < oj
+		}
+		return ci < cj
+	})
+	return categories, groups
+}
+
+// orderDocs returns entries in reading order, the order the sidebar lists them
+func orderDocs(entries []docEntry) []docEntry {
+	categories, groups := groupDocs(entries)
+	ordered := make([]docEntry, 0, len(entries))
+	for _, category := range categories {
+		ordered = append(ordered, groups[category]...)
+	}
+	return ordered
+}
+
+// renderSidebar builds the sidebar navigation, grouped by category in the
+// order given by groupDocs
+func renderSidebar(entries []docEntry) string {
+	categories, groups := groupDocs(entries)
+
+	var b strings.Builder
+	for _, category := range categories {
+		b.WriteString(`                <div class="docs-category">` + "\n")
+		fmt.Fprintf(&b, "                    <h3>%s</h3>\n", html.EscapeString(category))
+		for _, entry := range groups[category] {
+			fmt.Fprintf(&b, "                    <a href=\"%s\">%s", html.EscapeString(entry.URL), html.EscapeString(entry.Metadata.Title))
+			if entry.Metadata.Draft {
+				b.WriteString(` <span class="doc-draft-label">Draft</span>`)
+			}
+			if readingTime := entry.Metadata.ReadingTime(); readingTime != "" {
+				fmt.Fprintf(&b, " <span class=\"doc-reading-time\">%s</span>", readingTime)
+			}
+			b.WriteString("</a>\n")
+		}
+		b.WriteString("                </div>\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package docgen
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// sitemapFile and robotsFile are written to the output directory when
+	// Options.BaseURL is set. The server serves robotsFile at /robots.txt.
+	sitemapFile = "sitemap.xml"
+	robotsFile  = "robots.txt"
+
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+// sitemapURL is one <url> in a sitemap
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapURLSet is a sitemap's root element
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// writeSitemap writes a sitemap of entries, with absolute URLs under baseURL
+// and each page's source modification date as its last-modified date. URLs
+// are sorted so the output only changes when the docs do, and the file is
+// left untouched if its contents wouldn't change.
+func writeSitemap(path, baseURL string, entries []docEntry) error {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	set := sitemapURLSet{Xmlns: sitemapNamespace}
+	for _, entry := range entries {
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     baseURL + entry.URL,
+			LastMod: entry.ModTime.UTC().Format("2006-01-02"),
+		})
+	}
+	sort.Slice(set.URLs, func(i, j int) bool {
+		return set.URLs[i].Loc < set.URLs[j].Loc
+	})
+
+	data, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding sitemap: %w", err)
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	return writeIfChanged(path, data, "sitemap")
+}
+
+// writeRobots writes a robots.txt that lets crawlers index everything but
+// the account and API routes, and points them at the docs sitemap
+func writeRobots(path, baseURL string) error {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, prefix := range []string{"/admin/", "/api/", "/auth/", "/kv"} {
+		fmt.Fprintf(&b, "Disallow: %s\n", prefix)
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s%s%s\n", strings.TrimSuffix(baseURL, "/"), docsURLPrefix, sitemapFile)
+	return writeIfChanged(path, []byte(b.String()), "robots.txt")
+}
+
+// writeIfChanged writes data to path unless the file already holds exactly
+// that, describing the file as what in errors
+func writeIfChanged(path string, data []byte, what string) error {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", what, err)
+	}
+	return nil
+}
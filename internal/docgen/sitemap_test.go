@@ -0,0 +1,132 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateAllDocs_SitemapAndDrafts(t *testing.T) {
+	docsDir, outputDir := t.TempDir(), t.TempDir()
+	docs := map[string]string{
+		"b.md":     "---\ntitle: B\n---\nBee.\n",
+		"a.md":     "---\ntitle: A\ndraft: false\n---\nAy.\n",
+		"draft.md": "---\ntitle: Unfinished\ndraft: true\n---\nSoon.\n",
+	}
+	modTime := time.Date(2024, 3, 15, 23, 30, 0, 0, time.UTC)
+	for name, content := range docs {
+		path := filepath.Join(docsDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Output from before the doc became a draft
+	if err := os.WriteFile(filepath.Join(outputDir, "draft.html"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{BaseURL: "https://example.com/"}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+
+	sitemap, err := os.ReadFile(filepath.Join(outputDir, sitemapFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://example.com/static/docs/a.html</loc>
+    <lastmod>2024-03-15</lastmod>
+  </url>
+  <url>
+    <loc>https://example.com/static/docs/b.html</loc>
+    <lastmod>2024-03-15</lastmod>
+  </url>
+</urlset>
+`
+	if string(sitemap) != want {
+		t.Errorf("Unexpected sitemap:\n%s\nwant:\n%s", sitemap, want)
+	}
+
+	robots, err := os.ReadFile(filepath.Join(outputDir, robotsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(robots), "Sitemap: https://example.com/static/docs/sitemap.xml\n") {
+		t.Errorf("robots.txt doesn't point at the sitemap:\n%s", robots)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "draft.html")); !os.IsNotExist(err) {
+		t.Errorf("Expected draft output to be removed, got %v", err)
+	}
+	for _, name := range []string{"a.html", searchIndexFile} {
+		content, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(content), "Unfinished") {
+			t.Errorf("%s mentions the draft", name)
+		}
+	}
+}
+
+func TestGenerateAllDocs_NoBaseURLNoSitemap(t *testing.T) {
+	docsDir, outputDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(docsDir, "a.md"), []byte("# A\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateAllDocs(docsDir, outputDir, Options{}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+	for _, name := range []string{sitemapFile, robotsFile} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected no %s without a base URL, got %v", name, err)
+		}
+	}
+}
+
+func TestGenerateAllDocs_IncludeDrafts(t *testing.T) {
+	docsDir, outputDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(docsDir, "a.md"), []byte("---\ntitle: A\n---\nAy.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(docsDir, "draft.md"), []byte("---\ntitle: Unfinished\ndraft: true\n---\nSoon.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GenerateAllDocs(docsDir, outputDir, Options{BaseURL: "https://example.com", IncludeDrafts: true}); err != nil {
+		t.Fatalf("GenerateAllDocs failed: %v", err)
+	}
+	draft, err := os.ReadFile(filepath.Join(outputDir, "draft.html"))
+	if err != nil {
+		t.Fatalf("Expected draft to be generated: %v", err)
+	}
+	if !strings.Contains(string(draft), `<p class="doc-draft-banner" role="note">Draft: not published</p>`) {
+		t.Error("Expected a draft banner on the draft")
+	}
+	published, err := os.ReadFile(filepath.Join(outputDir, "a.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(published), `Unfinished <span class="doc-draft-label">Draft</span>`) {
+		t.Error("Expected the draft in the sidebar, labelled")
+	}
+	if strings.Contains(string(published), "doc-draft-banner") {
+		t.Error("Published doc has a draft banner")
+	}
+
+	// Previewing never publishes drafts to crawlers
+	sitemap, err := os.ReadFile(filepath.Join(outputDir, sitemapFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sitemap), "draft.html") {
+		t.Errorf("Sitemap lists the draft:\n%s", sitemap)
+	}
+}
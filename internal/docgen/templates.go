@@ -0,0 +1,83 @@
+package docgen
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"os"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// pageTemplates holds the full-page layouts: page.html for each doc and
+// landing.html for /learn.html
+var pageTemplates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// PageData is what the page templates are executed with. Title and
+// Description are plain text and get escaped; the other fields are
+// generated by docgen itself and inserted as-is.
+type PageData struct {
+	Title        string
+	Description  string
+	ReadingTime  string // e.g. "5 min read", or "" to leave it out
+	WordCount    int
+	Draft        bool                   // Only generated when previewing drafts
+	Docs         map[string]DocMetadata // By URL, for the landing page's cards
+	Categories   []DocCategory          // Every doc by category, for the landing page
+	Breadcrumbs  []NavLink              // From the landing page to this doc, which has no URL
+	Separator    string                 // Between breadcrumbs
+	Prev, Next   *NavLink               // Neighbors in reading order, nil at either end
+	Sidebar      template.HTML          // Rendered navigation, see renderSidebar
+	HighlightCSS template.CSS           // Stylesheet for highlighted code blocks
+	ThemeCSS     template.CSS           // Overrides docs.css's theme properties, or ""
+	Body         template.HTML          // The rendered markdown, trusted like the docs themselves
+	HasMath      bool                   // Body has math, so the page loads KaTeX
+	HasMermaid   bool                   // Body has a diagram, so the page loads Mermaid
+}
+
+// NavLink is a link to another doc page
+type NavLink struct {
+	Title string
+	URL   string
+}
+
+// renderPage executes the doc page template
+func renderPage(data PageData) ([]byte, error) {
+	return executeTemplate("page.html", data)
+}
+
+func executeTemplate(name string, data PageData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, fmt.Errorf("executing %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GenerateLandingPage creates the main /learn.html page, with reading times
+// on its cards and an index of every doc in docsDir by category
+func GenerateLandingPage(outputPath, docsDir string, opts Options) error {
+	entries, err := collectDocs(docsDir, "", opts)
+	if err != nil {
+		return err
+	}
+	entries, _ = publishedDocs(entries, opts)
+	docs := make(map[string]DocMetadata, len(entries))
+	for _, entry := range entries {
+		docs[entry.URL] = entry.Metadata
+	}
+
+	content, err := executeTemplate("landing.html", PageData{
+		Title:       "Learn Python",
+		Description: "Interactive Python tutorials and documentation for Trifling",
+		Docs:        docs,
+		Categories:  landingCategories(entries),
+		ThemeCSS:    themeCSS(opts.Theme),
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, content, 0644)
+}
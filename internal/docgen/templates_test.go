@@ -0,0 +1,107 @@
+package docgen
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderPage_EscapesTextButNotBody(t *testing.T) {
+	out, err := renderPage(PageData{
+		Title:        `Loops & <Lists>`,
+		Description:  `Say "hi"`,
+		Sidebar:      template.HTML(`<a href="/static/docs/intro.html">Intro</a>`),
+		HighlightCSS: template.CSS(`.chroma { color: red; }`),
+		Body:         template.HTML(`<div class="runnable-snippet" data-mode="text"></div>`),
+	})
+	if err != nil {
+		t.Fatalf("renderPage failed: %v", err)
+	}
+	page := string(out)
+
+	for _, want := range []string{
+		`<title>Loops &amp; &lt;Lists&gt; - Trifling Documentation</title>`,
+		`<meta name="description" content="Say &#34;hi&#34;">`,
+		`<a href="/static/docs/intro.html">Intro</a>`,
+		`.chroma { color: red; }`,
+		`<div class="runnable-snippet" data-mode="text"></div>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected page to contain %q", want)
+		}
+	}
+}
+
+func TestGenerateLandingPage(t *testing.T) {
+	path := t.TempDir() + "/learn.html"
+	if err := GenerateLandingPage(path, t.TempDir(), Options{}); err != nil {
+		t.Fatalf("GenerateLandingPage failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !strings.Contains(string(content), "<title>Learn Python - Trifling Documentation</title>") {
+		t.Errorf("Unexpected landing page:\n%s", content)
+	}
+}
+
+func TestGenerateLandingPage_Categories(t *testing.T) {
+	docsDir := t.TempDir()
+	docs := map[string]string{
+		"turtle.md": "---\ntitle: Turtle\ncategory: Graphics\n---\nDraw.\n",
+		"misc.md":   "---\ntitle: Misc\n---\nHi.\n",
+	}
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(docsDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "learn.html")
+	if err := GenerateLandingPage(path, docsDir, Options{}); err != nil {
+		t.Fatalf("GenerateLandingPage failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	// Breadcrumbs link to these sections
+	for _, want := range []string{
+		`<h3 id="category-graphics">Graphics</h3>`,
+		`<li><a href="/static/docs/turtle.html">Turtle</a></li>`,
+		`<h3 id="category-other">Other</h3>`,
+		`<li><a href="/static/docs/misc.html">Misc</a></li>`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected landing page to contain %q", want)
+		}
+	}
+}
+
+func TestRenderPage_AccessibilityLandmarks(t *testing.T) {
+	out, err := renderPage(PageData{Title: "Intro"})
+	if err != nil {
+		t.Fatalf("renderPage failed: %v", err)
+	}
+	page := string(out)
+
+	// The skip link must be the first thing in the body so keyboard users reach it first
+	body := page[strings.Index(page, "<body>")+len("<body>"):]
+	if !strings.HasPrefix(strings.TrimSpace(body), `<a href="#main-content" class="skip-link">Skip to main content</a>`) {
+		t.Errorf("Expected skip link at the top of the body:\n%s", body)
+	}
+
+	for _, want := range []string{
+		`<header class="app-header" role="banner">`,
+		`<nav class="nav-container" aria-label="Main">`,
+		`<aside class="docs-sidebar" role="complementary" aria-labelledby="docs-sidebar-heading">`,
+		`<main class="docs-content" id="main-content" role="main" tabindex="-1">`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected page to contain %q", want)
+		}
+	}
+}
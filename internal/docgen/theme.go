@@ -0,0 +1,90 @@
+package docgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Theme rebrands the docs without editing docs.css. Each field overrides one
+// of the CSS custom properties docs.css defines on :root; empty fields keep
+// the stylesheet's defaults, which are the original look.
+type Theme struct {
+	Primary string `json:"primary"` // --docs-primary: header, headings
+	Accent  string `json:"accent"`  // --docs-accent: links, buttons, highlights
+	Font    string `json:"font"`    // --docs-font: a font-family list for body text
+}
+
+var (
+	// cssColor matches hex colors, rgb()/hsl() functions and named colors.
+	// Anything else could break out of the declaration, so it's rejected.
+	cssColor = regexp.MustCompile(`^(#([0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})|(rgb|rgba|hsl|hsla)\([0-9.,%/ ]+\)|[a-zA-Z]+)$`)
+
+	// cssFontFamily matches a comma-separated list of family names, quoted
+	// or not
+	cssFontFamily = regexp.MustCompile(`^[A-Za-z0-9 ,'"-]+$`)
+)
+
+// LoadTheme reads a Theme from a JSON file such as
+// {"primary": "#1b4332", "accent": "#2d6a4f", "font": "Georgia, serif"}.
+// Values that aren't valid CSS are reported as warnings and dropped, so the
+// defaults are used for them instead.
+func LoadTheme(path string) (Theme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Theme{}, fmt.Errorf("reading theme: %w", err)
+	}
+	var theme Theme
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&theme); err != nil {
+		return Theme{}, fmt.Errorf("parsing theme %s: %w", path, err)
+	}
+
+	valid, invalid := theme.validated()
+	for _, name := range invalid {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring invalid theme %s in %s, using the default\n", name, path)
+	}
+	return valid, nil
+}
+
+// validated returns the theme with invalid values cleared, along with the
+// names of the fields that were
+func (t Theme) validated() (Theme, []string) {
+	var invalid []string
+	check := func(name string, value *string, pattern *regexp.Regexp) {
+		*value = strings.TrimSpace(*value)
+		if *value != "" && !pattern.MatchString(*value) {
+			invalid = append(invalid, fmt.Sprintf("%s %q", name, *value))
+			*value = ""
+		}
+	}
+	check("primary", &t.Primary, cssColor)
+	check("accent", &t.Accent, cssColor)
+	check("font", &t.Font, cssFontFamily)
+	return t, invalid
+}
+
+// themeCSS returns a :root rule overriding docs.css's custom properties with
+// the theme's valid values, or "" if it doesn't override any
+func themeCSS(t Theme) template.CSS {
+	t, _ = t.validated()
+	var b strings.Builder
+	for _, prop := range []struct{ name, value string }{
+		{"--docs-primary", t.Primary},
+		{"--docs-accent", t.Accent},
+		{"--docs-font", t.Font},
+	} {
+		if prop.value != "" {
+			fmt.Fprintf(&b, "    %s: %s;\n", prop.name, prop.value)
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return template.CSS(":root {\n" + b.String() + "}\n")
+}
@@ -0,0 +1,80 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestThemeCSS(t *testing.T) {
+	tests := []struct {
+		name  string
+		theme Theme
+		want  string
+	}{
+		{name: "defaults", theme: Theme{}, want: ""},
+		{
+			name:  "all set",
+			theme: Theme{Primary: "#1b4332", Accent: " rgb(45, 106, 79) ", Font: `"Atkinson Hyperlegible", Georgia, serif`},
+			want:  ":root {\n    --docs-primary: #1b4332;\n    --docs-accent: rgb(45, 106, 79);\n    --docs-font: \"Atkinson Hyperlegible\", Georgia, serif;\n}\n",
+		},
+		{
+			name:  "invalid values fall back",
+			theme: Theme{Primary: "red; } body { display: none", Accent: "teal", Font: "x</style><script>"},
+			want:  ":root {\n    --docs-accent: teal;\n}\n",
+		},
+		{name: "nothing valid", theme: Theme{Primary: "#12345"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(themeCSS(tt.theme)); got != tt.want {
+				t.Errorf("themeCSS = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTheme(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	theme, err := LoadTheme(write("ok.json", `{"primary": "navy", "accent": "#zzz"}`))
+	if err != nil {
+		t.Fatalf("LoadTheme failed: %v", err)
+	}
+	if theme != (Theme{Primary: "navy"}) {
+		t.Errorf("Expected the invalid accent to be dropped, got %+v", theme)
+	}
+
+	if _, err := LoadTheme(write("typo.json", `{"primray": "navy"}`)); err == nil {
+		t.Error("Expected an unknown field to be an error")
+	}
+	if _, err := LoadTheme(write("bad.json", `{`)); err == nil {
+		t.Error("Expected malformed JSON to be an error")
+	}
+}
+
+func TestRenderPage_Theme(t *testing.T) {
+	plain, err := renderPage(PageData{Title: "Intro"})
+	if err != nil {
+		t.Fatalf("renderPage failed: %v", err)
+	}
+	if strings.Contains(string(plain), "--docs-") {
+		t.Error("Expected no theme overrides by default")
+	}
+
+	themed, err := renderPage(PageData{Title: "Intro", ThemeCSS: themeCSS(Theme{Primary: "#1b4332"})})
+	if err != nil {
+		t.Fatalf("renderPage failed: %v", err)
+	}
+	if !strings.Contains(string(themed), "<style>\n:root {\n    --docs-primary: #1b4332;\n}\n    </style>") {
+		t.Errorf("Expected theme overrides in the head, got:\n%s", themed)
+	}
+}
@@ -0,0 +1,123 @@
+package docgen
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindVideo is the node kind for embedded videos
+var KindVideo = ast.NewNodeKind("Video")
+
+// youtubeShortcode matches a line holding `{{< youtube ID >}}`, optionally
+// with a quoted title after the ID
+var youtubeShortcode = regexp.MustCompile(`^\{\{<\s*youtube(?:\s+([^\s">]+))?(?:\s+"([^"]*)")?\s*>\}\}\s*$`)
+
+// youtubeID matches a valid YouTube video ID
+var youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// youtubeEmbedURL is the privacy-enhanced player, which sets no cookies until
+// the video is played
+const youtubeEmbedURL = "https://www.youtube-nocookie.com/embed/"
+
+// Video is a YouTube video embedded with a `{{< youtube ID >}}` line
+type Video struct {
+	ast.BaseBlock
+	ID    string // As written, which may be empty or invalid
+	Title string // For the iframe's accessible name; may be empty
+}
+
+// Dump implements ast.Node
+func (n *Video) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"ID": n.ID, "Title": n.Title}, nil)
+}
+
+// Kind implements ast.Node
+func (n *Video) Kind() ast.NodeKind {
+	return KindVideo
+}
+
+// Valid reports whether the video's ID could be a YouTube video
+func (n *Video) Valid() bool {
+	return youtubeID.MatchString(n.ID)
+}
+
+// videoBlockParser recognizes video shortcodes on lines of their own:
+//
+//	{{< youtube dQw4w9WgXcQ "Drawing a square with the turtle" >}}
+type videoBlockParser struct{}
+
+func (p *videoBlockParser) Trigger() []byte {
+	return []byte{'{'}
+}
+
+func (p *videoBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	match := youtubeShortcode.FindSubmatch(line[pos:])
+	if match == nil {
+		return nil, parser.NoChildren
+	}
+	reader.AdvanceToEOL()
+	return &Video{ID: string(match[1]), Title: string(match[2])}, parser.NoChildren
+}
+
+func (p *videoBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	return parser.Close
+}
+
+func (p *videoBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *videoBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *videoBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// VideoRenderer renders Video nodes as lazily loaded, responsive embeds, or
+// as a placeholder saying what's wrong if the ID isn't valid
+type VideoRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer
+func (r *VideoRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindVideo, r.renderVideo)
+}
+
+func (r *VideoRenderer) renderVideo(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*Video)
+	if !n.Valid() {
+		message := "Video unavailable: no YouTube video ID given"
+		if n.ID != "" {
+			message = fmt.Sprintf("Video unavailable: %q isn't a valid YouTube video ID", n.ID)
+		}
+		w.WriteString(`<div class="video-embed video-embed-invalid" role="note">`)
+		w.WriteString(html.EscapeString(message))
+		w.WriteString("</div>\n")
+		return ast.WalkSkipChildren, nil
+	}
+
+	title := n.Title
+	if title == "" {
+		title = "YouTube video"
+	}
+	fmt.Fprintf(w, `<div class="video-embed"><iframe src="%s%s" title="%s" loading="lazy" `+
+		`allow="accelerometer; encrypted-media; gyroscope; picture-in-picture" `+
+		`referrerpolicy="strict-origin-when-cross-origin" allowfullscreen></iframe></div>`+"\n",
+		youtubeEmbedURL, n.ID, html.EscapeString(title))
+	return ast.WalkSkipChildren, nil
+}
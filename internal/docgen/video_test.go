@@ -0,0 +1,67 @@
+package docgen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVideo(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:   "embed",
+			source: "Watch this:\n{{< youtube dQw4w9WgXcQ >}}\n",
+			want: []string{
+				"<p>Watch this:</p>",
+				`<div class="video-embed"><iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ" title="YouTube video" loading="lazy"`,
+			},
+		},
+		{
+			name:   "title",
+			source: `{{<youtube dQw4w9WgXcQ "Squares & <spirals>">}}`,
+			want:   []string{`title="Squares &amp; &lt;spirals&gt;"`},
+		},
+		{
+			name:    "invalid ID",
+			source:  "{{< youtube not-an-id&x=1 >}}\n",
+			want:    []string{`<div class="video-embed video-embed-invalid" role="note">Video unavailable: &#34;not-an-id&amp;x=1&#34; isn&#39;t a valid YouTube video ID</div>`},
+			notWant: []string{"<iframe"},
+		},
+		{
+			name:    "missing ID",
+			source:  "{{< youtube >}}\n",
+			want:    []string{"Video unavailable: no YouTube video ID given"},
+			notWant: []string{"<iframe"},
+		},
+		{
+			name:    "inline text is left alone",
+			source:  "Write `{{< youtube ID >}}` on a line of its own, not {{< youtube dQw4w9WgXcQ >}} mid-sentence.\n",
+			notWant: []string{"<iframe", "video-embed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := newMarkdown(Options{}).Convert([]byte(tt.source), &buf); err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			out := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("Expected output not to contain %q, got:\n%s", notWant, out)
+				}
+			}
+		})
+	}
+}
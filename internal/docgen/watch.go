@@ -0,0 +1,113 @@
+package docgen
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// watchPollInterval is how often Watch looks for changed markdown files
+	watchPollInterval = 250 * time.Millisecond
+
+	// watchDebounce is how long files must stay unchanged before Watch rebuilds,
+	// so an editor's burst of saves triggers one rebuild
+	watchDebounce = 300 * time.Millisecond
+)
+
+// fileState is what Watch compares to notice a changed file
+type fileState struct {
+	modTime int64 // Nanoseconds
+	size    int64
+}
+
+// Watch polls docsDir for added, changed or removed .md files and calls
+// rebuild with their paths once changes settle. Errors from rebuild are
+// passed to onError and watching continues. Watch returns when ctx is done.
+func Watch(ctx context.Context, docsDir string, rebuild func(changed []string) error, onError func(error)) error {
+	return watch(ctx, docsDir, watchPollInterval, watchDebounce, rebuild, onError)
+}
+
+func watch(ctx context.Context, docsDir string, poll, debounce time.Duration, rebuild func(changed []string) error, onError func(error)) error {
+	last, err := snapshotDocs(docsDir)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	pending := make(map[string]bool)
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			current, err := snapshotDocs(docsDir)
+			if err != nil {
+				// Editors sometimes replace files non-atomically; try again next tick
+				continue
+			}
+
+			if changed := diffSnapshots(last, current); len(changed) > 0 {
+				for _, path := range changed {
+					pending[path] = true
+				}
+				lastChange = now
+				last = current
+				continue
+			}
+
+			if len(pending) == 0 || now.Sub(lastChange) < debounce {
+				continue
+			}
+
+			changed := make([]string, 0, len(pending))
+			for path := range pending {
+				changed = append(changed, path)
+			}
+			sort.Strings(changed)
+			pending = make(map[string]bool)
+
+			if err := rebuild(changed); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// snapshotDocs records the state of every .md file under docsDir
+func snapshotDocs(docsDir string) (map[string]fileState, error) {
+	states := make(map[string]fileState)
+	err := filepath.Walk(docsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".md" {
+			return nil
+		}
+		states[path] = fileState{modTime: info.ModTime().UnixNano(), size: info.Size()}
+		return nil
+	})
+	return states, err
+}
+
+// diffSnapshots returns the paths that were added, changed or removed between two snapshots
+func diffSnapshots(before, after map[string]fileState) []string {
+	var changed []string
+	for path, state := range after {
+		if old, ok := before[path]; !ok || old != state {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
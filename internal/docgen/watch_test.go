@@ -0,0 +1,67 @@
+package docgen
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatch_DebouncesAndStops(t *testing.T) {
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "intro.md")
+	if err := os.WriteFile(doc, []byte("# Intro\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rebuilds := make(chan []string, 10)
+	done := make(chan error)
+	go func() {
+		done <- watch(ctx, dir, 10*time.Millisecond, 100*time.Millisecond, func(changed []string) error {
+			rebuilds <- changed
+			return nil
+		}, func(err error) {
+			t.Errorf("Unexpected rebuild error: %v", err)
+		})
+	}()
+
+	// A burst of saves, including a non-markdown file that should be ignored
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(doc, []byte("# Intro\n"+string(rune('a'+i))+"\n"), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	select {
+	case changed := <-rebuilds:
+		if !reflect.DeepEqual(changed, []string{doc}) {
+			t.Errorf("Expected rebuild for %s, got %v", doc, changed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for rebuild")
+	}
+
+	// The burst should have produced exactly one rebuild
+	time.Sleep(200 * time.Millisecond)
+	if len(rebuilds) != 0 {
+		t.Errorf("Expected one debounced rebuild, got %d more", len(rebuilds))
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean stop, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not stop after cancel")
+	}
+}
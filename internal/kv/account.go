@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// userPrefixes returns every prefix holding email's keys: the current
+// domain/{domain}/user/{localpart} and the legacy user/{email}
+func userPrefixes(email string) ([]string, error) {
+	email = strings.ToLower(email)
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 || strings.ContainsAny(email, "/\\") || strings.Contains(email, "..") {
+		return nil, fmt.Errorf("invalid email: %q", email)
+	}
+	return []string{
+		"domain/" + email[at+1:] + "/user/" + email[:at],
+		"user/" + email,
+	}, nil
+}
+
+// DeleteUser removes every key belonging to email, along with their sharing
+// and metadata, and returns how many keys were removed. The deletion is
+// audited before anything is removed.
+func (s *Store) DeleteUser(email string) (int, error) {
+	prefixes, err := userPrefixes(email)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, prefix := range prefixes {
+		keys, err := s.List(prefix, 0, true)
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		s.record("delete-user", prefix)
+		path, _ := s.keyPath(prefix)
+		if err := os.RemoveAll(path); err != nil {
+			return deleted, fmt.Errorf("failed to delete user data: %w", err)
+		}
+		deleted += len(keys)
+	}
+
+	for _, prefix := range prefixes {
+		os.RemoveAll(filepath.Join(s.dataDir, publicDir, prefix))
+		os.RemoveAll(filepath.Join(s.dataDir, metaDir, prefix))
+	}
+	return deleted, nil
+}
+
+// DeleteAccountRequest confirms an account deletion by repeating the email
+type DeleteAccountRequest struct {
+	Confirm string `json:"confirm"`
+}
+
+// DeleteAccountResult summarizes a completed account deletion
+type DeleteAccountResult struct {
+	Email         string `json:"email"`
+	KeysDeleted   int    `json:"keysDeleted"`
+	SessionsEnded int    `json:"sessionsEnded"`
+}
+
+// HandleDeleteAccount handles DELETE /api/account, removing all of the signed-in
+// user's data and ending all their sessions via endSessions. The body must be
+// {"confirm": "<the user's email>"} so it can't happen by accident.
+func (h *Handlers) HandleDeleteAccount(endSessions func(email string) int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		email, ok := r.Context().Value("user_email").(string)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		email = strings.ToLower(email)
+
+		var req DeleteAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.EqualFold(strings.TrimSpace(req.Confirm), email) {
+			http.Error(w, `Confirm by sending {"confirm": "<your email>"}`, http.StatusBadRequest)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "Account deletion requested", "audit", true, "user", email)
+		deleted, err := h.store.DeleteUser(email)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to delete account", "error", err, "user", email, "keys_deleted", deleted)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		ended := endSessions(email)
+		slog.InfoContext(r.Context(), "Account deleted", "audit", true, "user", email, "keys_deleted", deleted, "sessions_ended", ended)
+
+		setConsistencyToken(w)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DeleteAccountResult{Email: email, KeysDeleted: deleted, SessionsEnded: ended})
+	}
+}
@@ -0,0 +1,90 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleDeleteAccount(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	alice := "domain/example.com/user/alice/"
+	store.Put(alice+"trifle/a", []byte("a"))
+	store.Put(alice+"trifle/b", []byte("b"))
+	store.Put("user/alice@example.com/old", []byte("legacy"))
+	store.Put("domain/example.com/user/alicia/keep", []byte("not alice"))
+	store.Put("file/abc", []byte("shared blob"))
+	store.SetPublic(alice+"trifle/a", true)
+
+	var endedFor string
+	handler := handlers.HandleDeleteAccount(func(email string) int {
+		endedFor = email
+		return 2
+	})
+	serve := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/api/account", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "Alice@Example.com"))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// Nothing happens without the right confirmation
+	for _, body := range []string{"", `{}`, `{"confirm":"bob@example.com"}`} {
+		if w := serve(body); w.Code != http.StatusBadRequest {
+			t.Errorf("DELETE with body %q status = %d, want 400", body, w.Code)
+		}
+	}
+	if !store.Exists(alice + "trifle/a") {
+		t.Fatal("Unconfirmed deletion removed data")
+	}
+
+	w := serve(`{"confirm":"alice@example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d: %s", w.Code, w.Body.String())
+	}
+	var result DeleteAccountResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	want := DeleteAccountResult{Email: "alice@example.com", KeysDeleted: 3, SessionsEnded: 2}
+	if result != want {
+		t.Errorf("Result = %+v, want %+v", result, want)
+	}
+	if endedFor != "alice@example.com" {
+		t.Errorf("Sessions ended for %q", endedFor)
+	}
+
+	for _, key := range []string{alice + "trifle/a", alice + "trifle/b", "user/alice@example.com/old"} {
+		if store.Exists(key) {
+			t.Errorf("%s survived account deletion", key)
+		}
+	}
+	if store.IsPublic(alice + "trifle/a") {
+		t.Error("Sharing survived account deletion")
+	}
+	for _, key := range []string{"domain/example.com/user/alicia/keep", "file/abc"} {
+		if !store.Exists(key) {
+			t.Errorf("%s was deleted along with alice's account", key)
+		}
+	}
+}
+
+func TestDeleteUser_InvalidEmail(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	for _, email := range []string{"", "alice", "@example.com", "alice@", "../x@example.com", "a/b@example.com"} {
+		if _, err := store.DeleteUser(email); err == nil {
+			t.Errorf("DeleteUser(%q) succeeded", email)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultAuditMaxSize is how large the audit log grows before it is rotated.
+	// One rotated file is kept, so the log uses at most twice this on disk.
+	DefaultAuditMaxSize = 10 << 20
+
+	// auditQueueSize bounds how many entries can wait to be written. Writers
+	// never block on the log; entries beyond this are dropped and counted.
+	auditQueueSize = 1024
+
+	// defaultAuditLimit is how many entries GET /kvaudit returns by default
+	defaultAuditLimit = 100
+)
+
+// AuditEntry records one mutation of the store
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user,omitempty"` // Owner of the key; empty for shared keys such as file/*
+	Key  string    `json:"key"`
+	Op   string    `json:"op"` // "put", "create", "patch", "pointer", "delete" or "delete-user"
+}
+
+// AuditLog is an append-only log of store mutations, kept as JSON lines in a
+// file that is rotated once it reaches a maximum size. Entries are written by
+// a background goroutine so recording one costs a channel send.
+type AuditLog struct {
+	path    string
+	maxSize int64
+	now     func() time.Time
+
+	entries chan AuditEntry
+	dropped atomic.Int64
+	done    chan struct{}
+
+	mu   sync.Mutex // Guards the file against concurrent rotation and reads
+	file *os.File
+	size int64
+}
+
+// OpenAuditLog opens (or creates) the audit log at path and starts its writer.
+// Close it to flush pending entries.
+func OpenAuditLog(path string, maxSize int64) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), DefaultDirMode); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat audit log: %w", err)
+	}
+
+	l := &AuditLog{
+		path:    path,
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: make(chan AuditEntry, auditQueueSize),
+		done:    make(chan struct{}),
+		file:    file,
+		size:    info.Size(),
+	}
+	go l.run()
+	return l, nil
+}
+
+// SetAuditLog records every mutation made through the store in l
+func (s *Store) SetAuditLog(l *AuditLog) {
+	s.audit = l
+}
+
+// record notes a completed mutation of key: it drops anything cached from
+// the owner's keys, and queues an audit entry and webhook deliveries if
+// those are enabled
+func (s *Store) record(op, key string) {
+	owner := keyOwner(key)
+	s.graphs.invalidate(owner)
+	if s.webhooks != nil && owner != "" {
+		s.webhooks.notify(WebhookEvent{User: owner, Key: key, Op: op, Time: time.Now().UTC()})
+	}
+	if s.audit == nil {
+		return
+	}
+	select {
+	case s.audit.entries <- AuditEntry{Time: s.audit.now(), User: owner, Key: key, Op: op}:
+	default:
+		s.audit.dropped.Add(1)
+	}
+}
+
+// run writes queued entries until the log is closed
+func (l *AuditLog) run() {
+	defer close(l.done)
+	for entry := range l.entries {
+		if dropped := l.dropped.Swap(0); dropped > 0 {
+			slog.Warn("Audit log queue full, entries dropped", "dropped", dropped)
+		}
+		if err := l.write(entry); err != nil {
+			slog.Error("Failed to write audit entry", "error", err, "key", entry.Key, "op", entry.Op)
+		}
+	}
+}
+
+func (l *AuditLog) write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate moves the current log aside, replacing any older rotated log. If
+// that fails the current log stays open and keeps growing.
+func (l *AuditLog) rotate() error {
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen audit log: %w", err)
+	}
+	l.file.Close()
+	l.file = file
+	l.size = 0
+	return nil
+}
+
+// Close writes any queued entries and closes the log
+func (l *AuditLog) Close() error {
+	close(l.entries)
+	<-l.done
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// AuditQuery filters audit entries. Zero fields match everything.
+type AuditQuery struct {
+	User  string
+	Since time.Time // Inclusive
+	Until time.Time // Exclusive
+	Limit int       // Most recent entries to return
+}
+
+// Query returns the most recent matching entries, oldest first
+func (l *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var matches []AuditEntry
+	for _, path := range []string{l.path + ".1", l.path} {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue // A torn line from a crash mid-write
+			}
+			if q.User != "" && entry.User != q.User {
+				continue
+			}
+			if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+				continue
+			}
+			if !q.Until.IsZero() && !entry.Time.Before(q.Until) {
+				continue
+			}
+			matches = append(matches, entry)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+	}
+
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[len(matches)-q.Limit:]
+	}
+	return matches, nil
+}
+
+// HandleAudit handles GET /kvaudit?user=&since=&until=&limit=, returning
+// recent audit entries as JSON. Times are RFC 3339. Admin-only.
+func (l *AuditLog) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := AuditQuery{User: strings.ToLower(query.Get("user")), Limit: defaultAuditLimit}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid "+name+" parameter", http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+
+	entries, err := l.Query(q)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to query audit log", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,134 @@
+package kv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog_RecordsMutations(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	logPath := filepath.Join(t.TempDir(), "kv.log")
+	audit, err := OpenAuditLog(logPath, DefaultAuditMaxSize)
+	if err != nil {
+		t.Fatalf("OpenAuditLog failed: %v", err)
+	}
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	audit.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	store.SetAuditLog(audit)
+
+	alice := "domain/example.com/user/alice/"
+	store.Put(alice+"trifle/a", []byte(`{}`))
+	created, _ := store.Create(alice+"trifle", []byte("x"))
+	store.Patch(alice+"trifle/a", []byte(`{"b":1}`), "", nil)
+	store.PutPointer(alice+"latest", alice+"trifle/a")
+	store.Put("domain/example.com/user/bob/trifle", []byte("y"))
+	store.Delete(alice + "trifle/a")
+	if err := audit.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Entries survive a restart
+	audit, err = OpenAuditLog(logPath, DefaultAuditMaxSize)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer audit.Close()
+
+	entries, err := audit.Query(AuditQuery{User: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var got [][2]string
+	for _, e := range entries {
+		got = append(got, [2]string{e.Op, e.Key})
+	}
+	want := [][2]string{
+		{"put", alice + "trifle/a"},
+		{"create", created},
+		{"patch", alice + "trifle/a"},
+		{"pointer", alice + "latest"},
+		{"delete", alice + "trifle/a"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Entries = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Entry %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Time bounds are [since, until), and the limit keeps the most recent
+	entries, _ = audit.Query(AuditQuery{
+		Since: time.Date(2025, 3, 1, 9, 2, 0, 0, time.UTC),
+		Until: time.Date(2025, 3, 1, 9, 6, 0, 0, time.UTC),
+		Limit: 2,
+	})
+	if len(entries) != 2 || entries[0].Op != "pointer" || entries[1].User != "bob@example.com" {
+		t.Errorf("Bounded query = %+v", entries)
+	}
+}
+
+func TestAuditLog_Rotation(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "kv.log")
+	audit, err := OpenAuditLog(logPath, 300)
+	if err != nil {
+		t.Fatalf("OpenAuditLog failed: %v", err)
+	}
+	store, _ := NewStore(t.TempDir())
+	store.SetAuditLog(audit)
+	for range 20 {
+		store.Put("domain/example.com/user/alice/k", []byte("v"))
+	}
+	audit.Close()
+
+	for _, path := range []string{logPath, logPath + ".1"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat %s: %v", path, err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("%s is %d bytes, over the 300 byte limit", path, info.Size())
+		}
+	}
+}
+
+func TestHandleAudit(t *testing.T) {
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "kv.log"), DefaultAuditMaxSize)
+	if err != nil {
+		t.Fatalf("OpenAuditLog failed: %v", err)
+	}
+	store, _ := NewStore(t.TempDir())
+	store.SetAuditLog(audit)
+	store.Put("domain/example.com/user/alice/a", []byte("1"))
+	store.Put("domain/example.com/user/bob/b", []byte("2"))
+	audit.Close()
+
+	w := httptest.NewRecorder()
+	audit.HandleAudit(w, httptest.NewRequest(http.MethodGet, "/kvaudit?user=Bob@Example.com", nil))
+	var entries []AuditEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Key != "domain/example.com/user/bob/b" {
+		t.Errorf("Entries for bob = %+v", entries)
+	}
+
+	w = httptest.NewRecorder()
+	audit.HandleAudit(w, httptest.NewRequest(http.MethodGet, "/kvaudit?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid since status = %d, want 400", w.Code)
+	}
+}
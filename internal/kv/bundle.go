@@ -0,0 +1,135 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bundle is everything the server holds for one key, for migration or debugging.
+// For a trifle (a trifle/latest/{trifle_id} prefix) the history is every version
+// pointer ever written for it, oldest first, and Current is the newest.
+type Bundle struct {
+	Key     string          `json:"key"`
+	Current *BundleVersion  `json:"current"`
+	History []BundleVersion `json:"history"`
+}
+
+// BundleVersion is a single stored value within a bundle
+type BundleVersion struct {
+	Key      string          `json:"key"`
+	Size     int64           `json:"size"`
+	Modified time.Time       `json:"modified"`
+	Value    []byte          `json:"value,omitempty"` // Raw bytes (base64 in JSON)
+	Data     json.RawMessage `json:"data,omitempty"`  // Trifle version metadata, passed through verbatim
+}
+
+// trifleLatestPrefix reports whether key names a single trifle's latest-pointer
+// directory, returning the user prefix it lives under.
+// e.g. "domain/example.com/user/alice/trifle/latest/trifle_123" -> "domain/example.com/user/alice"
+func trifleLatestPrefix(key string) (string, bool) {
+	key = strings.TrimSuffix(key, "/")
+	idx := strings.LastIndex(key, "/trifle/latest/")
+	if idx == -1 {
+		return "", false
+	}
+	trifleID := key[idx+len("/trifle/latest/"):]
+	if trifleID == "" || strings.Contains(trifleID, "/") {
+		return "", false
+	}
+	return key[:idx], true
+}
+
+// buildBundle gathers the current value and history for a key
+func (h *Handlers) buildBundle(key string) (*Bundle, error) {
+	userPrefix, isTrifle := trifleLatestPrefix(key)
+	if !isTrifle {
+		// Plain key: just the current value, no history
+		version, err := h.bundleVersion(key, false)
+		if err != nil {
+			return nil, err
+		}
+		return &Bundle{Key: key, Current: version, History: []BundleVersion{}}, nil
+	}
+
+	pointers, err := h.store.List(strings.TrimSuffix(key, "/"), 1, false)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{Key: key, History: []BundleVersion{}}
+	for _, pointer := range pointers {
+		versionID := pointer[strings.LastIndex(pointer, "/")+1:]
+		info, err := h.store.Stat(pointer)
+		if err != nil {
+			return nil, err
+		}
+
+		version, err := h.bundleVersion(userPrefix+"/trifle/version/"+versionID, true)
+		if err != nil {
+			// Pointer without version data - still record that it existed
+			version = &BundleVersion{Key: userPrefix + "/trifle/version/" + versionID}
+		}
+		version.Modified = info.ModTime()
+		bundle.History = append(bundle.History, *version)
+	}
+
+	if len(bundle.History) == 0 {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+
+	sort.SliceStable(bundle.History, func(i, j int) bool {
+		if !bundle.History[i].Modified.Equal(bundle.History[j].Modified) {
+			return bundle.History[i].Modified.Before(bundle.History[j].Modified)
+		}
+		return bundle.History[i].Key < bundle.History[j].Key
+	})
+	current := bundle.History[len(bundle.History)-1]
+	bundle.Current = &current
+
+	return bundle, nil
+}
+
+// bundleVersion reads one stored value. Trifle version metadata is JSON written
+// by the client, so it is embedded as-is rather than base64-encoded.
+func (h *Handlers) bundleVersion(key string, isJSON bool) (*BundleVersion, error) {
+	value, err := h.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := h.store.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	version := &BundleVersion{Key: key, Size: info.Size(), Modified: info.ModTime()}
+	if isJSON && json.Valid(value) {
+		version.Data = value
+	} else {
+		version.Value = value
+	}
+	return version, nil
+}
+
+// handleBundle returns a key's current value and full history as one JSON download
+func (h *Handlers) handleBundle(w http.ResponseWriter, r *http.Request, key string) {
+	bundle, err := h.buildBundle(key)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "Not found", http.StatusNotFound)
+		} else {
+			slog.ErrorContext(r.Context(), "Failed to build bundle", "error", err, "key", key)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	filename := key[strings.LastIndex(strings.TrimSuffix(key, "/"), "/")+1:]
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.TrimSuffix(filename, "/")+`.bundle.json"`)
+	json.NewEncoder(w).Encode(bundle)
+}
@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHandleBundle_TrifleHistory(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	userPrefix := "domain/example.com/user/alice"
+	trifleKey := userPrefix + "/trifle/latest/trifle_123"
+	base := time.Now().Add(-time.Hour)
+
+	versions := []string{"version_aaa", "version_bbb", "version_ccc"}
+	for i, versionID := range versions {
+		versionData := `{"trifle_id":"trifle_123","logical_clock":` + strconv.Itoa(i+1) + `}`
+		if err := store.Put(userPrefix+"/trifle/version/"+versionID, []byte(versionData)); err != nil {
+			t.Fatalf("Put version failed: %v", err)
+		}
+		pointer := trifleKey + "/" + versionID
+		if err := store.Put(pointer, nil); err != nil {
+			t.Fatalf("Put pointer failed: %v", err)
+		}
+		// Give each pointer a distinct, increasing mtime
+		mtime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dataDir, pointer), mtime, mtime); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/kv/"+trifleKey+"?bundle", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	rec := httptest.NewRecorder()
+	handlers.HandleKV(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var bundle Bundle
+	if err := json.NewDecoder(rec.Body).Decode(&bundle); err != nil {
+		t.Fatalf("Failed to decode bundle: %v", err)
+	}
+
+	if len(bundle.History) != len(versions) {
+		t.Fatalf("Expected %d history entries, got %d", len(versions), len(bundle.History))
+	}
+	if bundle.Current == nil || bundle.Current.Key != userPrefix+"/trifle/version/version_ccc" {
+		t.Errorf("Expected newest version as current, got %+v", bundle.Current)
+	}
+	if string(bundle.Current.Data) != `{"trifle_id":"trifle_123","logical_clock":3}` {
+		t.Errorf("Unexpected current data: %s", bundle.Current.Data)
+	}
+}
+
+func TestHandleBundle_PlainKey(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	key := "domain/example.com/user/alice/profile"
+	if err := store.Put(key, []byte("profile-bytes")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/kv/"+key+"?bundle", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	rec := httptest.NewRecorder()
+	handlers.HandleKV(rec, req)
+
+	var bundle Bundle
+	if err := json.NewDecoder(rec.Body).Decode(&bundle); err != nil {
+		t.Fatalf("Failed to decode bundle: %v", err)
+	}
+	if bundle.Current == nil || string(bundle.Current.Value) != "profile-bytes" {
+		t.Errorf("Expected current value in bundle, got %+v", bundle.Current)
+	}
+	if len(bundle.History) != 0 {
+		t.Errorf("Expected no history for plain key, got %d entries", len(bundle.History))
+	}
+}
+
+func TestHandleBundle_OtherUserForbidden(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	req := httptest.NewRequest(http.MethodGet, "/kv/domain/example.com/user/bob/trifle/latest/trifle_1?bundle", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	rec := httptest.NewRecorder()
+	handlers.HandleKV(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,131 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tmpGrace is how old a temporary file must be before Compact removes it, so
+// an upload still in progress is never pulled out from under its writer
+const tmpGrace = time.Hour
+
+// CompactResult counts what Compact cleaned up
+type CompactResult struct {
+	TempFiles        int `json:"tempFiles"`        // Abandoned partial writes
+	OrphanedMetadata int `json:"orphanedMetadata"` // Metadata for keys that no longer exist
+	OrphanedShares   int `json:"orphanedShares"`   // Public markers for keys that no longer exist
+}
+
+// Compact removes leftovers of interrupted writes: temporary files from
+// values and pointers that were never renamed into place, and metadata or
+// sharing records whose key is gone. It is safe to run while the store is
+// serving; it never touches a live value, and skips temporary files young
+// enough to belong to a write still in progress.
+func (s *Store) Compact() (CompactResult, error) {
+	var result CompactResult
+	cutoff := time.Now().Add(-tmpGrace)
+
+	// Every write is staged in tmpDir, so that's the only place partial
+	// writes can be left behind
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, tmpDir))
+	if err != nil && !os.IsNotExist(err) {
+		return result, fmt.Errorf("failed to clean temporary files: %w", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(s.dataDir, tmpDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			return result, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		result.TempFiles++
+	}
+
+	if result.OrphanedMetadata, err = s.removeOrphans(metaDir); err != nil {
+		return result, err
+	}
+	if result.OrphanedShares, err = s.removeOrphans(publicDir); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// removeOrphans removes sidecar files under dir whose key no longer exists.
+// Each check holds the key's lock, so a concurrent write can't recreate the
+// key between the check and the removal.
+func (s *Store) removeOrphans(dir string) (int, error) {
+	root := filepath.Join(s.dataDir, dir)
+	removed := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		unlock := s.keyLocks.lock(key)
+		defer unlock()
+		keyPath, err := s.keyPath(key)
+		if err != nil {
+			return nil // Not a key we could have written; leave it for a human
+		}
+		if _, err := os.Lstat(keyPath); !os.IsNotExist(err) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to clean %s: %w", dir, err)
+	}
+	return removed, nil
+}
+
+// CompactTask runs Compact as a scheduled maintenance task
+func (s *Store) CompactTask(ctx context.Context) error {
+	result, err := s.Compact()
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Store compacted", "temp_files", result.TempFiles, "orphaned_metadata", result.OrphanedMetadata, "orphaned_shares", result.OrphanedShares)
+	return nil
+}
+
+// HandleCompact handles POST /admin/compact, running Compact and returning
+// what it cleaned up
+func (h *Handlers) HandleCompact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result, err := h.store.Compact()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Compaction failed", "error", err)
+		http.Error(w, "Compaction failed", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Store compacted", "audit", true, "temp_files", result.TempFiles, "orphaned_metadata", result.OrphanedMetadata, "orphaned_shares", result.OrphanedShares)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
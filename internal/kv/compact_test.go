@@ -0,0 +1,81 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	base := "domain/example.com/user/alice/"
+
+	// A live key with metadata and a share marker, and a deleted one whose
+	// sidecars were left behind
+	if _, err := store.PutReader(base+"live", strings.NewReader("hello"), Metadata{ContentType: "text/plain"}); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	if err := store.SetPublic(base+"live", true); err != nil {
+		t.Fatalf("SetPublic failed: %v", err)
+	}
+	for _, sidecar := range []string{metaDir, publicDir} {
+		path := filepath.Join(dir, sidecar, base+"gone")
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatalf("Failed to write orphan: %v", err)
+		}
+	}
+
+	// An abandoned write, and one that may still be in progress
+	tmp := filepath.Join(dir, tmpDir)
+	os.MkdirAll(tmp, 0755)
+	old := filepath.Join(tmp, "put-old")
+	fresh := filepath.Join(tmp, "put-fresh")
+	for _, path := range []string{old, fresh} {
+		if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatalf("Failed to write temp file: %v", err)
+		}
+	}
+	stale := time.Now().Add(-2 * tmpGrace)
+	if err := os.Chtimes(old, stale, stale); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	result, err := store.Compact()
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if want := (CompactResult{TempFiles: 1, OrphanedMetadata: 1, OrphanedShares: 1}); result != want {
+		t.Errorf("Compact = %+v, want %+v", result, want)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("Abandoned temp file was kept")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("Recent temp file was removed: %v", err)
+	}
+	for _, sidecar := range []string{metaDir, publicDir} {
+		if _, err := os.Stat(filepath.Join(dir, sidecar, base+"gone")); !os.IsNotExist(err) {
+			t.Errorf("Orphaned %s entry was kept", sidecar)
+		}
+	}
+	if meta, err := store.Metadata(base + "live"); err != nil || meta.ContentType != "text/plain" {
+		t.Errorf("Live key's metadata = %+v, %v", meta, err)
+	}
+	if !store.IsPublic(base + "live") {
+		t.Error("Live key is no longer shared")
+	}
+
+	// Nothing left to do the second time
+	result, err = store.Compact()
+	if err != nil || result != (CompactResult{}) {
+		t.Errorf("Second Compact = %+v, %v", result, err)
+	}
+}
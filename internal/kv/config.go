@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Consistency describes when a completed write becomes visible to List
+type Consistency string
+
+const (
+	// ConsistencyStrong means List reflects every write that has returned
+	ConsistencyStrong Consistency = "strong"
+	// ConsistencyEventual means List may briefly omit or include stale keys
+	ConsistencyEventual Consistency = "eventual"
+)
+
+// ListingConsistency reports the store's listing guarantee. The file store
+// writes straight to the local filesystem, so a key is listed as soon as
+// Put returns and disappears as soon as Delete returns.
+func (s *Store) ListingConsistency() Consistency {
+	return ConsistencyStrong
+}
+
+// ClientConfig describes server behavior that clients may adapt to
+type ClientConfig struct {
+	ListingConsistency Consistency `json:"listing_consistency"`
+	WriteLimit         *RateLimit  `json:"write_limit,omitempty"`    // Per-user write rate, if limited
+	ReadLimit          *RateLimit  `json:"read_limit,omitempty"`     // Per-user read rate, if limited
+	MaxValueSize       int64       `json:"max_value_size,omitempty"` // Largest storable value in bytes, if limited
+}
+
+// HandleConfig handles GET /api/config
+func (h *Handlers) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := ClientConfig{
+		ListingConsistency: h.store.ListingConsistency(),
+		MaxValueSize:       h.limits.MaxValueSize,
+	}
+	if h.limiter.limit.enabled() {
+		limit := h.limiter.limit
+		config.WriteLimit = &limit
+	}
+	if h.readLimiter.limit.enabled() {
+		limit := h.readLimiter.limit
+		config.ReadLimit = &limit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
@@ -0,0 +1,78 @@
+package kv
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// ConsistencyTokenHeader is set on mutation responses. Clients pass its
+	// value back in ConsistencyAfterHeader to read at least their own writes.
+	ConsistencyTokenHeader = "X-KV-Consistency-Token"
+
+	// ConsistencyAfterHeader asks a read to reflect every write up to a token
+	ConsistencyAfterHeader = "If-Consistency-After"
+
+	// defaultConsistencyWait is how long a read waits for a lagging backend
+	// before giving up with 412
+	defaultConsistencyWait = 2 * time.Second
+
+	// consistencyPollInterval is how often a waiting read rechecks the backend
+	consistencyPollInterval = 25 * time.Millisecond
+)
+
+// Freshness reports how far an eventually-consistent backend's reads have
+// caught up with its writes. Tokens are write timestamps in nanoseconds.
+type Freshness interface {
+	// AppliedThrough returns the token of the newest write visible to reads
+	AppliedThrough() int64
+}
+
+// SetFreshness makes reads that send If-Consistency-After wait until f has
+// caught up. Without one, as for the file store, reads always see every
+// completed write and the header is accepted but ignored.
+func (h *Handlers) SetFreshness(f Freshness) {
+	h.freshness = f
+}
+
+// setConsistencyToken marks a mutation response with a token covering the write
+func setConsistencyToken(w http.ResponseWriter) {
+	w.Header().Set(ConsistencyTokenHeader, strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// awaitConsistency holds a read until the backend reflects the writes named by
+// its If-Consistency-After token. If it can't, it writes an error response and
+// returns false.
+func (h *Handlers) awaitConsistency(w http.ResponseWriter, r *http.Request) bool {
+	tokenStr := r.Header.Get(ConsistencyAfterHeader)
+	if tokenStr == "" {
+		return true
+	}
+	token, err := strconv.ParseInt(tokenStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid "+ConsistencyAfterHeader+" token", http.StatusBadRequest)
+		return false
+	}
+	if h.freshness == nil {
+		return true
+	}
+
+	timeout := time.NewTimer(h.consistencyWait)
+	defer timeout.Stop()
+	ticker := time.NewTicker(consistencyPollInterval)
+	defer ticker.Stop()
+
+	for h.freshness.AppliedThrough() < token {
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-timeout.C:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Not yet consistent, retry", http.StatusPreconditionFailed)
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
@@ -0,0 +1,253 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// exportFormatNDJSON is the only export format: one ExportRecord per line
+const exportFormatNDJSON = "ndjson"
+
+// exportWriteTimeout is how long each record of an export has to reach the
+// client. Exports run as long as they need to, in place of the server's
+// write timeout, but a stalled client doesn't hold one open forever.
+const exportWriteTimeout = time.Minute
+
+// ExportRecord is one line of an NDJSON export. A pointer key has Pointer set
+// instead of a value. A key that couldn't be read has Error set, so one bad
+// key doesn't cut the rest of the stream short.
+type ExportRecord struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	Base64      bool   `json:"base64,omitempty"` // Value is base64, as it isn't UTF-8 text
+	ContentType string `json:"content_type,omitempty"`
+	Pointer     string `json:"pointer,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ImportResult summarizes an NDJSON import
+type ImportResult struct {
+	Imported int           `json:"imported"`
+	Skipped  int           `json:"skipped"` // Records exported with an error
+	Errors   []ImportError `json:"errors,omitempty"`
+}
+
+// ImportError describes a record that couldn't be imported
+type ImportError struct {
+	Line  int    `json:"line"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// exportRecord reads key into an ExportRecord, reporting failures in it
+func (s *Store) exportRecord(key string) ExportRecord {
+	record := ExportRecord{Key: key}
+	target, isPointer, err := s.PointerTarget(key)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	if isPointer {
+		record.Pointer = target
+		return record
+	}
+
+	value, err := s.Get(key)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	if utf8.Valid(value) {
+		record.Value = string(value)
+	} else {
+		record.Value = base64.StdEncoding.EncodeToString(value)
+		record.Base64 = true
+	}
+	if meta, err := s.Metadata(key); err == nil {
+		record.ContentType = meta.ContentType
+	}
+	return record
+}
+
+// HandleExport streams all of the signed-in user's keys as NDJSON
+// (GET /kvexport?format=ndjson), one ExportRecord per line, flushing each so
+// memory stays flat however large the account. POSTing the same stream back
+// imports it, overwriting keys that already exist. Content-addressed file/
+// keys are shared between users and aren't included.
+func (h *Handlers) HandleExport(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != exportFormatNDJSON {
+		http.Error(w, "Unsupported format, only format=ndjson is available", http.StatusBadRequest)
+		return
+	}
+	email, ok := r.Context().Value("user_email").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.handleExport(w, r, email)
+	case http.MethodPost:
+		h.handleImport(w, r, email)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handlers) handleExport(w http.ResponseWriter, r *http.Request, email string) {
+	// An export is one bulk read as far as the rate limit is concerned
+	if ok, retryAfter := h.readLimiter.allow(strings.ToLower(email)); !ok {
+		writeRateLimited(w, retryAfter, "Too many reads, slow down")
+		slog.WarnContext(r.Context(), "KV read rate limit exceeded", "user", email, "path", r.URL.Path)
+		return
+	}
+	prefixes, err := userPrefixes(email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var keys []string
+	for _, prefix := range prefixes {
+		prefixKeys, err := h.store.List(prefix, 0, true)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to list keys for export", "error", err, "prefix", prefix)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, prefixKeys...)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="trifle-export.ndjson"`)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	exported, failed := 0, 0
+	for _, key := range keys {
+		if r.Context().Err() != nil {
+			break // Client went away
+		}
+		rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		record := h.store.exportRecord(key)
+		if record.Error != "" {
+			slog.WarnContext(r.Context(), "Failed to export key", "error", record.Error, "key", key)
+			failed++
+		}
+		if err := enc.Encode(record); err != nil {
+			return
+		}
+		rc.Flush()
+		exported++
+	}
+	slog.InfoContext(r.Context(), "Exported keys", "user", email, "keys", exported, "failed", failed)
+}
+
+// maxImportLine bounds one NDJSON record: a value of the largest allowed
+// size, allowing for JSON escaping, plus room for the rest of the record
+func (h *Handlers) maxImportLine() int {
+	if h.limits.MaxValueSize <= 0 || h.limits.MaxValueSize > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(h.limits.MaxValueSize)*6 + 64<<10
+}
+
+func (h *Handlers) handleImport(w http.ResponseWriter, r *http.Request, email string) {
+	defer r.Body.Close()
+	// An import is one bulk write as far as the rate limit is concerned
+	if ok, retryAfter := h.limiter.allow(strings.ToLower(email)); !ok {
+		writeRateLimited(w, retryAfter, "Too many writes, slow down")
+		slog.WarnContext(r.Context(), "KV write rate limit exceeded", "user", email, "path", r.URL.Path)
+		return
+	}
+
+	var result ImportResult
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, h.maxImportLine())
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			result.Errors = append(result.Errors, ImportError{Line: line, Error: "invalid record: " + err.Error()})
+			continue
+		}
+		if record.Error != "" {
+			result.Skipped++
+			continue
+		}
+		if err := h.importRecord(r, record); err != nil {
+			result.Errors = append(result.Errors, ImportError{Line: line, Key: record.Key, Error: err.Error()})
+			continue
+		}
+		result.Imported++
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = fmt.Errorf("record too long (limit is %d bytes)", h.maxImportLine())
+		}
+		result.Errors = append(result.Errors, ImportError{Line: line + 1, Error: "import stopped: " + err.Error()})
+	}
+
+	slog.InfoContext(r.Context(), "Imported keys", "user", email, "keys", result.Imported, "skipped", result.Skipped, "errors", len(result.Errors))
+	setConsistencyToken(w)
+	w.Header().Set("Content-Type", "application/json")
+	// If nothing could be imported, the import as a whole failed
+	if result.Imported == 0 && len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// importRecord stores one record, with the checks a PUT of it would have
+func (h *Handlers) importRecord(r *http.Request, record ExportRecord) error {
+	if err := ValidateKey(record.Key); err != nil {
+		return err
+	}
+	if strings.HasPrefix(record.Key, "file/") {
+		return errors.New("file/ keys can't be imported")
+	}
+	if err := h.checkAuth(r, record.Key); err != nil {
+		return err
+	}
+
+	if record.Pointer != "" {
+		if err := h.checkAuth(r, record.Pointer); err != nil {
+			return fmt.Errorf("pointer target: %w", err)
+		}
+		return h.store.PutPointer(record.Key, record.Pointer)
+	}
+
+	value := []byte(record.Value)
+	if record.Base64 {
+		decoded, err := base64.StdEncoding.DecodeString(record.Value)
+		if err != nil {
+			return fmt.Errorf("invalid base64 value: %w", err)
+		}
+		value = decoded
+	}
+	allowance, err := h.allowance(record.Key)
+	if err != nil {
+		return err
+	}
+	if err := h.checkValueSize(int64(len(value)), allowance); err != nil {
+		return err
+	}
+	if err := h.validateSchema(record.Key, value); err != nil {
+		return err
+	}
+	_, err = h.store.PutReaderContext(r.Context(), record.Key, bytes.NewReader(value), contentTypeMetadata(record.ContentType))
+	return err
+}
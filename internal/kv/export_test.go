@@ -0,0 +1,149 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleExport_RoundTrip(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.SetVerifyChecksums(true)
+	handlers := NewHandlers(store)
+	prefix := "domain/example.com/user/alice/"
+	store.PutReader(prefix+"trifle/version/v1", strings.NewReader(`{"name":"hello"}`), Metadata{ContentType: "application/json"})
+	store.Put(prefix+"blob", []byte{0xff, 0x00, 0xfe})
+	store.PutPointer(prefix+"trifle/latest/t1/v1", prefix+"trifle/version/v1")
+	store.Put(prefix+"tampered", []byte("original"))
+	store.Put("domain/example.com/user/bob/secret", []byte("not alice's"))
+	if err := os.WriteFile(filepath.Join(dataDir, prefix+"tampered"), []byte("changed!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(h *Handlers, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/kvexport?format=ndjson", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "Alice@example.com"))
+		w := httptest.NewRecorder()
+		h.HandleExport(w, req)
+		return w
+	}
+
+	w := serve(handlers, http.MethodGet, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("GET = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	records := make(map[string]ExportRecord)
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		var record ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		records[record.Key] = record
+	}
+	want := map[string]ExportRecord{
+		prefix + "trifle/version/v1":   {Key: prefix + "trifle/version/v1", Value: `{"name":"hello"}`, ContentType: "application/json"},
+		prefix + "blob":                {Key: prefix + "blob", Value: "/wD+", Base64: true},
+		prefix + "trifle/latest/t1/v1": {Key: prefix + "trifle/latest/t1/v1", Pointer: prefix + "trifle/version/v1"},
+	}
+	if len(records) != len(want)+1 {
+		t.Errorf("Exported %d records, want %d: %v", len(records), len(want)+1, records)
+	}
+	for key, record := range want {
+		if records[key] != record {
+			t.Errorf("Record for %s = %+v, want %+v", key, records[key], record)
+		}
+	}
+	// The corrupt value is reported in place, and the stream carries on
+	if tampered := records[prefix+"tampered"]; tampered.Error == "" || tampered.Value != "" {
+		t.Errorf("Record for a corrupt value = %+v, want an error", tampered)
+	}
+
+	// Importing the export elsewhere restores everything that exported cleanly
+	restored, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	stream := w.Body.String() + `{"key":"domain/example.com/user/bob/stolen","value":"x"}` + "\nnot json\n"
+	w = serve(NewHandlers(restored), http.MethodPost, stream)
+	var result ImportResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("POST = %d %s", w.Code, w.Body.String())
+	}
+	if result.Imported != 3 || result.Skipped != 1 || len(result.Errors) != 2 {
+		t.Errorf("Import result = %+v, want 3 imported, 1 skipped, 2 errors", result)
+	}
+	if got, _ := restored.Get(prefix + "blob"); string(got) != "\xff\x00\xfe" {
+		t.Errorf("Restored blob = %q", got)
+	}
+	if got, _ := restored.Get(prefix + "trifle/latest/t1/v1"); string(got) != `{"name":"hello"}` {
+		t.Errorf("Restored pointer reads %q", got)
+	}
+	if meta, _ := restored.Metadata(prefix + "trifle/version/v1"); meta.ContentType != "application/json" {
+		t.Errorf("Restored content type = %q", meta.ContentType)
+	}
+	if restored.Exists("domain/example.com/user/bob/stolen") {
+		t.Error("Import wrote another user's key")
+	}
+}
+
+func TestHandleExport_UnsupportedFormat(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/kvexport?format=zip", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	w := httptest.NewRecorder()
+	NewHandlers(store).HandleExport(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("format=zip = %d, want 400", w.Code)
+	}
+}
+
+func TestHandleExport_RateLimits(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	handlers.SetWriteLimit(RateLimit{Rate: 1, Burst: 1})
+	handlers.SetReadLimit(RateLimit{Rate: 1, Burst: 1})
+	now := time.Now()
+	handlers.limiter.now = func() time.Time { return now }
+	handlers.readLimiter.now = func() time.Time { return now }
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/kvexport", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleExport(w, req)
+		return w
+	}
+
+	// Exports are reads and imports are writes, each a single request however
+	// many keys they cover
+	record := `{"key":"domain/example.com/user/alice/a","value":"1"}` + "\n"
+	for _, tt := range []struct{ method, body string }{
+		{http.MethodGet, ""},
+		{http.MethodPost, record + record},
+	} {
+		if w := serve(tt.method, tt.body); w.Code != http.StatusOK {
+			t.Fatalf("First %s = %d %s", tt.method, w.Code, w.Body.String())
+		}
+		if w := serve(tt.method, tt.body); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+			t.Errorf("Second %s = %d %q, want 429 with Retry-After 1", tt.method, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package kv
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TrifleGraph is the import graph of all of a user's trifles
+type TrifleGraph struct {
+	Trifles []GraphNode `json:"trifles"` // Sorted by key
+	Cycles  [][]string  `json:"cycles"`  // Keys of trifles that import each other, each cycle sorted
+	Orphans []string    `json:"orphans"` // Keys of trifles that neither import nor are imported by another
+}
+
+// GraphNode is one trifle and the trifles it imports
+type GraphNode struct {
+	Key       string   `json:"key"`
+	Name      string   `json:"name"`
+	Imports   []string `json:"imports"`             // Keys of the trifles it imports
+	Missing   []string `json:"missing"`             // Names it imports that match no trifle, or several
+	Truncated bool     `json:"truncated,omitempty"` // Its source is longer than was scanned for imports
+}
+
+// graphCache holds each user's TrifleGraph until one of their keys changes
+type graphCache struct {
+	mu      sync.Mutex
+	graphs  map[string]*TrifleGraph // By owner
+	changes map[string]uint64       // By owner, counting writes
+}
+
+// invalidate forgets owner's graph after a write to one of their keys
+func (c *graphCache) invalidate(owner string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.graphs, owner)
+	if c.changes == nil {
+		c.changes = make(map[string]uint64)
+	}
+	c.changes[owner]++
+}
+
+// TrifleGraph returns the import graph of the trifles under userPrefix
+// (domain/{domain}/user/{user}). It is cached until the user next writes a
+// key, so the returned graph must not be modified.
+func (s *Store) TrifleGraph(userPrefix string) (*TrifleGraph, error) {
+	owner := keyOwner(userPrefix)
+	c := &s.graphs
+	c.mu.Lock()
+	graph, ok := c.graphs[owner]
+	generation := c.changes[owner]
+	c.mu.Unlock()
+	if ok {
+		return graph, nil
+	}
+
+	graph, err := s.buildTrifleGraph(userPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only cache it if nothing was written while it was being built
+	c.mu.Lock()
+	if c.changes[owner] == generation {
+		if c.graphs == nil {
+			c.graphs = make(map[string]*TrifleGraph)
+		}
+		c.graphs[owner] = graph
+	}
+	c.mu.Unlock()
+	return graph, nil
+}
+
+// buildTrifleGraph scans every trifle under userPrefix for imports
+func (s *Store) buildTrifleGraph(userPrefix string) (*TrifleGraph, error) {
+	trifles, byName, err := s.userTrifles(userPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &TrifleGraph{Trifles: []GraphNode{}, Cycles: [][]string{}, Orphans: []string{}}
+	imported := map[string]bool{}
+	for key, trifle := range trifles {
+		node := GraphNode{Key: key, Name: trifle.Name, Imports: []string{}, Missing: []string{}, Truncated: len(trifle.Source) > maxImportScan}
+		for _, name := range parseTrifleImports(trifle.Source) {
+			if candidates := byName[name]; len(candidates) == 1 {
+				node.Imports = append(node.Imports, candidates[0])
+				imported[candidates[0]] = true
+			} else {
+				node.Missing = append(node.Missing, name)
+			}
+		}
+		graph.Trifles = append(graph.Trifles, node)
+	}
+	sort.Slice(graph.Trifles, func(i, j int) bool { return graph.Trifles[i].Key < graph.Trifles[j].Key })
+
+	for _, node := range graph.Trifles {
+		if len(node.Imports) == 0 && !imported[node.Key] {
+			graph.Orphans = append(graph.Orphans, node.Key)
+		}
+	}
+	graph.Cycles = findCycles(graph.Trifles)
+	return graph, nil
+}
+
+// findCycles returns the strongly connected components of the graph that
+// contain a cycle: more than one trifle, or one that imports itself. It uses
+// Tarjan's algorithm, so it's linear in the size of the graph.
+func findCycles(nodes []GraphNode) [][]string {
+	edges := make(map[string][]string, len(nodes))
+	for _, node := range nodes {
+		edges[node.Key] = node.Imports
+	}
+
+	cycles := [][]string{}
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+
+	var connect func(key string)
+	connect = func(key string) {
+		index[key] = len(index)
+		lowlink[key] = index[key]
+		stack = append(stack, key)
+		onStack[key] = true
+
+		selfLoop := false
+		for _, next := range edges[key] {
+			if next == key {
+				selfLoop = true
+			}
+			if _, seen := index[next]; !seen {
+				connect(next)
+				lowlink[key] = min(lowlink[key], lowlink[next])
+			} else if onStack[next] {
+				lowlink[key] = min(lowlink[key], index[next])
+			}
+		}
+
+		if lowlink[key] != index[key] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == key {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, node := range nodes {
+		if _, seen := index[node.Key]; !seen {
+			connect(node.Key)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// HandleGraph handles GET /kvgraph, returning the import graph of the
+// signed-in user's trifles
+func (h *Handlers) HandleGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	email, ok := r.Context().Value("user_email").(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	prefixes, err := userPrefixes(email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	graph, err := h.store.TrifleGraph(prefixes[0])
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to build trifle graph", "error", err, "user", strings.ToLower(email))
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graph)
+}
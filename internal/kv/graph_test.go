@@ -0,0 +1,78 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTrifleGraph(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	user := "domain/example.com/user/alice"
+
+	app := putTrifle(t, store, "t1", "app", "from trifling.mine.lib import x\nimport trifling.mine.gone\n")
+	lib := putTrifle(t, store, "t2", "lib", "x = 1\n")
+	ping := putTrifle(t, store, "t3", "ping", "from trifling.mine.pong import x\n")
+	pong := putTrifle(t, store, "t4", "pong", "from trifling.mine.ping import x\n")
+	self := putTrifle(t, store, "t5", "self", "import trifling.mine.self\n")
+	alone := putTrifle(t, store, "t6", "alone", "print('hi')\n")
+	// Imports past the scan limit aren't seen
+	huge := putTrifle(t, store, "t7", "huge", strings.Repeat("# padding\n", maxImportScan/10+1)+"import trifling.mine.lib\n")
+
+	req := httptest.NewRequest(http.MethodGet, "/kvgraph", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	w := httptest.NewRecorder()
+	handlers.HandleGraph(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d: %s", w.Code, w.Body.String())
+	}
+	var graph TrifleGraph
+	if err := json.NewDecoder(w.Body).Decode(&graph); err != nil {
+		t.Fatalf("Failed to decode graph: %v", err)
+	}
+
+	nodes := map[string]GraphNode{}
+	for _, node := range graph.Trifles {
+		nodes[node.Key] = node
+	}
+	if len(nodes) != 7 {
+		t.Fatalf("Got %d trifles, want 7", len(nodes))
+	}
+	if node := nodes[app]; !reflect.DeepEqual(node.Imports, []string{lib}) || !reflect.DeepEqual(node.Missing, []string{"gone"}) {
+		t.Errorf("app = %+v", node)
+	}
+	if node := nodes[huge]; len(node.Imports) != 0 || !node.Truncated {
+		t.Errorf("huge = %+v, want truncated with no imports", node)
+	}
+	if want := [][]string{{ping, pong}, {self}}; !reflect.DeepEqual(graph.Cycles, want) {
+		t.Errorf("Cycles = %v, want %v", graph.Cycles, want)
+	}
+	if want := []string{alone, huge}; !reflect.DeepEqual(graph.Orphans, want) {
+		t.Errorf("Orphans = %v, want %v", graph.Orphans, want)
+	}
+
+	// Cached until the user writes
+	cached, _ := store.TrifleGraph(user)
+	if again, _ := store.TrifleGraph(user); again != cached {
+		t.Error("Graph was rebuilt without any writes")
+	}
+	putTrifle(t, store, "t6", "alone", "from trifling.mine.lib import x\n")
+	graph2, err := store.TrifleGraph(user)
+	if err != nil {
+		t.Fatalf("TrifleGraph failed: %v", err)
+	}
+	if graph2 == cached {
+		t.Fatal("Graph not rebuilt after a write")
+	}
+	if want := []string{huge}; !reflect.DeepEqual(graph2.Orphans, want) {
+		t.Errorf("Orphans after write = %v, want %v", graph2.Orphans, want)
+	}
+}
@@ -1,26 +1,110 @@
 package kv
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// DefaultMaxValueSize is the largest value that may be stored unless
+// SetLimits says otherwise
+const DefaultMaxValueSize = 16 << 20
+
 // Handlers provides HTTP handlers for KV operations
 type Handlers struct {
-	store *Store
+	store           *Store
+	limiter         *rateLimiter  // Per-user writes
+	readLimiter     *rateLimiter  // Per-user reads
+	freshness       Freshness     // Nil for strongly consistent stores
+	consistencyWait time.Duration // How long reads wait for freshness
+	limits          Limits        // What PUT and POST may store
+	schemas         *Schemas      // What values under some prefixes must look like, if set
+	streams         streams       // Open long-lived responses, closed on shutdown
 }
 
 // NewHandlers creates a new KV handlers instance
 func NewHandlers(store *Store) *Handlers {
-	return &Handlers{store: store}
+	return &Handlers{
+		store:           store,
+		limiter:         newRateLimiter(RateLimit{}),
+		readLimiter:     newRateLimiter(RateLimit{}),
+		consistencyWait: defaultConsistencyWait,
+		limits:          DefaultLimits,
+	}
+}
+
+// SetWriteLimit sets the per-owner write rate limit. A zero limit disables it.
+func (h *Handlers) SetWriteLimit(limit RateLimit) {
+	h.limiter = newRateLimiter(limit)
+}
+
+// SetReadLimit sets the per-owner rate limit on GET and HEAD of /kv/ keys,
+// kept separate from the write limit so it can be set much higher. A zero
+// limit disables it.
+func (h *Handlers) SetReadLimit(limit RateLimit) {
+	h.readLimiter = newRateLimiter(limit)
+}
+
+// limitBody caps how much of a request body will be read at the max value size
+func (h *Handlers) limitBody(w http.ResponseWriter, r *http.Request) io.Reader {
+	return h.limitValue(w, r, -1)
+}
+
+// writeBodyError responds to a failure reading a request body, which may
+// have been cut off by limitValue
+func (h *Handlers) writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		if tooLarge.Limit == h.limits.MaxValueSize {
+			writeLimitError(w, &LimitError{Name: "max_value_size", Limit: tooLarge.Limit, Status: http.StatusRequestEntityTooLarge})
+		} else {
+			writeLimitError(w, &LimitError{Name: "quota_bytes", Limit: h.limits.QuotaBytes, Status: http.StatusInsufficientStorage})
+		}
+		return
+	}
+	http.Error(w, "Failed to read request body", http.StatusBadRequest)
+}
+
+// bodyReader remembers a failure reading a request body, to tell a client
+// problem from a storage one
+type bodyReader struct {
+	r   io.Reader
+	err error
 }
 
-// HandleKV handles GET, PUT, DELETE, HEAD for /kv/{key}
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// PruneRateLimits drops rate limit state for owners who haven't written or
+// read recently, so it doesn't grow with every user ever seen
+func (h *Handlers) PruneRateLimits(ctx context.Context) error {
+	if pruned := h.limiter.prune(); pruned > 0 {
+		slog.DebugContext(ctx, "Pruned idle write limits", "owners", pruned)
+	}
+	if pruned := h.readLimiter.prune(); pruned > 0 {
+		slog.DebugContext(ctx, "Pruned idle read limits", "owners", pruned)
+	}
+	return nil
+}
+
+// HandleKV handles GET, PUT, PATCH, DELETE, HEAD for /kv/{key}, and POST to
+// /kv/{prefix} to create a value under a new server-generated key. GET
+// /kv/limits, which no user's key can be named, describes the Limits.
 func (h *Handlers) HandleKV(w http.ResponseWriter, r *http.Request) {
 	// Extract key from path
 	key := strings.TrimPrefix(r.URL.Path, "/kv/")
@@ -28,6 +112,17 @@ func (h *Handlers) HandleKV(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Key required", http.StatusBadRequest)
 		return
 	}
+	if key == "limits" && r.Method == http.MethodGet {
+		h.handleLimits(w, r)
+		return
+	}
+	if err := ValidateKey(key); err != nil {
+		if len(key) > MaxKeyLength {
+			w.Header().Set(limitHeader, "max_key_length="+strconv.Itoa(MaxKeyLength))
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Check authorization
 	if err := h.checkAuth(r, key); err != nil {
@@ -35,11 +130,40 @@ func (h *Handlers) HandleKV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Throttle runaway writers before they touch the disk, and runaway
+	// readers against their own, higher limit
+	owner, _ := r.Context().Value("user_email").(string)
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete:
+		if ok, retryAfter := h.limiter.allow(strings.ToLower(owner)); !ok {
+			writeRateLimited(w, retryAfter, "Too many writes, slow down")
+			slog.WarnContext(r.Context(), "KV write rate limit exceeded", "user", owner, "key", key)
+			return
+		}
+	case http.MethodGet, http.MethodHead:
+		if ok, retryAfter := h.readLimiter.allow(strings.ToLower(owner)); !ok {
+			writeRateLimited(w, retryAfter, "Too many reads, slow down")
+			slog.WarnContext(r.Context(), "KV read rate limit exceeded", "user", owner, "key", key)
+			return
+		}
+	}
+
+	// Let clients read their own writes from a lagging backend
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if !h.awaitConsistency(w, r) {
+			return
+		}
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		h.handleGet(w, r, key)
 	case http.MethodPut:
 		h.handlePut(w, r, key)
+	case http.MethodPatch:
+		h.handlePatch(w, r, key)
+	case http.MethodPost:
+		h.handleCreate(w, r, key)
 	case http.MethodDelete:
 		h.handleDelete(w, r, key)
 	case http.MethodHead:
@@ -58,6 +182,10 @@ func (h *Handlers) HandleList(w http.ResponseWriter, r *http.Request) {
 
 	// Extract prefix from path
 	prefix := strings.TrimPrefix(r.URL.Path, "/kvlist/")
+	if err := ValidateKey(prefix); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Check authorization for prefix
 	if err := h.checkAuth(r, prefix); err != nil {
@@ -86,80 +214,465 @@ func (h *Handlers) HandleList(w http.ResponseWriter, r *http.Request) {
 		depth = 1
 	}
 
+	// Optional key bounds [start, end) and limit
+	keyRange := KeyRange{
+		Start: r.URL.Query().Get("start"),
+		End:   r.URL.Query().Get("end"),
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		keyRange.Limit = limit
+	}
+
+	// Optional sort order. Bounds select keys by name whatever the order, but
+	// the limit applies after sorting, so it takes e.g. the most recent keys.
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	if sortBy != "name" && sortBy != "modified" && sortBy != "size" {
+		http.Error(w, "Invalid sort parameter, expected name, modified or size", http.StatusBadRequest)
+		return
+	}
+	var desc bool
+	switch r.URL.Query().Get("order") {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		http.Error(w, "Invalid order parameter, expected asc or desc", http.StatusBadRequest)
+		return
+	}
+	limit := keyRange.Limit
+	if sortBy != "name" || desc {
+		keyRange.Limit = 0
+	}
+
+	if !h.awaitConsistency(w, r) {
+		return
+	}
+
+	if sortBy != "name" {
+		h.listMeta(w, r, prefix, depth, recursive, keyRange, sortBy, desc, limit)
+		return
+	}
+
 	// List keys
-	keys, err := h.store.List(prefix, depth, recursive)
+	keys, err := h.store.ListRangeContext(r.Context(), prefix, depth, recursive, keyRange)
 	if err != nil {
-		slog.Error("Failed to list keys", "error", err, "prefix", prefix)
+		slog.ErrorContext(r.Context(), "Failed to list keys", "error", err, "prefix", prefix)
 		http.Error(w, "Failed to list keys", http.StatusInternalServerError)
 		return
 	}
+	if desc {
+		slices.Reverse(keys)
+		if limit > 0 && len(keys) > limit {
+			keys = keys[:limit]
+		}
+	}
+
+	// Return as JSON array, of entries marking pointers if asked for
+	w.Header().Set("Content-Type", "application/json")
+	if !r.URL.Query().Has("pointers") {
+		json.NewEncoder(w).Encode(keys)
+		return
+	}
+
+	entries := make([]ListEntry, 0, len(keys))
+	for _, key := range keys {
+		entry := ListEntry{Key: key}
+		if target, isPointer, err := h.store.PointerTarget(key); err == nil && isPointer {
+			entry.Pointer = target
+		}
+		entries = append(entries, entry)
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+// listMeta responds to a listing sorted by modification time or size. These
+// return KeyInfo objects rather than plain keys, since the order only makes
+// sense alongside what it's sorted by. Ties are broken by key.
+func (h *Handlers) listMeta(w http.ResponseWriter, r *http.Request, prefix string, depth int, recursive bool, keyRange KeyRange, sortBy string, desc bool, limit int) {
+	infos, err := h.store.ListMetaContext(r.Context(), prefix, depth, recursive, keyRange)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list keys", "error", err, "prefix", prefix)
+		http.Error(w, "Failed to list keys", http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if desc {
+			a, b = b, a
+		}
+		switch {
+		case sortBy == "modified" && !a.Modified.Equal(b.Modified):
+			return a.Modified.Before(b.Modified)
+		case sortBy == "size" && a.Size != b.Size:
+			return a.Size < b.Size
+		}
+		return infos[i].Key < infos[j].Key
+	})
+	if limit > 0 && len(infos) > limit {
+		infos = infos[:limit]
+	}
 
-	// Return as JSON array
+	if r.URL.Query().Has("pointers") {
+		for i := range infos {
+			if target, isPointer, err := h.store.PointerTarget(infos[i].Key); err == nil && isPointer {
+				infos[i].Pointer = target
+			}
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(keys)
+	json.NewEncoder(w).Encode(infos)
+}
+
+// ListEntry is a listed key, returned by GET /kvlist/{prefix}?pointers
+type ListEntry struct {
+	Key     string `json:"key"`
+	Pointer string `json:"pointer,omitempty"` // Target key, if this key is a pointer
 }
 
 // handleGet retrieves a value
 func (h *Handlers) handleGet(w http.ResponseWriter, r *http.Request, key string) {
-	value, err := h.store.Get(key)
+	if r.URL.Query().Has("bundle") {
+		h.handleBundle(w, r, key)
+		return
+	}
+	if r.URL.Query().Has("len") {
+		h.handleLen(w, r, key)
+		return
+	}
+
+	// Read the version first: a write landing in between leaves the client
+	// an older version than its value, which only costs a redundant pull
+	version, _ := h.store.Version(key)
+
+	file, info, err := h.store.OpenContext(r.Context(), key)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, ErrCorrupt) {
+			slog.ErrorContext(r.Context(), "Checksum mismatch on read", "error", err, "key", key, "user", r.Context().Value("user_email"))
+			http.Error(w, "Stored value failed checksum verification", http.StatusInternalServerError)
+		} else if errors.Is(err, ErrPointerLoop) {
+			http.Error(w, err.Error(), http.StatusLoopDetected)
+		} else if errors.Is(err, ErrInvalidKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "Not found", http.StatusNotFound)
+		} else {
+			slog.ErrorContext(r.Context(), "Failed to get key", "error", err, "key", key)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	defer file.Close()
+
+	meta, err := h.store.Metadata(key)
+	if err != nil {
+		// Still serve the value, just without its content type
+		slog.WarnContext(r.Context(), "Failed to read metadata", "error", err, "key", key)
+	}
+
+	// Stream raw bytes from disk. ServeContent handles Range requests (206,
+	// multipart for several ranges, 416 when unsatisfiable), advertises
+	// Accept-Ranges, and answers conditional requests against the ETag.
+	setValueHeaders(w, info, meta)
+	w.Header().Set(versionHeader, strconv.FormatInt(version, 10))
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// handleLen returns just the size of a value (GET ?len), without reading it
+func (h *Handlers) handleLen(w http.ResponseWriter, r *http.Request, key string) {
+	info, err := h.store.Stat(key)
+	if err != nil || info.IsDir() {
+		if err == nil || strings.Contains(err.Error(), "not found") {
 			http.Error(w, "Not found", http.StatusNotFound)
+		} else if errors.Is(err, ErrPointerLoop) {
+			http.Error(w, err.Error(), http.StatusLoopDetected)
 		} else {
-			slog.Error("Failed to get key", "error", err, "key", key)
+			slog.ErrorContext(r.Context(), "Failed to stat key", "error", err, "key", key)
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 		}
 		return
 	}
 
-	// Return raw bytes
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(value)
+	w.Header().Set("ETag", etag(info))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"size": info.Size(),
+	})
 }
 
-// handlePut stores a value
+// versionHeader carries a key's version (see Store.Version) on reads and writes
+const versionHeader = "X-KV-Version"
+
+// etag derives an entity tag from a value's size and modification time,
+// so it can be computed without reading the value
+func etag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// handlePut stores a value, or with ?pointer=<target> makes key a pointer
 func (h *Handlers) handlePut(w http.ResponseWriter, r *http.Request, key string) {
-	// Read request body (raw bytes)
-	value, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+	if r.URL.Query().Has("pointer") {
+		h.handlePutPointer(w, r, key, r.URL.Query().Get("pointer"))
 		return
 	}
+
 	defer r.Body.Close()
+	if r.Header.Get("If-None-Match") == "*" {
+		h.handleCreateExclusive(w, r, key)
+		return
+	}
+	existed := h.store.Exists(key)
 
 	// Special case: file/* keys are idempotent
 	if strings.HasPrefix(key, "file/") {
 		// If key exists, just return success (content-addressed storage)
-		if h.store.Exists(key) {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("OK"))
+		if existed {
+			h.writePutResult(w, r, key, false)
 			return
 		}
 	}
 
-	// Store value
-	if err := h.store.Put(key, value); err != nil {
-		slog.Error("Failed to put key", "error", err, "key", key)
+	allowance, ok := h.checkAllowance(w, r, key)
+	if !ok {
+		return
+	}
+
+	// Stream the body to disk, so large values never sit in memory, unless
+	// a schema needs to see it first
+	body := &bodyReader{r: h.limitValue(w, r, allowance)}
+	if !h.checkSchema(w, r, key, body) {
+		return
+	}
+	if _, err := h.store.PutReaderContext(r.Context(), key, body, requestMetadata(r)); err != nil {
+		if body.err != nil {
+			h.writeBodyError(w, body.err)
+			return
+		}
+		if errors.Is(err, ErrInvalidKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to put key", "error", err, "key", key)
 		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
 
+	h.writePutResult(w, r, key, !existed)
+}
+
+// handleCreateExclusive handles PUT with If-None-Match: *, which stores the
+// value only if the key doesn't exist yet, so the first of several clients
+// claiming a key wins and the rest get 412
+func (h *Handlers) handleCreateExclusive(w http.ResponseWriter, r *http.Request, key string) {
+	allowance, ok := h.checkAllowance(w, r, key)
+	if !ok {
+		return
+	}
+	body := &bodyReader{r: h.limitValue(w, r, allowance)}
+	if !h.checkSchema(w, r, key, body) {
+		return
+	}
+	if _, err := h.store.CreateExclusiveReaderContext(r.Context(), key, body, requestMetadata(r)); err != nil {
+		if errors.Is(err, ErrKeyExists) {
+			http.Error(w, "Key already exists", http.StatusPreconditionFailed)
+			return
+		}
+		if body.err != nil {
+			h.writeBodyError(w, body.err)
+			return
+		}
+		if errors.Is(err, ErrInvalidKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to create key", "error", err, "key", key)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	h.writePutResult(w, r, key, true)
+}
+
+// handlePutPointer makes key point at target, e.g. config/latest -> config/v37
+func (h *Handlers) handlePutPointer(w http.ResponseWriter, r *http.Request, key, target string) {
+	if target == "" {
+		http.Error(w, "Pointer target required", http.StatusBadRequest)
+		return
+	}
+	// Content-addressed keys must hold the value they're named for, and
+	// mustn't be written through a pointer either
+	if strings.HasPrefix(key, "file/") {
+		http.Error(w, "Cannot create pointers under file/", http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(target, "file/") {
+		http.Error(w, "Cannot point at keys under file/", http.StatusBadRequest)
+		return
+	}
+	// A pointer must not expose a key its owner couldn't read directly
+	if err := h.checkAuth(r, target); err != nil {
+		http.Error(w, "Pointer target: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	_, isPointer, _ := h.store.PointerTarget(key)
+	created := !isPointer && !h.store.Exists(key)
+	if err := h.store.PutPointer(key, target); err != nil {
+		if errors.Is(err, ErrPointerLoop) || strings.Contains(err.Error(), "invalid") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to put pointer", "error", err, "key", key, "target", target)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	setConsistencyToken(w)
+	if wantsJSON(r) {
+		writeOperationResult(w, OperationResult{Key: key, Op: "put", Created: created, Pointer: target})
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
-// handleDelete deletes a key or prefix
+// OperationResult describes a completed mutation. It is returned instead of an
+// empty body to clients that send Accept: application/json, so they can update
+// their local state without a follow-up GET or HEAD.
+type OperationResult struct {
+	Key        string     `json:"key"`
+	Op         string     `json:"op"`                   // "put" or "delete"
+	ETag       string     `json:"etag,omitempty"`       // Same as a GET's ETag header
+	Generation int64      `json:"generation,omitempty"` // Changes with every write to the key
+	Version    int64      `json:"version,omitempty"`    // The key's version, for POST /kvsync
+	Created    bool       `json:"created"`              // The put created the key rather than replacing it
+	Modified   *time.Time `json:"modified,omitempty"`
+	Size       int64      `json:"size"`
+	Pointer    string     `json:"pointer,omitempty"` // Target key, when the put made a pointer
+}
+
+// wantsJSON reports whether the client asked for a JSON operation result
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeOperationResult encodes result as the response body
+func writeOperationResult(w http.ResponseWriter, result OperationResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// writePutResult responds to a successful PUT, with an OperationResult if the client wants one
+func (h *Handlers) writePutResult(w http.ResponseWriter, r *http.Request, key string, created bool) {
+	setConsistencyToken(w)
+	version, _ := h.store.Version(key)
+	w.Header().Set(versionHeader, strconv.FormatInt(version, 10))
+	if !wantsJSON(r) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+		return
+	}
+
+	result := OperationResult{Key: key, Op: "put", Created: created, Version: version}
+	if info, err := h.store.Stat(key); err == nil {
+		modified := info.ModTime().UTC()
+		result.ETag = etag(info)
+		result.Generation = modified.UnixNano()
+		result.Modified = &modified
+		result.Size = info.Size()
+		w.Header().Set("ETag", result.ETag)
+	} else {
+		// The write succeeded; report what we know rather than failing it
+		slog.WarnContext(r.Context(), "Failed to stat key after put", "error", err, "key", key)
+	}
+	writeOperationResult(w, result)
+}
+
+// handleCreate stores a value under a new random key beneath the given prefix
+func (h *Handlers) handleCreate(w http.ResponseWriter, r *http.Request, prefix string) {
+	// Content-addressed keys are derived from the value, never generated
+	if strings.HasPrefix(prefix, "file/") {
+		http.Error(w, "Cannot create generated keys under file/", http.StatusBadRequest)
+		return
+	}
+
+	allowance, ok := h.checkAllowance(w, r, prefix)
+	if !ok {
+		return
+	}
+	value, err := io.ReadAll(h.limitValue(w, r, allowance))
+	if err != nil {
+		h.writeBodyError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	// Whatever key is generated, it's directly under prefix
+	var schemaErr *SchemaError
+	if err := h.validateSchema(strings.TrimSuffix(prefix, "/")+"/", value); errors.As(err, &schemaErr) {
+		writeSchemaError(w, r, prefix, schemaErr)
+		return
+	}
+
+	key, err := h.store.CreateContext(r.Context(), prefix, value)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create key", "error", err, "prefix", prefix)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/kv/"+key)
+	setConsistencyToken(w)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"key": key,
+	})
+}
+
+// handleDelete deletes a key or prefix. With If-Match it only deletes while
+// the value's ETag still matches, answering 412 if it has changed or gone
+// since it was read; If-Match: * deletes anything that exists.
 func (h *Handlers) handleDelete(w http.ResponseWriter, r *http.Request, key string) {
-	if err := h.store.Delete(key); err != nil {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	var err error
+	if ifMatch == "" {
+		err = h.store.DeleteContext(r.Context(), key)
+	} else {
+		var deleted bool
+		deleted, err = h.store.ConditionalDeleteContext(r.Context(), key, ifMatch)
+		// A value deleted since it was read has changed too; only * merely
+		// asks that the key exists
+		gone := err != nil && strings.Contains(err.Error(), "not found") && ifMatch != "*"
+		if (err == nil && !deleted) || gone {
+			http.Error(w, "Value changed since it was read", http.StatusPreconditionFailed)
+			return
+		}
+	}
+	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			http.Error(w, "Not found", http.StatusNotFound)
 		} else {
-			slog.Error("Failed to delete key", "error", err, "key", key)
+			slog.ErrorContext(r.Context(), "Failed to delete key", "error", err, "key", key)
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 		}
 		return
 	}
 
+	setConsistencyToken(w)
+	if wantsJSON(r) {
+		writeOperationResult(w, OperationResult{Key: key, Op: "delete"})
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
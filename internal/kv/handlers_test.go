@@ -2,9 +2,18 @@ package kv
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCheckAuth_EmailNormalization(t *testing.T) {
@@ -343,3 +352,819 @@ func TestCheckAuth_NotAuthenticated(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleGet_Len(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	key := "domain/example.com/user/alice/profile"
+	value := `{"display_name":"Dapper Panda"}`
+	if err := store.Put(key, []byte(value)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/kv/"+key+"?len", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	rec := httptest.NewRecorder()
+	handlers.HandleKV(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "Dapper Panda") {
+		t.Error("Expected ?len response not to include the value")
+	}
+
+	var result map[string]int64
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result["size"] != int64(len(value)) {
+		t.Errorf("Expected size %d, got %d", len(value), result["size"])
+	}
+	if rec.Header().Get("ETag") == "" {
+		t.Error("Expected ETag header")
+	}
+
+	// Missing keys are 404
+	req = httptest.NewRequest(http.MethodGet, "/kv/domain/example.com/user/alice/missing?len", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	rec = httptest.NewRecorder()
+	handlers.HandleKV(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing key, got %d", rec.Code)
+	}
+}
+
+func TestHandleKV_WriteRateLimit(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	handlers.SetWriteLimit(RateLimit{Rate: 1, Burst: 2})
+
+	now := time.Now()
+	handlers.limiter.now = func() time.Time { return now }
+
+	put := func(email string) *httptest.ResponseRecorder {
+		user := strings.Split(email, "@")[0]
+		req := httptest.NewRequest(http.MethodPut, "/kv/domain/example.com/user/"+user+"/profile", strings.NewReader("{}"))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", email))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	// The burst is allowed through
+	for i := 0; i < 2; i++ {
+		if w := put("alice@example.com"); w.Code != http.StatusOK {
+			t.Fatalf("Write %d: expected 200, got %d", i, w.Code)
+		}
+	}
+
+	// The next write exceeds the rate
+	w := put("alice@example.com")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", got)
+	}
+
+	// Other users have their own allowance
+	if w := put("bob@example.com"); w.Code != http.StatusOK {
+		t.Errorf("Expected another user's write to succeed, got %d", w.Code)
+	}
+
+	// Once a token refills, alice can write again
+	now = now.Add(time.Second)
+	if w := put("alice@example.com"); w.Code != http.StatusOK {
+		t.Errorf("Expected write after refill to succeed, got %d", w.Code)
+	}
+
+	// Clients can discover the limit
+	req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	cw := httptest.NewRecorder()
+	handlers.HandleConfig(cw, req)
+	var config ClientConfig
+	if err := json.NewDecoder(cw.Body).Decode(&config); err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+	if config.WriteLimit == nil || config.WriteLimit.Rate != 1 || config.WriteLimit.Burst != 2 {
+		t.Errorf("Expected write limit in config, got %+v", config.WriteLimit)
+	}
+}
+
+func TestHandleKV_ReadRateLimit(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	handlers.SetWriteLimit(RateLimit{Rate: 1, Burst: 1})
+	handlers.SetReadLimit(RateLimit{Rate: 10, Burst: 2})
+
+	now := time.Now()
+	handlers.limiter.now = func() time.Time { return now }
+	handlers.readLimiter.now = func() time.Time { return now }
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/kv/domain/example.com/user/alice/profile", strings.NewReader("{}"))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodPut); w.Code != http.StatusOK {
+		t.Fatalf("PUT: expected 200, got %d", w.Code)
+	}
+	// Reads have their own bucket, untouched by the write
+	for i := 0; i < 2; i++ {
+		if w := serve(http.MethodGet); w.Code != http.StatusOK {
+			t.Fatalf("Read %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	w := serve(http.MethodHead)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 with Retry-After 1, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Once idle users' buckets have refilled they're dropped, so state
+	// doesn't grow with every user ever seen
+	handlers.PruneRateLimits(context.Background())
+	if len(handlers.limiter.buckets) != 1 || len(handlers.readLimiter.buckets) != 1 {
+		t.Errorf("Pruned buckets that hadn't refilled: %d write, %d read", len(handlers.limiter.buckets), len(handlers.readLimiter.buckets))
+	}
+	now = now.Add(time.Second)
+	handlers.PruneRateLimits(context.Background())
+	if len(handlers.limiter.buckets) != 0 || len(handlers.readLimiter.buckets) != 0 {
+		t.Errorf("Kept refilled buckets: %d write, %d read", len(handlers.limiter.buckets), len(handlers.readLimiter.buckets))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	cw := httptest.NewRecorder()
+	handlers.HandleConfig(cw, req)
+	var config ClientConfig
+	if err := json.NewDecoder(cw.Body).Decode(&config); err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+	if config.ReadLimit == nil || config.ReadLimit.Rate != 10 || config.ReadLimit.Burst != 2 {
+		t.Errorf("Expected read limit in config, got %+v", config.ReadLimit)
+	}
+}
+
+func TestHandlePut_OperationResult(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	put := func(body string) OperationResult {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/kv/domain/example.com/user/alice/profile", strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected JSON content type, got %q", ct)
+		}
+		var result OperationResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode result: %v", err)
+		}
+		if result.ETag == "" || result.ETag != w.Header().Get("ETag") {
+			t.Errorf("Expected result ETag to match header, got %q and %q", result.ETag, w.Header().Get("ETag"))
+		}
+		return result
+	}
+
+	first := put(`{"display_name":"Alice"}`)
+	if first.Key != "domain/example.com/user/alice/profile" || first.Op != "put" {
+		t.Errorf("Unexpected key/op: %+v", first)
+	}
+	if !first.Created {
+		t.Error("Expected first put to report created")
+	}
+	if first.Size != int64(len(`{"display_name":"Alice"}`)) {
+		t.Errorf("Expected size %d, got %d", len(`{"display_name":"Alice"}`), first.Size)
+	}
+	if first.Modified == nil || first.Generation != first.Modified.UnixNano() {
+		t.Errorf("Expected generation to match modified time, got %+v", first)
+	}
+
+	info, err := store.Stat(first.Key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if first.ETag != etag(info) {
+		t.Errorf("Expected ETag %s to match a GET's, got %s", etag(info), first.ETag)
+	}
+
+	second := put(`{"display_name":"Alicia!"}`)
+	if second.Created {
+		t.Error("Expected overwrite not to report created")
+	}
+	if second.Size != int64(len(`{"display_name":"Alicia!"}`)) || second.ETag == first.ETag {
+		t.Errorf("Expected result to describe the new value, got %+v", second)
+	}
+}
+
+func TestHandlePut_PlainResponseByDefault(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	req := httptest.NewRequest(http.MethodPut, "/kv/domain/example.com/user/alice/profile", strings.NewReader("{}"))
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	w := httptest.NewRecorder()
+	handlers.HandleKV(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "OK" {
+		t.Errorf("Expected plain 200 OK, got %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/kv/domain/example.com/user/alice/profile", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	w = httptest.NewRecorder()
+	handlers.HandleKV(w, req)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("Expected empty 204, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleList_Range(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	prefix := "domain/example.com/user/alice/notes"
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := store.Put(prefix+"/"+name, nil); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	list := func(query string) (int, []string) {
+		req := httptest.NewRequest(http.MethodGet, "/kvlist/"+prefix+"?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleList(w, req)
+		var keys []string
+		json.NewDecoder(w.Body).Decode(&keys)
+		return w.Code, keys
+	}
+
+	code, keys := list("start=" + prefix + "/b&end=" + prefix + "/d&limit=5")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if strings.Join(keys, ",") != prefix+"/b,"+prefix+"/c" {
+		t.Errorf("Expected [b, c], got %v", keys)
+	}
+
+	if _, keys := list("start=" + prefix + "/b&limit=1"); len(keys) != 1 || keys[0] != prefix+"/b" {
+		t.Errorf("Expected limit to keep only b, got %v", keys)
+	}
+
+	if code, _ := list("limit=0"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid limit, got %d", code)
+	}
+}
+
+// laggingFreshness is a fake eventually-consistent backend whose reads trail its writes
+type laggingFreshness struct {
+	applied atomic.Int64
+}
+
+func (f *laggingFreshness) AppliedThrough() int64 {
+	return f.applied.Load()
+}
+
+func TestHandleList_Sort(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	// c is newest; a and b tie on time; sizes are d > a > b = c
+	prefix := "domain/example.com/user/alice/notes"
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, v := range []struct {
+		name  string
+		value string
+		age   time.Duration
+	}{
+		{"a", "xx", time.Hour},
+		{"b", "x", time.Hour},
+		{"c", "x", 0},
+		{"d", "xxxx", 2 * time.Hour},
+	} {
+		store.Put(prefix+"/"+v.name, []byte(v.value))
+		mtime := base.Add(-v.age)
+		os.Chtimes(filepath.Join(dir, prefix, v.name), mtime, mtime)
+	}
+
+	list := func(query string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/kvlist/"+prefix+"?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleList(w, req)
+		if w.Code != http.StatusOK {
+			return w.Code, ""
+		}
+		// Plain keys or KeyInfo objects, reduced to names
+		var names []string
+		var keys []string
+		var infos []KeyInfo
+		body := w.Body.Bytes()
+		if json.Unmarshal(body, &keys) != nil {
+			keys = nil
+			json.Unmarshal(body, &infos)
+			for _, info := range infos {
+				keys = append(keys, info.Key)
+			}
+		}
+		for _, key := range keys {
+			names = append(names, strings.TrimPrefix(key, prefix+"/"))
+		}
+		return w.Code, strings.Join(names, ",")
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "a,b,c,d"},
+		{"sort=name&order=desc", "d,c,b,a"},
+		{"sort=name&order=desc&limit=2", "d,c"},
+		{"sort=modified", "d,a,b,c"},
+		{"sort=modified&order=desc", "c,a,b,d"},
+		{"sort=modified&order=desc&limit=2", "c,a"},
+		{"sort=size", "b,c,a,d"},
+		{"sort=size&order=desc&start=" + prefix + "/b", "d,b,c"},
+	}
+	for _, tt := range tests {
+		code, got := list(tt.query)
+		if code != http.StatusOK || got != tt.want {
+			t.Errorf("?%s = %d %q, want %q", tt.query, code, got, tt.want)
+		}
+	}
+
+	// Metadata sorts return objects; the default stays a list of strings
+	req := httptest.NewRequest(http.MethodGet, "/kvlist/"+prefix+"?sort=size", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	w := httptest.NewRecorder()
+	handlers.HandleList(w, req)
+	var infos []KeyInfo
+	if err := json.NewDecoder(w.Body).Decode(&infos); err != nil || len(infos) != 4 || infos[3].Size != 4 || !infos[3].Modified.Equal(base.Add(-2*time.Hour)) {
+		t.Errorf("KeyInfo response = %+v, %v", infos, err)
+	}
+
+	for _, query := range []string{"sort=random", "order=sideways"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("?%s: expected 400, got %d", query, code)
+		}
+	}
+}
+
+func TestHandleKV_ConsistencyToken(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	key := "domain/example.com/user/alice/profile"
+
+	serve := func(method, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/kv/"+key, strings.NewReader("v1"))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		if token != "" {
+			req.Header.Set(ConsistencyAfterHeader, token)
+		}
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	put := serve(http.MethodPut, "")
+	token := put.Header().Get(ConsistencyTokenHeader)
+	if put.Code != http.StatusOK || token == "" {
+		t.Fatalf("PUT status = %d, token = %q", put.Code, token)
+	}
+
+	// The file store is strongly consistent, so the token is a no-op
+	if w := serve(http.MethodGet, token); w.Code != http.StatusOK {
+		t.Errorf("GET without a lagging backend status = %d, want 200", w.Code)
+	}
+	if w := serve(http.MethodGet, "not-a-token"); w.Code != http.StatusBadRequest {
+		t.Errorf("GET with invalid token status = %d, want 400", w.Code)
+	}
+
+	backend := &laggingFreshness{}
+	handlers.SetFreshness(backend)
+	handlers.consistencyWait = 50 * time.Millisecond
+
+	// The backend never catches up
+	w := serve(http.MethodGet, token)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("GET from stale backend status = %d, want 412", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("412 response missing Retry-After")
+	}
+
+	// The backend catches up while the read waits
+	handlers.consistencyWait = 5 * time.Second
+	tokenValue, _ := strconv.ParseInt(token, 10, 64)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		backend.applied.Store(tokenValue)
+	}()
+	if w := serve(http.MethodGet, token); w.Code != http.StatusOK {
+		t.Errorf("GET after backend caught up status = %d, want 200", w.Code)
+	}
+
+	// Reads without a token never wait
+	backend.applied.Store(0)
+	if w := serve(http.MethodHead, ""); w.Code != http.StatusOK {
+		t.Errorf("HEAD without token status = %d, want 200", w.Code)
+	}
+
+	if w := serve(http.MethodDelete, ""); w.Header().Get(ConsistencyTokenHeader) == "" {
+		t.Error("DELETE response missing consistency token")
+	}
+}
+
+func TestHandleKV_PointerKeys(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	base := "domain/example.com/user/alice/"
+	store.Put(base+"config/v37", []byte("thirty-seven"))
+	store.Put("domain/example.com/user/bob/secret", []byte("bob's"))
+
+	serve := func(handler http.HandlerFunc, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if w := serve(handlers.HandleKV, http.MethodPut, "/kv/"+base+"config/latest?pointer="+base+"config/v37"); w.Code != http.StatusOK {
+		t.Fatalf("PUT pointer status = %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(handlers.HandleKV, http.MethodGet, "/kv/"+base+"config/latest"); w.Code != http.StatusOK || w.Body.String() != "thirty-seven" {
+		t.Errorf("GET through pointer = %d %q", w.Code, w.Body.String())
+	}
+
+	// Pointing at another user's key would leak it
+	if w := serve(handlers.HandleKV, http.MethodPut, "/kv/"+base+"peek?pointer=domain/example.com/user/bob/secret"); w.Code != http.StatusForbidden {
+		t.Errorf("PUT pointer to another user's key status = %d, want 403", w.Code)
+	}
+
+	serve(handlers.HandleKV, http.MethodPut, "/kv/"+base+"broken?pointer="+base+"missing")
+	if w := serve(handlers.HandleKV, http.MethodGet, "/kv/"+base+"broken"); w.Code != http.StatusNotFound {
+		t.Errorf("GET of broken pointer status = %d, want 404", w.Code)
+	}
+
+	serve(handlers.HandleKV, http.MethodPut, "/kv/"+base+"loop/a?pointer="+base+"loop/b")
+	serve(handlers.HandleKV, http.MethodPut, "/kv/"+base+"loop/b?pointer="+base+"loop/a")
+	if w := serve(handlers.HandleKV, http.MethodGet, "/kv/"+base+"loop/a"); w.Code != http.StatusLoopDetected {
+		t.Errorf("GET of pointer cycle status = %d, want 508", w.Code)
+	}
+
+	w := serve(handlers.HandleList, http.MethodGet, "/kvlist/"+base+"config?pointers")
+	var entries []ListEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Failed to decode listing: %v", err)
+	}
+	want := []ListEntry{
+		{Key: base + "config/latest", Pointer: base + "config/v37"},
+		{Key: base + "config/v37"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("listing = %+v, want %+v", entries, want)
+	}
+}
+
+func TestHandleKV_PointersCannotReachFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	base := "domain/evil.com/user/mallory/"
+	hash := strings.Repeat("0", 60)
+	store.Put("file/ab/cd/"+hash+"0000", []byte("genuine"))
+
+	serve := func(email, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", email))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	// file/ is readable by everyone, but can't be pointed at
+	if w := serve("mallory@evil.com", http.MethodPut, "/kv/"+base+"p?pointer=file/ab", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT pointer to file/ = %d, want 400", w.Code)
+	}
+	if err := store.PutPointer(base+"p", "file/ab"); err == nil {
+		t.Error("PutPointer accepted a target under file/")
+	}
+
+	// Nor can a directory, which keys under the pointer would reach into
+	store.Put(base+"dir/x", []byte("mine"))
+	if err := store.PutPointer(base+"q", base+"dir"); err == nil {
+		t.Error("PutPointer accepted a directory target")
+	}
+
+	// A pointer to a directory made some other way, such as before the
+	// checks above, is never written or read through
+	if err := os.Symlink(filepath.Join("..", "..", "..", "..", "file", "ab"), filepath.Join(dir, base, "p")); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve("mallory@evil.com", http.MethodPut, "/kv/"+base+"p/cd/"+hash+"1111", "planted"); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT through a pointer = %d, want 400", w.Code)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "file", "ab", "cd", hash+"1111")); !os.IsNotExist(err) {
+		t.Errorf("PUT through a pointer wrote into file/: %v", err)
+	}
+	if w := serve("mallory@evil.com", http.MethodGet, "/kv/"+base+"p/cd/"+hash+"0000", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("GET through a pointer = %d, want 400", w.Code)
+	}
+	if w := serve("alice@example.com", http.MethodGet, "/kv/file/ab/cd/"+hash+"1111", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET of the planted file/ key = %d %q, want 404", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleShare_PublicReads(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	base := "domain/example.com/user/alice/"
+	store.Put(base+"trifle/hello", []byte("print('hi')"))
+	store.Put(base+"trifle/private", []byte("secret"))
+
+	serve := func(handler http.HandlerFunc, method, path, email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if email != "" {
+			req = req.WithContext(context.WithValue(req.Context(), "user_email", email))
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// Only the owner can share
+	if w := serve(handlers.HandleShare, http.MethodPost, "/kvshare/"+base+"trifle/hello", "bob@example.com"); w.Code != http.StatusForbidden {
+		t.Errorf("Share by non-owner status = %d, want 403", w.Code)
+	}
+	if w := serve(handlers.HandleShare, http.MethodPost, "/kvshare/"+base+"trifle/missing", "alice@example.com"); w.Code != http.StatusNotFound {
+		t.Errorf("Share of missing key status = %d, want 404", w.Code)
+	}
+
+	w := serve(handlers.HandleShare, http.MethodPost, "/kvshare/"+base+"trifle/hello", "alice@example.com")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Share status = %d: %s", w.Code, w.Body.String())
+	}
+	var result ShareResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode share result: %v", err)
+	}
+	if result.URL != "/public/alice@example.com/trifle/hello" {
+		t.Fatalf("Share URL = %q", result.URL)
+	}
+
+	// Anyone can read the shared key, but nothing else
+	if w := serve(handlers.HandlePublic, http.MethodGet, result.URL, ""); w.Code != http.StatusOK || w.Body.String() != "print('hi')" {
+		t.Errorf("Public GET = %d %q", w.Code, w.Body.String())
+	}
+	for _, path := range []string{
+		"/public/alice@example.com/trifle/private",
+		"/public/alice@example.com/trifle",
+		"/public/alice@example.com/trifle/",
+		"/public/alice@example.com/",
+		"/public/alice@example.com/trifle/../trifle/private",
+	} {
+		if w := serve(handlers.HandlePublic, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("Public GET %s status = %d, want 404", path, w.Code)
+		}
+	}
+
+	// The public flag is metadata, not a key
+	keys, _ := store.List(base, 0, true)
+	if len(keys) != 2 {
+		t.Errorf("Expected sharing to add no keys, got %v", keys)
+	}
+
+	// Unsharing takes effect immediately
+	if w := serve(handlers.HandleShare, http.MethodDelete, "/kvshare/"+base+"trifle/hello", "alice@example.com"); w.Code != http.StatusNoContent {
+		t.Fatalf("Unshare status = %d", w.Code)
+	}
+	if w := serve(handlers.HandlePublic, http.MethodGet, result.URL, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Public GET after unshare status = %d, want 404", w.Code)
+	}
+
+	// A deleted and recreated key starts out private
+	serve(handlers.HandleShare, http.MethodPost, "/kvshare/"+base+"trifle/hello", "alice@example.com")
+	store.Delete(base + "trifle/hello")
+	store.Put(base+"trifle/hello", []byte("new"))
+	if w := serve(handlers.HandlePublic, http.MethodGet, result.URL, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Public GET of recreated key status = %d, want 404", w.Code)
+	}
+}
+
+func TestHandleKV_StreamingValues(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	handlers.SetLimits(Limits{MaxValueSize: 1024})
+	key := "domain/example.com/user/alice/image"
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/kv/"+key, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	value := strings.Repeat("x", 1024)
+	if w := serve(http.MethodPut, value); w.Code != http.StatusOK {
+		t.Fatalf("PUT at the limit status = %d: %s", w.Code, w.Body.String())
+	}
+	w := serve(http.MethodGet, "")
+	if w.Code != http.StatusOK || w.Body.String() != value {
+		t.Fatalf("GET status = %d, body length %d", w.Code, w.Body.Len())
+	}
+	if w.Header().Get("Content-Length") != "1024" {
+		t.Errorf("Content-Length = %q, want 1024", w.Header().Get("Content-Length"))
+	}
+
+	// An oversized upload is refused and leaves the old value in place
+	w = serve(http.MethodPut, value+"y")
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "1024") {
+		t.Errorf("PUT over the limit = %d %q, want 413 naming the limit", w.Code, w.Body.String())
+	}
+	if got, _ := store.Get(key); string(got) != value {
+		t.Errorf("Oversized PUT changed the value to %d bytes", len(got))
+	}
+	if w := serve(http.MethodPost, value+"y"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST over the limit status = %d, want 413", w.Code)
+	}
+
+	// Partial writes are cleaned up and never listed
+	leftovers, _ := os.ReadDir(filepath.Join(dir, tmpDir))
+	if len(leftovers) != 0 {
+		t.Errorf("Temp files left behind: %v", leftovers)
+	}
+	keys, _ := store.List("domain/example.com/user/alice", 0, true)
+	if !reflect.DeepEqual(keys, []string{key}) {
+		t.Errorf("Listing = %v, want just %s", keys, key)
+	}
+}
+
+func TestHandleGet_Range(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	key := "domain/example.com/user/alice/clip"
+	store.Put(key, []byte("0123456789"))
+
+	get := func(rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/kv/"+key, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK || w.Header().Get("Accept-Ranges") != "bytes" || w.Body.String() != "0123456789" {
+		t.Errorf("Full GET = %d %q, Accept-Ranges %q", w.Code, w.Body.String(), w.Header().Get("Accept-Ranges"))
+	}
+
+	tests := []struct {
+		rangeHeader  string
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", "2345", "bytes 2-5/10"},
+		{"bytes=7-", "789", "bytes 7-9/10"},
+		{"bytes=-3", "789", "bytes 7-9/10"},
+		{"bytes=8-100", "89", "bytes 8-9/10"},
+	}
+	for _, tt := range tests {
+		w := get(tt.rangeHeader)
+		if w.Code != http.StatusPartialContent || w.Body.String() != tt.body || w.Header().Get("Content-Range") != tt.contentRange {
+			t.Errorf("Range %s = %d %q %q, want 206 %q %q", tt.rangeHeader, w.Code, w.Body.String(), w.Header().Get("Content-Range"), tt.body, tt.contentRange)
+		}
+	}
+
+	if w := get("bytes=20-30"); w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */10" {
+		t.Errorf("Unsatisfiable range = %d %q, want 416", w.Code, w.Header().Get("Content-Range"))
+	}
+
+	// Several ranges come back as multipart/byteranges
+	w = get("bytes=0-1,8-9")
+	if w.Code != http.StatusPartialContent || !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Errorf("Multi-range = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestHandleKV_AdversarialKeys(t *testing.T) {
+	root := t.TempDir()
+	dataDir := filepath.Join(root, "data")
+	store, err := NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	base := "/kv/domain/example.com/user/alice/"
+
+	paths := []string{
+		"/kv/../../etc/passwd",
+		base + "../../bob/secret",
+		base + "..",
+		base + "%2e%2e/%2e%2e/bob/secret",
+		base + "%2e%2e%2f%2e%2e%2fbob%2fsecret",
+		base + "a%00b",
+		base + "a\\..\\b",
+		base + "a//b",
+		base + "./a",
+		base + "my..notes", // ".." is refused anywhere, even where it can't traverse
+		base + strings.Repeat("k", MaxKeyLength),
+		base + strings.Repeat("d/", MaxKeyDepth) + "k",
+	}
+	for _, path := range paths {
+		for _, method := range []string{http.MethodPut, http.MethodGet, http.MethodDelete} {
+			req := httptest.NewRequest(method, "/", strings.NewReader("pwned"))
+			req.URL.Path = mustUnescape(t, path)
+			req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+			w := httptest.NewRecorder()
+			handlers.HandleKV(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s %q status = %d, want 400", method, path, w.Code)
+			}
+		}
+	}
+
+	// Nothing was written anywhere, inside the data directory or out
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("Adversarial request wrote %s", path)
+		}
+		return nil
+	})
+
+	// Ordinary keys near the limits are fine
+	for _, key := range []string{strings.Repeat("k", 200), strings.Repeat("d/", MaxKeyDepth-6) + "k"} {
+		req := httptest.NewRequest(http.MethodPut, base+key, strings.NewReader("ok"))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("PUT %q status = %d: %s", key, w.Code, w.Body.String())
+		}
+	}
+}
+
+func mustUnescape(t *testing.T, path string) string {
+	t.Helper()
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		t.Fatalf("Bad test path %q: %v", path, err)
+	}
+	return unescaped
+}
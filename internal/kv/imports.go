@@ -0,0 +1,307 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrImportCycle is returned when trifles import each other in a loop
+var ErrImportCycle = errors.New("import cycle")
+
+// trifleImportPackage is the Python package trifles import each other through,
+// as in "from trifling.mine.colors import RED"
+const trifleImportPackage = "trifling.mine."
+
+// maxImportScan bounds how much of a source file is scanned for imports.
+// Imports come first in practice, and it keeps a huge file from being
+// parsed in full on every scan.
+const maxImportScan = 64 << 10
+
+// TrifleSource is the current version of a trifle, as the client syncs it:
+// the newest pointer under trifle/latest/{id}, naming a trifle/version/{version}
+// JSON document, whose files are stored under file/
+type TrifleSource struct {
+	Key    string `json:"key"` // {user}/trifle/latest/{id}
+	Name   string `json:"name"`
+	Source string `json:"source"` // main.py, which is what an import runs
+}
+
+// trifleVersionData is the part of a client's trifle version document the
+// server reads
+type trifleVersionData struct {
+	Name  string `json:"name"`
+	Files []struct {
+		Path string `json:"path"`
+		Hash string `json:"hash"`
+	} `json:"files"`
+}
+
+// TrifleSource reads the current version of the trifle at key, a
+// {user}/trifle/latest/{id} prefix
+func (s *Store) TrifleSource(key string) (*TrifleSource, error) {
+	key = strings.TrimSuffix(key, "/")
+	userPrefix, ok := trifleLatestPrefix(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a trifle", ErrInvalidKey, key)
+	}
+
+	// Like a bundle's current version: the newest pointer, ties broken by key
+	pointers, err := s.List(key, 1, false)
+	if err != nil {
+		return nil, err
+	}
+	var newest string
+	var newestInfo os.FileInfo
+	for _, pointer := range pointers {
+		info, err := s.Stat(pointer)
+		if err != nil {
+			return nil, err
+		}
+		if newestInfo == nil || info.ModTime().After(newestInfo.ModTime()) ||
+			(info.ModTime().Equal(newestInfo.ModTime()) && pointer > newest) {
+			newest, newestInfo = pointer, info
+		}
+	}
+	if newest == "" {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+
+	versionKey := userPrefix + "/trifle/version/" + newest[strings.LastIndex(newest, "/")+1:]
+	data, err := s.Get(versionKey)
+	if err != nil {
+		return nil, err
+	}
+	var version trifleVersionData
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrNotJSON, versionKey, err)
+	}
+
+	trifle := &TrifleSource{Key: key, Name: version.Name}
+	for _, file := range version.Files {
+		if file.Path != "main.py" {
+			continue
+		}
+		if len(file.Hash) < 4 {
+			return nil, fmt.Errorf("%w: %s has an invalid hash for main.py", ErrInvalidKey, versionKey)
+		}
+		source, err := s.Get("file/" + file.Hash[0:2] + "/" + file.Hash[2:4] + "/" + file.Hash)
+		if err != nil {
+			return nil, err
+		}
+		trifle.Source = string(source)
+	}
+	return trifle, nil
+}
+
+// parseTrifleImports returns the names of the trifles a Python source imports,
+// in order of first appearance. Both "from trifling.mine.x import y" and
+// "import trifling.mine.x" count; for trifling.mine.x.helpers, the trifle is x.
+// Only the first maxImportScan bytes are scanned.
+func parseTrifleImports(source string) []string {
+	if len(source) > maxImportScan {
+		source = source[:strings.LastIndex(source[:maxImportScan], "\n")+1]
+	}
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(module string) {
+		module, ok := strings.CutPrefix(strings.TrimSpace(module), trifleImportPackage)
+		if !ok {
+			return
+		}
+		name, _, _ := strings.Cut(module, ".")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, line := range strings.Split(source, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "from":
+			add(fields[1])
+		case "import":
+			// import a, trifling.mine.x as y
+			for _, module := range strings.Split(strings.Join(fields[1:], " "), ",") {
+				module, _, _ = strings.Cut(strings.TrimSpace(module), " ")
+				add(module)
+			}
+		}
+	}
+	return names
+}
+
+// ImportGraph is a trifle's transitive imports, resolved against its owner's
+// trifles by name
+type ImportGraph struct {
+	Root    string           `json:"root"`
+	Trifles []ResolvedTrifle `json:"trifles"` // Dependencies before the trifles that import them; Root is last
+	Missing []MissingImport  `json:"missing"`
+}
+
+// ResolvedTrifle is one trifle in an import graph
+type ResolvedTrifle struct {
+	TrifleSource
+	Imports []string `json:"imports"` // Names of the trifles it imports
+}
+
+// MissingImport is an import that couldn't be resolved
+type MissingImport struct {
+	Name       string `json:"name"`
+	ImportedBy string `json:"importedBy"` // Key of the importing trifle
+	Reason     string `json:"reason"`
+}
+
+// userTrifles reads the current version of each of the trifles under
+// userPrefix, returning them by key and their keys by name. Trifles without
+// readable version data are skipped, as they can't be imported either.
+func (s *Store) userTrifles(userPrefix string) (map[string]*TrifleSource, map[string][]string, error) {
+	latest := userPrefix + "/trifle/latest"
+	pointers, err := s.List(latest, 1, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trifles := map[string]*TrifleSource{}
+	byName := map[string][]string{}
+	for _, pointer := range pointers {
+		id, _, _ := strings.Cut(strings.TrimPrefix(pointer, latest+"/"), "/")
+		trifleKey := latest + "/" + id
+		if _, ok := trifles[trifleKey]; ok {
+			continue
+		}
+		trifle, err := s.TrifleSource(trifleKey)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") || errors.Is(err, ErrNotJSON) || errors.Is(err, ErrInvalidKey) {
+				continue // e.g. a pointer without version data, as bundles allow
+			}
+			return nil, nil, err
+		}
+		trifles[trifleKey] = trifle
+		byName[trifle.Name] = append(byName[trifle.Name], trifleKey)
+	}
+	for _, keys := range byName {
+		sort.Strings(keys)
+	}
+	return trifles, byName, nil
+}
+
+// ResolveImports resolves the imports of the trifle at key, and of the trifles
+// it imports, against the trifles of the same user. Missing or ambiguously
+// named trifles are listed rather than failing the whole graph; a cycle,
+// including a trifle importing itself, is ErrImportCycle.
+func (s *Store) ResolveImports(key string) (*ImportGraph, error) {
+	key = strings.TrimSuffix(key, "/")
+	userPrefix, ok := trifleLatestPrefix(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a trifle", ErrInvalidKey, key)
+	}
+
+	// Imports name trifles, so every trifle of the user's has to be read
+	trifles, byName, err := s.userTrifles(userPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := trifles[key]; !ok {
+		// Not found, or not a trifle userTrifles could read: say why
+		if _, err := s.TrifleSource(key); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+
+	graph := &ImportGraph{Root: key, Trifles: []ResolvedTrifle{}, Missing: []MissingImport{}}
+	done := map[string]bool{}
+	var path []string // Trifles being resolved, outermost first
+
+	var visit func(trifleKey string) error
+	visit = func(trifleKey string) error {
+		for i, onPath := range path {
+			if onPath == trifleKey {
+				var names []string
+				for _, k := range append(path[i:], trifleKey) {
+					names = append(names, trifles[k].Name)
+				}
+				return fmt.Errorf("%w: %s", ErrImportCycle, strings.Join(names, " -> "))
+			}
+		}
+		if done[trifleKey] {
+			return nil
+		}
+		path = append(path, trifleKey)
+
+		trifle := trifles[trifleKey]
+		imports := parseTrifleImports(trifle.Source)
+		for _, name := range imports {
+			switch candidates := byName[name]; len(candidates) {
+			case 0:
+				graph.Missing = append(graph.Missing, MissingImport{Name: name, ImportedBy: trifleKey, Reason: "no trifle with this name"})
+			case 1:
+				if err := visit(candidates[0]); err != nil {
+					return err
+				}
+			default:
+				graph.Missing = append(graph.Missing, MissingImport{Name: name, ImportedBy: trifleKey, Reason: fmt.Sprintf("%d trifles have this name: %s", len(candidates), strings.Join(candidates, ", "))})
+			}
+		}
+
+		path = path[:len(path)-1]
+		done[trifleKey] = true
+		graph.Trifles = append(graph.Trifles, ResolvedTrifle{TrifleSource: *trifle, Imports: append([]string{}, imports...)})
+		return nil
+	}
+	if err := visit(key); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// HandleImports handles GET /kvimports/{key}, where key is one of the user's
+// trifles ({user}/trifle/latest/{id}), returning its resolved ImportGraph
+func (h *Handlers) HandleImports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/kvimports/")
+	if err := ValidateKey(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.checkAuth(r, key); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if _, ok := trifleLatestPrefix(key); !ok || strings.HasPrefix(key, "file/") {
+		http.Error(w, "Not a trifle: expected {user}/trifle/latest/{trifle_id}", http.StatusBadRequest)
+		return
+	}
+
+	graph, err := h.store.ResolveImports(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrImportCycle):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case strings.Contains(err.Error(), "not found"):
+			http.Error(w, "Not found", http.StatusNotFound)
+		default:
+			slog.ErrorContext(r.Context(), "Failed to resolve imports", "error", err, "key", key)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graph)
+}
@@ -0,0 +1,118 @@
+package kv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// putTrifle stores a trifle the way the client syncs one
+func putTrifle(t *testing.T, store *Store, id, name, source string) string {
+	t.Helper()
+	prefix := "domain/example.com/user/alice/trifle"
+	sum := sha256.Sum256([]byte(source))
+	hash := hex.EncodeToString(sum[:])
+	version, _ := json.Marshal(map[string]any{
+		"trifle_id": id,
+		"name":      name,
+		"files":     []map[string]string{{"path": "main.py", "hash": hash}},
+	})
+	for key, value := range map[string][]byte{
+		"file/" + hash[0:2] + "/" + hash[2:4] + "/" + hash: []byte(source),
+		prefix + "/version/version_" + id:                  version,
+		prefix + "/latest/" + id + "/version_" + id:        nil,
+	} {
+		if err := store.Put(key, value); err != nil {
+			t.Fatalf("Put %s failed: %v", key, err)
+		}
+	}
+	return prefix + "/latest/" + id
+}
+
+func TestParseTrifleImports(t *testing.T) {
+	source := `import math
+from trifling.mine.colors import RED, lighten
+import trifling.mine.shapes as s, os
+from trifling.mine.project.helpers import util
+from trifling.canvas import ctx
+# from trifling.mine.commented import nothing
+from trifling.mine.colors import BLUE
+`
+	want := []string{"colors", "shapes", "project"}
+	if got := parseTrifleImports(source); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTrifleImports = %v, want %v", got, want)
+	}
+}
+
+func TestResolveImports(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	app := putTrifle(t, store, "t1", "app", "from trifling.mine.shapes import square\nfrom trifling.mine.colors import RED\nimport trifling.mine.nowhere\n")
+	shapes := putTrifle(t, store, "t2", "shapes", "from trifling.mine.colors import BLUE\ndef square(): pass\n")
+	colors := putTrifle(t, store, "t3", "colors", "RED = 1\nBLUE = 2\nfrom trifling.mine.dup import x\n")
+	putTrifle(t, store, "t4", "dup", "")
+	putTrifle(t, store, "t5", "dup", "")
+
+	graph, err := store.ResolveImports(app)
+	if err != nil {
+		t.Fatalf("ResolveImports failed: %v", err)
+	}
+
+	var order []string
+	for _, trifle := range graph.Trifles {
+		order = append(order, trifle.Key)
+	}
+	if want := []string{colors, shapes, app}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Resolution order = %v, want %v", order, want)
+	}
+	if graph.Trifles[0].Source != "RED = 1\nBLUE = 2\nfrom trifling.mine.dup import x\n" {
+		t.Errorf("Unexpected source for colors: %q", graph.Trifles[0].Source)
+	}
+
+	missing := map[string]string{}
+	for _, m := range graph.Missing {
+		missing[m.Name] = m.ImportedBy
+	}
+	if len(graph.Missing) != 2 || missing["nowhere"] != app || missing["dup"] != colors {
+		t.Errorf("Missing = %+v, want nowhere (from app) and ambiguous dup (from colors)", graph.Missing)
+	}
+}
+
+func TestResolveImports_Cycles(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	a := putTrifle(t, store, "a", "a", "from trifling.mine.b import x\n")
+	putTrifle(t, store, "b", "b", "from trifling.mine.c import x\n")
+	putTrifle(t, store, "c", "c", "from trifling.mine.a import x\n")
+	self := putTrifle(t, store, "s", "self", "import trifling.mine.self\n")
+
+	for _, key := range []string{a, self} {
+		if _, err := store.ResolveImports(key); !errors.Is(err, ErrImportCycle) {
+			t.Errorf("ResolveImports(%s) = %v, want ErrImportCycle", key, err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/kvimports/"+a, nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	w := httptest.NewRecorder()
+	handlers.HandleImports(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Cycle status = %d, want 422", w.Code)
+	}
+	if body := w.Body.String(); body != "import cycle: a -> b -> c -> a\n" {
+		t.Errorf("Cycle message = %q", body)
+	}
+}
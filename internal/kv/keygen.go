@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// KeyOptions controls server-generated keys for collection POSTs
+type KeyOptions struct {
+	Length      int    // Number of characters in a generated key
+	Charset     string // Characters to draw from
+	MaxAttempts int    // Attempts before giving up on collisions
+}
+
+// DefaultKeyOptions gives 36^16 possible keys, so collisions are vanishingly rare
+var DefaultKeyOptions = KeyOptions{
+	Length:      16,
+	Charset:     "abcdefghijklmnopqrstuvwxyz0123456789",
+	MaxAttempts: 5,
+}
+
+// randomKey generates a random key using crypto/rand
+func randomKey(opts KeyOptions) (string, error) {
+	if opts.Length < 1 || opts.Charset == "" {
+		return "", fmt.Errorf("invalid key options: length %d, charset %q", opts.Length, opts.Charset)
+	}
+
+	var b strings.Builder
+	max := big.NewInt(int64(len(opts.Charset)))
+	for i := 0; i < opts.Length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate random number: %w", err)
+		}
+		b.WriteByte(opts.Charset[n.Int64()])
+	}
+
+	return b.String(), nil
+}
+
+// SetKeyOptions configures server-generated keys
+func (s *Store) SetKeyOptions(opts KeyOptions) {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+	s.keyOpts = opts
+}
+
+// Create stores a value under a new random key beneath prefix and returns the key.
+// On the rare collision with an existing key it retries with a fresh key, up to
+// MaxAttempts times. Creation is serialized so two requests can't claim the same key.
+func (s *Store) Create(prefix string, value []byte) (string, error) {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	for attempt := 0; attempt < s.keyOpts.MaxAttempts; attempt++ {
+		name, err := s.randomKey(s.keyOpts)
+		if err != nil {
+			return "", err
+		}
+
+		key := prefix + "/" + name
+		if err := s.CreateExclusive(key, value); err != nil {
+			if errors.Is(err, ErrKeyExists) {
+				continue
+			}
+			return "", err
+		}
+		return key, nil
+	}
+
+	return "", fmt.Errorf("failed to generate unique key under %s after %d attempts", prefix, s.keyOpts.MaxAttempts)
+}
@@ -0,0 +1,159 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Limits bounds what clients may store. A zero field means no limit.
+// Content-addressed file/ values are shared between users, so they count
+// toward no one's keys or quota.
+type Limits struct {
+	MaxValueSize   int64 `json:"max_value_size"`    // Bytes in one value
+	MaxKeysPerUser int64 `json:"max_keys_per_user"` // Values one user may store
+	QuotaBytes     int64 `json:"quota_bytes"`       // Total bytes one user may store
+}
+
+// DefaultLimits are the Limits of handlers that haven't been given others
+var DefaultLimits = Limits{MaxValueSize: DefaultMaxValueSize}
+
+// limitHeader names the limit a refused request ran into and its value, e.g.
+// "max_value_size=16777216", so clients can adapt without parsing the message
+const limitHeader = "X-KV-Limit"
+
+// LimitError is returned when a write would exceed one of the Limits, or a
+// key MaxKeyLength
+type LimitError struct {
+	Name   string // The limit's JSON name, e.g. "max_value_size"
+	Limit  int64
+	Status int // 413 for a single value or key that's too big, 507 for a full quota
+}
+
+func (e *LimitError) Error() string {
+	switch e.Name {
+	case "max_value_size":
+		return fmt.Sprintf("Value too large (limit is %d bytes)", e.Limit)
+	case "quota_bytes":
+		return fmt.Sprintf("Storage quota exceeded (limit is %d bytes)", e.Limit)
+	case "max_keys_per_user":
+		return fmt.Sprintf("Too many keys (limit is %d)", e.Limit)
+	}
+	return fmt.Sprintf("%s exceeded (limit is %d)", e.Name, e.Limit)
+}
+
+// writeLimitError responds to a request refused by err, naming the limit in
+// limitHeader as well as the message
+func writeLimitError(w http.ResponseWriter, err *LimitError) {
+	w.Header().Set(limitHeader, err.Name+"="+strconv.FormatInt(err.Limit, 10))
+	http.Error(w, err.Error(), err.Status)
+}
+
+// SetLimits replaces the handlers' Limits
+func (h *Handlers) SetLimits(limits Limits) {
+	h.limits = limits
+}
+
+// LimitsInfo is the body of GET /kv/limits. Key limits are fixed, since
+// they're about what the filesystem can hold.
+type LimitsInfo struct {
+	Limits
+	MaxKeyLength int    `json:"max_key_length"`
+	MaxKeyDepth  int    `json:"max_key_depth"`
+	Usage        *Usage `json:"usage,omitempty"` // The requesting user's, when they have a quota
+}
+
+// handleLimits handles GET /kv/limits, so clients can learn the limits before
+// running into them
+func (h *Handlers) handleLimits(w http.ResponseWriter, r *http.Request) {
+	info := LimitsInfo{Limits: h.limits, MaxKeyLength: MaxKeyLength, MaxKeyDepth: MaxKeyDepth}
+	if email, ok := r.Context().Value("user_email").(string); ok && h.hasQuota() {
+		if usage, err := h.store.UserUsage(email); err == nil {
+			info.Usage = &usage
+		} else {
+			slog.WarnContext(r.Context(), "Failed to compute usage", "error", err, "user", email)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// hasQuota reports whether users' keys or bytes are limited
+func (h *Handlers) hasQuota() bool {
+	return h.limits.MaxKeysPerUser > 0 || h.limits.QuotaBytes > 0
+}
+
+// allowance returns how many bytes a write to key may store under its
+// owner's quota, or -1 if it has none, and a *LimitError if it may not be
+// written at all. Replacing a value frees up its size.
+func (h *Handlers) allowance(key string) (int64, error) {
+	owner := keyOwner(key)
+	if owner == "" || !h.hasQuota() {
+		return -1, nil
+	}
+	usage, err := h.store.UserUsage(owner)
+	if err != nil {
+		return 0, err
+	}
+
+	var existing int64
+	exists := false
+	if path, err := h.store.keyPath(key); err == nil {
+		if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+			existing, exists = info.Size(), true
+		}
+	}
+	if limit := h.limits.MaxKeysPerUser; limit > 0 && !exists && usage.Keys >= limit {
+		return 0, &LimitError{Name: "max_keys_per_user", Limit: limit, Status: http.StatusInsufficientStorage}
+	}
+	if h.limits.QuotaBytes <= 0 {
+		return -1, nil
+	}
+	return max(h.limits.QuotaBytes-usage.Bytes+existing, 0), nil
+}
+
+// checkAllowance is allowance for a handler, responding to the request
+// itself if the write can't go ahead
+func (h *Handlers) checkAllowance(w http.ResponseWriter, r *http.Request, key string) (int64, bool) {
+	allowance, err := h.allowance(key)
+	if err != nil {
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			writeLimitError(w, limitErr)
+		} else {
+			slog.ErrorContext(r.Context(), "Failed to check quota", "error", err, "key", key)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return 0, false
+	}
+	return allowance, true
+}
+
+// limitValue caps how much of a request body will be read at the max value
+// size, or the allowance left by the owner's quota if that's smaller
+func (h *Handlers) limitValue(w http.ResponseWriter, r *http.Request, allowance int64) io.Reader {
+	limit := h.limits.MaxValueSize
+	if allowance >= 0 && (limit <= 0 || allowance < limit) {
+		limit = allowance
+	} else if limit <= 0 {
+		return r.Body
+	}
+	return http.MaxBytesReader(w, r.Body, limit)
+}
+
+// checkValueSize returns a *LimitError if a value of size bytes may not be
+// stored with the given allowance
+func (h *Handlers) checkValueSize(size, allowance int64) error {
+	if limit := h.limits.MaxValueSize; limit > 0 && size > limit {
+		return &LimitError{Name: "max_value_size", Limit: limit, Status: http.StatusRequestEntityTooLarge}
+	}
+	if allowance >= 0 && size > allowance {
+		return &LimitError{Name: "quota_bytes", Limit: h.limits.QuotaBytes, Status: http.StatusInsufficientStorage}
+	}
+	return nil
+}
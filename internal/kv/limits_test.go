@@ -0,0 +1,77 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleKV_Limits(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	handlers.SetLimits(Limits{MaxValueSize: 10, MaxKeysPerUser: 3, QuotaBytes: 24})
+	prefix := "domain/example.com/user/alice/"
+
+	serve := func(method, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/kv/"+key, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+	expectLimit := func(w *httptest.ResponseRecorder, what string, status int, header, message string) {
+		t.Helper()
+		if w.Code != status || w.Header().Get(limitHeader) != header || !strings.Contains(w.Body.String(), message) {
+			t.Errorf("%s = %d %s %q, want %d %s mentioning %q", what, w.Code, w.Header().Get(limitHeader), w.Body.String(), status, header, message)
+		}
+	}
+
+	w := serve(http.MethodGet, "limits", "")
+	var info LimitsInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("GET /kv/limits = %d %s", w.Code, w.Body.String())
+	}
+	want := LimitsInfo{
+		Limits:       Limits{MaxValueSize: 10, MaxKeysPerUser: 3, QuotaBytes: 24},
+		MaxKeyLength: MaxKeyLength,
+		MaxKeyDepth:  MaxKeyDepth,
+		Usage:        &Usage{},
+	}
+	if info.Limits != want.Limits || info.MaxKeyLength != want.MaxKeyLength || info.MaxKeyDepth != want.MaxKeyDepth || info.Usage == nil || *info.Usage != *want.Usage {
+		t.Errorf("GET /kv/limits = %+v, want %+v", info, want)
+	}
+
+	expectLimit(serve(http.MethodPut, prefix+"big", "12345678901"), "PUT over max_value_size", http.StatusRequestEntityTooLarge, "max_value_size=10", "10 bytes")
+	expectLimit(serve(http.MethodPut, prefix+strings.Repeat("k", MaxKeyLength), "x"), "PUT with a long key", http.StatusBadRequest, "max_key_length=1024", "1024 bytes")
+
+	for _, name := range []string{"a", "b"} {
+		if w := serve(http.MethodPut, prefix+name, "1234567890"); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s = %d %s", name, w.Code, w.Body.String())
+		}
+	}
+	// 20 of 24 bytes used: a 5-byte value won't fit, though replacing one does
+	expectLimit(serve(http.MethodPut, prefix+"c", "12345"), "PUT over quota_bytes", http.StatusInsufficientStorage, "quota_bytes=24", "24 bytes")
+	if store.Exists(prefix + "c") {
+		t.Error("PUT over quota_bytes stored the value")
+	}
+	if w := serve(http.MethodPut, prefix+"a", "123456789"); w.Code != http.StatusOK {
+		t.Errorf("PUT replacing a value within quota = %d %s", w.Code, w.Body.String())
+	}
+	expectLimit(serve(http.MethodPost, prefix+"generated/", "123456"), "POST over quota_bytes", http.StatusInsufficientStorage, "quota_bytes=24", "24 bytes")
+
+	if w := serve(http.MethodPut, prefix+"c", "1"); w.Code != http.StatusOK {
+		t.Fatalf("PUT c = %d %s", w.Code, w.Body.String())
+	}
+	expectLimit(serve(http.MethodPut, prefix+"d", ""), "PUT over max_keys_per_user", http.StatusInsufficientStorage, "max_keys_per_user=3", "limit is 3")
+
+	// Shared values count toward no one's quota
+	if w := serve(http.MethodPut, "file/ab/cd/abcd", "1234567890"); w.Code != http.StatusOK {
+		t.Errorf("PUT of a file/ value = %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,121 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// metaDir holds a JSON sidecar of metadata for each key that has any, at the
+// key's own path. Like publicDir, it sits outside every user's prefix.
+const metaDir = ".meta"
+
+// defaultContentType is served for values stored without a content type,
+// including every value stored before content types were recorded
+const defaultContentType = "application/octet-stream"
+
+// Metadata is stored alongside a value. The zero value means none.
+type Metadata struct {
+	ContentType string `json:"contentType,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`  // Hex checksum of the value, empty for values stored before checksums were recorded
+	Version     int64  `json:"version,omitempty"` // See Store.Version
+}
+
+// metaPath returns the path of key's metadata sidecar
+func (s *Store) metaPath(key string) (string, error) {
+	if _, err := s.keyPath(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dataDir, metaDir, key), nil
+}
+
+// writeMetadata replaces key's metadata, removing the sidecar if there's none
+func (s *Store) writeMetadata(key string, meta Metadata) error {
+	path, err := s.metaPath(key)
+	if err != nil {
+		return err
+	}
+	if meta == (Metadata{}) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to clear metadata: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if _, err := s.writeFile(path, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// Metadata returns the metadata stored with key's value, following pointer
+// keys. Values without any have the zero Metadata.
+func (s *Store) Metadata(key string) (Metadata, error) {
+	key, err := s.resolve(key)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return s.readMetadata(key)
+}
+
+// readMetadata is Metadata for a key that isn't a pointer
+func (s *Store) readMetadata(key string) (Metadata, error) {
+	path, err := s.metaPath(key)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var meta Metadata
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("failed to decode metadata for %s: %w", key, err)
+	}
+	return meta, nil
+}
+
+// requestMetadata returns the metadata to store from a PUT. Unparseable
+// content types are dropped rather than stored and echoed back.
+func requestMetadata(r *http.Request) Metadata {
+	return contentTypeMetadata(r.Header.Get("Content-Type"))
+}
+
+// contentTypeMetadata is requestMetadata for a content type from elsewhere,
+// such as an import
+func contentTypeMetadata(contentType string) Metadata {
+	if contentType == "" {
+		return Metadata{}
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Metadata{}
+	}
+	return Metadata{ContentType: mime.FormatMediaType(mediaType, params)}
+}
+
+// setValueHeaders describes a stored value being served. Values are served
+// from the app's own origin, so a stored HTML page is sandboxed: it renders,
+// but can't run script as the app or read its cookies.
+func setValueHeaders(w http.ResponseWriter, info os.FileInfo, meta Metadata) {
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	w.Header().Set("ETag", etag(info))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Content-Security-Policy", "sandbox")
+}
@@ -0,0 +1,106 @@
+package kv
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleKV_ContentType(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	base := "domain/example.com/user/alice/"
+
+	serve := func(method, key, contentType, body string) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, "/kv/"+key, reader)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+	contentTypeOf := func(key string) string {
+		t.Helper()
+		w := serve(http.MethodGet, key, "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d", key, w.Code)
+		}
+		return w.Header().Get("Content-Type")
+	}
+
+	serve(http.MethodPut, base+"logo.svg", "image/svg+xml", "<svg/>")
+	if got := contentTypeOf(base + "logo.svg"); got != "image/svg+xml" {
+		t.Errorf("Content-Type = %q, want image/svg+xml", got)
+	}
+
+	// Stored HTML renders sandboxed, away from the app's origin
+	w := serve(http.MethodPut, base+"page", "text/html; charset=UTF-8", "<script>alert(1)</script>")
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d", w.Code)
+	}
+	w = serve(http.MethodGet, base+"page", "", "")
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=UTF-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if w.Header().Get("Content-Security-Policy") != "sandbox" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("Missing sandboxing headers: %v", w.Header())
+	}
+
+	// Values written before content types were recorded, or without one, get the default
+	os.MkdirAll(filepath.Join(dir, base), 0755)
+	os.WriteFile(filepath.Join(dir, base+"legacy"), []byte("old"), 0644)
+	if got := contentTypeOf(base + "legacy"); got != "application/octet-stream" {
+		t.Errorf("Legacy Content-Type = %q", got)
+	}
+	serve(http.MethodPut, base+"logo.svg", "", "<svg/>")
+	if got := contentTypeOf(base + "logo.svg"); got != "application/octet-stream" {
+		t.Errorf("Content-Type after untyped PUT = %q", got)
+	}
+	serve(http.MethodPut, base+"bad", "not a/media type;;", "x")
+	if got := contentTypeOf(base + "bad"); got != "application/octet-stream" {
+		t.Errorf("Content-Type for invalid type = %q", got)
+	}
+
+	// Pointers serve their target's type, and patches keep it
+	serve(http.MethodPut, base+"doc", "application/vnd.trifle+json", `{"a":1}`)
+	serve(http.MethodPut, base+"latest?pointer="+base+"doc", "", "")
+	if got := contentTypeOf(base + "latest"); got != "application/vnd.trifle+json" {
+		t.Errorf("Content-Type through pointer = %q", got)
+	}
+	if w := serve(http.MethodPatch, base+"doc", "application/merge-patch+json", `{"b":2}`); w.Code != http.StatusOK {
+		t.Fatalf("PATCH status = %d", w.Code)
+	}
+	if got := contentTypeOf(base + "doc"); got != "application/vnd.trifle+json" {
+		t.Errorf("Content-Type after PATCH = %q", got)
+	}
+
+	// A deleted and recreated key forgets its old type
+	serve(http.MethodDelete, base+"doc", "", "")
+	store.Put(base+"doc", []byte("new"))
+	if got := contentTypeOf(base + "doc"); got != "application/octet-stream" {
+		t.Errorf("Content-Type after recreate = %q", got)
+	}
+
+	// Metadata lives outside the user's keys
+	keys, _ := store.List(base, 0, true)
+	for _, key := range keys {
+		if strings.Contains(key, metaDir) {
+			t.Errorf("Metadata listed as a key: %s", key)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package kv
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 )
 
@@ -9,6 +10,7 @@ import (
 type Session interface {
 	Email() string
 	IsAuthenticated() bool
+	ImpersonatedBy() string // Admin acting as this user, or "" for the user themselves
 }
 
 // SessionGetter can retrieve a session from a request
@@ -28,6 +30,18 @@ func RequireAuth(sessionGetter SessionGetter) func(http.HandlerFunc) http.Handle
 
 			// Add user email to context
 			ctx := context.WithValue(r.Context(), "user_email", session.Email())
+
+			// Impersonation is read-only, and every access is audited
+			if admin := session.ImpersonatedBy(); admin != "" {
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					slog.WarnContext(r.Context(), "Impersonated KV write blocked", "audit", true, "admin", admin, "user", session.Email(), "method", r.Method, "path", r.URL.Path)
+					http.Error(w, "Impersonated sessions are read-only", http.StatusForbidden)
+					return
+				}
+				slog.InfoContext(r.Context(), "Impersonated KV access", "audit", true, "admin", admin, "user", session.Email(), "method", r.Method, "path", r.URL.Path)
+				ctx = context.WithValue(ctx, "impersonated_by", admin)
+			}
+
 			next.ServeHTTP(w, r.WithContext(ctx))
 		}
 	}
@@ -35,8 +49,9 @@ func RequireAuth(sessionGetter SessionGetter) func(http.HandlerFunc) http.Handle
 
 // SessionAdapter adapts auth.Session to kv.Session interface
 type SessionAdapter struct {
-	email         string
-	authenticated bool
+	email          string
+	authenticated  bool
+	impersonatedBy string
 }
 
 func (sa *SessionAdapter) Email() string {
@@ -47,29 +62,30 @@ func (sa *SessionAdapter) IsAuthenticated() bool {
 	return sa.authenticated
 }
 
+func (sa *SessionAdapter) ImpersonatedBy() string {
+	return sa.impersonatedBy
+}
+
 // NewSessionAdapter creates an adapter for auth.Session
-func NewSessionAdapter(email string, authenticated bool) *SessionAdapter {
+func NewSessionAdapter(email string, authenticated bool, impersonatedBy string) *SessionAdapter {
 	return &SessionAdapter{
-		email:         email,
-		authenticated: authenticated,
+		email:          email,
+		authenticated:  authenticated,
+		impersonatedBy: impersonatedBy,
 	}
 }
 
 // SessionManagerAdapter adapts auth.SessionManager to kv.SessionGetter
 type SessionManagerAdapter struct {
-	getSession func(*http.Request) (string, bool, error) // Returns (email, authenticated, error)
+	getSession func(*http.Request) (Session, error)
 }
 
 func (sma *SessionManagerAdapter) GetSession(r *http.Request) (Session, error) {
-	email, authenticated, err := sma.getSession(r)
-	if err != nil {
-		return nil, err
-	}
-	return NewSessionAdapter(email, authenticated), nil
+	return sma.getSession(r)
 }
 
 // NewSessionManagerAdapter creates a session manager adapter
-func NewSessionManagerAdapter(getSession func(*http.Request) (string, bool, error)) *SessionManagerAdapter {
+func NewSessionManagerAdapter(getSession func(*http.Request) (Session, error)) *SessionManagerAdapter {
 	return &SessionManagerAdapter{
 		getSession: getSession,
 	}
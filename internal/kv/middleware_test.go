@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeSessionGetter struct {
+	session Session
+}
+
+func (g fakeSessionGetter) GetSession(r *http.Request) (Session, error) {
+	return g.session, nil
+}
+
+func TestRequireAuth_ImpersonationIsReadOnlyAndAudited(t *testing.T) {
+	var logs bytes.Buffer
+	oldLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(oldLogger)
+
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	key := "domain/example.com/user/alice/profile"
+	if err := store.Put(key, []byte(`{"display_name":"Alice"}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	session := NewSessionAdapter("alice@example.com", true, "admin@example.com")
+	handler := RequireAuth(fakeSessionGetter{session})(handlers.HandleKV)
+
+	// Reads succeed and are logged with both identities
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/kv/"+key, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected impersonated read to succeed, got %d", w.Code)
+	}
+	if !strings.Contains(logs.String(), `msg="Impersonated KV access" audit=true admin=admin@example.com user=alice@example.com method=GET path=/kv/`+key) {
+		t.Errorf("Expected audited read, got logs:\n%s", logs.String())
+	}
+
+	// Every kind of write is refused, and the attempt is logged too
+	for _, method := range []string{http.MethodPut, http.MethodPost, http.MethodDelete} {
+		logs.Reset()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/kv/"+key, strings.NewReader("{}")))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", method, w.Code)
+		}
+		if !strings.Contains(logs.String(), `msg="Impersonated KV write blocked" audit=true admin=admin@example.com user=alice@example.com method=`+method) {
+			t.Errorf("%s: expected audited refusal, got logs:\n%s", method, logs.String())
+		}
+	}
+
+	value, err := store.Get(key)
+	if err != nil || string(value) != `{"display_name":"Alice"}` {
+		t.Errorf("Value changed under impersonation: %q, %v", value, err)
+	}
+}
@@ -0,0 +1,213 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotJSON is returned by Patch when the stored value isn't a JSON document
+	ErrNotJSON = errors.New("stored value is not JSON")
+
+	// ErrInvalidPatch is returned by Patch when the patch isn't valid JSON
+	ErrInvalidPatch = errors.New("invalid merge patch")
+
+	// ErrPreconditionFailed is returned when an If-Match ETag no longer matches
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// keyLocks serializes writers to the same key. Keys share a fixed set of
+// mutexes by hash, so the set never grows.
+type keyLocks [64]sync.Mutex
+
+func (l *keyLocks) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &l[h.Sum32()%uint32(len(l))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Patch applies an RFC 7386 JSON merge patch to the JSON document stored at
+// key (following pointers) and returns the merged document. If ifMatch is not
+// empty, the patch only applies while the value's ETag still matches it. If
+// check isn't nil, it vets the merged document before it's stored, and any
+// error it returns is returned as is.
+func (s *Store) Patch(key string, patch []byte, ifMatch string, check func(merged []byte) error) ([]byte, error) {
+	key, err := s.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	defer s.keyLocks.lock(key)()
+
+	var patchDoc any
+	if err := decodeJSON(patch, &patchDoc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	if ifMatch != "" {
+		info, err := s.Stat(key)
+		if err != nil {
+			return nil, err
+		}
+		if !etagMatches(ifMatch, etag(info)) {
+			return nil, ErrPreconditionFailed
+		}
+	}
+
+	current, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := decodeJSON(current, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotJSON, key)
+	}
+
+	merged, err := json.Marshal(mergePatch(doc, patchDoc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode merged document: %w", err)
+	}
+	if check != nil {
+		if err := check(merged); err != nil {
+			return nil, err
+		}
+	}
+	meta, err := s.Metadata(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.putReader(key, bytes.NewReader(merged), meta); err != nil {
+		return nil, err
+	}
+	s.record("patch", key)
+	return merged, nil
+}
+
+// decodeJSON decodes exactly one JSON value, keeping numbers exact
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("trailing data after JSON value")
+	}
+	return nil
+}
+
+// mergePatch implements the RFC 7386 MergePatch algorithm: objects merge
+// recursively, null removes a member, and anything else replaces the target
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any)
+	}
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+		} else {
+			targetObj[name] = mergePatch(targetObj[name], value)
+		}
+	}
+	return targetObj
+}
+
+// etagMatches reports whether an If-Match header names etag. A weak ETag is
+// accepted too: ours are only weakened by response compression, and still
+// name exactly one stored value.
+func etagMatches(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// handlePatch applies a JSON merge patch to a stored document and returns the
+// result. Send If-Match with the ETag from a previous read to avoid
+// overwriting someone else's concurrent edit.
+func (h *Handlers) handlePatch(w http.ResponseWriter, r *http.Request, key string) {
+	// Content-addressed keys must hold the value they're named for
+	if strings.HasPrefix(key, "file/") {
+		http.Error(w, "Cannot patch keys under file/", http.StatusBadRequest)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		w.Header().Set("Accept-Patch", "application/merge-patch+json")
+		http.Error(w, "PATCH requires Content-Type: application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+	patch, err := io.ReadAll(h.limitBody(w, r))
+	if err != nil {
+		h.writeBodyError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	// The merged document is what gets stored, so the limits and schema a
+	// PUT of it would have apply to it, not to the patch
+	target := key
+	if resolved, err := h.store.resolve(key); err == nil {
+		target = resolved
+	}
+	allowance, ok := h.checkAllowance(w, r, target)
+	if !ok {
+		return
+	}
+	check := func(merged []byte) error {
+		if err := h.checkValueSize(int64(len(merged)), allowance); err != nil {
+			return err
+		}
+		return h.validateSchema(target, merged)
+	}
+
+	merged, err := h.store.PatchContext(r.Context(), key, patch, r.Header.Get("If-Match"), check)
+	if err != nil {
+		var limitErr *LimitError
+		var schemaErr *SchemaError
+		switch {
+		case errors.As(err, &limitErr):
+			writeLimitError(w, limitErr)
+		case errors.As(err, &schemaErr):
+			writeSchemaError(w, r, target, schemaErr)
+		case errors.Is(err, ErrInvalidPatch):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrNotJSON):
+			http.Error(w, "Stored value is not a JSON document", http.StatusConflict)
+		case errors.Is(err, ErrPreconditionFailed):
+			http.Error(w, "Value changed since it was read", http.StatusPreconditionFailed)
+		case errors.Is(err, ErrPointerLoop):
+			http.Error(w, err.Error(), http.StatusLoopDetected)
+		case strings.Contains(err.Error(), "not found"):
+			http.Error(w, "Not found", http.StatusNotFound)
+		default:
+			slog.ErrorContext(r.Context(), "Failed to patch key", "error", err, "key", key)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setConsistencyToken(w)
+	if info, err := h.store.Stat(key); err == nil {
+		w.Header().Set("ETag", etag(info))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(merged)
+}
@@ -0,0 +1,150 @@
+package kv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7386, Appendix A
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		// Numbers survive unchanged
+		{`{"n":12345678901234567890}`, `{"m":0.1}`, `{"m":0.1,"n":12345678901234567890}`},
+	}
+
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	for _, tt := range tests {
+		store.Put("doc", []byte(tt.target))
+		got, err := store.Patch("doc", []byte(tt.patch), "", nil)
+		if err != nil {
+			t.Errorf("Patch(%s, %s) failed: %v", tt.target, tt.patch, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Patch(%s, %s) = %s, want %s", tt.target, tt.patch, got, tt.want)
+		}
+		if stored, _ := store.Get("doc"); string(stored) != tt.want {
+			t.Errorf("Stored %s, want %s", stored, tt.want)
+		}
+	}
+}
+
+func TestHandleKV_Patch(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	key := "domain/example.com/user/alice/trifle"
+	store.Put(key, []byte(`{"name":"hello","files":{"main.py":"abc"}}`))
+	store.Put(key+"-text", []byte("not json"))
+
+	patch := func(key, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/kv/"+key, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	w := patch(key, `{"name":"renamed"}`, "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"files":{"main.py":"abc"},"name":"renamed"}` {
+		t.Fatalf("PATCH = %d %s", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get(ConsistencyTokenHeader) == "" {
+		t.Errorf("Expected ETag and consistency token, got %v", w.Header())
+	}
+
+	// Conditional patches apply only to the version they were based on
+	if w := patch(key, `{"files":{"util.py":"def"}}`, etag); w.Code != http.StatusOK {
+		t.Fatalf("PATCH with current ETag = %d %s", w.Code, w.Body.String())
+	}
+	if w := patch(key, `{"name":"clobbered"}`, etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with stale ETag = %d, want 412", w.Code)
+	}
+	if got, _ := store.Get(key); string(got) != `{"files":{"main.py":"abc","util.py":"def"},"name":"renamed"}` {
+		t.Errorf("Stored %s", got)
+	}
+
+	if w := patch(key+"-text", `{"a":1}`, ""); w.Code != http.StatusConflict {
+		t.Errorf("PATCH of non-JSON value = %d, want 409", w.Code)
+	}
+	if w := patch(key, `{"a":`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("PATCH with invalid JSON = %d, want 400", w.Code)
+	}
+	if w := patch(key+"-missing", `{"a":1}`, ""); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH of missing key = %d, want 404", w.Code)
+	}
+	if w := patch("file/abc", `{"a":1}`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("PATCH under file/ = %d, want 400", w.Code)
+	}
+}
+
+func TestHandleKV_PatchLimits(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	handlers.SetLimits(Limits{MaxValueSize: 20, QuotaBytes: 30})
+	prefix := "domain/example.com/user/alice/"
+	store.Put(prefix+"doc", []byte(`{"a":"1"}`))
+	store.Put(prefix+"other", []byte(`{}`))
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/kv/"+prefix+"doc", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	// Each patch is small, but the document they build up isn't
+	if w := patch(`{"b":"1234"}`); w.Code != http.StatusOK {
+		t.Fatalf("PATCH within limits = %d %s", w.Code, w.Body.String())
+	}
+	if w := patch(`{"c":"12345"}`); w.Code != http.StatusRequestEntityTooLarge || w.Header().Get(limitHeader) != "max_value_size=20" {
+		t.Errorf("PATCH past max value size = %d %v, want 413", w.Code, w.Header())
+	}
+
+	// Now Alice's other value leaves 14 bytes of her quota for this one
+	store.Put(prefix+"other", []byte(`{"b":"12345678"}`))
+	if w := patch(`{"b":null}`); w.Code != http.StatusOK {
+		t.Fatalf("PATCH shrinking the value = %d %s", w.Code, w.Body.String())
+	}
+	if w := patch(`{"c":"1234"}`); w.Code != http.StatusInsufficientStorage || w.Header().Get(limitHeader) != "quota_bytes=30" {
+		t.Errorf("PATCH past quota = %d %v, want 507", w.Code, w.Header())
+	}
+	if got, _ := store.Get(prefix + "doc"); string(got) != `{"a":"1"}` {
+		t.Errorf("Refused patches changed the value to %s", got)
+	}
+}
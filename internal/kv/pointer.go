@@ -0,0 +1,134 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxPointerDepth bounds how many pointers a read follows, e.g.
+// config/latest -> config/stable -> config/v37 is a depth of two
+const maxPointerDepth = 8
+
+// ErrPointerLoop is returned when resolving a pointer key runs into a cycle or
+// follows more than maxPointerDepth pointers
+var ErrPointerLoop = errors.New("pointer loop")
+
+// PutPointer makes key a pointer to target, replacing any value it had. Reads
+// of key transparently return target's value. Pointers are stored as
+// symlinks, but always resolved by the store rather than the filesystem so
+// they can never leave the data directory.
+func (s *Store) PutPointer(key, target string) error {
+	defer s.keyLocks.lock(key)()
+
+	path, err := s.keyPath(key)
+	if err != nil {
+		return err
+	}
+	targetPath, err := s.keyPath(target)
+	if err != nil {
+		return fmt.Errorf("invalid pointer target: %w", err)
+	}
+	if path == targetPath {
+		return fmt.Errorf("%w: %s points to itself", ErrPointerLoop, key)
+	}
+	// The shared file/ store is readable by everyone, but writes through a
+	// pointer into it would bypass its content addressing
+	if strings.HasPrefix(target, "file/") {
+		return fmt.Errorf("invalid pointer target: %s is under file/", target)
+	}
+	// A pointer to a directory would let keys under the pointer reach
+	// everything under the target
+	if strings.HasSuffix(target, "/") {
+		return fmt.Errorf("invalid pointer target: %s is a prefix, not a key", target)
+	}
+	if info, err := os.Lstat(targetPath); err == nil && info.IsDir() {
+		return fmt.Errorf("invalid pointer target: %s is a prefix, not a key", target)
+	}
+
+	if err := s.mkdirAll(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	link, err := filepath.Rel(filepath.Dir(path), targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to compute pointer target: %w", err)
+	}
+
+	// Create the link in tmpDir and rename it into place, so readers see
+	// either the old value or the new pointer. The link is relative to the
+	// key's directory, so it only resolves once it's there.
+	tmp, err := s.tempName("pointer-")
+	if err != nil {
+		return fmt.Errorf("failed to write pointer: %w", err)
+	}
+	if err := os.Symlink(link, tmp); err != nil {
+		return fmt.Errorf("failed to write pointer: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write pointer: %w", err)
+	}
+	// Reads through the pointer get the target's metadata; the pointer's own
+	// only records its version
+	version, err := s.nextVersion(key)
+	if err != nil {
+		return err
+	}
+	if err := s.writeMetadata(key, Metadata{Version: version}); err != nil {
+		return err
+	}
+	s.record("pointer", key)
+	return nil
+}
+
+// PointerTarget returns the key that key points to directly, and whether key
+// is a pointer at all
+func (s *Store) PointerTarget(key string) (string, bool, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return "", false, err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to stat key: %w", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "", false, nil
+	}
+
+	link, err := os.Readlink(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read pointer: %w", err)
+	}
+	rel, err := filepath.Rel(s.dataDir, filepath.Join(filepath.Dir(path), link))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false, fmt.Errorf("pointer %s leaves the data directory", key)
+	}
+	return filepath.ToSlash(rel), true, nil
+}
+
+// resolve follows pointers from key to the key holding the value. A key that
+// isn't a pointer, including one that doesn't exist, resolves to itself.
+func (s *Store) resolve(key string) (string, error) {
+	seen := make(map[string]bool)
+	for depth := 0; ; depth++ {
+		target, isPointer, err := s.PointerTarget(key)
+		if err != nil || !isPointer {
+			return key, err
+		}
+		if seen[key] {
+			return "", fmt.Errorf("%w: cycle through %s", ErrPointerLoop, key)
+		}
+		if depth == maxPointerDepth {
+			return "", fmt.Errorf("%w: more than %d pointers from %s", ErrPointerLoop, maxPointerDepth, key)
+		}
+		seen[key] = true
+		key = target
+	}
+}
@@ -0,0 +1,135 @@
+package kv
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPointer_Resolution(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	if err := store.Put("config/v37", []byte("thirty-seven")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.PutPointer("config/latest", "config/v37"); err != nil {
+		t.Fatalf("PutPointer failed: %v", err)
+	}
+	// Pointers may point at pointers, including across directories
+	if err := store.PutPointer("aliases/current", "config/latest"); err != nil {
+		t.Fatalf("PutPointer failed: %v", err)
+	}
+
+	for _, key := range []string{"config/latest", "aliases/current"} {
+		value, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %v", key, err)
+		}
+		if string(value) != "thirty-seven" {
+			t.Errorf("Get(%s) = %q, want thirty-seven", key, value)
+		}
+	}
+	if info, err := store.Stat("config/latest"); err != nil || info.Size() != int64(len("thirty-seven")) {
+		t.Errorf("Stat through pointer = %v, %v", info, err)
+	}
+
+	target, isPointer, err := store.PointerTarget("config/latest")
+	if err != nil || !isPointer || target != "config/v37" {
+		t.Errorf("PointerTarget = %q, %v, %v", target, isPointer, err)
+	}
+	if _, isPointer, _ := store.PointerTarget("config/v37"); isPointer {
+		t.Error("ordinary key reported as a pointer")
+	}
+
+	// Repointing swaps the target
+	store.Put("config/v38", []byte("thirty-eight"))
+	if err := store.PutPointer("config/latest", "config/v38"); err != nil {
+		t.Fatalf("PutPointer failed: %v", err)
+	}
+	if value, _ := store.Get("config/latest"); string(value) != "thirty-eight" {
+		t.Errorf("Get after repoint = %q, want thirty-eight", value)
+	}
+
+	// Putting a value replaces the pointer rather than writing through it
+	if err := store.Put("config/latest", []byte("inline")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if value, _ := store.Get("config/v38"); string(value) != "thirty-eight" {
+		t.Errorf("Put wrote through the pointer: target = %q", value)
+	}
+
+	// Deleting a pointer leaves its target alone
+	store.PutPointer("config/latest", "config/v38")
+	if err := store.Delete("config/latest"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if !store.Exists("config/v38") {
+		t.Error("deleting the pointer deleted its target")
+	}
+}
+
+func TestPointer_BrokenTarget(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	if err := store.PutPointer("config/latest", "config/missing"); err != nil {
+		t.Fatalf("PutPointer failed: %v", err)
+	}
+	if _, err := store.Get("config/latest"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Get of broken pointer: err = %v, want not found", err)
+	}
+
+	// A broken pointer is still a key that can be listed and deleted
+	keys, err := store.List("config", 1, false)
+	if err != nil || len(keys) != 1 || keys[0] != "config/latest" {
+		t.Errorf("List = %v, %v", keys, err)
+	}
+	if err := store.Delete("config/latest"); err != nil {
+		t.Errorf("Delete of broken pointer failed: %v", err)
+	}
+}
+
+func TestPointer_Loops(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	if err := store.PutPointer("a", "a"); !errors.Is(err, ErrPointerLoop) {
+		t.Errorf("self pointer: err = %v, want ErrPointerLoop", err)
+	}
+
+	store.PutPointer("loop/a", "loop/b")
+	store.PutPointer("loop/b", "loop/a")
+	if _, err := store.Get("loop/a"); !errors.Is(err, ErrPointerLoop) {
+		t.Errorf("Get of cycle: err = %v, want ErrPointerLoop", err)
+	}
+
+	// A long enough chain is refused even without a cycle
+	for i := 0; i <= maxPointerDepth; i++ {
+		store.PutPointer("chain/"+strconv.Itoa(i), "chain/"+strconv.Itoa(i+1))
+	}
+	store.Put("chain/"+strconv.Itoa(maxPointerDepth+1), []byte("end"))
+	if _, err := store.Get("chain/0"); !errors.Is(err, ErrPointerLoop) {
+		t.Errorf("Get of over-long chain: err = %v, want ErrPointerLoop", err)
+	}
+	if value, err := store.Get("chain/1"); err != nil || string(value) != "end" {
+		t.Errorf("Get of chain at max depth = %q, %v", value, err)
+	}
+}
+
+func TestPointer_TargetMustStayInStore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if err := store.PutPointer("escape", "../outside"); err == nil {
+		t.Error("PutPointer accepted a target outside the store")
+	}
+}
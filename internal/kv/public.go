@@ -0,0 +1,180 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// publicDir holds an empty marker file for each publicly readable key, at the
+// key's own path. It sits outside every user's prefix, so markers never show
+// up in listings and can't be reached through /kv/.
+const publicDir = ".public"
+
+// publicPath returns the marker path recording that key is public
+func (s *Store) publicPath(key string) (string, error) {
+	if _, err := s.keyPath(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dataDir, publicDir, key), nil
+}
+
+// SetPublic marks an existing key as publicly readable, or not. Deleting a
+// key also makes it private again.
+func (s *Store) SetPublic(key string, public bool) error {
+	path, err := s.publicPath(key)
+	if err != nil {
+		return err
+	}
+
+	if !public {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to unshare key: %w", err)
+		}
+		return nil
+	}
+
+	info, err := s.Stat(key)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("key not found: %s is a prefix", key)
+	}
+	if err := s.mkdirAll(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	if err := os.WriteFile(path, nil, s.fileMode); err != nil {
+		return fmt.Errorf("failed to share key: %w", err)
+	}
+	return nil
+}
+
+// IsPublic reports whether key has been marked publicly readable
+func (s *Store) IsPublic(key string) bool {
+	path, err := s.publicPath(key)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// ShareResult is returned by POST /kvshare/{key}
+type ShareResult struct {
+	Key string `json:"key"`
+	URL string `json:"url"` // Path of the public, unauthenticated copy
+}
+
+// HandleShare handles POST /kvshare/{key}, which makes a key publicly readable
+// at the returned URL, and DELETE /kvshare/{key}, which stops sharing it
+func (h *Handlers) HandleShare(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/kvshare/")
+	if err := ValidateKey(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.checkAuth(r, key); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Only per-user keys have a public URL; file/* is already readable by all
+	publicPath, ok := publicURLPath(key)
+	if !ok {
+		http.Error(w, "Only domain/{domain}/user/{user}/... keys can be shared", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if err := h.store.SetPublic(key, true); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				http.Error(w, "Not found", http.StatusNotFound)
+			} else if errors.Is(err, ErrPointerLoop) {
+				http.Error(w, err.Error(), http.StatusLoopDetected)
+			} else {
+				slog.ErrorContext(r.Context(), "Failed to share key", "error", err, "key", key)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+		slog.InfoContext(r.Context(), "Key shared publicly", "key", key, "user", r.Context().Value("user_email"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ShareResult{Key: key, URL: publicPath})
+
+	case http.MethodDelete:
+		if err := h.store.SetPublic(key, false); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to unshare key", "error", err, "key", key)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "Key unshared", "key", key, "user", r.Context().Value("user_email"))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandlePublic handles GET /public/{email}/{key}, serving a shared key without
+// authentication. {key} is relative to the user's own prefix. Anything not
+// shared, including prefixes, is a plain 404 so nothing can be listed or probed.
+func (h *Handlers) HandlePublic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, ok := publicKey(strings.TrimPrefix(r.URL.Path, "/public/"))
+	if !ok || ValidateKey(key) != nil || !h.store.IsPublic(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, info, err := h.store.OpenContext(r.Context(), key)
+	if err != nil {
+		if !strings.Contains(err.Error(), "not found") {
+			slog.ErrorContext(r.Context(), "Failed to get public key", "error", err, "key", key)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	meta, err := h.store.Metadata(key)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to read metadata", "error", err, "key", key)
+	}
+	setValueHeaders(w, info, meta)
+	w.Header().Set("Cache-Control", "no-cache") // Unsharing must take effect immediately
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// publicURLPath returns the public path for a domain/{domain}/user/{user}/{rest} key
+func publicURLPath(key string) (string, bool) {
+	parts := strings.SplitN(key, "/", 5)
+	if len(parts) < 5 || parts[0] != "domain" || parts[2] != "user" || parts[4] == "" {
+		return "", false
+	}
+	return "/public/" + parts[3] + "@" + parts[1] + "/" + parts[4], true
+}
+
+// publicKey is the inverse of publicURLPath, mapping {email}/{rest} to a key
+func publicKey(path string) (string, bool) {
+	email, rest, ok := strings.Cut(path, "/")
+	if !ok || rest == "" || strings.HasSuffix(rest, "/") {
+		return "", false
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", false
+	}
+	email = strings.ToLower(email)
+	return "domain/" + email[at+1:] + "/user/" + email[:at] + "/" + rest, true
+}
@@ -0,0 +1,98 @@
+package kv
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit caps how fast one owner may write, or read, as a token bucket:
+// Rate requests per second on average, with bursts of up to Burst requests
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// enabled reports whether the limit restricts anything
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// tokenBucket tracks one owner's remaining allowance
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter enforces a RateLimit per owner
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   RateLimit
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes one token from owner's bucket. If none is available it returns
+// false and how long until one will be.
+func (l *rateLimiter) allow(owner string) (bool, time.Duration) {
+	if !l.limit.enabled() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, ok := l.buckets[owner]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[owner] = bucket
+	}
+
+	// Refill for the time elapsed since the last request
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(float64(l.limit.Burst), bucket.tokens+elapsed*l.limit.Rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// prune forgets owners whose buckets have refilled, since a fresh bucket
+// would be identical. It returns how many were removed.
+func (l *rateLimiter) prune() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pruned := 0
+	for owner, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, owner)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// writeRateLimited refuses a request with a 429, saying in Retry-After how
+// many whole seconds until the client's next token
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
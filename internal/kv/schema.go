@@ -0,0 +1,485 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxSchemaErrors caps how many validation errors one PUT reports
+const maxSchemaErrors = 20
+
+// Schema is a compiled JSON Schema. Only a subset of the keywords is
+// supported: type, enum, const, properties, required, additionalProperties,
+// items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum,
+// exclusiveMinimum and exclusiveMaximum, along with annotations such as
+// title and description. A schema using any other keyword fails to load,
+// rather than having it silently ignored.
+type Schema struct {
+	types            []string
+	enum             []any
+	constValue       any
+	hasConst         bool
+	properties       map[string]*Schema
+	required         []string
+	additional       *Schema // Schema for properties not in properties, if any
+	noAdditional     bool    // additionalProperties: false
+	items            *Schema
+	minItems         *int
+	maxItems         *int
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+}
+
+// schemaTypes are the values the type keyword may take
+var schemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// compileSchema parses a schema. at is its location, for error messages.
+func compileSchema(raw json.RawMessage, at string) (*Schema, error) {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keywords); err != nil || keywords == nil {
+		return nil, fmt.Errorf("%s: a schema must be a JSON object", at)
+	}
+
+	s := &Schema{}
+	for name, value := range keywords {
+		var err error
+		switch name {
+		case "$schema", "$id", "$comment", "title", "description", "default", "examples":
+			// Annotations don't affect validation
+		case "type":
+			if err = json.Unmarshal(value, &s.types); err != nil {
+				var single string
+				if err = json.Unmarshal(value, &single); err == nil {
+					s.types = []string{single}
+				}
+			}
+			for _, t := range s.types {
+				if !slices.Contains(schemaTypes, t) {
+					err = fmt.Errorf("unknown type %q", t)
+				}
+			}
+		case "enum":
+			err = json.Unmarshal(value, &s.enum)
+		case "const":
+			s.hasConst = true
+			err = json.Unmarshal(value, &s.constValue)
+		case "properties":
+			var properties map[string]json.RawMessage
+			if err = json.Unmarshal(value, &properties); err != nil {
+				break
+			}
+			s.properties = make(map[string]*Schema, len(properties))
+			for property, sub := range properties {
+				compiled, err := compileSchema(sub, at+"/properties/"+property)
+				if err != nil {
+					return nil, err
+				}
+				s.properties[property] = compiled
+			}
+		case "required":
+			err = json.Unmarshal(value, &s.required)
+		case "additionalProperties":
+			var allowed bool
+			if json.Unmarshal(value, &allowed) == nil {
+				s.noAdditional = !allowed
+				break
+			}
+			if s.additional, err = compileSchema(value, at+"/additionalProperties"); err != nil {
+				return nil, err
+			}
+		case "items":
+			if s.items, err = compileSchema(value, at+"/items"); err != nil {
+				return nil, err
+			}
+		case "minItems":
+			err = json.Unmarshal(value, &s.minItems)
+		case "maxItems":
+			err = json.Unmarshal(value, &s.maxItems)
+		case "minLength":
+			err = json.Unmarshal(value, &s.minLength)
+		case "maxLength":
+			err = json.Unmarshal(value, &s.maxLength)
+		case "pattern":
+			var expr string
+			if err = json.Unmarshal(value, &expr); err == nil {
+				s.pattern, err = regexp.Compile(expr)
+			}
+		case "minimum":
+			err = json.Unmarshal(value, &s.minimum)
+		case "maximum":
+			err = json.Unmarshal(value, &s.maximum)
+		case "exclusiveMinimum":
+			err = json.Unmarshal(value, &s.exclusiveMinimum)
+		case "exclusiveMaximum":
+			err = json.Unmarshal(value, &s.exclusiveMaximum)
+		default:
+			return nil, fmt.Errorf("%s: unsupported keyword %q", at, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", at, name, err)
+		}
+	}
+	return s, nil
+}
+
+// Validate checks a decoded JSON value against the schema, returning what's
+// wrong with it, if anything, each error prefixed with the JSON Pointer of
+// the offending part of the value
+func (s *Schema) Validate(value any) []string {
+	errs := s.validate(value, "", nil)
+	if len(errs) > maxSchemaErrors {
+		errs = append(errs[:maxSchemaErrors], fmt.Sprintf("and %d more", len(errs)-maxSchemaErrors))
+	}
+	return errs
+}
+
+func (s *Schema) validate(value any, at string, errs []string) []string {
+	fail := func(format string, args ...any) {
+		where := at
+		if where == "" {
+			where = "/"
+		}
+		errs = append(errs, where+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return hasSchemaType(value, t) }) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), schemaType(value))
+		return errs
+	}
+	if s.hasConst && !reflect.DeepEqual(value, s.constValue) {
+		fail("must be %s", jsonString(s.constValue))
+	}
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(e any) bool { return reflect.DeepEqual(value, e) }) {
+		fail("must be one of %s", jsonString(s.enum))
+	}
+
+	switch value := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.minLength != nil && length < *s.minLength {
+			fail("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			fail("must match %s", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && value < *s.minimum {
+			fail("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && value > *s.maximum {
+			fail("must be at most %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+			fail("must be greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+			fail("must be less than %v", *s.exclusiveMaximum)
+		}
+	case []any:
+		if s.minItems != nil && len(value) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range value {
+				errs = s.items.validate(item, fmt.Sprintf("%s/%d", at, i), errs)
+			}
+		}
+	case map[string]any:
+		for _, property := range s.required {
+			if _, ok := value[property]; !ok {
+				fail("missing required property %q", property)
+			}
+		}
+		// Sorted, so the same value always gets the same errors
+		properties := make([]string, 0, len(value))
+		for property := range value {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		for _, property := range properties {
+			where := at + "/" + pointerEscape(property)
+			switch sub, ok := s.properties[property]; {
+			case ok:
+				errs = sub.validate(value[property], where, errs)
+			case s.noAdditional:
+				errs = append(errs, where+": property not allowed")
+			case s.additional != nil:
+				errs = s.additional.validate(value[property], where, errs)
+			}
+		}
+	}
+	return errs
+}
+
+// hasSchemaType reports whether a decoded JSON value is of a schema type
+func hasSchemaType(value any, t string) bool {
+	if t == "integer" {
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	}
+	return schemaType(value) == t || t == "number" && schemaType(value) == "integer"
+}
+
+// schemaType names the type of a decoded JSON value, as the type keyword would
+func schemaType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// jsonString renders a schema value for an error message
+func jsonString(value any) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// pointerEscape escapes a property name for a JSON Pointer (RFC 6901)
+func pointerEscape(property string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(property, "~", "~0"), "/", "~1")
+}
+
+// Schemas maps key prefixes to the JSON Schemas values stored under them
+// must match. Prefixes are matched against the key after its owner's
+// domain/{domain}/user/{user}/ or user/{email}/ part, so "meta/" covers
+// every user's meta/ keys; keys with no owner, such as file/ ones, are
+// matched whole. The longest matching prefix wins.
+type Schemas struct {
+	path string // The file they were loaded from, for Reload
+
+	mu       sync.RWMutex // Guards schemas, which Reload replaces
+	schemas  map[string]*Schema
+	prefixes []string // Of schemas, longest first
+}
+
+// LoadSchemas reads schemas from a JSON file holding an object that maps
+// each key prefix to its schema, e.g. {"meta/": {"type": "object"}}
+func LoadSchemas(path string) (*Schemas, error) {
+	s := &Schemas{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the schema file and swaps in its contents at once. If
+// the file can't be read, or any schema in it doesn't compile, the current
+// schemas are kept and the error says why.
+func (s *Schemas) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to load schemas: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to load schemas from %s: %w", s.path, err)
+	}
+
+	schemas := make(map[string]*Schema, len(raw))
+	prefixes := make([]string, 0, len(raw))
+	for prefix, source := range raw {
+		if prefix == "" {
+			return fmt.Errorf("failed to load schemas from %s: empty prefix", s.path)
+		}
+		schema, err := compileSchema(source, prefix)
+		if err != nil {
+			return fmt.Errorf("failed to load schemas from %s: %w", s.path, err)
+		}
+		schemas[prefix] = schema
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+
+	s.mu.Lock()
+	s.schemas, s.prefixes = schemas, prefixes
+	s.mu.Unlock()
+	return nil
+}
+
+// Prefixes returns the prefixes that have schemas, in sorted order
+func (s *Schemas) Prefixes() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	prefixes := slices.Clone(s.prefixes)
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// lookup returns the schema covering key and its prefix, or nil if none does
+func (s *Schemas) lookup(key string) (string, *Schema) {
+	if s == nil {
+		return "", nil
+	}
+	relative := ownerRelativeKey(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(relative, prefix) {
+			return prefix, s.schemas[prefix]
+		}
+	}
+	return "", nil
+}
+
+// ownerRelativeKey returns key without its owner's part, as keyOwner finds
+// it: "domain/example.com/user/alice/meta/x" and
+// "user/alice@example.com/meta/x" both become "meta/x"
+func ownerRelativeKey(key string) string {
+	parts := strings.SplitN(key, "/", 5)
+	switch {
+	case parts[0] == "domain" && len(parts) == 5 && parts[2] == "user":
+		return parts[4]
+	case parts[0] == "user" && len(parts) >= 3:
+		return strings.SplitN(key, "/", 3)[2]
+	}
+	return key
+}
+
+// SetSchemas makes writes of keys the schemas cover validate their values
+// first. Without schemas, values are stored as-is.
+func (h *Handlers) SetSchemas(schemas *Schemas) {
+	h.schemas = schemas
+}
+
+// SchemaError is returned for a value that doesn't match the schema
+// covering its key
+type SchemaError struct {
+	Prefix string
+	Errors []string
+}
+
+func (e *SchemaError) Error() string {
+	return "value doesn't match the schema for " + e.Prefix + ": " + strings.Join(e.Errors, "; ")
+}
+
+// schemaErrors is the body of a 422 response to a write whose value doesn't
+// match its schema
+type schemaErrors struct {
+	Error  string   `json:"error"`
+	Prefix string   `json:"prefix"`
+	Errors []string `json:"errors"`
+}
+
+// validateSchema checks value against the schema covering key, if any,
+// returning a *SchemaError if it doesn't match
+func (h *Handlers) validateSchema(key string, value []byte) error {
+	prefix, schema := h.schemas.lookup(key)
+	if schema == nil {
+		return nil
+	}
+
+	var doc any
+	var errs []string
+	if err := json.Unmarshal(value, &doc); err != nil {
+		errs = []string{"/: not valid JSON: " + err.Error()}
+	} else {
+		errs = schema.Validate(doc)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &SchemaError{Prefix: prefix, Errors: errs}
+}
+
+// writeSchemaError refuses a write of key with a 422 listing what's wrong
+// with its value
+func writeSchemaError(w http.ResponseWriter, r *http.Request, key string, err *SchemaError) {
+	slog.InfoContext(r.Context(), "Rejected value not matching its schema", "key", key, "prefix", err.Prefix, "errors", len(err.Errors))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(schemaErrors{
+		Error:  "Value doesn't match the schema for " + err.Prefix,
+		Prefix: err.Prefix,
+		Errors: err.Errors,
+	})
+}
+
+// checkSchema validates the value of a PUT of key against the schema
+// covering it, if any. Such a value is read into memory to be checked, and
+// body is then left reading it from there. If it returns false it has
+// responded, with a 422 for a value that doesn't match.
+func (h *Handlers) checkSchema(w http.ResponseWriter, r *http.Request, key string, body *bodyReader) bool {
+	if _, schema := h.schemas.lookup(key); schema == nil {
+		return true
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		h.writeBodyError(w, err)
+		return false
+	}
+	body.r = bytes.NewReader(data)
+
+	var schemaErr *SchemaError
+	if err := h.validateSchema(key, data); errors.As(err, &schemaErr) {
+		writeSchemaError(w, r, key, schemaErr)
+		return false
+	}
+	return true
+}
+
+// HandleReloadSchemas handles POST /admin/reload-schemas, re-reading the
+// schema file so changes apply without a restart, and returns the prefixes
+// that now have schemas. Admin-only.
+func (h *Handlers) HandleReloadSchemas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.schemas == nil {
+		http.Error(w, "No schemas configured", http.StatusNotFound)
+		return
+	}
+	if err := h.schemas.Reload(); err != nil {
+		slog.ErrorContext(r.Context(), "Schema reload failed", "audit", true, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	prefixes := h.schemas.Prefixes()
+	slog.InfoContext(r.Context(), "Schemas reloaded", "audit", true, "prefixes", prefixes)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"prefixes": prefixes})
+}
@@ -0,0 +1,247 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandleKV_Schemas(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	if err := os.WriteFile(path, []byte(`{
+		"meta/": {
+			"type": "object",
+			"required": ["name"],
+			"properties": {
+				"name": {"type": "string", "minLength": 1},
+				"tags": {"type": "array", "items": {"type": "string"}}
+			}
+		},
+		"profile/": {
+			"type": "object",
+			"required": ["email"],
+			"additionalProperties": false,
+			"properties": {"email": {"type": "string", "pattern": "@"}}
+		}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schemas, err := LoadSchemas(path)
+	if err != nil {
+		t.Fatalf("LoadSchemas failed: %v", err)
+	}
+	handlers := NewHandlers(store)
+	prefix := "domain/example.com/user/alice/"
+
+	put := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/kv/"+key, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+	expectErrors := func(w *httptest.ResponseRecorder, what string, want ...string) {
+		t.Helper()
+		var body schemaErrors
+		if w.Code != http.StatusUnprocessableEntity || json.Unmarshal(w.Body.Bytes(), &body) != nil {
+			t.Errorf("%s = %d %s, want 422 with errors", what, w.Code, w.Body.String())
+			return
+		}
+		if !reflect.DeepEqual(body.Errors, want) {
+			t.Errorf("%s errors = %q, want %q", what, body.Errors, want)
+		}
+	}
+
+	// Without schemas, anything goes, as before
+	if w := put(prefix+"meta/trifle1", "not json"); w.Code != http.StatusOK {
+		t.Errorf("PUT without schemas = %d %s", w.Code, w.Body.String())
+	}
+
+	handlers.SetSchemas(schemas)
+	valid := `{"name": "Spiral", "tags": ["turtle"]}`
+	if w := put(prefix+"meta/trifle1", valid); w.Code != http.StatusOK {
+		t.Errorf("PUT of a matching value = %d %s", w.Code, w.Body.String())
+	}
+	if got, _ := store.Get(prefix + "meta/trifle1"); string(got) != valid {
+		t.Errorf("Stored %q, want %q", got, valid)
+	}
+	// The prefix applies to every user's keys, in either layout
+	expectErrors(put("user/alice@example.com/meta/trifle2", `{"tags": ["turtle", 3]}`), "PUT of a nonconforming value",
+		`/: missing required property "name"`, "/tags/1: expected string, got integer")
+	expectErrors(put(prefix+"meta/trifle3", "{"), "PUT of invalid JSON",
+		"/: not valid JSON: unexpected end of JSON input")
+	if store.Exists(prefix + "meta/trifle3") {
+		t.Error("PUT of a nonconforming value stored it")
+	}
+
+	// A value that suits meta/ is checked against profile/'s schema there
+	expectErrors(put(prefix+"profile/main", valid), "PUT of a meta/ value under profile/",
+		`/: missing required property "email"`, "/name: property not allowed", "/tags: property not allowed")
+
+	// Exclusive creates are checked too
+	req := httptest.NewRequest(http.MethodPut, "/kv/"+prefix+"profile/new", strings.NewReader(`{"email": "nope"}`))
+	req.Header.Set("If-None-Match", "*")
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	w := httptest.NewRecorder()
+	handlers.HandleKV(w, req)
+	expectErrors(w, "Exclusive PUT of a nonconforming value", "/email: must match @")
+
+	// Keys no schema covers are stored as-is
+	if w := put(prefix+"notes/today", "not json"); w.Code != http.StatusOK {
+		t.Errorf("PUT of an uncovered key = %d %s", w.Code, w.Body.String())
+	}
+
+	// Reloading picks up changes; a broken file keeps the last good schemas
+	reload := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handlers.HandleReloadSchemas(w, httptest.NewRequest(http.MethodPost, "/admin/reload-schemas", nil))
+		return w
+	}
+	if err := os.WriteFile(path, []byte(`{"notes/": {"type": "string"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := reload(); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"prefixes":["notes/"]}` {
+		t.Errorf("Reload = %d %s", w.Code, w.Body.String())
+	}
+	expectErrors(put(prefix+"notes/today", "{}"), "PUT after reload", "/: expected string, got object")
+	if w := put(prefix+"meta/trifle3", "{"); w.Code != http.StatusOK {
+		t.Errorf("PUT under a prefix dropped by reload = %d %s", w.Code, w.Body.String())
+	}
+	if err := os.WriteFile(path, []byte(`{"notes/": {"type": "string", "format": "date"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := reload(); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `unsupported keyword "format"`) {
+		t.Errorf("Reload of an unsupported schema = %d %s", w.Code, w.Body.String())
+	}
+	if got := schemas.Prefixes(); !reflect.DeepEqual(got, []string{"notes/"}) {
+		t.Errorf("Prefixes after a failed reload = %q", got)
+	}
+}
+
+func TestSchema_Validate(t *testing.T) {
+	schema, err := compileSchema(json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"count": {"type": "integer", "minimum": 0, "exclusiveMaximum": 10},
+			"ratio": {"type": ["number", "null"]},
+			"kind": {"enum": ["a", "b"]},
+			"version": {"const": 2},
+			"a/b": {"type": "boolean"}
+		},
+		"additionalProperties": {"type": "string", "maxLength": 3}
+	}`), "test")
+	if err != nil {
+		t.Fatalf("compileSchema failed: %v", err)
+	}
+
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{`{"count": 3, "ratio": 0.5, "kind": "a", "version": 2, "a/b": true, "x": "abc"}`, nil},
+		{`{"ratio": null, "version": 2.0}`, nil},
+		{`{"count": 1.5}`, []string{"/count: expected integer, got number"}},
+		{`{"count": -1}`, []string{"/count: must be at least 0"}},
+		{`{"count": 10}`, []string{"/count: must be less than 10"}},
+		{`{"kind": "c", "version": 3}`, []string{`/kind: must be one of ["a","b"]`, "/version: must be 2"}},
+		{`{"a/b": 1, "x": "abcd"}`, []string{"/a~1b: expected boolean, got integer", "/x: must be at most 3 characters long"}},
+		{`[]`, []string{"/: expected object, got array"}},
+	}
+	for _, tt := range tests {
+		var value any
+		if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+			t.Fatal(err)
+		}
+		if got := schema.Validate(value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Validate(%s) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	if _, err := compileSchema(json.RawMessage(`{"type": "strnig"}`), "test"); err == nil {
+		t.Error("compileSchema accepted an unknown type")
+	}
+	if _, err := compileSchema(json.RawMessage(`{"items": {"pattern": "("}}`), "test"); err == nil || !strings.Contains(err.Error(), "test/items/pattern") {
+		t.Errorf("compileSchema of a bad nested pattern = %v", err)
+	}
+}
+
+func TestHandleKV_SchemasOnEveryWrite(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	if err := os.WriteFile(path, []byte(`{
+		"meta/": {
+			"type": "object",
+			"required": ["name"],
+			"properties": {"name": {"type": "string", "minLength": 1}}
+		}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schemas, err := LoadSchemas(path)
+	if err != nil {
+		t.Fatalf("LoadSchemas failed: %v", err)
+	}
+	handlers := NewHandlers(store)
+	handlers.SetSchemas(schemas)
+	prefix := "domain/example.com/user/alice/"
+	store.Put(prefix+"meta/trifle1", []byte(`{"name":"Spiral"}`))
+
+	serve := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		if path == "/kvexport" {
+			handlers.HandleExport(w, req)
+		} else {
+			handlers.HandleKV(w, req)
+		}
+		return w
+	}
+
+	// A patch is checked by the document it produces, not on its own
+	patch := "application/merge-patch+json"
+	if w := serve(http.MethodPatch, "/kv/"+prefix+"meta/trifle1", patch, `{"tags":["turtle"]}`); w.Code != http.StatusOK {
+		t.Errorf("PATCH producing a matching value = %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPatch, "/kv/"+prefix+"meta/trifle1", patch, `{"name":null}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("PATCH removing a required property = %d %s, want 422", w.Code, w.Body.String())
+	}
+	if got, _ := store.Get(prefix + "meta/trifle1"); string(got) != `{"name":"Spiral","tags":["turtle"]}` {
+		t.Errorf("Stored %s after a refused PATCH", got)
+	}
+
+	// Generated keys are checked by the prefix they go under
+	if w := serve(http.MethodPost, "/kv/"+prefix+"meta", "", `{"name":""}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("POST of a nonconforming value = %d %s, want 422", w.Code, w.Body.String())
+	}
+	if keys, _ := store.List(prefix+"meta/", 0, true); len(keys) != 1 {
+		t.Errorf("POST of a nonconforming value stored it: %v", keys)
+	}
+
+	// Imported records are checked one by one
+	record := `{"key":"` + prefix + `meta/imported","value":"{}"}` + "\n"
+	w := serve(http.MethodPost, "/kvexport", "", record)
+	var result ImportResult
+	if w.Code != http.StatusUnprocessableEntity || json.Unmarshal(w.Body.Bytes(), &result) != nil || len(result.Errors) != 1 {
+		t.Errorf("Import of a nonconforming value = %d %s, want 422 with its error", w.Code, w.Body.String())
+	}
+	if store.Exists(prefix + "meta/imported") {
+		t.Error("Import of a nonconforming value stored it")
+	}
+}
@@ -3,90 +3,550 @@
 package kv
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Default permissions for created files and directories
+const (
+	DefaultFileMode os.FileMode = 0644
+	DefaultDirMode  os.FileMode = 0755
+)
+
+// tmpDir holds values while they are being written, under the data directory
+// so they can be renamed into place
+const tmpDir = ".tmp"
+
+// ErrKeyExists is returned by CreateExclusive when the key already exists
+var ErrKeyExists = errors.New("key already exists")
+
+// ErrCorrupt is returned when reading a stored value that no longer matches
+// its recorded checksum, e.g. after being truncated on disk
+var ErrCorrupt = errors.New("stored value is corrupt")
+
 // Store manages key-value storage operations
 type Store struct {
-	dataDir string
+	dataDir         string
+	verifyChecksums bool
+	fileMode        os.FileMode // Permissions for created files
+	dirMode         os.FileMode // Permissions for created directories
+
+	createMu  sync.Mutex                 // Serializes Create so collision checks are race-free
+	keyLocks  keyLocks                   // Serializes writers to the same key, e.g. Patch's read-modify-write
+	audit     *AuditLog                  // Nil unless auditing is enabled
+	webhooks  *Webhooks                  // Nil unless webhooks are configured
+	graphs    graphCache                 // Each user's TrifleGraph
+	usage     atomic.Pointer[UsageStats] // Last computed, nil until the first scan
+	keyOpts   KeyOptions
+	randomKey func(KeyOptions) (string, error) // Overridable for tests
 }
 
 // NewStore creates a new KV store instance
 func NewStore(dataDir string) (*Store, error) {
 	// Ensure data directory exists
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(dataDir, DefaultDirMode); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
 	return &Store{
-		dataDir: dataDir,
+		dataDir:   dataDir,
+		fileMode:  DefaultFileMode,
+		dirMode:   DefaultDirMode,
+		keyOpts:   DefaultKeyOptions,
+		randomKey: randomKey,
 	}, nil
 }
 
+// Bounds on key names, so a key always maps to a sane filesystem path
+const (
+	MaxKeyLength = 1024 // Bytes
+	MaxKeyDepth  = 32   // Slash-separated segments
+)
+
+// ErrInvalidKey is returned for keys that could not safely name a file
+var ErrInvalidKey = errors.New("invalid key")
+
+// ValidateKey checks that a key (or a prefix, which may end in "/") is safe to
+// map onto the filesystem: no traversal, no null bytes, no empty or "."
+// segments, and within MaxKeyLength and MaxKeyDepth
+func ValidateKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("%w: empty", ErrInvalidKey)
+	case len(key) > MaxKeyLength:
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidKey, MaxKeyLength)
+	case strings.Contains(key, ".."):
+		return fmt.Errorf("%w: contains '..'", ErrInvalidKey)
+	case strings.HasPrefix(key, "/"):
+		return fmt.Errorf("%w: starts with '/'", ErrInvalidKey)
+	case strings.ContainsAny(key, "\x00\\"):
+		return fmt.Errorf("%w: contains a null byte or backslash", ErrInvalidKey)
+	}
+
+	segments := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	if len(segments) > MaxKeyDepth {
+		return fmt.Errorf("%w: more than %d segments", ErrInvalidKey, MaxKeyDepth)
+	}
+	for _, segment := range segments {
+		if segment == "" || segment == "." {
+			return fmt.Errorf("%w: empty or '.' segment", ErrInvalidKey)
+		}
+	}
+	return nil
+}
+
 // keyPath converts a key to a filesystem path
 // key "user/alice@example.com/profile" -> "data/user/alice@example.com/profile"
 func (s *Store) keyPath(key string) (string, error) {
-	// Validate key doesn't escape data directory
-	if strings.Contains(key, "..") {
-		return "", fmt.Errorf("invalid key: contains '..'")
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+
+	// Belt and braces: whatever the key, the path must stay in the data directory
+	path := filepath.Join(s.dataDir, key)
+	rel, err := filepath.Rel(s.dataDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: escapes the data directory", ErrInvalidKey)
 	}
-	if strings.HasPrefix(key, "/") {
-		return "", fmt.Errorf("invalid key: starts with '/'")
+	if err := s.checkNotUnderPointer(key); err != nil {
+		return "", err
 	}
+	return path, nil
+}
 
-	return filepath.Join(s.dataDir, key), nil
+// checkNotUnderPointer refuses a key inside a pointer. Pointers are symlinks,
+// which the filesystem would follow, so reads and writes of "p/x" would reach
+// "x" under wherever p points rather than failing.
+func (s *Store) checkNotUnderPointer(key string) error {
+	segments := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	dir := s.dataDir
+	for i, segment := range segments[:len(segments)-1] {
+		dir = filepath.Join(dir, segment)
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return nil // Nothing exists below a missing directory
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a pointer", ErrInvalidKey, strings.Join(segments[:i+1], "/"))
+		}
+	}
+	return nil
 }
 
-// Get retrieves a value by key
+// Get retrieves a value by key, following pointer keys
 func (s *Store) Get(key string) ([]byte, error) {
-	path, err := s.keyPath(key)
+	file, _, err := s.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readValue(key, file)
+}
+
+// get is Get for callers already holding the lock of key, which must not be
+// a pointer
+func (s *Store) get(key string) ([]byte, error) {
+	file, _, err := s.openFile(key, s.verifyChecksums)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+	return readValue(key, file)
+}
+
+// readValue reads an opened value in full
+func readValue(key string, file *os.File) ([]byte, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// Open opens a key's value for streaming, following pointer keys. The caller
+// must close the returned file. With SetVerifyChecksums, a value that doesn't
+// match its checksum is ErrCorrupt.
+func (s *Store) Open(key string) (*os.File, os.FileInfo, error) {
+	key, err := s.resolve(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.openChecked(key, s.verifyChecksums)
+}
+
+// openChecked is openFile, confirming any mismatch before reporting it.
+// Readers don't take the key's lock, so a write may have landed between
+// reading the value and its checksum; holding the lock, the two are
+// consistent, and a mismatch is real.
+func (s *Store) openChecked(key string, verify bool) (*os.File, os.FileInfo, error) {
+	file, info, err := s.openFile(key, verify)
+	if errors.Is(err, ErrCorrupt) {
+		unlock := s.keyLocks.lock(key)
+		file, info, err = s.openFile(key, verify)
+		unlock()
+	}
+	return file, info, err
+}
 
-	data, err := os.ReadFile(path)
+// openFile opens the value at key, which must not be a pointer, verifying it
+// if verify is set
+func (s *Store) openFile(key string, verify bool) (*os.File, os.FileInfo, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("key not found: %s", key)
+			return nil, nil, fmt.Errorf("key not found: %s", key)
 		}
-		return nil, fmt.Errorf("failed to read key: %w", err)
+		return nil, nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to stat key: %w", err)
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, nil, fmt.Errorf("key not found: %s is a prefix", key)
 	}
 
-	return data, nil
+	if !verify {
+		return file, info, nil
+	}
+	if err := s.verifyFile(key, file); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// verifyFile checks an open value against the checksum recorded when it was
+// written and a file/* value against the hash it is named for. Other values
+// written before checksums were recorded have nothing to check. The file is
+// left rewound for the caller.
+func (s *Store) verifyFile(key string, file *os.File) error {
+	meta, err := s.readMetadata(key)
+	if err != nil {
+		return err
+	}
+	checkName := strings.HasPrefix(key, "file/")
+	if meta.SHA256 == "" && !checkName {
+		return nil
+	}
+
+	// Hash the file in a first pass, then rewind
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+
+	if meta.SHA256 != "" && actual != meta.SHA256 {
+		return fmt.Errorf("%w: %s (recorded sha256 %s, got %s)", ErrCorrupt, key, meta.SHA256, actual)
+	}
+	if checkName {
+		return checkHash(key, actual)
+	}
+	return nil
 }
 
-// Put stores a value by key (upsert)
+// SetVerifyChecksums enables or disables verifying every value on every read,
+// against its recorded checksum and, for content-addressed file/* values, the
+// hash they are named for. That reads each value twice, so it's off by
+// default; Verify scans the whole store on demand.
+func (s *Store) SetVerifyChecksums(enabled bool) {
+	s.verifyChecksums = enabled
+}
+
+// checkHash compares a value's hex SHA-256 with the one a file/* key is named for
+func checkHash(key, actual string) error {
+	expected := key[strings.LastIndex(key, "/")+1:]
+	if actual != strings.ToLower(expected) {
+		return fmt.Errorf("%w: %s (expected sha256 %s, got %s)", ErrCorrupt, key, expected, actual)
+	}
+
+	return nil
+}
+
+// Put stores a value by key (upsert). A pointer at key is replaced, not written through.
 func (s *Store) Put(key string, value []byte) error {
-	path, err := s.keyPath(key)
+	unlock := s.keyLocks.lock(key)
+	err := s.put(key, value)
+	unlock()
 	if err != nil {
 		return err
 	}
+	s.record("put", key)
+	return nil
+}
+
+// put is Put for callers already holding key's lock
+func (s *Store) put(key string, value []byte) error {
+	_, err := s.putReader(key, bytes.NewReader(value), Metadata{})
+	return err
+}
+
+// PutReader stores the contents of r by key, along with its metadata,
+// streaming them to disk rather than holding the value in memory. It returns
+// the number of bytes stored. If reading r fails, the key keeps its previous
+// value.
+func (s *Store) PutReader(key string, r io.Reader, meta Metadata) (int64, error) {
+	unlock := s.keyLocks.lock(key)
+	n, err := s.putReader(key, r, meta)
+	unlock()
+	if err != nil {
+		return n, err
+	}
+	s.record("put", key)
+	return n, nil
+}
+
+// CreateExclusive stores a value only if key doesn't exist yet, as a value or
+// a pointer, and returns ErrKeyExists otherwise. Of several concurrent
+// creates of one key, exactly one succeeds.
+func (s *Store) CreateExclusive(key string, value []byte) error {
+	_, err := s.CreateExclusiveReader(key, bytes.NewReader(value), Metadata{})
+	return err
+}
+
+// CreateExclusiveReader is CreateExclusive, streaming the value from r like
+// PutReader
+func (s *Store) CreateExclusiveReader(key string, r io.Reader, meta Metadata) (int64, error) {
+	unlock := s.keyLocks.lock(key)
+	n, err := s.writeValue(key, r, meta, true)
+	unlock()
+	if err != nil {
+		return n, err
+	}
+	s.record("create", key)
+	return n, nil
+}
+
+// putReader writes the value, replacing any metadata the key had and
+// recording the value's checksum. Renaming into place replaces a pointer at
+// key rather than writing through it.
+func (s *Store) putReader(key string, r io.Reader, meta Metadata) (int64, error) {
+	return s.writeValue(key, r, meta, false)
+}
+
+// writeValue is putReader, or with exclusive set, fails with ErrKeyExists
+// rather than replace an existing key
+func (s *Store) writeValue(key string, r io.Reader, meta Metadata, exclusive bool) (int64, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return 0, err
+	}
+	hash := sha256.New()
+	var n int64
+	if exclusive {
+		n, err = s.createFile(path, io.TeeReader(r, hash))
+	} else {
+		n, err = s.writeFile(path, io.TeeReader(r, hash))
+	}
+	if err != nil {
+		return n, err
+	}
+	meta.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if meta.Version, err = s.nextVersion(key); err != nil {
+		return n, err
+	}
+	if err := s.writeMetadata(key, meta); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// writeFile writes r to a temporary file and renames it over path, so readers
+// see either the old contents or the whole new ones
+func (s *Store) writeFile(path string, r io.Reader) (int64, error) {
+	tmp, n, err := s.stageFile(path, r)
+	if err != nil {
+		return n, err
+	}
+	defer os.Remove(tmp) // No-op once renamed
 
+	if err := os.Rename(tmp, path); err != nil {
+		return n, fmt.Errorf("failed to write key: %w", err)
+	}
+	return n, nil
+}
+
+// createFile is writeFile for a path that must not exist yet, returning
+// ErrKeyExists if it does. Linking, unlike renaming, fails rather than
+// replace an existing file, and does so atomically.
+func (s *Store) createFile(path string, r io.Reader) (int64, error) {
+	tmp, n, err := s.stageFile(path, r)
+	if err != nil {
+		return n, err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, path); err != nil {
+		if os.IsExist(err) {
+			return n, ErrKeyExists
+		}
+		return n, fmt.Errorf("failed to write key: %w", err)
+	}
+	return n, nil
+}
+
+// stageFile writes r to a temporary file ready to be moved to path, creating
+// path's parent directories, and returns its name. The caller must remove it
+// if it isn't moved.
+func (s *Store) stageFile(path string, r io.Reader) (string, int64, error) {
 	// Create parent directories
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return fmt.Errorf("failed to create directories: %w", err)
+	if err := s.mkdirAll(filepath.Dir(path)); err != nil {
+		return "", 0, fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	tmp, err := s.createTemp("put-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write key: %w", err)
 	}
 
-	// Write value
-	if err := os.WriteFile(path, value, 0644); err != nil {
-		return fmt.Errorf("failed to write key: %w", err)
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", n, fmt.Errorf("failed to write key: %w", err)
+	}
+	// The chmod makes the mode exact despite the process umask
+	if err := tmp.Chmod(s.fileMode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", n, fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", n, fmt.Errorf("failed to write key: %w", err)
+	}
+	return tmp.Name(), n, nil
+}
+
+// createTemp creates a file in tmpDir. Partial writes live outside every
+// prefix so they never show up in listings.
+func (s *Store) createTemp(pattern string) (*os.File, error) {
+	tmpPath := filepath.Join(s.dataDir, tmpDir)
+	if err := s.mkdirAll(tmpPath); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	return os.CreateTemp(tmpPath, pattern)
+}
+
+// tempName reserves an unused name in tmpDir, for temporary files that
+// aren't regular files, such as symlinks
+func (s *Store) tempName(prefix string) (string, error) {
+	tmp, err := s.createTemp(prefix + "*")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	if err := os.Remove(tmp.Name()); err != nil {
+		return "", err
 	}
+	return tmp.Name(), nil
+}
 
+// CheckWritable verifies that the data directory accepts writes by creating
+// and removing a probe file. It backs the server's readiness check.
+func (s *Store) CheckWritable() error {
+	probe, err := os.CreateTemp(s.dataDir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("data directory not writable: %w", err)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("failed to remove probe file: %w", err)
+	}
 	return nil
 }
 
+// SetPermissions sets the permission bits for files and directories the store
+// creates from now on. The data directory itself is left as it is.
+func (s *Store) SetPermissions(fileMode, dirMode os.FileMode) {
+	s.fileMode = fileMode.Perm()
+	s.dirMode = dirMode.Perm()
+}
+
+// mkdirAll is like os.MkdirAll, but gives each directory it creates exactly
+// the store's directory mode regardless of the process umask
+func (s *Store) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s exists and is not a directory", dir)
+		}
+		return nil
+	}
+
+	if err := s.mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, s.dirMode); err != nil {
+		// Lost a race with another writer creating the same directory
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(dir, s.dirMode)
+}
+
 // Delete removes a key and all its descendants (if it's a prefix)
 func (s *Store) Delete(key string) error {
+	if err := s.delete(key); err != nil {
+		return err
+	}
+	s.record("delete", key)
+	return nil
+}
+
+// ConditionalDelete deletes key only while its ETag (that of a GET, following
+// pointers) matches ifMatch, as Patch checks, and reports whether it did.
+// Writers to key wait until it's done, so a concurrent Put either lands first
+// and makes the ETag mismatch, or lands after the delete and recreates the key.
+func (s *Store) ConditionalDelete(key, ifMatch string) (bool, error) {
+	defer s.keyLocks.lock(key)()
+	info, err := s.Stat(key)
+	if err != nil {
+		return false, err
+	}
+	if !etagMatches(ifMatch, etag(info)) {
+		return false, nil
+	}
+	if err := s.delete(key); err != nil {
+		return false, err
+	}
+	s.record("delete", key)
+	return true, nil
+}
+
+// delete is Delete without recording the operation
+func (s *Store) delete(key string) error {
 	path, err := s.keyPath(key)
 	if err != nil {
 		return err
 	}
 
-	// Check if path exists
-	info, err := os.Stat(path)
+	// Check if path exists. Lstat, so deleting a pointer removes only the pointer.
+	info, err := os.Lstat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return fmt.Errorf("key not found: %s", key)
@@ -106,9 +566,38 @@ func (s *Store) Delete(key string) error {
 		}
 	}
 
+	// A key recreated later shouldn't inherit its predecessor's sharing or metadata
+	if publicPath, err := s.publicPath(key); err == nil {
+		os.RemoveAll(publicPath)
+	}
+	if metaPath, err := s.metaPath(key); err == nil {
+		os.RemoveAll(metaPath)
+	}
 	return nil
 }
 
+// Stat returns file info for a key (size and modification time), following pointer keys
+func (s *Store) Stat(key string) (os.FileInfo, error) {
+	key, err := s.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	path, err := s.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("key not found: %s", key)
+		}
+		return nil, fmt.Errorf("failed to stat key: %w", err)
+	}
+
+	return info, nil
+}
+
 // Exists checks if a key exists
 func (s *Store) Exists(key string) bool {
 	path, err := s.keyPath(key)
@@ -182,6 +671,80 @@ func (s *Store) List(prefix string, depth int, recursive bool) ([]string, error)
 	return keys, nil
 }
 
+// KeyRange bounds a listing to keys in [Start, End) in lexicographic order,
+// returning at most Limit of them. Empty bounds and a zero limit are unbounded.
+type KeyRange struct {
+	Start string
+	End   string
+	Limit int
+}
+
+// contains reports whether key falls within the range's bounds
+func (r KeyRange) contains(key string) bool {
+	if r.Start != "" && key < r.Start {
+		return false
+	}
+	if r.End != "" && key >= r.End {
+		return false
+	}
+	return true
+}
+
+// ListRange is List restricted to a key range. Keys are returned sorted.
+// The file store has no index, so this lists the prefix and filters it.
+func (s *Store) ListRange(prefix string, depth int, recursive bool, r KeyRange) ([]string, error) {
+	keys, err := s.List(prefix, depth, recursive)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	inRange := []string{}
+	for _, key := range keys {
+		if !r.contains(key) {
+			continue
+		}
+		if r.Limit > 0 && len(inRange) == r.Limit {
+			break
+		}
+		inRange = append(inRange, key)
+	}
+	return inRange, nil
+}
+
+// KeyInfo is a listed key with its size and modification time
+type KeyInfo struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Pointer  string    `json:"pointer,omitempty"` // Target key, if this key is a pointer and the caller asked
+}
+
+// ListMeta is ListRange with each key's size and modification time. A pointer
+// reports its target's, or its own if the target is gone.
+func (s *Store) ListMeta(prefix string, depth int, recursive bool, r KeyRange) ([]KeyInfo, error) {
+	keys, err := s.ListRange(prefix, depth, recursive, r)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]KeyInfo, 0, len(keys))
+	for _, key := range keys {
+		info, err := s.Stat(key)
+		if err != nil {
+			path, _ := s.keyPath(key)
+			if info, err = os.Lstat(path); err != nil {
+				if os.IsNotExist(err) {
+					continue // Deleted since it was listed
+				}
+				return nil, fmt.Errorf("failed to stat key: %w", err)
+			}
+		}
+		infos = append(infos, KeyInfo{Key: key, Size: info.Size(), Modified: info.ModTime().UTC()})
+	}
+	return infos, nil
+}
+
 // walkWithDepth walks a directory tree up to a specified depth
 func (s *Store) walkWithDepth(root string, currentDepth, maxDepth int, fn func(string, os.FileInfo) error) error {
 	entries, err := os.ReadDir(root)
@@ -0,0 +1,549 @@
+package kv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGet_VerifyChecksums(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.SetVerifyChecksums(true)
+
+	value := []byte("print('hello')\n")
+	sum := sha256.Sum256(value)
+	hash := hex.EncodeToString(sum[:])
+	key := "file/" + hash[0:2] + "/" + hash[2:4] + "/" + hash
+
+	if err := store.Put(key, value); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	got, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get of intact value failed: %v", err)
+	}
+	if string(got) != string(value) {
+		t.Errorf("Expected %q, got %q", value, got)
+	}
+	file, _, err := store.Open(key)
+	if err != nil {
+		t.Fatalf("Open of intact value failed: %v", err)
+	}
+	streamed, _ := io.ReadAll(file)
+	file.Close()
+	if string(streamed) != string(value) {
+		t.Errorf("Expected Open to stream %q from the start, got %q", value, streamed)
+	}
+
+	// Tamper with the file on disk behind the store's back
+	if err := os.WriteFile(filepath.Join(dataDir, key), []byte("print('evil')\n"), 0644); err != nil {
+		t.Fatalf("Failed to tamper with file: %v", err)
+	}
+
+	if _, err := store.Get(key); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for tampered value, got %v", err)
+	}
+	if _, _, err := store.Open(key); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt opening tampered value, got %v", err)
+	}
+
+	// With verification disabled reads don't hash the value, but a scan
+	// still finds it
+	store.SetVerifyChecksums(false)
+	if got, err := store.Get(key); err != nil || string(got) != "print('evil')\n" {
+		t.Errorf("Get with verification disabled = %q, %v", got, err)
+	}
+	if corrupt, err := store.Verify(); err != nil || len(corrupt) != 1 || corrupt[0].Key != key {
+		t.Errorf("Verify = %+v, %v, want just %s", corrupt, err, key)
+	}
+
+	// A value stored before checksums were recorded is only checked against its name
+	if err := os.Remove(filepath.Join(dataDir, metaDir, key)); err != nil {
+		t.Fatalf("Failed to remove metadata: %v", err)
+	}
+	if _, err := store.Get(key); err != nil {
+		t.Errorf("Expected no error with verification disabled, got %v", err)
+	}
+	store.SetVerifyChecksums(true)
+	if _, err := store.Get(key); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for tampered legacy value, got %v", err)
+	}
+}
+
+func TestGet_VerifyChecksumsIgnoresUserKeys(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.SetVerifyChecksums(true)
+
+	key := "domain/example.com/user/alice/profile"
+	if err := store.Put(key, []byte(`{"display_name":"Alice"}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if _, err := store.Get(key); err != nil {
+		t.Errorf("Expected non-file key to skip verification, got %v", err)
+	}
+}
+
+func TestCreate_RetriesOnCollision(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	prefix := "domain/example.com/user/alice/notes"
+	if err := store.Put(prefix+"/taken", []byte("existing")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// First generated key collides with the existing one, second is fresh
+	names := []string{"taken", "fresh"}
+	store.randomKey = func(KeyOptions) (string, error) {
+		name := names[0]
+		names = names[1:]
+		return name, nil
+	}
+
+	key, err := store.Create(prefix, []byte("new"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if key != prefix+"/fresh" {
+		t.Errorf("Expected retry to produce %s/fresh, got %s", prefix, key)
+	}
+
+	existing, err := store.Get(prefix + "/taken")
+	if err != nil || string(existing) != "existing" {
+		t.Errorf("Existing value was overwritten: %q, %v", existing, err)
+	}
+}
+
+func TestCreate_GivesUpAfterMaxAttempts(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.SetKeyOptions(KeyOptions{Length: 4, Charset: "ab", MaxAttempts: 3})
+
+	prefix := "domain/example.com/user/alice/notes"
+	if err := store.Put(prefix+"/taken", []byte("existing")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	calls := 0
+	store.randomKey = func(KeyOptions) (string, error) {
+		calls++
+		return "taken", nil
+	}
+
+	if _, err := store.Create(prefix, []byte("new")); err == nil {
+		t.Error("Expected error when every attempt collides")
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestRandomKey_UsesCharsetAndLength(t *testing.T) {
+	key, err := randomKey(KeyOptions{Length: 32, Charset: "xyz", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("randomKey failed: %v", err)
+	}
+	if len(key) != 32 {
+		t.Errorf("Expected length 32, got %d", len(key))
+	}
+	for _, c := range key {
+		if c != 'x' && c != 'y' && c != 'z' {
+			t.Errorf("Unexpected character %q in %s", c, key)
+		}
+	}
+}
+
+func TestList_StrongConsistency(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	if store.ListingConsistency() != ConsistencyStrong {
+		t.Fatalf("Expected file store to report strong listing, got %s", store.ListingConsistency())
+	}
+
+	// Every write must be visible to the very next list, and every delete gone from it
+	prefix := "domain/example.com/user/alice/trifle/latest"
+	for i := 0; i < 20; i++ {
+		key := prefix + "/trifle_" + strconv.Itoa(i) + "/version_a"
+		if err := store.Put(key, nil); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		keys, err := store.List(prefix, 2, false)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(keys) != i+1 {
+			t.Fatalf("Expected %d keys immediately after write, got %d", i+1, len(keys))
+		}
+	}
+
+	if err := store.Delete(prefix + "/trifle_0"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	keys, err := store.List(prefix, 2, false)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(keys) != 19 {
+		t.Errorf("Expected deleted key to vanish from listing, got %d keys", len(keys))
+	}
+}
+
+func TestPut_HonorsConfiguredPermissions(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.SetPermissions(0640, 0750)
+
+	key := "domain/example.com/user/alice/trifle/latest/t1/v1"
+	if err := store.Put(key, []byte("x")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dataDir, key))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected file mode 0640, got %o", info.Mode().Perm())
+	}
+
+	for dir := filepath.Dir(key); dir != "."; dir = filepath.Dir(dir) {
+		info, err := os.Stat(filepath.Join(dataDir, dir))
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Mode().Perm() != 0750 {
+			t.Errorf("Expected directory %s mode 0750, got %o", dir, info.Mode().Perm())
+		}
+	}
+
+	// Generated keys go through the same path
+	created, err := store.Create("domain/example.com/user/alice/notes", []byte("y"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	info, err = os.Stat(filepath.Join(dataDir, created))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected created file mode 0640, got %o", info.Mode().Perm())
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	if err := store.CheckWritable(); err != nil {
+		t.Fatalf("Expected writable store, got %v", err)
+	}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected probe file to be cleaned up, found %d entries", len(entries))
+	}
+
+	if err := os.RemoveAll(dataDir); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if err := store.CheckWritable(); err == nil {
+		t.Error("Expected error for missing data directory")
+	}
+}
+
+func TestListRange_Bounds(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	prefix := "domain/example.com/user/alice/notes"
+	for _, name := range []string{"a", "b", "b-1", "c", "d"} {
+		if err := store.Put(prefix+"/"+name, nil); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		r     KeyRange
+		wants []string
+	}{
+		{name: "unbounded", r: KeyRange{}, wants: []string{"a", "b", "b-1", "c", "d"}},
+		{name: "start is inclusive", r: KeyRange{Start: prefix + "/b"}, wants: []string{"b", "b-1", "c", "d"}},
+		{name: "end is exclusive", r: KeyRange{End: prefix + "/c"}, wants: []string{"a", "b", "b-1"}},
+		{name: "both bounds", r: KeyRange{Start: prefix + "/b", End: prefix + "/d"}, wants: []string{"b", "b-1", "c"}},
+		{name: "bounds between keys", r: KeyRange{Start: prefix + "/aa", End: prefix + "/bz"}, wants: []string{"b", "b-1"}},
+		{name: "empty range", r: KeyRange{Start: prefix + "/c", End: prefix + "/c"}, wants: []string{}},
+		{name: "limit", r: KeyRange{Start: prefix + "/b", Limit: 2}, wants: []string{"b", "b-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := store.ListRange(prefix, 1, false, tt.r)
+			if err != nil {
+				t.Fatalf("ListRange failed: %v", err)
+			}
+			wants := make([]string, len(tt.wants))
+			for i, name := range tt.wants {
+				wants[i] = prefix + "/" + name
+			}
+			if !reflect.DeepEqual(keys, wants) {
+				t.Errorf("Expected %v, got %v", wants, keys)
+			}
+		})
+	}
+}
+
+// failingReader returns some data and then an error, like a dropped upload
+type failingReader struct{ sent bool }
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.sent {
+		return 0, errors.New("connection reset")
+	}
+	f.sent = true
+	return copy(p, "partial"), nil
+}
+
+func TestPutReader_FailureKeepsOldValue(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Put("k", []byte("original"))
+
+	if _, err := store.PutReader("k", &failingReader{}, Metadata{}); err == nil {
+		t.Fatal("PutReader succeeded despite a read error")
+	}
+	if got, _ := store.Get("k"); string(got) != "original" {
+		t.Errorf("Value after failed PutReader = %q, want original", got)
+	}
+
+	n, err := store.PutReader("k", strings.NewReader("replacement"), Metadata{})
+	if err != nil || n != int64(len("replacement")) {
+		t.Fatalf("PutReader = %d, %v", n, err)
+	}
+	if got, _ := store.Get("k"); string(got) != "replacement" {
+		t.Errorf("Value = %q, want replacement", got)
+	}
+}
+
+func TestCreateExclusive_ConcurrentCreatesOneWins(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	key := "domain/example.com/user/alice/names/claimed"
+
+	const clients = 20
+	var wg sync.WaitGroup
+	errs := make([]error, clients)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = store.CreateExclusive(key, []byte("client "+strconv.Itoa(i)))
+		}()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			if winner != -1 {
+				t.Fatalf("Clients %d and %d both created the key", winner, i)
+			}
+			winner = i
+		case !errors.Is(err, ErrKeyExists):
+			t.Errorf("Client %d: unexpected error %v", i, err)
+		}
+	}
+	if winner == -1 {
+		t.Fatal("No client created the key")
+	}
+	if got, _ := store.Get(key); string(got) != "client "+strconv.Itoa(winner) {
+		t.Errorf("Value = %q, want the winner's (client %d)", got, winner)
+	}
+
+	// Pointers count as existing too
+	store.PutPointer(key+"-pointer", key)
+	if err := store.CreateExclusive(key+"-pointer", nil); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("CreateExclusive over a pointer = %v, want ErrKeyExists", err)
+	}
+}
+
+func TestHandleKV_IfNoneMatch(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	key := "domain/example.com/user/alice/names/claimed"
+
+	const clients = 10
+	codes := make([]int, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPut, "/kv/"+key, strings.NewReader("client "+strconv.Itoa(i)))
+			req.Header.Set("If-None-Match", "*")
+			req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+			w := httptest.NewRecorder()
+			handlers.HandleKV(w, req)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+
+	won := 0
+	for i, code := range codes {
+		switch code {
+		case http.StatusOK:
+			won++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Errorf("Client %d: status %d", i, code)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d clients won, want exactly 1", won)
+	}
+}
+
+func TestConditionalDelete_RacesPut(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	key := "domain/example.com/user/alice/trifle"
+
+	for i := range 50 {
+		if err := store.Put(key, []byte("read")); err != nil {
+			t.Fatal(err)
+		}
+		info, err := store.Stat(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var deleted bool
+		var deleteErr, putErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			deleted, deleteErr = store.ConditionalDelete(key, etag(info))
+		}()
+		go func() {
+			defer wg.Done()
+			putErr = store.Put(key, []byte("overwritten"))
+		}()
+		wg.Wait()
+
+		if deleteErr != nil || putErr != nil {
+			t.Fatalf("Round %d: delete err %v, put err %v", i, deleteErr, putErr)
+		}
+		// Whichever went first, the overwrite must survive: either the
+		// delete saw it and declined, or it recreated the deleted key
+		if got, err := store.Get(key); err != nil || string(got) != "overwritten" {
+			t.Fatalf("Round %d (deleted %v): value = %q, %v; want the overwrite", i, deleted, got, err)
+		}
+	}
+}
+
+func TestHandleKV_IfMatchDelete(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	key := "domain/example.com/user/alice/trifle"
+
+	request := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/kv/"+key, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleKV(w, req)
+		return w
+	}
+
+	currentETag := func() string {
+		info, err := store.Stat(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return etag(info)
+	}
+	request(http.MethodPut, "first", "")
+	stale := currentETag()
+	request(http.MethodPut, "second, longer", "")
+	current := currentETag()
+
+	if w := request(http.MethodDelete, "", stale); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with stale ETag = %d, want 412", w.Code)
+	}
+	if !store.Exists(key) {
+		t.Fatal("DELETE with stale ETag removed the key")
+	}
+	if w := request(http.MethodDelete, "", current); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE with current ETag = %d, want 204", w.Code)
+	}
+	if store.Exists(key) {
+		t.Fatal("DELETE with current ETag left the key")
+	}
+	// Deleted since it was read is a change too
+	if w := request(http.MethodDelete, "", current); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE of a deleted key with its ETag = %d, want 412", w.Code)
+	}
+
+	if w := request(http.MethodDelete, "", "*"); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE with If-Match: * of a missing key = %d, want 404", w.Code)
+	}
+	request(http.MethodPut, "third", "")
+	if w := request(http.MethodDelete, "", "*"); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE with If-Match: * = %d, want 204", w.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package kv
+
+import (
+	"context"
+	"sync"
+)
+
+// streams tracks long-lived responses, such as watch streams, which never
+// finish on their own and so would hold up a graceful shutdown
+type streams struct {
+	mu      sync.Mutex
+	cancels map[int]context.CancelFunc
+	next    int
+	closed  bool
+	active  sync.WaitGroup
+}
+
+// beginStream registers a long-lived response. The returned context is
+// canceled when the request ends or the server starts shutting down; the
+// handler should then flush a final event and return. done must be called
+// when the handler returns. Once shutdown has begun, the context is already
+// canceled.
+func (h *Handlers) beginStream(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &h.streams
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		cancel()
+		return ctx, func() {}
+	}
+	if s.cancels == nil {
+		s.cancels = make(map[int]context.CancelFunc)
+	}
+	id := s.next
+	s.next++
+	s.cancels[id] = cancel
+	s.active.Add(1)
+
+	return ctx, func() {
+		s.mu.Lock()
+		delete(s.cancels, id)
+		s.mu.Unlock()
+		cancel()
+		s.active.Done()
+	}
+}
+
+// CloseStreams signals every open stream to finish, refuses new ones, and
+// waits for their handlers to return or ctx to end. Call it before
+// http.Server.Shutdown, which would otherwise wait on streams forever.
+func (h *Handlers) CloseStreams(ctx context.Context) error {
+	s := &h.streams
+	s.mu.Lock()
+	s.closed = true
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
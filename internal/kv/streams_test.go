@@ -0,0 +1,63 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCloseStreams(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	// A well-behaved stream flushes and returns once its context ends
+	ctx, done := handlers.beginStream(context.Background())
+	flushed := make(chan struct{})
+	go func() {
+		defer done()
+		<-ctx.Done()
+		close(flushed)
+	}()
+
+	// One that finished earlier doesn't hold anything up
+	_, earlier := handlers.beginStream(context.Background())
+	earlier()
+
+	if err := handlers.CloseStreams(context.Background()); err != nil {
+		t.Fatalf("CloseStreams failed: %v", err)
+	}
+	select {
+	case <-flushed:
+	default:
+		t.Error("CloseStreams returned before the stream finished")
+	}
+
+	// No new streams once closing
+	late, lateDone := handlers.beginStream(context.Background())
+	defer lateDone()
+	if late.Err() == nil {
+		t.Error("Stream started after CloseStreams is still open")
+	}
+}
+
+func TestCloseStreams_Timeout(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	// A stream that ignores its context
+	_, done := handlers.beginStream(context.Background())
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := handlers.CloseStreams(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseStreams = %v, want deadline exceeded", err)
+	}
+}
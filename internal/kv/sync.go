@@ -0,0 +1,221 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version returns key's version, which changes with every write to it,
+// including making it a pointer. Versions are hybrid timestamps: the write's
+// time in nanoseconds, bumped past the previous version if the clock went
+// backwards. They only ever increase, even across deleting and recreating the
+// key, so a client can tell a value it has synced from any later one. A
+// missing key is version 0. A key written before versions were recorded
+// reports its modification time until its next write.
+func (s *Store) Version(key string) (int64, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to stat key: %w", err)
+	}
+
+	meta, err := s.readMetadata(key)
+	if err != nil {
+		return 0, err
+	}
+	if meta.Version != 0 {
+		return meta.Version, nil
+	}
+	return info.ModTime().UnixNano(), nil
+}
+
+// nextVersion returns the version for a write to key, for callers holding its
+// lock
+func (s *Store) nextVersion(key string) (int64, error) {
+	prev, err := s.Version(key)
+	if err != nil {
+		return 0, err
+	}
+	version := time.Now().UnixNano()
+	if version <= prev {
+		version = prev + 1
+	}
+	return version, nil
+}
+
+// MaxSyncKeys bounds how many keys one POST /kvsync may compare
+const MaxSyncKeys = 1000
+
+// SyncRequest is the body of POST /kvsync: the client's copy of some keys, and
+// optionally a prefix whose other server keys the client should learn about
+type SyncRequest struct {
+	Prefix string    `json:"prefix,omitempty"`
+	Keys   []SyncKey `json:"keys"`
+}
+
+// SyncKey is the client's state of one key
+type SyncKey struct {
+	Key      string    `json:"key"`
+	Version  int64     `json:"version"`  // Server version the client last synced, 0 if never
+	Changed  bool      `json:"changed"`  // The client changed its copy since then
+	Modified time.Time `json:"modified"` // When the client last changed its copy, for settling conflicts
+}
+
+// SyncAction tells the client what to do with a key
+type SyncAction string
+
+const (
+	SyncOK       SyncAction = "ok"       // Nothing changed
+	SyncPush     SyncAction = "push"     // Only the client changed; PUT (or DELETE) the client's copy
+	SyncPull     SyncAction = "pull"     // Only the server changed; GET the server's copy, or delete the client's if version is 0
+	SyncConflict SyncAction = "conflict" // Both changed; see Winner
+)
+
+// SyncResult is the server's answer for one key
+type SyncResult struct {
+	Key      string     `json:"key"`
+	Action   SyncAction `json:"action"`
+	Version  int64      `json:"version"`           // Server's current version, 0 if it has no such key
+	Modified time.Time  `json:"modified,omitzero"` // When the server's copy last changed
+	Winner   string     `json:"winner,omitempty"`  // For conflicts, "client" or "server": whichever changed last
+}
+
+// SyncResponse is the body returned by POST /kvsync. Results are in request
+// order, followed by any other keys under the prefix, sorted.
+type SyncResponse struct {
+	Results []SyncResult `json:"results"`
+}
+
+// compareVersions decides what a client should do with one key. Anything
+// other than the version the client last synced, including 0 for a deleted
+// key, means the server's copy changed.
+func compareVersions(client SyncKey, version int64, modified time.Time) SyncResult {
+	result := SyncResult{Key: client.Key, Version: version, Modified: modified}
+	switch {
+	case version == client.Version && !client.Changed:
+		result.Action = SyncOK
+	case version == client.Version:
+		result.Action = SyncPush
+	case !client.Changed:
+		result.Action = SyncPull
+	default:
+		// Last write wins, but the client decides whether to ask the user
+		result.Action = SyncConflict
+		result.Winner = "server"
+		if version == 0 || client.Modified.After(modified) {
+			result.Winner = "client"
+		}
+	}
+	return result
+}
+
+// HandleSync handles POST /kvsync, comparing the client's versions of a batch
+// of keys with the server's and saying which to push, pull, or resolve
+func (h *Handlers) HandleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// A sync only reads, so it takes one token from the caller's read limit,
+	// however many keys it compares
+	owner, _ := r.Context().Value("user_email").(string)
+	if ok, retryAfter := h.readLimiter.allow(strings.ToLower(owner)); !ok {
+		writeRateLimited(w, retryAfter, "Too many reads, slow down")
+		slog.WarnContext(r.Context(), "KV read rate limit exceeded", "user", owner, "path", r.URL.Path)
+		return
+	}
+
+	var req SyncRequest
+	if err := json.NewDecoder(h.limitBody(w, r)).Decode(&req); err != nil {
+		http.Error(w, "Invalid sync request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Keys) > MaxSyncKeys {
+		http.Error(w, "Too many keys, at most "+strconv.Itoa(MaxSyncKeys)+" per request", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	requested := make(map[string]bool, len(req.Keys))
+	for _, client := range req.Keys {
+		if err := ValidateKey(client.Key); err != nil {
+			http.Error(w, client.Key+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.checkAuth(r, client.Key); err != nil {
+			http.Error(w, client.Key+": "+err.Error(), http.StatusForbidden)
+			return
+		}
+		requested[client.Key] = true
+	}
+	if req.Prefix != "" {
+		if err := ValidateKey(req.Prefix); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.checkAuth(r, req.Prefix); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	if !h.awaitConsistency(w, r) {
+		return
+	}
+
+	resp := SyncResponse{Results: make([]SyncResult, 0, len(req.Keys))}
+	compare := func(client SyncKey) bool {
+		version, err := h.store.Version(client.Key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read key version", "error", err, "key", client.Key)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return false
+		}
+		var modified time.Time
+		if version != 0 {
+			if info, err := h.store.Stat(client.Key); err == nil {
+				modified = info.ModTime().UTC()
+			}
+		}
+		resp.Results = append(resp.Results, compareVersions(client, version, modified))
+		return true
+	}
+
+	for _, client := range req.Keys {
+		if !compare(client) {
+			return
+		}
+	}
+
+	// Keys the client has never seen are new on the server, so always pulls
+	if req.Prefix != "" {
+		keys, err := h.store.List(req.Prefix, 0, true)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to list keys", "error", err, "prefix", req.Prefix)
+			http.Error(w, "Failed to list keys", http.StatusInternalServerError)
+			return
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !requested[key] && !compare(SyncKey{Key: key}) {
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
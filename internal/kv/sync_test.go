@@ -0,0 +1,183 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersion_Monotonic(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	key := "domain/example.com/user/alice/notes/a"
+
+	if v, err := store.Version(key); err != nil || v != 0 {
+		t.Fatalf("Version of missing key = %d, %v", v, err)
+	}
+
+	var versions []int64
+	record := func(what string) {
+		t.Helper()
+		v, err := store.Version(key)
+		if err != nil {
+			t.Fatalf("Version after %s: %v", what, err)
+		}
+		if len(versions) > 0 && v <= versions[len(versions)-1] {
+			t.Errorf("Version after %s = %d, not above %d", what, v, versions[len(versions)-1])
+		}
+		versions = append(versions, v)
+	}
+
+	store.Put(key, []byte("1"))
+	record("put")
+	store.Patch(key, []byte(`{}`), "", nil)
+	record("patch")
+	store.PutPointer(key, "domain/example.com/user/alice/notes/b")
+	record("pointer")
+	store.Delete(key)
+	if v, _ := store.Version(key); v != 0 {
+		t.Errorf("Version after delete = %d", v)
+	}
+	store.Put(key, []byte("2"))
+	record("recreate")
+
+	// A key stored before versions were recorded reports its mtime
+	legacy := filepath.Join(dir, "domain/example.com/user/alice/notes/legacy")
+	os.WriteFile(legacy, []byte("old"), 0644)
+	info, _ := os.Stat(legacy)
+	if v, _ := store.Version("domain/example.com/user/alice/notes/legacy"); v != info.ModTime().UnixNano() {
+		t.Errorf("Legacy version = %d, want mtime %d", v, info.ModTime().UnixNano())
+	}
+}
+
+func TestHandleSync(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	base := "domain/example.com/user/alice/"
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		if path == "/kvsync" {
+			handlers.HandleSync(w, req)
+		} else {
+			handlers.HandleKV(w, req)
+		}
+		return w
+	}
+	versionOf := func(w *httptest.ResponseRecorder) int64 {
+		v, _ := strconv.ParseInt(w.Header().Get("X-KV-Version"), 10, 64)
+		return v
+	}
+
+	// The client last synced these versions
+	same := versionOf(serve(http.MethodPut, "/kv/"+base+"same", "s"))
+	local := versionOf(serve(http.MethodPut, "/kv/"+base+"local", "l"))
+	remote := versionOf(serve(http.MethodPut, "/kv/"+base+"remote", "r"))
+	both := versionOf(serve(http.MethodPut, "/kv/"+base+"both", "b"))
+	gone := versionOf(serve(http.MethodPut, "/kv/"+base+"gone", "g"))
+	if same == 0 || versionOf(serve(http.MethodGet, "/kv/"+base+"same", "")) != same {
+		t.Fatalf("GET and PUT disagree on version %d", same)
+	}
+
+	// Then other devices wrote to the server
+	serve(http.MethodPut, "/kv/"+base+"remote", "r2")
+	serve(http.MethodPut, "/kv/"+base+"both", "b2")
+	serve(http.MethodDelete, "/kv/"+base+"gone", "")
+	serve(http.MethodPut, "/kv/"+base+"new", "n")
+
+	old := time.Now().Add(-time.Hour)
+	req := SyncRequest{
+		Prefix: base,
+		Keys: []SyncKey{
+			{Key: base + "same", Version: same},
+			{Key: base + "local", Version: local, Changed: true},
+			{Key: base + "remote", Version: remote},
+			{Key: base + "both", Version: both, Changed: true, Modified: old},
+			{Key: base + "gone", Version: gone, Changed: true, Modified: old},
+			{Key: base + "unsynced", Changed: true},
+		},
+	}
+	body, _ := json.Marshal(req)
+	w := serve(http.MethodPost, "/kvsync", string(body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Sync status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp SyncResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	want := []struct {
+		key    string
+		action SyncAction
+		winner string
+	}{
+		{"same", SyncOK, ""},
+		{"local", SyncPush, ""},
+		{"remote", SyncPull, ""},
+		{"both", SyncConflict, "server"},
+		{"gone", SyncConflict, "client"},
+		{"unsynced", SyncPush, ""},
+		{"new", SyncPull, ""},
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("Got %d results, want %d: %+v", len(resp.Results), len(want), resp.Results)
+	}
+	for i, want := range want {
+		got := resp.Results[i]
+		if got.Key != base+want.key || got.Action != want.action || got.Winner != want.winner {
+			t.Errorf("Result %d = %+v, want %s %s %s", i, got, want.key, want.action, want.winner)
+		}
+	}
+	if resp.Results[0].Version != same || resp.Results[4].Version != 0 {
+		t.Errorf("Unexpected server versions: %+v", resp.Results)
+	}
+
+	// Only the user's own keys
+	w = serve(http.MethodPost, "/kvsync", `{"keys":[{"key":"domain/example.com/user/bob/x"}]}`)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Sync of another user's key: status %d", w.Code)
+	}
+}
+
+func TestHandleSync_ReadRateLimit(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	handlers.SetReadLimit(RateLimit{Rate: 1, Burst: 1})
+	now := time.Now()
+	handlers.readLimiter.now = func() time.Time { return now }
+
+	sync := func() *httptest.ResponseRecorder {
+		body := `{"prefix":"domain/example.com/user/alice/","keys":[{"key":"domain/example.com/user/alice/a"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/kvsync", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+		w := httptest.NewRecorder()
+		handlers.HandleSync(w, req)
+		return w
+	}
+
+	if w := sync(); w.Code != http.StatusOK {
+		t.Fatalf("First sync = %d %s", w.Code, w.Body.String())
+	}
+	if w := sync(); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Second sync = %d %q, want 429 with Retry-After 1", w.Code, w.Header().Get("Retry-After"))
+	}
+}
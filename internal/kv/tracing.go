@@ -0,0 +1,152 @@
+package kv
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans created by this package
+const tracerName = "github.com/zellyn/trifle/internal/kv"
+
+// startSpan starts a span for a store operation as a child of any span in ctx
+func startSpan(ctx context.Context, op, key string) trace.Span {
+	attrs := []attribute.KeyValue{
+		attribute.String("kv.op", op),
+		attribute.String("kv.key", key),
+	}
+	if owner := keyOwner(key); owner != "" {
+		attrs = append(attrs, attribute.String("kv.owner", owner))
+	}
+	_, span := otel.Tracer(tracerName).Start(ctx, "kv."+op, trace.WithAttributes(attrs...))
+	return span
+}
+
+// endSpan records the bytes moved and any failure, then ends the span.
+// A missing key is an ordinary outcome, not an error.
+func endSpan(span trace.Span, bytes int, err error) {
+	span.SetAttributes(attribute.Int("kv.bytes", bytes))
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// keyOwner returns the email of the user a key belongs to, or "" for shared
+// keys such as file/*
+func keyOwner(key string) string {
+	parts := strings.SplitN(key, "/", 5)
+	switch {
+	case parts[0] == "domain" && len(parts) >= 4 && parts[2] == "user":
+		return parts[3] + "@" + parts[1]
+	case parts[0] == "user" && len(parts) >= 2:
+		return parts[1]
+	}
+	return ""
+}
+
+// GetContext is Get, traced as a child of any span in ctx
+func (s *Store) GetContext(ctx context.Context, key string) ([]byte, error) {
+	span := startSpan(ctx, "get", key)
+	value, err := s.Get(key)
+	endSpan(span, len(value), err)
+	return value, err
+}
+
+// OpenContext is Open, traced as a child of any span in ctx
+func (s *Store) OpenContext(ctx context.Context, key string) (*os.File, os.FileInfo, error) {
+	span := startSpan(ctx, "get", key)
+	file, info, err := s.Open(key)
+	size := 0
+	if info != nil {
+		size = int(info.Size())
+	}
+	endSpan(span, size, err)
+	return file, info, err
+}
+
+// PutContext is Put, traced as a child of any span in ctx
+func (s *Store) PutContext(ctx context.Context, key string, value []byte) error {
+	span := startSpan(ctx, "put", key)
+	err := s.Put(key, value)
+	endSpan(span, len(value), err)
+	return err
+}
+
+// PutReaderContext is PutReader, traced as a child of any span in ctx
+func (s *Store) PutReaderContext(ctx context.Context, key string, r io.Reader, meta Metadata) (int64, error) {
+	span := startSpan(ctx, "put", key)
+	n, err := s.PutReader(key, r, meta)
+	endSpan(span, int(n), err)
+	return n, err
+}
+
+// CreateExclusiveReaderContext is CreateExclusiveReader, traced as a child of
+// any span in ctx
+func (s *Store) CreateExclusiveReaderContext(ctx context.Context, key string, r io.Reader, meta Metadata) (int64, error) {
+	span := startSpan(ctx, "create", key)
+	n, err := s.CreateExclusiveReader(key, r, meta)
+	endSpan(span, int(n), err)
+	return n, err
+}
+
+// CreateContext is Create, traced as a child of any span in ctx
+func (s *Store) CreateContext(ctx context.Context, prefix string, value []byte) (string, error) {
+	span := startSpan(ctx, "create", prefix)
+	key, err := s.Create(prefix, value)
+	if key != "" {
+		span.SetAttributes(attribute.String("kv.created_key", key))
+	}
+	endSpan(span, len(value), err)
+	return key, err
+}
+
+// PatchContext is Patch, traced as a child of any span in ctx
+func (s *Store) PatchContext(ctx context.Context, key string, patch []byte, ifMatch string, check func(merged []byte) error) ([]byte, error) {
+	span := startSpan(ctx, "patch", key)
+	merged, err := s.Patch(key, patch, ifMatch, check)
+	endSpan(span, len(merged), err)
+	return merged, err
+}
+
+// DeleteContext is Delete, traced as a child of any span in ctx
+func (s *Store) DeleteContext(ctx context.Context, key string) error {
+	span := startSpan(ctx, "delete", key)
+	err := s.Delete(key)
+	endSpan(span, 0, err)
+	return err
+}
+
+// ConditionalDeleteContext is ConditionalDelete, traced as a child of any
+// span in ctx
+func (s *Store) ConditionalDeleteContext(ctx context.Context, key, ifMatch string) (bool, error) {
+	span := startSpan(ctx, "delete", key)
+	deleted, err := s.ConditionalDelete(key, ifMatch)
+	endSpan(span, 0, err)
+	return deleted, err
+}
+
+// ListMetaContext is ListMeta, traced as a child of any span in ctx
+func (s *Store) ListMetaContext(ctx context.Context, prefix string, depth int, recursive bool, r KeyRange) ([]KeyInfo, error) {
+	span := startSpan(ctx, "list", prefix)
+	infos, err := s.ListMeta(prefix, depth, recursive, r)
+	span.SetAttributes(attribute.Int("kv.keys", len(infos)))
+	endSpan(span, 0, err)
+	return infos, err
+}
+
+// ListRangeContext is ListRange, traced as a child of any span in ctx
+func (s *Store) ListRangeContext(ctx context.Context, prefix string, depth int, recursive bool, r KeyRange) ([]string, error) {
+	span := startSpan(ctx, "list", prefix)
+	keys, err := s.ListRange(prefix, depth, recursive, r)
+	span.SetAttributes(attribute.Int("kv.keys", len(keys)))
+	endSpan(span, 0, err)
+	return keys, err
+}
@@ -0,0 +1,111 @@
+package kv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zellyn/trifle/internal/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs an in-memory span recorder as the global tracer provider
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracing_KVRequestSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handler := middleware.Tracing(http.HandlerFunc(NewHandlers(store).HandleKV))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	key := "domain/example.com/user/alice/profile"
+	req := httptest.NewRequest(http.MethodPut, "/kv/"+key, strings.NewReader("hello"))
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d", w.Code)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	// Children end before their parents
+	kvSpan, httpSpan := spans[0], spans[1]
+
+	if httpSpan.Name() != "HTTP PUT" {
+		t.Errorf("root span name = %q, want HTTP PUT", httpSpan.Name())
+	}
+	if got := httpSpan.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("root span trace ID = %s, want incoming %s", got, traceID)
+	}
+	if got := spanAttrs(httpSpan)["http.response.status_code"].AsInt64(); got != http.StatusOK {
+		t.Errorf("root span status code = %d", got)
+	}
+
+	if kvSpan.Name() != "kv.put" {
+		t.Errorf("child span name = %q, want kv.put", kvSpan.Name())
+	}
+	if kvSpan.Parent().SpanID() != httpSpan.SpanContext().SpanID() {
+		t.Error("kv span is not a child of the request span")
+	}
+	attrs := spanAttrs(kvSpan)
+	for name, want := range map[attribute.Key]string{
+		"kv.op":    "put",
+		"kv.key":   key,
+		"kv.owner": "alice@example.com",
+	} {
+		if got := attrs[name].AsString(); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if got := attrs["kv.bytes"].AsInt64(); got != 5 {
+		t.Errorf("kv.bytes = %d, want 5", got)
+	}
+}
+
+func TestKeyOwner(t *testing.T) {
+	tests := map[string]string{
+		"domain/example.com/user/alice/trifle/x": "alice@example.com",
+		"user/alice@example.com/profile":         "alice@example.com",
+		"file/ab/cd/abcd":                        "",
+		"domain/example.com":                     "",
+	}
+	for key, want := range tests {
+		if got := keyOwner(key); got != want {
+			t.Errorf("keyOwner(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Usage counts stored values and their total size
+type Usage struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// add counts one value of size bytes
+func (u *Usage) add(size int64) {
+	u.Keys++
+	u.Bytes += size
+}
+
+// UserUsage is one user's share of the store
+type UserUsage struct {
+	User string `json:"user"`
+	Usage
+}
+
+// UsageStats is how much of the store each user is using. Content-addressed
+// file/* values are shared between users, so they're counted separately.
+type UsageStats struct {
+	Users      []UserUsage `json:"users"` // Largest first
+	Shared     Usage       `json:"shared"`
+	Total      Usage       `json:"total"`
+	ComputedAt time.Time   `json:"computedAt"`
+}
+
+// ComputeUsage scans every stored value and totals it by owner. Pointers
+// aren't counted, since their targets are.
+func (s *Store) ComputeUsage() (*UsageStats, error) {
+	byUser := map[string]*Usage{}
+	stats := &UsageStats{Users: []UserUsage{}}
+
+	err := filepath.WalkDir(s.dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == s.dataDir {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dataDir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		// Only the key namespaces hold values; skip temporary files,
+		// metadata, the audit log and the like
+		if d.IsDir() {
+			if !strings.Contains(key, "/") && key != "domain" && key != "user" && key != "file" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.Contains(key, "/") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Deleted mid-scan
+		}
+
+		if strings.HasPrefix(key, "file/") {
+			stats.Total.add(info.Size())
+			stats.Shared.add(info.Size())
+			return nil
+		}
+		owner := keyOwner(key)
+		if owner == "" {
+			return nil // e.g. domain/{domain}/shared, which no one user owns
+		}
+		stats.Total.add(info.Size())
+		if byUser[owner] == nil {
+			byUser[owner] = &Usage{}
+		}
+		byUser[owner].add(info.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan store: %w", err)
+	}
+
+	for user, usage := range byUser {
+		stats.Users = append(stats.Users, UserUsage{User: user, Usage: *usage})
+	}
+	sort.Slice(stats.Users, func(i, j int) bool {
+		if stats.Users[i].Bytes != stats.Users[j].Bytes {
+			return stats.Users[i].Bytes > stats.Users[j].Bytes
+		}
+		return stats.Users[i].User < stats.Users[j].User
+	})
+	stats.ComputedAt = time.Now().UTC()
+	return stats, nil
+}
+
+// UserUsage totals the values email owns right now, in both key layouts, by
+// scanning just their keys. Pointers aren't counted, as in ComputeUsage.
+func (s *Store) UserUsage(email string) (Usage, error) {
+	var usage Usage
+	prefixes, err := userPrefixes(email)
+	if err != nil {
+		return usage, err
+	}
+	for _, prefix := range prefixes {
+		err := filepath.WalkDir(filepath.Join(s.dataDir, prefix), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil // No keys in this layout, or deleted mid-scan
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				usage.add(info.Size())
+			}
+			return nil
+		})
+		if err != nil {
+			return usage, fmt.Errorf("failed to scan %s: %w", prefix, err)
+		}
+	}
+	return usage, nil
+}
+
+// Usage returns the most recently computed usage, computing it now if it
+// never has been. RefreshUsage keeps it current without blocking callers.
+func (s *Store) Usage() (*UsageStats, error) {
+	if stats := s.usage.Load(); stats != nil {
+		return stats, nil
+	}
+	stats, err := s.ComputeUsage()
+	if err != nil {
+		return nil, err
+	}
+	s.usage.CompareAndSwap(nil, stats)
+	return stats, nil
+}
+
+// RefreshUsage recomputes usage as a scheduled maintenance task
+func (s *Store) RefreshUsage(ctx context.Context) error {
+	stats, err := s.ComputeUsage()
+	if err != nil {
+		return err
+	}
+	s.usage.Store(stats)
+	slog.DebugContext(ctx, "Usage statistics refreshed", "users", len(stats.Users), "bytes", stats.Total.Bytes)
+	return nil
+}
+
+// HandleUsage handles GET /kvstats, returning per-user usage as of the last
+// scan (see computedAt)
+func (h *Handlers) HandleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := h.store.Usage()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to compute usage", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,74 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+
+	for key, value := range map[string]string{
+		"domain/example.com/user/alice/a": "12345",
+		"domain/example.com/user/alice/b": "123",
+		"user/bob@gmail.com/x":            "1234567890",
+		"file/ab/cd/abcd":                 "12",
+	} {
+		if err := store.Put(key, []byte(value)); err != nil {
+			t.Fatalf("Put %s failed: %v", key, err)
+		}
+	}
+	if err := store.PutPointer("domain/example.com/user/alice/latest/p", "domain/example.com/user/alice/a"); err != nil {
+		t.Fatalf("PutPointer failed: %v", err)
+	}
+	// Not values: neither is counted
+	os.WriteFile(filepath.Join(dir, "allowlist.txt"), []byte("alice@example.com\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".tmp", "stray"), []byte("xxxxxxxx"), 0644)
+
+	req := httptest.NewRequest(http.MethodGet, "/kvstats", nil)
+	w := httptest.NewRecorder()
+	handlers.HandleUsage(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d: %s", w.Code, w.Body.String())
+	}
+	var stats UsageStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+
+	want := []UserUsage{
+		{User: "bob@gmail.com", Usage: Usage{Keys: 1, Bytes: 10}},
+		{User: "alice@example.com", Usage: Usage{Keys: 2, Bytes: 8}},
+	}
+	if len(stats.Users) != len(want) || stats.Users[0] != want[0] || stats.Users[1] != want[1] {
+		t.Errorf("Users = %+v, want %+v", stats.Users, want)
+	}
+	if stats.Shared != (Usage{Keys: 1, Bytes: 2}) {
+		t.Errorf("Shared = %+v", stats.Shared)
+	}
+	if stats.Total != (Usage{Keys: 4, Bytes: 20}) {
+		t.Errorf("Total = %+v", stats.Total)
+	}
+
+	// Cached until refreshed
+	store.Put("user/bob@gmail.com/y", []byte("1"))
+	if cached, _ := store.Usage(); cached.Total.Keys != 4 {
+		t.Errorf("Cached total = %+v, want the earlier scan", cached.Total)
+	}
+	if err := store.RefreshUsage(context.Background()); err != nil {
+		t.Fatalf("RefreshUsage failed: %v", err)
+	}
+	if refreshed, _ := store.Usage(); refreshed.Total.Keys != 5 {
+		t.Errorf("Refreshed total = %+v, want 5 keys", refreshed.Total)
+	}
+}
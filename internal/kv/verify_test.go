@@ -0,0 +1,87 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksums_DetectTruncation(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	handlers := NewHandlers(store)
+	base := "domain/example.com/user/alice/"
+
+	store.Put(base+"good", []byte("intact"))
+	store.Put(base+"bad", []byte("print('hello, world')"))
+	if err := store.PutPointer(base+"latest", base+"bad"); err != nil {
+		t.Fatalf("PutPointer failed: %v", err)
+	}
+
+	// Stored before checksums were recorded: trusted as-is
+	os.WriteFile(filepath.Join(dir, base+"legacy"), []byte("old"), 0644)
+	if got, err := store.Get(base + "legacy"); err != nil || string(got) != "old" {
+		t.Errorf("Get legacy = %q, %v", got, err)
+	}
+
+	// Truncated behind the store's back, as by a disk problem
+	if err := os.Truncate(filepath.Join(dir, base+"bad"), 5); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	// Reads only hash values when asked to
+	if got, err := store.Get(base + "bad"); err != nil || string(got) != "print" {
+		t.Errorf("Get without verification = %q, %v", got, err)
+	}
+	store.SetVerifyChecksums(true)
+	for _, key := range []string{base + "bad", base + "latest"} {
+		if _, err := store.Get(key); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Get %s: expected ErrCorrupt, got %v", key, err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/kv/"+base+"bad", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_email", "alice@example.com"))
+	w := httptest.NewRecorder()
+	handlers.HandleKV(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("GET of corrupt value: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	corrupt, err := store.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(corrupt) != 1 || corrupt[0].Key != base+"bad" {
+		t.Errorf("Verify = %+v, want just %sbad", corrupt, base)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.HandleVerify(w, httptest.NewRequest(http.MethodGet, "/admin/verify", nil))
+	var result VerifyResult
+	json.NewDecoder(w.Body).Decode(&result)
+	if w.Code != http.StatusInternalServerError || len(result.Corrupt) != 1 {
+		t.Errorf("HandleVerify = %d, %+v", w.Code, result)
+	}
+
+	// Rewriting fixes the corrupt value, and gives the legacy one a checksum
+	store.Put(base+"bad", []byte("rewritten"))
+	store.Put(base+"legacy", []byte("updated"))
+	if meta, _ := store.Metadata(base + "legacy"); meta.SHA256 == "" {
+		t.Error("No checksum recorded when legacy value was updated")
+	}
+	w = httptest.NewRecorder()
+	handlers.HandleVerify(w, httptest.NewRequest(http.MethodGet, "/admin/verify", nil))
+	result = VerifyResult{}
+	json.NewDecoder(w.Body).Decode(&result)
+	if w.Code != http.StatusOK || len(result.Corrupt) != 0 {
+		t.Errorf("HandleVerify after rewrite = %d, %+v", w.Code, result)
+	}
+}
@@ -0,0 +1,228 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// webhookQueueSize bounds how many deliveries can wait to be sent. Writers
+	// never block on webhooks; events beyond this are dropped and counted.
+	webhookQueueSize = 1024
+
+	// webhookWorkers is how many deliveries are sent at once, so one slow
+	// receiver doesn't hold up the rest
+	webhookWorkers = 4
+
+	// webhookAttempts is how many times a delivery is tried before giving up
+	webhookAttempts = 5
+
+	// WebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// request body, keyed with the shared secret
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookEvent is the JSON body POSTed to a webhook when one of its user's
+// keys changes
+type WebhookEvent struct {
+	User string    `json:"user"`
+	Key  string    `json:"key"`
+	Op   string    `json:"op"` // As in AuditEntry
+	Time time.Time `json:"time"`
+}
+
+// webhookDelivery is one event on its way to one URL
+type webhookDelivery struct {
+	url  string
+	body []byte
+}
+
+// Webhooks notifies external services of changes to particular users' keys.
+// Deliveries are queued and sent by background workers, retrying failures
+// with exponential backoff, so a slow or failing receiver never blocks a
+// write.
+type Webhooks struct {
+	hooks   map[string][]string // URLs by user email
+	secret  []byte
+	client  *http.Client
+	backoff time.Duration // Before the first retry, doubling after each
+
+	queue   chan webhookDelivery
+	dropped atomic.Int64
+	stop    context.Context // Cancelled to abandon retries at shutdown
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// LoadWebhooks reads webhook registrations from path, a JSON object mapping
+// user emails to lists of URLs, and starts delivering. Payloads are signed
+// with secret. Close it to stop.
+func LoadWebhooks(path string, secret []byte) (*Webhooks, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+	var config map[string][]string
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse webhooks %s: %w", path, err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("webhooks need a signing secret")
+	}
+
+	hooks := make(map[string][]string, len(config))
+	for user, urls := range config {
+		for _, raw := range urls {
+			u, err := url.Parse(raw)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return nil, fmt.Errorf("invalid webhook URL for %s: %q", user, raw)
+			}
+		}
+		user = strings.ToLower(strings.TrimSpace(user))
+		hooks[user] = append(hooks[user], urls...)
+	}
+	return NewWebhooks(hooks, secret), nil
+}
+
+// NewWebhooks starts delivering events for the users in hooks, a map of user
+// emails to URLs
+func NewWebhooks(hooks map[string][]string, secret []byte) *Webhooks {
+	stop, cancel := context.WithCancel(context.Background())
+	w := &Webhooks{
+		hooks:   hooks,
+		secret:  secret,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: time.Second,
+		queue:   make(chan webhookDelivery, webhookQueueSize),
+		stop:    stop,
+		cancel:  cancel,
+	}
+	for range webhookWorkers {
+		w.wg.Add(1)
+		go w.run()
+	}
+	return w
+}
+
+// SetWebhooks notifies w of every mutation made through the store
+func (s *Store) SetWebhooks(w *Webhooks) {
+	s.webhooks = w
+}
+
+// notify queues event for each of its user's webhooks
+func (w *Webhooks) notify(event WebhookEvent) {
+	urls := w.hooks[event.User]
+	if len(urls) == 0 {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode webhook event", "error", err, "key", event.Key)
+		return
+	}
+	for _, u := range urls {
+		select {
+		case w.queue <- webhookDelivery{url: u, body: body}:
+		default:
+			w.dropped.Add(1)
+		}
+	}
+}
+
+// run sends queued deliveries until the queue is closed
+func (w *Webhooks) run() {
+	defer w.wg.Done()
+	for delivery := range w.queue {
+		if dropped := w.dropped.Swap(0); dropped > 0 {
+			slog.Warn("Webhook queue full, deliveries dropped", "dropped", dropped)
+		}
+		w.deliver(delivery)
+	}
+}
+
+// deliver sends one delivery, retrying until it succeeds, fails permanently,
+// runs out of attempts or the webhooks are closed
+func (w *Webhooks) deliver(delivery webhookDelivery) {
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.send(delivery)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			slog.Error("Webhook delivery failed", "error", err, "url", delivery.url, "attempts", attempt)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-w.stop.Done():
+			slog.Warn("Webhook delivery abandoned at shutdown", "error", err, "url", delivery.url, "attempts", attempt)
+			return
+		}
+	}
+}
+
+// send POSTs delivery once, reporting whether a failure is worth retrying:
+// network errors, 429s and 5xx are; other rejections aren't
+func (w *Webhooks) send(delivery webhookDelivery) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(w.stop, http.MethodPost, delivery.url, bytes.NewReader(delivery.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.secret, delivery.body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// SignWebhook returns the WebhookSignatureHeader value for body. Receivers
+// verify a delivery by computing it themselves and comparing with
+// hmac.Equal.
+func SignWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Close sends the deliveries already queued, giving up on any still pending
+// or retrying when ctx is done
+func (w *Webhooks) Close(ctx context.Context) error {
+	close(w.queue)
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	defer w.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
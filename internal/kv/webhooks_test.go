@@ -0,0 +1,107 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	secret := []byte("shh")
+	var mu sync.Mutex
+	var events []WebhookEvent
+	attempts := 0
+	received := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(WebhookSignatureHeader); got != SignWebhook(secret, body) {
+			t.Errorf("Signature = %q, want %q", got, SignWebhook(secret, body))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// The first delivery fails once and is retried
+		if attempts++; attempts == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("Invalid payload %q: %v", body, err)
+		}
+		events = append(events, event)
+		received <- struct{}{}
+	}))
+	defer server.Close()
+
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	webhooks := NewWebhooks(map[string][]string{"alice@example.com": {server.URL}}, secret)
+	webhooks.backoff = time.Millisecond
+	store.SetWebhooks(webhooks)
+
+	if err := store.Put("domain/example.com/user/alice/a", []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// Other users' changes aren't sent
+	if err := store.Put("domain/example.com/user/bob/b", []byte("2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook not delivered")
+	}
+	if err := webhooks.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 || len(events) != 1 {
+		t.Fatalf("Got %d attempts and events %+v, want one event after a retry", attempts, events)
+	}
+	if e := events[0]; e.User != "alice@example.com" || e.Key != "domain/example.com/user/alice/a" || e.Op != "put" || e.Time.IsZero() {
+		t.Errorf("Event = %+v", e)
+	}
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	dir := t.TempDir()
+	for name, test := range map[string]struct {
+		config string
+		secret string
+		err    string
+	}{
+		"valid":      {`{"Alice@Example.com": ["https://example.com/hook"]}`, "shh", ""},
+		"no secret":  {`{"alice@example.com": ["https://example.com/hook"]}`, "", "secret"},
+		"bad url":    {`{"alice@example.com": ["ftp://example.com/hook"]}`, "shh", "invalid webhook URL"},
+		"not a list": {`{"alice@example.com": "https://example.com/hook"}`, "shh", "failed to parse"},
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".json")
+		os.WriteFile(path, []byte(test.config), 0600)
+		webhooks, err := LoadWebhooks(path, []byte(test.secret))
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if len(webhooks.hooks["alice@example.com"]) != 1 {
+				t.Errorf("%s: emails weren't normalized: %v", name, webhooks.hooks)
+			}
+			webhooks.Close(context.Background())
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error = %v, want %q", name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodySize is the largest request body a route accepts unless it's
+// allowed more: twice the default KV value size, for JSON and base64 overhead
+const DefaultMaxBodySize = 32 << 20
+
+// BodyLimit caps the size of every request body, beneath any check the
+// handler itself makes, so a single huge request can't exhaust memory.
+// Routes that take bulk uploads can be allowed a different limit.
+type BodyLimit struct {
+	limit  int64
+	routes map[string]int64 // By route pattern
+}
+
+// NewBodyLimit returns a BodyLimit that caps request bodies at limit bytes,
+// or doesn't if limit is 0
+func NewBodyLimit(limit int64) *BodyLimit {
+	return &BodyLimit{limit: limit, routes: make(map[string]int64)}
+}
+
+// Allow gives requests matching pattern a limit of their own, 0 meaning none.
+// As with http.ServeMux, a pattern ending in "/" matches every path under
+// it, and the longest matching pattern wins. Call it before serving.
+func (b *BodyLimit) Allow(pattern string, limit int64) {
+	b.routes[pattern] = limit
+}
+
+// limitFor returns the limit for requests to path
+func (b *BodyLimit) limitFor(path string) int64 {
+	limit, matched := b.limit, ""
+	for pattern, l := range b.routes {
+		if len(pattern) <= len(matched) {
+			continue
+		}
+		if path == pattern || strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) {
+			limit, matched = l, pattern
+		}
+	}
+	return limit
+}
+
+// Wrap refuses a request whose Content-Length is over its limit with a 413
+// before the handler sees it, and cuts off a chunked body at the limit, so
+// the handler's next read fails with an *http.MaxBytesError
+func (b *BodyLimit) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := b.limitFor(r.URL.Path)
+		if limit > 0 {
+			if r.ContentLength > limit {
+				w.Header().Set("Connection", "close")
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
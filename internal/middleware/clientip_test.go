@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 127.0.0.1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	tests := []struct {
+		name, remote, forwardedFor, realIP string
+		want                               string
+	}{
+		{"no headers", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"spoofed from untrusted peer", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy", "127.0.0.1:5000", "198.51.100.1", "", "198.51.100.1"},
+		{"client prepends a fake hop", "127.0.0.1:5000", "192.0.2.66, 198.51.100.1", "", "198.51.100.1"},
+		{"chain of trusted proxies", "127.0.0.1:5000", "198.51.100.1, 10.1.2.3", "", "198.51.100.1"},
+		{"X-Real-IP from trusted proxy", "10.0.0.5:5000", "", "198.51.100.1", "198.51.100.1"},
+		{"garbage X-Real-IP", "10.0.0.5:5000", "", "not-an-ip", "10.0.0.5"},
+		{"IPv6 client", "127.0.0.1:5000", "2001:db8::1", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := RequestClientIP(req, trusted); got != tt.want {
+				t.Errorf("RequestClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP_TrustsNothingByDefault(t *testing.T) {
+	trusted, err := ParseTrustedProxies("")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	var seen string
+	handler := ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientIPFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen != "127.0.0.1" {
+		t.Errorf("client IP = %q, want the peer 127.0.0.1", seen)
+	}
+}
+
+func TestParseTrustedProxies_Invalid(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "proxy.internal", "10.0.0.0/8,nope"} {
+		if _, err := ParseTrustedProxies(value); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded, want an error", value)
+		}
+	}
+}
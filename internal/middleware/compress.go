@@ -0,0 +1,300 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultCompressMinSize is the smallest response worth compressing; below
+// it the encoding overhead outweighs the savings
+const DefaultCompressMinSize = 1024
+
+// incompressibleTypes are content types that are already compressed
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-brotli",
+	"application/pdf",
+}
+
+// gzipMagic starts every gzip stream, so pre-compressed bodies are left alone
+// even if they don't say so
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// dynamicBrotliLevel trades some of Brotli's ratio for speed, since responses
+// are compressed as they're sent. Precompress uses a higher level.
+const dynamicBrotliLevel = 5
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+var brotliWriters = sync.Pool{New: func() any { return brotli.NewWriterLevel(io.Discard, dynamicBrotliLevel) }}
+
+// Compress encodes responses with Brotli, gzip or deflate, in that order of
+// preference, for clients that accept it. Responses smaller than minSize, already-encoded responses and
+// already-compressed content types are sent as-is.
+//
+// A compressed response is a different representation, so its strong ETag
+// is made weak. net/http compares If-None-Match weakly, so conditional
+// requests still get 304s.
+func Compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			// Byte ranges refer to the unencoded body, so leave them alone
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks br, gzip or deflate from an Accept-Encoding header,
+// honoring q=0 exclusions
+func negotiateEncoding(header string) string {
+	return negotiate(header, "br", "gzip", "deflate")
+}
+
+// negotiate returns the first of encodings an Accept-Encoding header accepts,
+// or "" for none
+func negotiate(header string, encodings ...string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+
+	for _, encoding := range encodings {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter buffers the start of a response until it knows whether
+// compressing is worthwhile, then either compresses or passes it through
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status      int
+	buf         []byte
+	decided     bool
+	encoder     io.WriteCloser // Nil when passing through
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+
+	// Bodiless and informational responses go straight through
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		if status == http.StatusNotModified && !variesByEncoding(cw.Header()) {
+			addVary(cw.Header())
+			weakenETag(cw.Header())
+		}
+		cw.decided = true
+		cw.sendHeader()
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide chooses whether to compress based on what has been buffered, then
+// sends the headers and the buffered bytes
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	h := cw.Header()
+
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// Sniff now, as net/http would, rather than from compressed bytes
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	// A handler that varies by Accept-Encoding itself, like Precompress, has
+	// already chosen the encoding
+	compressible := h.Get("Content-Encoding") == "" && !variesByEncoding(h) &&
+		!incompressibleType(h.Get("Content-Type")) &&
+		!bytes.HasPrefix(cw.buf, gzipMagic)
+	if compressible {
+		addVary(h)
+	}
+
+	if compressible && len(cw.buf) >= cw.minSize {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		weakenETag(h)
+		cw.encoder = cw.newEncoder()
+	}
+
+	cw.sendHeader()
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) newEncoder() io.WriteCloser {
+	switch cw.encoding {
+	case "br":
+		bw := brotliWriters.Get().(*brotli.Writer)
+		bw.Reset(cw.ResponseWriter)
+		return bw
+	case "gzip":
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(cw.ResponseWriter)
+		return gz
+	}
+	fw, _ := flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression) // Only fails for bad levels
+	return fw
+}
+
+func (cw *compressWriter) sendHeader() {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// Close flushes any buffered or compressed output at the end of the response
+func (cw *compressWriter) Close() error {
+	if cw.status == 0 {
+		// The handler wrote nothing; let net/http send its implicit 200
+		return nil
+	}
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+
+	err := cw.encoder.Close()
+	switch encoder := cw.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *brotli.Writer:
+		brotliWriters.Put(encoder)
+	}
+	cw.encoder = nil
+	return err
+}
+
+// Flush sends what has been written so far, so streamed responses aren't
+// held back waiting for minSize bytes
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide()
+	}
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// incompressibleType reports whether contentType is already compressed
+func incompressibleType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// variesByEncoding reports whether h already says the response depends on
+// Accept-Encoding
+func variesByEncoding(h http.Header) bool {
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addVary notes that the response depends on Accept-Encoding, once
+func addVary(h http.Header) {
+	if !variesByEncoding(h) {
+		h.Add("Vary", "Accept-Encoding")
+	}
+}
+
+// weakenETag marks a strong ETag weak, since the encoded bytes differ
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
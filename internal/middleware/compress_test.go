@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func serveCompressed(handler http.Handler, acceptEncoding string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/docs/page.html", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	Compress(DefaultCompressMinSize)(handler).ServeHTTP(w, req)
+	return w
+}
+
+func TestCompress_GzipsLargeText(t *testing.T) {
+	body := strings.Repeat("<p>Hello, Trifling!</p>\n", 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", "4800")
+		w.Header().Set("ETag", `"abc"`)
+		io.WriteString(w, body)
+	})
+
+	w := serveCompressed(handler, "br;q=0, gzip;q=0.8", nil)
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", got)
+	}
+	if got := w.Header().Get("ETag"); got != `W/"abc"` {
+		t.Errorf("ETag = %q, want weak", got)
+	}
+	if got := w.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length = %q, want it removed", got)
+	}
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("response is not gzip: %v", err)
+	}
+	decoded, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("failed to decompress: %v", err)
+	}
+	if string(decoded) != body {
+		t.Error("decompressed body does not match")
+	}
+}
+
+func TestCompress_PrefersBrotli(t *testing.T) {
+	body := strings.Repeat("print('Hello, Trifling!')\n", 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, body)
+	})
+
+	w := serveCompressed(handler, "gzip, deflate, br", nil)
+	if got := w.Header().Get("Content-Encoding"); got != "br" {
+		t.Fatalf("Content-Encoding = %q, want br", got)
+	}
+	decoded, err := io.ReadAll(brotli.NewReader(w.Body))
+	if err != nil {
+		t.Fatalf("failed to decompress: %v", err)
+	}
+	if string(decoded) != body {
+		t.Error("decompressed body does not match")
+	}
+}
+
+func TestCompress_LeavesResponsesAlone(t *testing.T) {
+	large := strings.Repeat("a", 4*DefaultCompressMinSize)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	io.WriteString(gz, large)
+	gz.Close()
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		encoding       string
+		body           string
+	}{
+		{"client does not accept gzip", "", "text/plain", "", large},
+		{"gzip refused", "gzip;q=0, identity", "text/plain", "", large},
+		{"below threshold", "gzip", "text/plain", "", "tiny"},
+		{"already compressed type", "gzip", "image/png", "", large},
+		{"already encoded", "gzip", "text/plain", "gzip", gzipped.String()},
+		{"pre-compressed bytes", "gzip", "application/octet-stream", "", gzipped.String()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				io.WriteString(w, tt.body)
+			})
+
+			w := serveCompressed(handler, tt.acceptEncoding, nil)
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if w.Body.String() != tt.body {
+				t.Error("body was modified")
+			}
+		})
+	}
+}
+
+func TestCompress_ConditionalRequests(t *testing.T) {
+	content := strings.Repeat("body { margin: 0; }\n", 200)
+	modified := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "style.css", modified, strings.NewReader(content))
+	})
+
+	first := serveCompressed(handler, "gzip", nil)
+	if first.Code != http.StatusOK || first.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("first response: status %d, encoding %q", first.Code, first.Header().Get("Content-Encoding"))
+	}
+	etag := first.Header().Get("ETag")
+
+	second := serveCompressed(handler, "gzip", http.Header{"If-None-Match": {etag}})
+	if second.Code != http.StatusNotModified {
+		t.Errorf("revalidation with %s: status %d, want 304", etag, second.Code)
+	}
+	if got := second.Header().Get("ETag"); got != etag {
+		t.Errorf("304 ETag = %q, want %q", got, etag)
+	}
+
+	third := serveCompressed(handler, "gzip", http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}})
+	if third.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: status %d, want 304", third.Code)
+	}
+}
+
+func TestCompress_RangeRequestsUncompressed(t *testing.T) {
+	content := strings.Repeat("0123456789", 500)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.txt", time.Time{}, strings.NewReader(content))
+	})
+
+	w := serveCompressed(handler, "gzip", http.Header{"Range": {"bytes=0-9"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "0123456789" {
+		t.Errorf("range response: status %d, body %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CSPReportPath is where browsers send Content Security Policy violation reports
+const CSPReportPath = "/csp-report"
+
+// cspReportGroup names CSPReportPath in the Reporting-Endpoints header for report-to
+const cspReportGroup = "csp-endpoint"
+
+// DefaultCSP allows what the app needs today: scripts, styles and fonts from
+// the Ace, Pyodide and KaTeX CDNs, WebAssembly for Pyodide, eval for
+// JavaScript snippets, inline scripts in the static pages, blob: workers, and
+// YouTube embeds in the docs.
+const DefaultCSP = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' 'unsafe-eval' 'wasm-unsafe-eval' https://cdnjs.cloudflare.com https://cdn.jsdelivr.net; " +
+	"style-src 'self' 'unsafe-inline' https://cdnjs.cloudflare.com; " +
+	"font-src 'self' data: https://cdnjs.cloudflare.com; " +
+	"img-src 'self' data: blob: https:; " +
+	"connect-src 'self' https://cdnjs.cloudflare.com https://cdn.jsdelivr.net; " +
+	"worker-src 'self' blob:; " +
+	"frame-src https://www.youtube-nocookie.com; " +
+	"object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+
+// maxCSPReportSize caps how much of a report body is read
+const maxCSPReportSize = 64 << 10
+
+// CSP sets a Content Security Policy on every response, with report-uri and
+// report-to pointing at CSPReportPath. Unless enforce is set the policy is
+// sent as Content-Security-Policy-Report-Only, so violations are reported
+// without breaking anything while the policy is tuned.
+func CSP(policy string, enforce bool) func(http.Handler) http.Handler {
+	header := "Content-Security-Policy-Report-Only"
+	if enforce {
+		header = "Content-Security-Policy"
+	}
+	value := strings.TrimRight(strings.TrimSpace(policy), ";") +
+		"; report-uri " + CSPReportPath + "; report-to " + cspReportGroup
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(header, value)
+			w.Header().Set("Reporting-Endpoints", cspReportGroup+`="`+CSPReportPath+`"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSPReportHandler logs CSP violation reports. It accepts both the
+// report-uri format (application/csp-report) and the Reporting API format
+// (application/reports+json). At most limit reports are logged per window;
+// the rest are counted and summarized when the next window opens.
+type CSPReportHandler struct {
+	limit  int
+	window time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	logged      int
+	dropped     int
+	now         func() time.Time
+}
+
+// NewCSPReportHandler creates a report handler logging at most limit reports per window
+func NewCSPReportHandler(limit int, window time.Duration) *CSPReportHandler {
+	return &CSPReportHandler{limit: limit, window: window, now: time.Now}
+}
+
+// cspViolation is the part of a report worth logging
+type cspViolation struct {
+	DocumentURI string
+	Directive   string
+	BlockedURI  string
+}
+
+func (h *CSPReportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportSize))
+	if err != nil {
+		http.Error(w, "Failed to read report", http.StatusBadRequest)
+		return
+	}
+
+	violations, err := parseCSPReports(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		http.Error(w, "Invalid report", http.StatusBadRequest)
+		return
+	}
+
+	for _, v := range violations {
+		if !h.allow() {
+			continue
+		}
+		slog.Info("CSP violation",
+			"document_uri", v.DocumentURI,
+			"directive", v.Directive,
+			"blocked_uri", v.BlockedURI,
+		)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allow reports whether another report may be logged in the current window
+func (h *CSPReportHandler) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	if now.Sub(h.windowStart) >= h.window {
+		if h.dropped > 0 {
+			slog.Info("CSP violation reports dropped by rate limit", "count", h.dropped)
+		}
+		h.windowStart = now
+		h.logged = 0
+		h.dropped = 0
+	}
+
+	if h.logged >= h.limit {
+		h.dropped++
+		return false
+	}
+	h.logged++
+	return true
+}
+
+// parseCSPReports extracts violations from either report format
+func parseCSPReports(contentType string, body []byte) ([]cspViolation, error) {
+	if strings.HasPrefix(contentType, "application/reports+json") {
+		var reports []struct {
+			Type string `json:"type"`
+			Body struct {
+				DocumentURL        string `json:"documentURL"`
+				EffectiveDirective string `json:"effectiveDirective"`
+				BlockedURL         string `json:"blockedURL"`
+			} `json:"body"`
+		}
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+
+		var violations []cspViolation
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			violations = append(violations, cspViolation{
+				DocumentURI: report.Body.DocumentURL,
+				Directive:   report.Body.EffectiveDirective,
+				BlockedURI:  report.Body.BlockedURL,
+			})
+		}
+		return violations, nil
+	}
+
+	// report-uri format, sent as application/csp-report
+	var report struct {
+		CSPReport struct {
+			DocumentURI        string `json:"document-uri"`
+			ViolatedDirective  string `json:"violated-directive"`
+			EffectiveDirective string `json:"effective-directive"`
+			BlockedURI         string `json:"blocked-uri"`
+		} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, err
+	}
+
+	directive := report.CSPReport.EffectiveDirective
+	if directive == "" {
+		directive = report.CSPReport.ViolatedDirective
+	}
+	return []cspViolation{{
+		DocumentURI: report.CSPReport.DocumentURI,
+		Directive:   directive,
+		BlockedURI:  report.CSPReport.BlockedURI,
+	}}, nil
+}
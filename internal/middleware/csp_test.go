@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs redirects the default logger into a buffer for the test. Like
+// main's logger, it adds request IDs from the context.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(NewRequestIDLogHandler(slog.NewTextHandler(&buf, nil))))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestCSPReportHandler_AcceptsAndLogsReport(t *testing.T) {
+	logs := captureLogs(t)
+	handler := NewCSPReportHandler(10, time.Minute)
+
+	report := `{"csp-report": {
+		"document-uri": "https://trifling.org/learn/",
+		"violated-directive": "script-src-elem",
+		"effective-directive": "script-src-elem",
+		"blocked-uri": "https://evil.example/x.js"
+	}}`
+	req := httptest.NewRequest(http.MethodPost, CSPReportPath, strings.NewReader(report))
+	req.Header.Set("Content-Type", "application/csp-report")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	out := logs.String()
+	for _, want := range []string{"CSP violation", "level=INFO", "directive=script-src-elem", "blocked_uri=https://evil.example/x.js"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCSPReportHandler_AcceptsReportingAPI(t *testing.T) {
+	logs := captureLogs(t)
+	handler := NewCSPReportHandler(10, time.Minute)
+
+	report := `[{"type": "csp-violation", "body": {
+		"documentURL": "https://trifling.org/",
+		"effectiveDirective": "worker-src",
+		"blockedURL": "blob"
+	}}]`
+	req := httptest.NewRequest(http.MethodPost, CSPReportPath, strings.NewReader(report))
+	req.Header.Set("Content-Type", "application/reports+json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if !strings.Contains(logs.String(), "directive=worker-src") {
+		t.Errorf("report not logged:\n%s", logs.String())
+	}
+}
+
+func TestCSPReportHandler_RateLimitsLogging(t *testing.T) {
+	logs := captureLogs(t)
+	handler := NewCSPReportHandler(2, time.Minute)
+	now := time.Unix(1000, 0)
+	handler.now = func() time.Time { return now }
+
+	post := func() {
+		req := httptest.NewRequest(http.MethodPost, CSPReportPath, strings.NewReader(`{"csp-report": {"blocked-uri": "inline"}}`))
+		req.Header.Set("Content-Type", "application/csp-report")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		post()
+	}
+	if got := strings.Count(logs.String(), `msg="CSP violation"`); got != 2 {
+		t.Errorf("logged %d reports, want 2", got)
+	}
+
+	now = now.Add(time.Minute)
+	post()
+	if !strings.Contains(logs.String(), "count=3") {
+		t.Errorf("dropped reports not summarized:\n%s", logs.String())
+	}
+}
+
+func TestCSPReportHandler_RejectsInvalid(t *testing.T) {
+	handler := NewCSPReportHandler(10, time.Minute)
+
+	req := httptest.NewRequest(http.MethodGet, CSPReportPath, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, CSPReportPath, strings.NewReader("not json"))
+	req.Header.Set("Content-Type", "application/csp-report")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid body status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCSP_SetsHeaders(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	CSP(DefaultCSP, false)(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	policy := w.Header().Get("Content-Security-Policy-Report-Only")
+	if !strings.Contains(policy, "report-uri /csp-report; report-to csp-endpoint") {
+		t.Errorf("report-only policy = %q", policy)
+	}
+	if w.Header().Get("Content-Security-Policy") != "" {
+		t.Error("enforcing header set in report-only mode")
+	}
+	if got := w.Header().Get("Reporting-Endpoints"); got != `csp-endpoint="/csp-report"` {
+		t.Errorf("Reporting-Endpoints = %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	CSP("default-src 'self';", true)(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := w.Header().Get("Content-Security-Policy"); got != "default-src 'self'; report-uri /csp-report; report-to csp-endpoint" {
+		t.Errorf("enforced policy = %q", got)
+	}
+}
@@ -0,0 +1,54 @@
+// Package middleware provides HTTP middleware applied to every request before routing.
+package middleware
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// hopByHopHeaders are meaningful only for a single connection and must not be
+// passed on to handlers (RFC 9110 section 7.6.1)
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// SanitizeHeaders strips hop-by-hop headers before the request reaches any
+// handler.
+//
+// It doesn't check framing, which net/http settles before any middleware
+// runs: requests with conflicting Content-Length values get a 400 and ones
+// with a Transfer-Encoding other than chunked a 501, and a request with both
+// Content-Length and chunked Transfer-Encoding has its body read as chunked
+// and arrives with Content-Length removed (RFC 9112 section 6.3). net/http
+// keeps that last connection open, so a proxy in front that frames by
+// Content-Length must reject such requests itself to rule out smuggling.
+func SanitizeHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stripHopByHop(r.Header)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stripHopByHop removes hop-by-hop headers, including any named in Connection
+func stripHopByHop(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(textproto.CanonicalMIMEHeaderKey(name))
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSanitizeHeaders_Framing checks what net/http guarantees about framing
+// before SanitizeHeaders runs, by sending raw requests to a real listener
+func TestSanitizeHeaders_Framing(t *testing.T) {
+	var seen string
+	server := httptest.NewServer(SanitizeHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen = fmt.Sprintf("length %d, Content-Length %q, body %q", r.ContentLength, r.Header.Values("Content-Length"), body)
+	})))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		request string
+		status  int
+		seen    string // What the handler saw, or "" if it wasn't called
+	}{
+		{
+			name:    "content-length with transfer-encoding",
+			request: "Content-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+			status:  http.StatusOK,
+			seen:    `length -1, Content-Length [], body "abc"`,
+		},
+		{
+			name:    "conflicting content-length values",
+			request: "Content-Length: 4\r\nContent-Length: 5\r\n\r\nabcde",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "repeated content-length",
+			request: "Content-Length: 4\r\nContent-Length: 4\r\n\r\nabcd",
+			status:  http.StatusOK,
+			seen:    `length 4, Content-Length ["4"], body "abcd"`,
+		},
+		{
+			name:    "unsupported transfer-encoding",
+			request: "Transfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+			status:  http.StatusNotImplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = ""
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.WriteString(conn, "POST /kv/x HTTP/1.1\r\nHost: example.com\r\n"+tt.request); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("Reading response: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if seen != tt.seen {
+				t.Errorf("Handler saw %q, want %q", seen, tt.seen)
+			}
+		})
+	}
+}
+
+func TestSanitizeHeaders_StripsHopByHop(t *testing.T) {
+	var seen http.Header
+	handler := SanitizeHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "keep-alive, X-Internal-Secret")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("X-Internal-Secret", "hunter2")
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	for _, name := range []string{"Connection", "Keep-Alive", "Proxy-Authorization", "Upgrade", "X-Internal-Secret"} {
+		if seen.Get(name) != "" {
+			t.Errorf("Expected %s to be stripped, got %q", name, seen.Get(name))
+		}
+	}
+	if seen.Get("Accept") != "application/json" {
+		t.Error("End-to-end headers should be preserved")
+	}
+}
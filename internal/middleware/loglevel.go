@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// logLevels are the levels CycleLogLevel steps through, most verbose first
+var logLevels = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+
+// logLevelStatus is the body of /admin/loglevel requests and responses
+type logLevelStatus struct {
+	Level string `json:"level"`
+}
+
+// SetLogLevel changes level, which the process logger's handler reads on
+// every record, so the change applies to the very next line logged. It is
+// logged at the more severe of the old and new levels, so it always shows.
+func SetLogLevel(ctx context.Context, level *slog.LevelVar, to slog.Level) {
+	from := level.Level()
+	level.Set(to)
+	slog.Log(ctx, max(from, to, slog.LevelInfo), "Log level changed", "audit", true, "level", to.String(), "previous", from.String())
+}
+
+// CycleLogLevel moves level on to the next of debug, info, warn and error,
+// wrapping around, as on SIGUSR1
+func CycleLogLevel(ctx context.Context, level *slog.LevelVar) {
+	next := logLevels[0]
+	for i, l := range logLevels[:len(logLevels)-1] {
+		if level.Level() == l {
+			next = logLevels[i+1]
+		}
+	}
+	SetLogLevel(ctx, level, next)
+}
+
+// HandleLogLevel handles /admin/loglevel: GET reports the log level and
+// POST {"level": "debug" | "info" | "warn" | "error"} changes it. Admin-only.
+func HandleLogLevel(level *slog.LevelVar) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req logLevelStatus
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			var to slog.Level
+			if err := to.UnmarshalText([]byte(strings.TrimSpace(req.Level))); err != nil {
+				http.Error(w, "Level must be debug, info, warn or error", http.StatusBadRequest)
+				return
+			}
+			SetLogLevel(r.Context(), level, to)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logLevelStatus{Level: strings.ToLower(level.Level().String())})
+	}
+}
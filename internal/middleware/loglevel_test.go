@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHandleLogLevel(t *testing.T) {
+	level := new(slog.LevelVar)
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	logger := slog.New(slog.NewTextHandler(&lockedWriter{w: &buf, mu: &mu}, &slog.HandlerOptions{Level: level}))
+	handler := HandleLogLevel(level)
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/loglevel", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodGet, ""); strings.TrimSpace(w.Body.String()) != `{"level":"info"}` {
+		t.Errorf("GET = %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, `{"level":"verbose"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("POST of an unknown level = %d, want 400", w.Code)
+	}
+
+	// Log from several goroutines while the level changes underneath them
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				logger.Debug("noise")
+			}
+		}()
+	}
+	w := serve(http.MethodPost, `{"level":"DEBUG"}`)
+	wg.Wait()
+	if strings.TrimSpace(w.Body.String()) != `{"level":"debug"}` {
+		t.Errorf("POST = %d %s", w.Code, w.Body.String())
+	}
+
+	// The very next line sees the new level
+	mu.Lock()
+	buf.Reset()
+	mu.Unlock()
+	logger.Debug("after")
+	if !strings.Contains(buf.String(), "msg=after") {
+		t.Errorf("Debug line not logged after switching to debug: %q", buf.String())
+	}
+}
+
+func TestCycleLogLevel(t *testing.T) {
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelDebug)
+	want := []slog.Level{slog.LevelInfo, slog.LevelWarn, slog.LevelError, slog.LevelDebug}
+	for _, next := range want {
+		CycleLogLevel(context.Background(), level)
+		if level.Level() != next {
+			t.Errorf("CycleLogLevel moved to %v, want %v", level.Level(), next)
+		}
+	}
+}
+
+// lockedWriter serializes writes, as os.Stdout would for whole lines
+type lockedWriter struct {
+	w  *bytes.Buffer
+	mu *sync.Mutex
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MaintenanceMode is how much of the server is available during maintenance
+type MaintenanceMode int32
+
+const (
+	// MaintenanceOff serves everything
+	MaintenanceOff MaintenanceMode = iota
+	// MaintenanceReadOnly refuses writes but serves reads and sign-ins
+	MaintenanceReadOnly
+	// MaintenanceDown refuses everything but admin, sign-in and health checks
+	MaintenanceDown
+)
+
+var maintenanceModeNames = []string{"off", "read-only", "down"}
+
+func (m MaintenanceMode) String() string {
+	if m < 0 || int(m) >= len(maintenanceModeNames) {
+		return fmt.Sprintf("MaintenanceMode(%d)", m)
+	}
+	return maintenanceModeNames[m]
+}
+
+// ParseMaintenanceMode parses "off", "read-only" or "down"
+func ParseMaintenanceMode(s string) (MaintenanceMode, error) {
+	for i, name := range maintenanceModeNames {
+		if s == name {
+			return MaintenanceMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown maintenance mode %q, expected off, read-only or down", s)
+}
+
+// maintenanceExemptPaths are path prefixes served in any mode, so admins can
+// still sign in and turn maintenance off, and load balancers can see it
+var maintenanceExemptPaths = []string{"/admin/", "/auth/", "/healthz", "/readyz", CSPReportPath}
+
+// Maintenance puts the server into read-only or down mode without a restart.
+// The mode is checked with one atomic load per request.
+type Maintenance struct {
+	mode       atomic.Int32
+	retryAfter time.Duration // Sent in Retry-After with refusals
+}
+
+// NewMaintenance returns a Maintenance that starts off and asks refused
+// clients to retry after retryAfter
+func NewMaintenance(retryAfter time.Duration) *Maintenance {
+	return &Maintenance{retryAfter: retryAfter}
+}
+
+// Mode returns the current mode
+func (m *Maintenance) Mode() MaintenanceMode {
+	return MaintenanceMode(m.mode.Load())
+}
+
+// SetMode switches to mode
+func (m *Maintenance) SetMode(mode MaintenanceMode) {
+	m.mode.Store(int32(mode))
+}
+
+// Wrap refuses requests the current mode doesn't allow with a 503 and a
+// Retry-After header
+func (m *Maintenance) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode := m.Mode()
+		if mode == MaintenanceOff || maintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if mode == MaintenanceReadOnly {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+		if mode == MaintenanceReadOnly {
+			http.Error(w, "Down for maintenance: read-only, try again later", http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, "Down for maintenance, try again later", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// maintenanceExempt reports whether path is served in every mode
+func maintenanceExempt(path string) bool {
+	for _, prefix := range maintenanceExemptPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceStatus is the body of GET and POST /admin/maintenance
+type maintenanceStatus struct {
+	Mode string `json:"mode"`
+}
+
+// HandleMaintenance handles /admin/maintenance: GET reports the mode and
+// POST {"mode": "off" | "read-only" | "down"} changes it. Admin-only.
+func (m *Maintenance) HandleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req maintenanceStatus
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		mode, err := ParseMaintenanceMode(req.Mode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous := m.Mode()
+		m.SetMode(mode)
+		slog.WarnContext(r.Context(), "Maintenance mode changed", "audit", true, "mode", mode.String(), "previous", previous.String())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenanceStatus{Mode: m.Mode().String()})
+}
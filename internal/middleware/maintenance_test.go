@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	m := NewMaintenance(2 * time.Minute)
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	tests := []struct {
+		mode   MaintenanceMode
+		method string
+		path   string
+		status int
+	}{
+		{MaintenanceOff, http.MethodPut, "/kv/user/a@b.c/x", http.StatusOK},
+		{MaintenanceReadOnly, http.MethodGet, "/kv/user/a@b.c/x", http.StatusOK},
+		{MaintenanceReadOnly, http.MethodPut, "/kv/user/a@b.c/x", http.StatusServiceUnavailable},
+		{MaintenanceReadOnly, http.MethodDelete, "/kv/user/a@b.c/x", http.StatusServiceUnavailable},
+		{MaintenanceReadOnly, http.MethodPost, "/kvsync", http.StatusServiceUnavailable},
+		{MaintenanceReadOnly, http.MethodPost, "/admin/maintenance", http.StatusOK},
+		{MaintenanceDown, http.MethodGet, "/kv/user/a@b.c/x", http.StatusServiceUnavailable},
+		{MaintenanceDown, http.MethodGet, "/", http.StatusServiceUnavailable},
+		{MaintenanceDown, http.MethodGet, "/auth/login", http.StatusOK},
+		{MaintenanceDown, http.MethodGet, "/readyz", http.StatusOK},
+		{MaintenanceDown, http.MethodPost, "/admin/maintenance", http.StatusOK},
+	}
+	for _, tt := range tests {
+		m.SetMode(tt.mode)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: %s %s = %d, want %d", tt.mode, tt.method, tt.path, w.Code, tt.status)
+		}
+		if tt.status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "120" {
+			t.Errorf("%s: %s %s: Retry-After = %q, want 120", tt.mode, tt.method, tt.path, w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestHandleMaintenance(t *testing.T) {
+	m := NewMaintenance(time.Minute)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader(`{"mode": "read-only"}`))
+	w := httptest.NewRecorder()
+	m.HandleMaintenance(w, req)
+	if w.Code != http.StatusOK || m.Mode() != MaintenanceReadOnly {
+		t.Fatalf("POST read-only: status %d, mode %s", w.Code, m.Mode())
+	}
+
+	w = httptest.NewRecorder()
+	m.HandleMaintenance(w, httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil))
+	if body := strings.TrimSpace(w.Body.String()); body != `{"mode":"read-only"}` {
+		t.Errorf("GET = %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	m.HandleMaintenance(w, httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader(`{"mode": "sideways"}`)))
+	if w.Code != http.StatusBadRequest || m.Mode() != MaintenanceReadOnly {
+		t.Errorf("POST invalid mode: status %d, mode %s", w.Code, m.Mode())
+	}
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// precompressBrotliLevel is the Brotli level for Precompress. Levels 10 and
+// 11 shave another 10% but are over ten times slower, which on the docs
+// would hold up startup by tens of seconds.
+const precompressBrotliLevel = 9
+
+// precompressedFile is one file of a Precompress file system in each of its
+// encodings. Encodings that wouldn't save anything are nil.
+type precompressedFile struct {
+	contentType string
+	hash        string // Of the unencoded content, for ETags
+	identity    []byte
+	br          []byte
+	gzip        []byte
+}
+
+// precompressed serves files compressed once, up front
+type precompressed struct {
+	files    map[string]*precompressedFile // By slash-separated path
+	fallback http.Handler
+}
+
+// Precompress serves fsys, which must never change, like http.FileServer,
+// except that files of at least minSize bytes are Brotli- and gzip-encoded
+// once, at high levels, rather than on every request. Each encoding is its
+// own representation with its own strong ETag, so conditional and range
+// requests work on whichever one is sent.
+//
+// Anything it doesn't hold, such as directory listings, smaller files and
+// methods other than GET and HEAD, is left to http.FileServer.
+func Precompress(fsys fs.FS, minSize int) (http.Handler, error) {
+	var names []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() >= int64(minSize) && !incompressibleType(mime.TypeByExtension(path.Ext(name))) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Compressing this hard is slow, so use every core
+	p := &precompressed{files: make(map[string]*precompressedFile, len(names)), fallback: http.FileServer(http.FS(fsys))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	work := make(chan string)
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				file, err := precompressFile(fsys, name)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				p.files[name] = file
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return p, nil
+}
+
+// precompressFile reads and encodes one file
+func precompressFile(fsys fs.FS, name string) (*precompressedFile, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	file := &precompressedFile{
+		contentType: mime.TypeByExtension(path.Ext(name)),
+		hash:        hex.EncodeToString(sum[:8]),
+		identity:    data,
+	}
+	if file.contentType == "" {
+		file.contentType = http.DetectContentType(data)
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, precompressBrotliLevel)
+	bw.Write(data)
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	if br.Len() < len(data) {
+		file.br = br.Bytes()
+	}
+
+	var gz bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression) // Only fails for bad levels
+	gw.Write(data)
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	if gz.Len() < len(data) {
+		file.gzip = gz.Bytes()
+	}
+	return file, nil
+}
+
+func (p *precompressed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	file := p.files[name]
+	// http.FileServer redirects .../index.html to .../, so let it
+	if file == nil || strings.HasSuffix(r.URL.Path, "/index.html") ||
+		(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		p.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	var offered []string
+	if file.br != nil {
+		offered = append(offered, "br")
+	}
+	if file.gzip != nil {
+		offered = append(offered, "gzip")
+	}
+	encoding := negotiate(r.Header.Get("Accept-Encoding"), offered...)
+
+	h := w.Header()
+	h.Set("Content-Type", file.contentType)
+	addVary(h)
+	body := file.identity
+	switch encoding {
+	case "br":
+		body = file.br
+	case "gzip":
+		body = file.gzip
+	}
+	if encoding == "" {
+		h.Set("ETag", `"`+file.hash+`"`)
+	} else {
+		h.Set("Content-Encoding", encoding)
+		h.Set("ETag", `"`+file.hash+"-"+encoding+`"`)
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(body))
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestPrecompress(t *testing.T) {
+	page := strings.Repeat("<p>Turtles all the way down.</p>\n", 200)
+	handler, err := Precompress(fstest.MapFS{
+		"docs/turtle.html": {Data: []byte(page)},
+		"docs/index.html":  {Data: []byte(page)},
+		"tiny.txt":         {Data: []byte("tiny")},
+	}, DefaultCompressMinSize)
+	if err != nil {
+		t.Fatalf("Precompress failed: %v", err)
+	}
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		// As in main, behind the dynamic compression, which must leave it alone
+		Compress(DefaultCompressMinSize)(handler).ServeHTTP(w, req)
+		return w
+	}
+
+	etags := map[string]string{}
+	for _, tt := range []struct {
+		acceptEncoding string
+		encoding       string
+		decode         func(io.Reader) io.Reader
+	}{
+		{"gzip, br", "br", func(r io.Reader) io.Reader { return brotli.NewReader(r) }},
+		{"gzip", "gzip", func(r io.Reader) io.Reader { gz, _ := gzip.NewReader(r); return gz }},
+		{"", "", func(r io.Reader) io.Reader { return r }},
+	} {
+		w := serve("/docs/turtle.html", http.Header{"Accept-Encoding": {tt.acceptEncoding}})
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != tt.encoding {
+			t.Fatalf("Accept-Encoding %q: status %d, encoding %q, want %q", tt.acceptEncoding, w.Code, w.Header().Get("Content-Encoding"), tt.encoding)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q", tt.acceptEncoding, got)
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+			t.Errorf("Accept-Encoding %q: Content-Type = %q", tt.acceptEncoding, got)
+		}
+		decoded, _ := io.ReadAll(tt.decode(w.Body))
+		if string(decoded) != page {
+			t.Errorf("Accept-Encoding %q: body does not match", tt.acceptEncoding)
+		}
+		etag := w.Header().Get("ETag")
+		if strings.HasPrefix(etag, "W/") || etags[etag] != "" {
+			t.Errorf("Accept-Encoding %q: ETag %s is weak or shared with %q", tt.acceptEncoding, etag, etags[etag])
+		}
+		etags[etag] = tt.acceptEncoding + " "
+
+		// Revalidation gets a 304 with the same ETag
+		w = serve("/docs/turtle.html", http.Header{"Accept-Encoding": {tt.acceptEncoding}, "If-None-Match": {etag}})
+		if w.Code != http.StatusNotModified || w.Header().Get("ETag") != etag {
+			t.Errorf("Accept-Encoding %q: revalidation status %d, ETag %q", tt.acceptEncoding, w.Code, w.Header().Get("ETag"))
+		}
+	}
+
+	// Ranges are of the encoded bytes
+	full := serve("/docs/turtle.html", http.Header{"Accept-Encoding": {"br"}}).Body.String()
+	w := serve("/docs/turtle.html", http.Header{"Accept-Encoding": {"br"}, "Range": {"bytes=0-9"}})
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "br" || w.Body.String() != full[:10] {
+		t.Errorf("Range: status %d, encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+
+	// Directories serve their index
+	if w := serve("/docs/", http.Header{"Accept-Encoding": {"br"}}); w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("/docs/: status %d, encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+
+	// Small files fall through to the file server
+	if w := serve("/tiny.txt", http.Header{"Accept-Encoding": {"br"}}); w.Code != http.StatusOK || w.Body.String() != "tiny" {
+		t.Errorf("/tiny.txt: status %d, body %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panicking handler into a logged error and a clean 500,
+// instead of a dropped connection. The log line carries the stack trace and,
+// via the request context, the request ID. http.ErrAbortHandler is re-panicked
+// so deliberate aborts still work.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &headerRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			slog.ErrorContext(r.Context(), "Panic in handler",
+				"panic", v,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"stack", string(debug.Stack()),
+			)
+
+			// Too late for a clean error if the response has started
+			if rec.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// headerRecorder notes whether a response has been started
+type headerRecorder struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rec *headerRecorder) WriteHeader(status int) {
+	rec.wroteHeader = true
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *headerRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *headerRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
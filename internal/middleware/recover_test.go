@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover_PanicBecomes500(t *testing.T) {
+	logs := captureLogs(t)
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"] = 1 // Assignment to a nil map panics
+	})
+	handler := RequestID(DefaultRequestIDHeader, false)(Recover(panicking))
+
+	w := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				t.Fatalf("panic propagated past Recover: %v", v)
+			}
+		}()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/kv/bad", nil))
+	}()
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "nil map") {
+		t.Error("panic details leaked to the client")
+	}
+
+	out := logs.String()
+	for _, want := range []string{
+		`msg="Panic in handler"`,
+		"nil map",
+		"path=/kv/bad",
+		"recover_test.go", // The stack trace points at the culprit
+		"request_id=" + w.Header().Get(DefaultRequestIDHeader),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRecover_AbortHandlerStillAborts(t *testing.T) {
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
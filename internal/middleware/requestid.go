@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// DefaultRequestIDHeader carries request IDs in and out
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds inbound IDs so they can't bloat every log line
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID gives every request an ID, stored in its context for
+// RequestIDFromContext and echoed in the response under header. If
+// trustIncoming is set, a well-formed ID already on the request (from a
+// proxy or client) is kept; otherwise a new one is always generated, so a
+// client can't forge IDs in the logs.
+func RequestID(header string, trustIncoming bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := ""
+			if trustIncoming {
+				if incoming := r.Header.Get(header); validRequestID(incoming) {
+					id = incoming
+				}
+			}
+			if id == "" {
+				id = newRequestID()
+			}
+
+			w.Header().Set(header, id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+// RequestIDFromContext returns the request's ID, or "" outside a request
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a short random ID for correlating a request's log lines
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short IDs of URL-safe characters, as proxies generate
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDLogHandler adds a request_id attribute to records logged with a
+// request's context, e.g. via slog.ErrorContext(r.Context(), ...)
+type requestIDLogHandler struct {
+	slog.Handler
+}
+
+// NewRequestIDLogHandler wraps h so that log lines from a request carry its ID
+func NewRequestIDLogHandler(h slog.Handler) slog.Handler {
+	return requestIDLogHandler{h}
+}
+
+func (h requestIDLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDLogHandler) WithGroup(name string) slog.Handler {
+	return requestIDLogHandler{h.Handler.WithGroup(name)}
+}
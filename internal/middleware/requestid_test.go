@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveRequestID runs a request through RequestID, returning the ID the
+// handler saw and the recorded response
+func serveRequestID(t *testing.T, trust bool, incoming string) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+	var seen string
+	handler := RequestID(DefaultRequestIDHeader, trust)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if incoming != "" {
+		req.Header.Set(DefaultRequestIDHeader, incoming)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return seen, w
+}
+
+func TestRequestID_GeneratesAndEchoes(t *testing.T) {
+	id, w := serveRequestID(t, false, "")
+	if id == "" {
+		t.Fatal("no request ID in context")
+	}
+	if got := w.Header().Get(DefaultRequestIDHeader); got != id {
+		t.Errorf("response header = %q, want %q", got, id)
+	}
+
+	other, _ := serveRequestID(t, false, "")
+	if other == id {
+		t.Errorf("two requests got the same ID %q", id)
+	}
+}
+
+func TestRequestID_IncomingTrust(t *testing.T) {
+	tests := []struct {
+		name     string
+		trust    bool
+		incoming string
+		keep     bool
+	}{
+		{"trusted", true, "lb-1234abcd", true},
+		{"untrusted", false, "lb-1234abcd", false},
+		{"trusted but malformed", true, "bad id\nforged=1", false},
+		{"trusted but too long", true, strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, w := serveRequestID(t, tt.trust, tt.incoming)
+			if (id == tt.incoming) != tt.keep {
+				t.Errorf("ID = %q, incoming %q, want kept = %v", id, tt.incoming, tt.keep)
+			}
+			if got := w.Header().Get(DefaultRequestIDHeader); got != id {
+				t.Errorf("response header = %q, want %q", got, id)
+			}
+		})
+	}
+}
+
+func TestRequestIDFromContext_Empty(t *testing.T) {
+	if id := RequestIDFromContext(context.Background()); id != "" {
+		t.Errorf("RequestIDFromContext = %q, want empty", id)
+	}
+}
+
+func TestRequestIDLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewRequestIDLogHandler(slog.NewTextHandler(&buf, nil))).With("component", "kv")
+
+	handler := RequestID(DefaultRequestIDHeader, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "inside request")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	logger.Info("outside request")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), buf.String())
+	}
+	id := w.Header().Get(DefaultRequestIDHeader)
+	if !strings.Contains(lines[0], "request_id="+id) || !strings.Contains(lines[0], "component=kv") {
+		t.Errorf("request log line missing attributes: %s", lines[0])
+	}
+	if strings.Contains(lines[1], "request_id") {
+		t.Errorf("log line outside a request has a request_id: %s", lines[1])
+	}
+}
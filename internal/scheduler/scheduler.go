@@ -0,0 +1,166 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultJitter is the fraction of its interval by which a task's runs are
+// randomly moved earlier or later, so tasks with equal intervals drift apart
+const DefaultJitter = 0.1
+
+// Task is a background job run periodically by a Scheduler
+type Task struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// TaskStats describes a task's most recent run
+type TaskStats struct {
+	Name         string        `json:"name"`
+	Interval     time.Duration `json:"interval"`
+	Runs         int           `json:"runs"`
+	Running      bool          `json:"running"`
+	LastRun      time.Time     `json:"lastRun,omitzero"`
+	LastDuration time.Duration `json:"lastDuration"`
+	LastError    string        `json:"lastError,omitempty"`
+}
+
+// Scheduler runs maintenance tasks at their intervals, with no more than a
+// fixed number running at once. A task whose turn comes while the cap is
+// reached waits for a slot rather than being skipped.
+type Scheduler struct {
+	tasks  []Task
+	slots  chan struct{}
+	jitter float64
+
+	mu    sync.Mutex
+	stats map[string]*TaskStats
+
+	wg sync.WaitGroup
+}
+
+// New creates a scheduler that runs at most maxConcurrent tasks at a time
+func New(maxConcurrent int) *Scheduler {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Scheduler{
+		slots:  make(chan struct{}, maxConcurrent),
+		jitter: DefaultJitter,
+		stats:  make(map[string]*TaskStats),
+	}
+}
+
+// Add registers a task. Tasks must be added before Start.
+func (s *Scheduler) Add(task Task) {
+	s.tasks = append(s.tasks, task)
+	s.stats[task.Name] = &TaskStats{Name: task.Name, Interval: task.Interval}
+}
+
+// Start runs every task until ctx is cancelled. Each task's first run comes
+// at a random point within its first interval, so a restart doesn't fire
+// them all together.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, task := range s.tasks {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, task, time.Duration(rand.Int64N(int64(task.Interval)+1)))
+		}()
+	}
+}
+
+// Wait blocks until every task has stopped after Start's context is cancelled
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, task Task, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case s.slots <- struct{}{}:
+		}
+		s.run(ctx, task)
+		<-s.slots
+
+		timer.Reset(s.jittered(task.Interval))
+	}
+}
+
+// run runs task once and records how it went
+func (s *Scheduler) run(ctx context.Context, task Task) {
+	s.mu.Lock()
+	s.stats[task.Name].Running = true
+	s.mu.Unlock()
+
+	start := time.Now()
+	err := task.Run(ctx)
+	duration := time.Since(start)
+
+	s.mu.Lock()
+	stats := s.stats[task.Name]
+	stats.Running = false
+	stats.Runs++
+	stats.LastRun = start
+	stats.LastDuration = duration
+	stats.LastError = ""
+	if err != nil {
+		stats.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Maintenance task failed", "task", task.Name, "duration", duration, "error", err)
+		return
+	}
+	slog.Debug("Maintenance task finished", "task", task.Name, "duration", duration)
+}
+
+// jittered returns interval moved randomly by up to the scheduler's jitter
+func (s *Scheduler) jittered(interval time.Duration) time.Duration {
+	spread := int64(float64(interval) * s.jitter)
+	if spread <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int64N(2*spread+1)-spread)
+}
+
+// Stats returns a snapshot of every task's stats, in the order tasks were added
+func (s *Scheduler) Stats() []TaskStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]TaskStats, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		stats = append(stats, *s.stats[task.Name])
+	}
+	return stats
+}
+
+// HandleStats serves task stats as JSON (GET)
+func (s *Scheduler) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"tasks": s.Stats()})
+}
@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler_ConcurrencyCap(t *testing.T) {
+	var running, maxRunning, runs atomic.Int32
+	work := func(ctx context.Context) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		runs.Add(1)
+		return nil
+	}
+
+	s := New(1)
+	s.Add(Task{Name: "a", Interval: time.Millisecond, Run: work})
+	s.Add(Task{Name: "b", Interval: time.Millisecond, Run: work})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for runs.Load() < 10 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	s.Wait()
+
+	if runs.Load() < 10 {
+		t.Fatalf("Expected at least 10 runs, got %d", runs.Load())
+	}
+	if maxRunning.Load() != 1 {
+		t.Errorf("Expected at most 1 task running at once, saw %d", maxRunning.Load())
+	}
+	for _, stats := range s.Stats() {
+		if stats.Runs == 0 || stats.LastRun.IsZero() || stats.Running {
+			t.Errorf("Unexpected stats after runs: %+v", stats)
+		}
+	}
+}
+
+func TestScheduler_RecordsErrors(t *testing.T) {
+	done := make(chan struct{})
+	s := New(1)
+	s.Add(Task{Name: "failing", Interval: time.Millisecond, Run: func(ctx context.Context) error {
+		select {
+		case done <- struct{}{}:
+		default:
+		}
+		return errors.New("disk on fire")
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	<-done
+	cancel()
+	s.Wait()
+
+	stats := s.Stats()
+	if len(stats) != 1 || stats[0].LastError != "disk on fire" {
+		t.Errorf("Expected last error to be recorded, got %+v", stats)
+	}
+}
+
+func TestScheduler_Jitter(t *testing.T) {
+	s := New(1)
+	for range 100 {
+		d := s.jittered(time.Second)
+		if d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Fatalf("Jittered interval %v outside ±10%%", d)
+		}
+	}
+}
@@ -3,18 +3,34 @@ package main
 import (
 	"context"
 	"embed"
+	"encoding/base64"
+	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/zellyn/trifle/internal/auth"
 	"github.com/zellyn/trifle/internal/kv"
+	"github.com/zellyn/trifle/internal/middleware"
+	"github.com/zellyn/trifle/internal/scheduler"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 //go:embed web
@@ -24,43 +40,171 @@ var webFS embed.FS
 var staticFS embed.FS
 
 func main() {
-	// Set up structured logging
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	// Flags override the environment, which overrides the defaults, so several
+	// instances can run side by side on one machine
+	dataFlag := flag.String("data", "", "data directory (overrides DATA_DIR; default ./data)")
+	portFlag := flag.String("port", "", "port to listen on (overrides PORT; default 3000, or 443 with TLS)")
+	redirectFlag := flag.String("redirect-url", "", "OAuth redirect URL (overrides OAUTH_REDIRECT_URL)")
+	compactFlag := flag.Bool("compact", false, "clean up abandoned temporary files and orphaned metadata in the data directory, then exit")
+	flag.Parse()
+
+	// Set up structured logging. The level can be changed while running, by
+	// an admin or SIGUSR1 (below).
+	logLevel := new(slog.LevelVar)
+	logger, err := newLogger(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"), logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+		os.Exit(1)
+	}
 	slog.SetDefault(logger)
 
-	// Get port from environment or default to 3000
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
+	// Data directory for flat-file storage
+	dataDir := firstNonEmpty(*dataFlag, os.Getenv("DATA_DIR"), "./data")
+
+	// Optional TLS, for running without a reverse proxy: either a certificate
+	// and key from files, or certificates obtained from Let's Encrypt
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		slog.Error("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		os.Exit(1)
+	}
+	var autocertDomains []string
+	if domains := os.Getenv("TLS_AUTOCERT_DOMAIN"); domains != "" {
+		if tlsCertFile != "" {
+			slog.Error("Set either TLS_CERT_FILE/TLS_KEY_FILE or TLS_AUTOCERT_DOMAIN, not both")
+			os.Exit(1)
+		}
+		for _, domain := range strings.Split(domains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				autocertDomains = append(autocertDomains, domain)
+			}
+		}
+	}
+	useTLS := tlsCertFile != "" || len(autocertDomains) > 0
+
+	// Get port from flag or environment, or default to 3000 (443 when serving TLS)
+	defaultPort := "3000"
+	if useTLS {
+		defaultPort = "443"
 	}
+	port := firstNonEmpty(*portFlag, os.Getenv("PORT"), defaultPort)
 
 	// Get OAuth redirect URL (used to determine if we're in production)
-	redirectURL := os.Getenv("OAUTH_REDIRECT_URL")
+	redirectURL := firstNonEmpty(*redirectFlag, os.Getenv("OAUTH_REDIRECT_URL"))
 	if redirectURL == "" {
-		// Default to localhost if not specified
-		redirectURL = fmt.Sprintf("http://localhost:%s/auth/callback", port)
+		// Default to this server if not specified
+		switch {
+		case len(autocertDomains) > 0:
+			redirectURL = fmt.Sprintf("https://%s/auth/callback", autocertDomains[0])
+		case useTLS:
+			redirectURL = fmt.Sprintf("https://localhost:%s/auth/callback", port)
+		default:
+			redirectURL = fmt.Sprintf("http://localhost:%s/auth/callback", port)
+		}
 	}
 
 	// Determine if we're in production based on redirect URL scheme
 	isProduction := strings.HasPrefix(redirectURL, "https://")
-
-	// Data directory for flat-file storage
-	dataDir := "./data"
+	if useTLS && !isProduction {
+		slog.Warn("Serving TLS but OAUTH_REDIRECT_URL is not https; session cookies will not be marked secure", "redirect_url", redirectURL)
+	}
 
 	// Initialize KV store
 	kvStore, err2 := kv.NewStore(dataDir)
 	if err2 != nil {
-		slog.Error("Failed to initialize KV store", "error", err2)
+		slog.Error("Failed to initialize KV store", "dir", dataDir, "error", err2)
+		os.Exit(1)
+	}
+	if err := kvStore.CheckWritable(); err != nil {
+		slog.Error("Data directory is not writable", "dir", dataDir, "error", err)
 		os.Exit(1)
 	}
 
-	slog.Info("Storage initialized successfully", "dataDir", dataDir)
+	// Optionally verify values on every read (costs a hash per read)
+	if os.Getenv("KV_VERIFY_CHECKSUMS") == "true" {
+		kvStore.SetVerifyChecksums(true)
+		slog.Info("KV checksum verification enabled")
+	}
+
+	// Permissions for stored files and directories, e.g. 0600/0700 to restrict data to the service user
+	fileMode, dirMode := kv.DefaultFileMode, kv.DefaultDirMode
+	for _, setting := range []struct {
+		env  string
+		mode *os.FileMode
+	}{
+		{"KV_FILE_MODE", &fileMode},
+		{"KV_DIR_MODE", &dirMode},
+	} {
+		value := os.Getenv(setting.env)
+		if value == "" {
+			continue
+		}
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || mode > 0777 {
+			slog.Error("Invalid permission mode, expected octal like 0640", "env", setting.env, "value", value)
+			os.Exit(1)
+		}
+		*setting.mode = os.FileMode(mode)
+	}
+	kvStore.SetPermissions(fileMode, dirMode)
+
+	// Audit log of every KV mutation, kept beside the data but outside any
+	// user's keys
+	auditMaxSize := int64(kv.DefaultAuditMaxSize)
+	if sizeStr := os.Getenv("AUDIT_LOG_MAX_SIZE"); sizeStr != "" {
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || size <= 0 {
+			slog.Error("Invalid AUDIT_LOG_MAX_SIZE", "value", sizeStr, "error", err)
+			os.Exit(1)
+		}
+		auditMaxSize = size
+	}
+	auditLog, err := kv.OpenAuditLog(filepath.Join(dataDir, ".audit", "kv.log"), auditMaxSize)
+	if err != nil {
+		slog.Error("Failed to open audit log", "error", err)
+		os.Exit(1)
+	}
+	kvStore.SetAuditLog(auditLog)
+
+	// Outgoing webhooks, e.g. so a grading system hears about a user's changes
+	var webhooks *kv.Webhooks
+	if webhooksPath := os.Getenv("WEBHOOKS_FILE"); webhooksPath != "" {
+		webhooks, err = kv.LoadWebhooks(webhooksPath, []byte(os.Getenv("WEBHOOK_SECRET")))
+		if err != nil {
+			slog.Error("Failed to load webhooks", "error", err, "path", webhooksPath)
+			os.Exit(1)
+		}
+		kvStore.SetWebhooks(webhooks)
+		slog.Info("Webhooks enabled", "path", webhooksPath)
+	}
+
+	slog.Info("Storage initialized successfully", "dataDir", dataDir, "fileMode", fileMode, "dirMode", dirMode)
+
+	if *compactFlag {
+		result, err := kvStore.Compact()
+		auditLog.Close()
+		if err != nil {
+			slog.Error("Compaction failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Store compacted", "temp_files", result.TempFiles, "orphaned_metadata", result.OrphanedMetadata, "orphaned_shares", result.OrphanedShares)
+		return
+	}
 
 	// Initialize session manager (for OAuth)
 	sessionMgr := auth.NewSessionManager(isProduction)
 
+	// Tolerate clock skew in session and token expiry checks
+	if leewayStr := os.Getenv("CLOCK_SKEW_LEEWAY"); leewayStr != "" {
+		leeway, err := time.ParseDuration(leewayStr)
+		if err != nil || leeway < 0 {
+			slog.Error("Invalid CLOCK_SKEW_LEEWAY", "value", leewayStr, "error", err)
+			os.Exit(1)
+		}
+		sessionMgr.SetClockSkewLeeway(leeway)
+	}
+
 	// Get OAuth credentials
 	clientID, clientSecret, err3 := auth.GetOAuthCredentials()
 	if err3 != nil {
@@ -69,15 +213,46 @@ func main() {
 	}
 
 	// Load email allowlist
-	allowlistPath := fmt.Sprintf("%s/allowlist.txt", dataDir)
+	allowlistPath := filepath.Join(dataDir, "allowlist.txt")
 	allowlist, err4 := auth.NewAllowlist(allowlistPath)
 	if err4 != nil {
 		slog.Error("Failed to load allowlist", "error", err4, "path", allowlistPath)
 		os.Exit(1)
 	}
 
-	// Initialize OAuth config
-	oauthConfig := auth.NewOAuthConfig(clientID, clientSecret, redirectURL, sessionMgr, allowlist)
+	// Initialize OAuth config. OAUTH_SCOPES (space- or comma-separated)
+	// replaces the default email and profile scopes; add offline_access to
+	// keep refresh tokens.
+	oauthScopes := strings.FieldsFunc(os.Getenv("OAUTH_SCOPES"), func(r rune) bool { return r == ',' || r == ' ' })
+	oauthConfig := auth.NewOAuthConfig(clientID, clientSecret, redirectURL, sessionMgr, allowlist, oauthScopes)
+	if logoutRedirect := os.Getenv("LOGOUT_REDIRECT"); logoutRedirect != "" {
+		if !auth.SafeRedirectPath(logoutRedirect) {
+			slog.Error("Invalid LOGOUT_REDIRECT, must be a path on this site such as /learn.html", "value", logoutRedirect)
+			os.Exit(1)
+		}
+		oauthConfig.LogoutRedirect = logoutRedirect
+	}
+
+	// Optional TOTP second factor. Secrets are encrypted at rest, so without
+	// the key enrolled users couldn't sign in; refuse to start rather than
+	// quietly skip their second factor.
+	totpPath := filepath.Join(dataDir, ".totp", "secrets.json")
+	var totpStore *auth.TOTPStore
+	if keyStr := os.Getenv("TOTP_ENCRYPTION_KEY"); keyStr != "" {
+		key, err := base64.StdEncoding.DecodeString(keyStr)
+		if err == nil {
+			totpStore, err = auth.OpenTOTPStore(totpPath, key, "Trifle")
+		}
+		if err != nil {
+			slog.Error("Invalid TOTP_ENCRYPTION_KEY or secrets", "error", err, "path", totpPath)
+			os.Exit(1)
+		}
+		oauthConfig.TOTP = totpStore
+		slog.Info("TOTP two-factor authentication enabled")
+	} else if _, err := os.Stat(totpPath); err == nil {
+		slog.Error("TOTP secrets exist but TOTP_ENCRYPTION_KEY is not set", "path", totpPath)
+		os.Exit(1)
+	}
 
 	// Set up web filesystem
 	webContent, err5 := fs.Sub(webFS, "web")
@@ -98,24 +273,186 @@ func main() {
 	mux.HandleFunc("/auth/callback", oauthConfig.HandleCallback)
 	mux.HandleFunc("/auth/logout", oauthConfig.HandleLogout)
 	mux.HandleFunc("/api/whoami", auth.HandleWhoAmI(sessionMgr))
+	mux.HandleFunc("/auth/sessions", auth.HandleSessions(sessionMgr))
+	mux.HandleFunc("/auth/sessions/", auth.HandleSessions(sessionMgr))
+	if totpStore != nil {
+		totp := &auth.TOTPHandler{SessionMgr: sessionMgr, Store: totpStore}
+		mux.HandleFunc("/auth/totp/enroll", totp.HandleEnroll)
+		mux.HandleFunc("/auth/totp/confirm", totp.HandleConfirm)
+		mux.HandleFunc("/auth/totp/verify", totp.HandleVerify)
+		mux.HandleFunc("/auth/totp", totp.HandleDisable)
+	}
+
+	// Admins come from ADMIN_EMAILS and admin: lines in the allowlist
+	admins := auth.NewAdmins(strings.Split(os.Getenv("ADMIN_EMAILS"), ","))
+	admins.SetAllowlist(allowlist)
+
+	// Read-only, audited impersonation for support
+	impersonation := &auth.ImpersonationHandler{SessionMgr: sessionMgr, Admins: admins}
+	mux.HandleFunc("/admin/impersonate", impersonation.HandleImpersonate)
+	mux.HandleFunc("/admin/stop-impersonate", impersonation.HandleStopImpersonate)
+
+	// Rotate OAuth credentials without a restart (also on SIGHUP, below)
+	mux.HandleFunc("/admin/reload-oauth", oauthConfig.HandleReload(admins))
+
+	// Re-read the allowlist after editing it
+	mux.HandleFunc("/admin/reload-allowlist", allowlist.HandleReload(sessionMgr, admins))
 
 	// KV API handlers (require authentication)
 	kvHandlers := kv.NewHandlers(kvStore)
 
+	// Per-user write rate limit, to stop a runaway client from hammering the
+	// disk, and a separate one for reads, usually much higher
+	if limit, ok := rateLimitEnv("KV_WRITE_RATE", "KV_WRITE_BURST"); ok {
+		kvHandlers.SetWriteLimit(limit)
+		slog.Info("KV write rate limit enabled", "rate", limit.Rate, "burst", limit.Burst)
+	}
+	if limit, ok := rateLimitEnv("KV_READ_RATE", "KV_READ_BURST"); ok {
+		kvHandlers.SetReadLimit(limit)
+		slog.Info("KV read rate limit enabled", "rate", limit.Rate, "burst", limit.Burst)
+	}
+
+	// Background maintenance runs on a shared scheduler, capped so jobs don't
+	// all hit the disk at once
+	maxMaintenance := 1
+	if concurrencyStr := os.Getenv("MAINTENANCE_CONCURRENCY"); concurrencyStr != "" {
+		n, err := strconv.Atoi(concurrencyStr)
+		if err != nil || n < 1 {
+			slog.Error("Invalid MAINTENANCE_CONCURRENCY", "value", concurrencyStr, "error", err)
+			os.Exit(1)
+		}
+		maxMaintenance = n
+	}
+	maintenance := scheduler.New(maxMaintenance)
+	maintenance.Add(scheduler.Task{
+		Name:     "write-limit-prune",
+		Interval: durationEnv("WRITE_LIMIT_PRUNE_INTERVAL", 10*time.Minute),
+		Run:      kvHandlers.PruneRateLimits,
+	})
+	maintenance.Add(scheduler.Task{
+		Name:     "session-sweep",
+		Interval: durationEnv("SESSION_SWEEP_INTERVAL", time.Hour),
+		Run:      sessionMgr.SweepSessions,
+	})
+	maintenance.Add(scheduler.Task{
+		Name:     "usage-stats",
+		Interval: durationEnv("USAGE_STATS_INTERVAL", 15*time.Minute),
+		Run:      kvStore.RefreshUsage,
+	})
+	if oauthConfig.Offline() {
+		maintenance.Add(scheduler.Task{
+			Name:     "oauth-refresh",
+			Interval: durationEnv("OAUTH_REFRESH_INTERVAL", 5*time.Minute),
+			Run:      oauthConfig.RefreshTokens,
+		})
+	}
+	maintenance.Add(scheduler.Task{
+		Name:     "compact",
+		Interval: durationEnv("COMPACT_INTERVAL", 24*time.Hour),
+		Run:      kvStore.CompactTask,
+	})
+
+	// What a client may store. Uploads are streamed to disk, so the largest
+	// value bounds disk use rather than memory; per-user quotas are off
+	// unless set.
+	kvLimits := kv.Limits{
+		MaxValueSize:   sizeEnv("KV_MAX_VALUE_SIZE", kv.DefaultLimits.MaxValueSize),
+		MaxKeysPerUser: sizeEnv("KV_MAX_KEYS_PER_USER", kv.DefaultLimits.MaxKeysPerUser),
+		QuotaBytes:     sizeEnv("KV_QUOTA_BYTES", kv.DefaultLimits.QuotaBytes),
+	}
+	kvHandlers.SetLimits(kvLimits)
+
+	// JSON Schemas that values under some key prefixes must match. Opt-in:
+	// without KV_SCHEMAS, values are stored as-is.
+	if schemasPath := os.Getenv("KV_SCHEMAS"); schemasPath != "" {
+		schemas, err := kv.LoadSchemas(schemasPath)
+		if err != nil {
+			slog.Error("Invalid KV_SCHEMAS", "path", schemasPath, "error", err)
+			os.Exit(1)
+		}
+		kvHandlers.SetSchemas(schemas)
+		slog.Info("KV schemas loaded", "path", schemasPath, "prefixes", schemas.Prefixes())
+	}
+
 	// Create session adapter for KV middleware
-	kvSessionAdapter := kv.NewSessionManagerAdapter(func(r *http.Request) (string, bool, error) {
-		session, err := sessionMgr.GetSession(r)
+	kvSessionAdapter := kv.NewSessionManagerAdapter(func(r *http.Request) (kv.Session, error) {
+		session, err := sessionMgr.GetAPISession(r)
 		if err != nil {
-			return "", false, err
+			return nil, err
 		}
-		return session.Email, session.Authenticated, nil
+		return kv.NewSessionAdapter(session.Email, session.Authenticated, session.ImpersonatedBy), nil
 	})
 
 	requireAuth := kv.RequireAuth(kvSessionAdapter)
 
-	// KV endpoints
-	mux.HandleFunc("/kv/", requireAuth(kvHandlers.HandleKV))
-	mux.HandleFunc("/kvlist/", requireAuth(kvHandlers.HandleList))
+	// Server timeouts. ReadHeaderTimeout bounds how long a client may dribble
+	// its headers (slowloris); ReadTimeout and WriteTimeout cover whole
+	// request and response bodies, so they must allow the largest upload or
+	// download on a slow connection.
+	readHeaderTimeout := durationEnv("HTTP_READ_HEADER_TIMEOUT", 10*time.Second)
+	readTimeout := durationEnv("HTTP_READ_TIMEOUT", 15*time.Second)
+	writeTimeout := durationEnv("HTTP_WRITE_TIMEOUT", 15*time.Second)
+	idleTimeout := durationEnv("HTTP_IDLE_TIMEOUT", 60*time.Second)
+	if readHeaderTimeout > readTimeout {
+		slog.Warn("HTTP_READ_HEADER_TIMEOUT exceeds HTTP_READ_TIMEOUT, which bounds headers too", "read_header_timeout", readHeaderTimeout, "read_timeout", readTimeout)
+	}
+
+	// Per-route budgets for producing a response, so one stuck KV operation
+	// can't hold a connection for long, while syncs, scans and the like that
+	// legitimately run long get more than the server-wide write timeout
+	fastTimeout := durationEnv("ROUTE_TIMEOUT", 10*time.Second)
+	slowTimeout := durationEnv("SLOW_ROUTE_TIMEOUT", 2*time.Minute)
+	withTimeout := routeTimeouts(writeTimeout)
+	withDeadline := routeDeadlines(readTimeout, writeTimeout)
+
+	// Admin-only operational stats, such as when maintenance tasks last ran
+	requireAdmin := auth.RequireAdmin(sessionMgr, admins)
+	mux.HandleFunc("/admin/stats", requireAdmin(maintenance.HandleStats))
+	// Read-only or down for maintenance, e.g. during migrations, without a restart
+	maintenanceMode := middleware.NewMaintenance(durationEnv("MAINTENANCE_RETRY_AFTER", 5*time.Minute))
+	if value := os.Getenv("MAINTENANCE_MODE"); value != "" {
+		mode, err := middleware.ParseMaintenanceMode(value)
+		if err != nil {
+			slog.Error("Invalid MAINTENANCE_MODE", "error", err)
+			os.Exit(1)
+		}
+		maintenanceMode.SetMode(mode)
+		slog.Warn("Starting in maintenance mode", "mode", mode.String())
+	}
+	mux.HandleFunc("/admin/maintenance", requireAdmin(maintenanceMode.HandleMaintenance))
+	mux.HandleFunc("/admin/loglevel", requireAdmin(middleware.HandleLogLevel(logLevel)))
+
+	// Dashboard over the admin endpoints above. It lives in web/admin/, which
+	// the file server mustn't serve to everyone.
+	mux.HandleFunc("/admin", auth.RequireAdminPage(sessionMgr, admins)(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, webContent, "admin/index.html")
+	}))
+	mux.Handle("/admin/", http.NotFoundHandler())
+	mux.HandleFunc("/admin/compact", withTimeout(slowTimeout, requireAdmin(kvHandlers.HandleCompact)))
+	mux.HandleFunc("/admin/verify", withTimeout(slowTimeout, requireAdmin(kvHandlers.HandleVerify)))
+	mux.HandleFunc("/admin/reload-schemas", requireAdmin(kvHandlers.HandleReloadSchemas))
+	mux.HandleFunc("/kvaudit", withTimeout(fastTimeout, requireAdmin(auditLog.HandleAudit)))
+	mux.HandleFunc("/kvstats", withTimeout(slowTimeout, requireAdmin(kvHandlers.HandleUsage)))
+
+	// KV endpoints. Values stream to and from disk, so the routes serving
+	// them use withDeadline, which doesn't buffer the response.
+	mux.HandleFunc("/kv/", withDeadline(fastTimeout, requireAuth(kvHandlers.HandleKV)))
+	mux.HandleFunc("/kvlist/", withDeadline(fastTimeout, requireAuth(kvHandlers.HandleList)))
+	mux.HandleFunc("/kvshare/", withTimeout(fastTimeout, requireAuth(kvHandlers.HandleShare)))
+	mux.HandleFunc("/kvsync", withTimeout(slowTimeout, requireAuth(kvHandlers.HandleSync)))
+	mux.HandleFunc("/kvimports/", withTimeout(slowTimeout, requireAuth(kvHandlers.HandleImports)))
+	// Not under withTimeout, which buffers: exports stream, however long they take
+	mux.HandleFunc("/kvexport", requireAuth(kvHandlers.HandleExport))
+	mux.HandleFunc("/kvgraph", withTimeout(slowTimeout, requireAuth(kvHandlers.HandleGraph)))
+
+	// Delete the signed-in user's account and all their data
+	mux.HandleFunc("/api/account", withTimeout(slowTimeout, requireAuth(kvHandlers.HandleDeleteAccount(sessionMgr.DestroyUser))))
+
+	// Publicly shared keys, readable without logging in
+	mux.HandleFunc("/public/", withDeadline(fastTimeout, kvHandlers.HandlePublic))
+
+	// Client-facing server configuration (no auth, describes server behavior only)
+	mux.HandleFunc("/api/config", kvHandlers.HandleConfig)
 
 	// Serve static files from embedded web directory
 	mux.Handle("/css/", http.FileServer(http.FS(webContent)))
@@ -127,27 +464,175 @@ func main() {
 		slog.Error("Failed to get static subdirectory", "error", err6)
 		os.Exit(1)
 	}
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticContent))))
+	// The docs never change while running, so they're compressed once here
+	// rather than on every request
+	staticHandler, err := middleware.Precompress(staticContent, middleware.DefaultCompressMinSize)
+	if err != nil {
+		slog.Error("Failed to compress static files", "error", err)
+		os.Exit(1)
+	}
+	mux.Handle("/static/", http.StripPrefix("/static/", staticHandler))
+
+	// docgen writes robots.txt next to the docs' sitemap when given a base URL
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, staticContent, "docs/robots.txt")
+	})
+
+	// Load balancer probes: liveness is unconditional, readiness needs working
+	// storage and flips off while shutting down so traffic drains first
+	probes := &healthProbes{store: kvStore, allowlist: allowlist, maintenance: maintenanceMode}
+	mux.HandleFunc("/healthz", probes.handleLiveness)
+	mux.HandleFunc("/readyz", probes.handleReadiness)
+
+	// Content Security Policy. Report-only unless CSP_ENFORCE=true, so the
+	// policy can be tuned from violation reports without breaking Pyodide.
+	cspPolicy := middleware.DefaultCSP
+	if policy := os.Getenv("CSP_POLICY"); policy != "" {
+		cspPolicy = policy
+	}
+	csp := middleware.CSP(cspPolicy, os.Getenv("CSP_ENFORCE") == "true")
+	mux.Handle(middleware.CSPReportPath, middleware.NewCSPReportHandler(20, time.Minute))
+
+	// Cap every request body beneath the KV value checks. Imports stream
+	// many values, so they get their own limit, and if values may be larger
+	// than the global cap the KV routes enforce their own limit instead, so
+	// clients are told which one they hit.
+	maxBodySize := sizeEnv("MAX_BODY_SIZE", middleware.DefaultMaxBodySize)
+	bodyLimit := middleware.NewBodyLimit(maxBodySize)
+	bodyLimit.Allow("/kvexport", sizeEnv("MAX_IMPORT_SIZE", 1<<30))
+	if maxBodySize > 0 && (kvLimits.MaxValueSize == 0 || kvLimits.MaxValueSize >= maxBodySize) {
+		bodyLimit.Allow("/kv/", 0)
+		bodyLimit.Allow("/kvsync", 0)
+	}
+
+	// Request IDs correlate a request's log lines and are echoed to the client.
+	// Only trust inbound IDs when a proxy in front sets them.
+	requestIDHeader := middleware.DefaultRequestIDHeader
+	if header := os.Getenv("REQUEST_ID_HEADER"); header != "" {
+		requestIDHeader = header
+	}
+	requestID := middleware.RequestID(requestIDHeader, os.Getenv("TRUST_REQUEST_ID") == "true")
+
+	// Client IPs come from X-Forwarded-For or X-Real-IP only when the peer is
+	// one of TRUSTED_PROXIES; otherwise anyone could claim any address
+	trustedProxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		slog.Error("Invalid TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
+	clientIP := middleware.ClientIP(trustedProxies)
+
+	// Compress docs pages, scripts and JSON listings for clients that accept it
+	compress := middleware.Compress(middleware.DefaultCompressMinSize)
 
-	// Create HTTP server with logging middleware
+	// Tracing is a no-op unless an OTLP endpoint is configured
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// Create HTTP server with request ID, client IP, tracing, logging, compression, panic
+	// recovery, CSP, header hardening, maintenance mode and body limit middleware.
+	// Logging sits outside compression so it records the bytes actually sent,
+	// and recovery inside it so a recovered panic is logged as a 500.
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%s", port),
-		Handler:      loggingMiddleware(mux),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              fmt.Sprintf(":%s", port),
+		Handler:           requestID(clientIP(middleware.Tracing(loggingMiddleware(compress(middleware.Recover(csp(middleware.SanitizeHeaders(maintenanceMode.Wrap(bodyLimit.Wrap(mux)))))))))),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	// With autocert, certificates are fetched on first use and cached in the
+	// data directory. Port 80 answers ACME HTTP-01 challenges and redirects
+	// everything else to HTTPS.
+	var challengeServer *http.Server
+	if len(autocertDomains) > 0 {
+		certManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(autocertDomains...),
+			Cache:      autocert.DirCache(filepath.Join(dataDir, "autocert")),
+		}
+		server.TLSConfig = certManager.TLSConfig()
+		challengeServer = &http.Server{
+			Addr:              ":80",
+			Handler:           certManager.HTTPHandler(nil),
+			ReadHeaderTimeout: readHeaderTimeout,
+			ReadTimeout:       readTimeout,
+			WriteTimeout:      writeTimeout,
+		}
+		go func() {
+			if err := challengeServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("ACME challenge server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
 	}
 
+	maintenanceCtx, stopMaintenance := context.WithCancel(context.Background())
+	maintenance.Start(maintenanceCtx)
+
 	// Start server in goroutine
 	go func() {
-		serverURL := fmt.Sprintf("http://localhost:%s/", port)
+		scheme := "http"
+		if useTLS {
+			scheme = "https"
+		}
+		serverURL := fmt.Sprintf("%s://localhost:%s/", scheme, port)
 		slog.Info("Trifle server starting", "url", serverURL)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+
+		var err error
+		switch {
+		case len(autocertDomains) > 0:
+			err = server.ListenAndServeTLS("", "") // Certificates come from TLSConfig
+		case tlsCertFile != "":
+			err = server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+		default:
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("Server failed", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	// How long to keep serving after readiness fails on shutdown. Only needed
+	// behind a load balancer, so it defaults to zero outside production.
+	var drainDelay time.Duration
+	if isProduction {
+		drainDelay = 5 * time.Second
+	}
+	if delayStr := os.Getenv("SHUTDOWN_DRAIN_DELAY"); delayStr != "" {
+		delay, err := time.ParseDuration(delayStr)
+		if err != nil || delay < 0 {
+			slog.Error("Invalid SHUTDOWN_DRAIN_DELAY", "value", delayStr, "error", err)
+			os.Exit(1)
+		}
+		drainDelay = delay
+	}
+
+	// SIGHUP reloads OAuth credentials, e.g. after rotating the client secret
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			if err := oauthConfig.Reload(); err != nil {
+				slog.Error("OAuth credential reload failed", "error", err)
+			}
+		}
+	}()
+
+	// SIGUSR1 steps the log level through debug, info, warn and error
+	usr1Ch := make(chan os.Signal, 1)
+	signal.Notify(usr1Ch, syscall.SIGUSR1)
+	go func() {
+		for range usr1Ch {
+			middleware.CycleLogLevel(context.Background(), logLevel)
+		}
+	}()
+
 	// Wait for interrupt signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -155,27 +640,341 @@ func main() {
 
 	slog.Info("Shutting down server...")
 
-	// Graceful shutdown
+	// Fail readiness and give the load balancer time to stop sending traffic
+	probes.shuttingDown.Store(true)
+	if drainDelay > 0 {
+		slog.Info("Draining before shutdown", "delay", drainDelay)
+		time.Sleep(drainDelay)
+	}
+
+	// Graceful shutdown. Long-lived streams never finish by themselves, so
+	// they're told to close first; anything still open when the timeout runs
+	// out is cut off.
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	if err := kvHandlers.CloseStreams(ctx); err != nil {
+		slog.Warn("Streams still open at shutdown timeout", "error", err)
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		slog.Error("Server shutdown error", "error", err)
+		if err := server.Close(); err != nil {
+			slog.Error("Failed to force-close connections", "error", err)
+		}
+	}
+	if challengeServer != nil {
+		if err := challengeServer.Shutdown(ctx); err != nil {
+			slog.Error("ACME challenge server shutdown error", "error", err)
+		}
+	}
+	stopMaintenance()
+	maintenance.Wait()
+	if err := auditLog.Close(); err != nil {
+		slog.Error("Failed to close audit log", "error", err)
+	}
+	if webhooks != nil {
+		if err := webhooks.Close(ctx); err != nil {
+			slog.Warn("Webhook deliveries still pending at shutdown timeout", "error", err)
+		}
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
 
 	slog.Info("Server stopped")
 }
 
-// loggingMiddleware logs HTTP requests
+// durationEnv returns the duration in the named environment variable, or def
+// if it is unset. Invalid or non-positive durations are fatal.
+func durationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Error("Invalid "+name, "value", value, "error", err)
+		os.Exit(1)
+	}
+	return d
+}
+
+// sizeEnv returns the non-negative integer in environment variable name, or
+// def if it's unset. An invalid value is fatal.
+func sizeEnv(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		slog.Error("Invalid "+name, "value", value, "error", err)
+		os.Exit(1)
+	}
+	return n
+}
+
+// rateLimitEnv returns the rate limit set by environment variables rateName,
+// requests per second, and burstName, which defaults to the rate rounded
+// up. ok is false if rateName is unset. An invalid value is fatal.
+func rateLimitEnv(rateName, burstName string) (limit kv.RateLimit, ok bool) {
+	rateStr := os.Getenv(rateName)
+	if rateStr == "" {
+		return kv.RateLimit{}, false
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate <= 0 {
+		slog.Error("Invalid "+rateName, "value", rateStr, "error", err)
+		os.Exit(1)
+	}
+	burst := int(math.Ceil(rate))
+	if burstStr := os.Getenv(burstName); burstStr != "" {
+		burst, err = strconv.Atoi(burstStr)
+		if err != nil || burst <= 0 {
+			slog.Error("Invalid "+burstName, "value", burstStr, "error", err)
+			os.Exit(1)
+		}
+	}
+	return kv.RateLimit{Rate: rate, Burst: burst}, true
+}
+
+// routeTimeouts returns withTimeout, which gives a handler d to produce its
+// response. Past that the client gets a 503 and the request's context is
+// cancelled. The response is buffered until the handler finishes (see
+// http.TimeoutHandler), so it can't stream; once done it has sendTimeout
+// to reach the client, in place of the server's write timeout.
+func routeTimeouts(sendTimeout time.Duration) func(time.Duration, http.HandlerFunc) http.HandlerFunc {
+	return func(d time.Duration, h http.HandlerFunc) http.HandlerFunc {
+		timeout := http.TimeoutHandler(h, d, fmt.Sprintf("Request timed out: the server took longer than %s to respond\n", d))
+		return func(w http.ResponseWriter, r *http.Request) {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + sendTimeout)); err != nil {
+				slog.DebugContext(r.Context(), "Failed to extend write deadline", "error", err)
+			}
+			timeout.ServeHTTP(w, r)
+		}
+	}
+}
+
+// routeDeadlines returns withDeadline, for routes that stream request or
+// response bodies, which withTimeout would buffer. The handler's context is
+// cancelled after d, stopping work that checks it, but a transfer in progress
+// isn't cut off: each read of the body pushes the read deadline readTimeout
+// ahead, and each write of the response pushes the write deadline
+// sendTimeout ahead, so a transfer takes as long as it keeps moving.
+func routeDeadlines(readTimeout, sendTimeout time.Duration) func(time.Duration, http.HandlerFunc) http.HandlerFunc {
+	return func(d time.Duration, h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			if err := rc.SetWriteDeadline(time.Now().Add(d + sendTimeout)); err != nil {
+				slog.DebugContext(r.Context(), "Failed to extend write deadline", "error", err)
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &deadlineReader{ReadCloser: r.Body, rc: rc, timeout: readTimeout}
+			}
+			h(&deadlineWriter{ResponseWriter: w, rc: rc, timeout: sendTimeout}, r)
+		}
+	}
+}
+
+// deadlineReader extends the connection's read deadline before each read
+type deadlineReader struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	r.rc.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.ReadCloser.Read(p)
+}
+
+// deadlineWriter extends the connection's write deadline before each write
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set; the exporter reads the other
+// standard OTEL_* variables itself. Otherwise the global tracer provider stays
+// a no-op. The returned function flushes pending spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "trifle")),
+		resource.WithFromEnv(), // OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES win
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	slog.Info("Tracing enabled")
+	return provider.Shutdown, nil
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// newLogger creates the process logger. format is "text" (the default) or
+// "json"; level is a slog level name such as "debug" or "warn" (default "info").
+// The logger reads its level from levelVar, which is set to level, so it can
+// be changed later.
+func newLogger(format, level string, levelVar *slog.LevelVar) (*slog.Logger, error) {
+	levelVar.Set(slog.LevelInfo)
+	if level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL: %w", err)
+		}
+		levelVar.Set(l)
+	}
+	opts := &slog.HandlerOptions{Level: levelVar}
+
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		return nil, fmt.Errorf("LOG_FORMAT must be text or json, got %q", format)
+	}
+
+	// Lines logged with a request's context carry its request_id
+	return slog.New(middleware.NewRequestIDLogHandler(handler)), nil
+}
+
+// statusRecorder captures the status code and body size of a response for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to flush)
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// loggingMiddleware logs HTTP requests with their status and response size.
+// It runs inside middleware.RequestID, so the logger adds the request_id.
+// Health probes are logged at debug level so frequent load balancer checks
+// don't flood the logs.
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
 		duration := time.Since(start)
-		slog.Info("HTTP request",
+
+		// A handler that writes nothing still sends an implicit 200
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			level = slog.LevelDebug
+		}
+		slog.Log(r.Context(), level, "HTTP request",
 			"method", r.Method,
 			"path", r.URL.Path,
+			"status", status,
+			"bytes", rec.bytes,
+			"client_ip", middleware.ClientIPFromContext(r.Context()),
 			"duration", duration,
 		)
 	})
 }
+
+// healthProbes serves /healthz and /readyz
+type healthProbes struct {
+	store        *kv.Store
+	allowlist    *auth.Allowlist
+	maintenance  *middleware.Maintenance
+	shuttingDown atomic.Bool
+}
+
+// handleLiveness reports that the process is up
+func (p *healthProbes) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadiness reports whether the server should receive traffic
+func (p *healthProbes) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	if p.shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if p.allowlist == nil {
+		http.Error(w, "allowlist not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	mode := p.maintenance.Mode()
+	if mode == middleware.MaintenanceDown {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	if err := p.store.CheckWritable(); err != nil {
+		slog.Warn("Readiness check failed", "error", err)
+		http.Error(w, "storage not writable", http.StatusServiceUnavailable)
+		return
+	}
+	if mode == middleware.MaintenanceReadOnly {
+		// Still ready: reads are served
+		w.Write([]byte("ok (read-only for maintenance)\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
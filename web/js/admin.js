@@ -0,0 +1,145 @@
+/**
+ * Admin Dashboard
+ * Shows server state, storage use, recent writes and maintenance tasks from the admin JSON endpoints
+ */
+
+import { showError } from './notifications.js';
+
+// How many audit entries to show
+const AUDIT_LIMIT = 50;
+
+/**
+ * Fetch JSON from an admin endpoint
+ */
+async function getJSON(url) {
+    const response = await fetch(url, { credentials: 'same-origin', cache: 'no-store' });
+    if (!response.ok) {
+        throw new Error(`${url}: ${response.status} ${response.statusText}`);
+    }
+    return response.json();
+}
+
+/**
+ * Format a byte count for display, e.g. 1536 -> "1.5 KB"
+ */
+function formatBytes(bytes) {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let value = bytes;
+    let unit = 0;
+    while (value >= 1024 && unit < units.length - 1) {
+        value /= 1024;
+        unit++;
+    }
+    return `${unit === 0 ? value : value.toFixed(1)} ${units[unit]}`;
+}
+
+/**
+ * Format an RFC 3339 time in the browser's locale, or a dash if unset
+ */
+function formatTime(time) {
+    return time ? new Date(time).toLocaleString() : '—';
+}
+
+/**
+ * Replace a table body's rows. Each row is a list of cells: strings, or
+ * {text, className} objects. Text is never parsed as HTML.
+ */
+function fillTable(tbodyId, rows, columns, emptyMessage) {
+    const tbody = document.getElementById(tbodyId);
+    tbody.replaceChildren();
+
+    if (rows.length === 0) {
+        const tr = document.createElement('tr');
+        const td = document.createElement('td');
+        td.colSpan = columns;
+        td.className = 'admin-empty';
+        td.textContent = emptyMessage;
+        tr.appendChild(td);
+        tbody.appendChild(tr);
+        return;
+    }
+
+    for (const row of rows) {
+        const tr = document.createElement('tr');
+        for (const cell of row) {
+            const td = document.createElement('td');
+            if (typeof cell === 'object') {
+                td.textContent = cell.text;
+                td.className = cell.className;
+            } else {
+                td.textContent = cell;
+            }
+            tr.appendChild(td);
+        }
+        tbody.appendChild(tr);
+    }
+}
+
+/**
+ * Show maintenance mode and log level
+ */
+async function loadServerState() {
+    const [maintenance, logLevel] = await Promise.all([
+        getJSON('/admin/maintenance'),
+        getJSON('/admin/loglevel')
+    ]);
+    document.getElementById('maintenanceMode').textContent = maintenance.mode;
+    document.getElementById('logLevel').textContent = logLevel.level;
+}
+
+/**
+ * Show storage totals and each user's share
+ */
+async function loadStorage() {
+    const stats = await getJSON('/kvstats');
+    document.getElementById('storageSummary').textContent =
+        `${stats.total.keys} keys, ${formatBytes(stats.total.bytes)} in total ` +
+        `(${formatBytes(stats.shared.bytes)} in shared files), as of ${formatTime(stats.computedAt)}.`;
+    fillTable('usersTable', stats.users.map((user) => [
+        user.user,
+        { text: String(user.keys), className: 'number' },
+        { text: formatBytes(user.bytes), className: 'number' }
+    ]), 3, 'No users have stored anything yet.');
+}
+
+/**
+ * Show the most recent audit log entries, newest first
+ */
+async function loadAudit() {
+    const entries = await getJSON(`/kvaudit?limit=${AUDIT_LIMIT}`);
+    fillTable('auditTable', entries.reverse().map((entry) => [
+        formatTime(entry.time),
+        entry.op,
+        entry.user || '—',
+        { text: entry.key, className: 'key' }
+    ]), 4, 'No recent activity.');
+}
+
+/**
+ * Show when each scheduled maintenance task last ran
+ */
+async function loadTasks() {
+    const { tasks } = await getJSON('/admin/stats');
+    fillTable('tasksTable', tasks.map((task) => [
+        task.running ? `${task.name} (running)` : task.name,
+        { text: String(task.runs), className: 'number' },
+        formatTime(task.lastRun),
+        task.lastError || ''
+    ]), 4, 'No maintenance tasks.');
+}
+
+/**
+ * Load every section, reporting failures without blocking the others
+ */
+async function refresh() {
+    const results = await Promise.allSettled([loadServerState(), loadStorage(), loadAudit(), loadTasks()]);
+    for (const result of results) {
+        if (result.status === 'rejected') {
+            console.error('Failed to load admin data:', result.reason);
+            showError(`Failed to load admin data: ${result.reason.message}`);
+        }
+    }
+}
+
+document.getElementById('refreshBtn').addEventListener('click', refresh);
+refresh();
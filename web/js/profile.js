@@ -54,6 +54,7 @@ async function init() {
         const urlParams = new URLSearchParams(window.location.search);
         const errorMsg = urlParams.get('error');
         const justLoggedIn = urlParams.get('logged_in');
+        const totpRequired = urlParams.get('totp') === 'required';
 
         if (errorMsg) {
             showError(errorMsg);
@@ -73,6 +74,12 @@ async function init() {
         // Set up event listeners
         setupEventListeners();
 
+        // Logged in with Google, but two-factor auth needs a code first
+        if (totpRequired) {
+            window.history.replaceState({}, '', '/profile.html');
+            showTotpPrompt();
+        }
+
         // Auto-sync if just logged in
         if (justLoggedIn === 'true') {
             // Clean up URL first
@@ -231,6 +238,44 @@ async function handleLoginSync() {
     }
 }
 
+/**
+ * Show the two-factor code prompt, finishing login once the code is accepted
+ */
+function showTotpPrompt() {
+    const form = document.getElementById('totpPrompt');
+    const input = document.getElementById('totpCode');
+    const btn = document.getElementById('totpSubmitBtn');
+    form.style.display = '';
+    input.focus();
+
+    form.addEventListener('submit', async (e) => {
+        e.preventDefault();
+        btn.disabled = true;
+        try {
+            const response = await fetch('/auth/totp/verify', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ code: input.value.trim() }),
+            });
+            if (response.ok) {
+                window.location.href = '/profile.html?logged_in=true';
+                return;
+            }
+            const message = (await response.text()).trim();
+            showError(response.status === 401 && message === 'Incorrect code'
+                ? 'Incorrect code. Please try again.'
+                : message || 'Verification failed. Please log in again.');
+            input.value = '';
+            input.focus();
+        } catch (error) {
+            console.error('TOTP verification failed:', error);
+            showError('Verification failed. Please try again.');
+        } finally {
+            btn.disabled = false;
+        }
+    });
+}
+
 /**
  * Handle logout
  */
@@ -48,11 +48,45 @@ async function initWorker() {
 // Counter for unique snippet IDs
 let snippetIdCounter = 0;
 
+// Snippets on a `cumulative: true` page, in page order
+const cumulativeSnippets = [];
+
+// Parse a data-highlight value like "3,5-7" into line numbers
+function parseLineRanges(spec) {
+    const lines = [];
+    for (const part of spec.split(',')) {
+        const [start, end = start] = part.split('-').map(Number);
+        if (!Number.isInteger(start) || !Number.isInteger(end)) continue;
+        for (let line = start; line <= end; line++) {
+            lines.push(line);
+        }
+    }
+    return lines;
+}
+
+// Copy text to the clipboard, flashing a check mark on the button
+function copyWithFeedback(button, text) {
+    navigator.clipboard.writeText(text).then(() => {
+        const originalText = button.textContent;
+        button.textContent = '✓';
+        setTimeout(() => {
+            button.textContent = originalText;
+        }, 1000);
+    });
+}
+
 class CodeSnippet {
     constructor(container) {
         this.container = container;
         this.snippetId = snippetIdCounter++;  // Unique ID for this snippet
-        this.mode = container.dataset.mode; // 'text' or 'graphics'
+        this.mode = container.dataset.mode; // 'text', 'graphics' or 'js'
+        this.setup = container.dataset.setup || ''; // Hidden code run before the visible code
+        this.cumulative = container.dataset.cumulative === 'true'; // Also run every earlier snippet
+        this.index = Number(container.dataset.index || 0);
+        this.highlight = parseLineRanges(container.dataset.highlight || ''); // 1-based lines to highlight
+        this.readOnly = container.dataset.readonly === 'true'; // Runnable and copyable, but not editable
+        this.session = container.dataset.session || null; // Python namespace shared with same-named snippets
+        this.trifleName = container.dataset.trifleName || null; // Author's suggested name for Make Trifle
         this.codeDiv = container.querySelector('.snippet-code');
         this.outputDiv = container.querySelector('.snippet-output');
         this.runBtn = container.querySelector('.run-btn');
@@ -87,7 +121,7 @@ class CodeSnippet {
         // Create Ace editor
         this.editor = ace.edit(this.codeDiv);
         this.editor.setTheme('ace/theme/monokai');
-        this.editor.session.setMode('ace/mode/python');
+        this.editor.session.setMode(this.mode === 'js' ? 'ace/mode/javascript' : 'ace/mode/python');
         this.editor.setOptions({
             fontSize: '13px',
             showPrintMargin: false,
@@ -97,6 +131,12 @@ class CodeSnippet {
             minLines: 3,
         });
         this.editor.setValue(code, -1);
+        this.highlightLines();
+        if (this.readOnly) {
+            this.editor.setReadOnly(true);
+            this.editor.textInput.getElement().setAttribute('aria-readonly', 'true');
+            this.container.classList.add('snippet-readonly');
+        }
 
         // Set up output area
         this.setupOutput();
@@ -112,6 +152,11 @@ class CodeSnippet {
         this.copyBtn.addEventListener('click', () => this.copyCode());
         this.makeTrifleBtn.addEventListener('click', () => this.makeTrifle());
 
+        // Trifles are Python projects, so JavaScript snippets can't become one
+        if (this.mode === 'js') {
+            this.makeTrifleBtn.style.display = 'none';
+        }
+
         // Cmd/Ctrl+Enter to run
         this.editor.commands.addCommand({
             name: 'run',
@@ -120,6 +165,20 @@ class CodeSnippet {
         });
     }
 
+    // Mark the lines named by data-highlight, in the text and the gutter.
+    // The markers stay put on their rows as the reader edits.
+    highlightLines() {
+        if (this.highlight.length === 0) return;
+        const Range = ace.require('ace/range').Range;
+        const session = this.editor.session;
+        for (const line of this.highlight) {
+            const row = line - 1;
+            if (row >= session.getLength()) continue;
+            session.addMarker(new Range(row, 0, row, 1), 'snippet-highlight-line', 'fullLine');
+            session.addGutterDecoration(row, 'snippet-highlight-gutter');
+        }
+    }
+
     async loadAce() {
         // Load Ace editor if not already loaded
         if (window.ace) return;
@@ -170,6 +229,12 @@ class CodeSnippet {
     async run() {
         if (this.isRunning) return;
 
+        // JavaScript runs directly in the page, no Pyodide needed
+        if (this.mode === 'js') {
+            this.runJavaScript();
+            return;
+        }
+
         // Initialize worker if needed
         if (!sharedWorker) {
             await initWorker();
@@ -217,8 +282,8 @@ class CodeSnippet {
         // Show output area
         this.outputDiv.style.display = 'block';
 
-        // Get code
-        const code = this.editor.getValue();
+        // Get code (hidden setup first, then what the learner sees)
+        const code = this.fullCode();
 
         // Load code as single file
         sharedWorker.postMessage({
@@ -232,9 +297,30 @@ class CodeSnippet {
         sharedWorker.postMessage({
             type: 'run',
             mainFile: 'snippet.py',
+            session: this.session,
         });
     }
 
+    runJavaScript() {
+        this.terminal.clear();
+        this.outputDiv.style.display = 'block';
+
+        // Route console output to the snippet terminal
+        const format = (args) => args.map(a => typeof a === 'string' ? a : JSON.stringify(a)).join(' ') + '\n';
+        const snippetConsole = {
+            log: (...args) => this.terminal.write(format(args)),
+            info: (...args) => this.terminal.write(format(args)),
+            warn: (...args) => this.terminal.write(format(args), 'error'),
+            error: (...args) => this.terminal.write(format(args), 'error'),
+        };
+
+        try {
+            new Function('console', this.fullCode())(snippetConsole);
+        } catch (error) {
+            this.terminal.write(`${error.name}: ${error.message}\n`, 'error');
+        }
+    }
+
     stop() {
         if (!this.isRunning) return;
 
@@ -407,24 +493,37 @@ class CodeSnippet {
     }
 
 
+    // Visible editor code prefixed with any hidden setup, so it runs standalone.
+    // On cumulative pages, earlier snippets in the same language come first.
+    fullCode() {
+        return this.priorCode() + this.setup + this.editor.getValue();
+    }
+
+    priorCode() {
+        if (!this.cumulative) {
+            return '';
+        }
+        const isJS = this.mode === 'js';
+        return cumulativeSnippets
+            .filter(s => s.index < this.index && (s.mode === 'js') === isJS)
+            .map(s => {
+                const code = s.setup + (s.editor ? s.editor.getValue() : s.codeDiv.dataset.code);
+                return code.endsWith('\n') ? code : code + '\n';
+            })
+            .join('');
+    }
+
     copyCode() {
-        const code = this.editor.getValue();
-        navigator.clipboard.writeText(code).then(() => {
-            const originalText = this.copyBtn.textContent;
-            this.copyBtn.textContent = '✓';
-            setTimeout(() => {
-                this.copyBtn.textContent = originalText;
-            }, 1000);
-        });
+        copyWithFeedback(this.copyBtn, this.fullCode());
     }
 
     async makeTrifle() {
-        const code = this.editor.getValue();
+        const code = this.fullCode();
 
-        // Generate a default name based on the page title and snippet position
+        // Use the author's suggested name, or generate one from the page title and snippet position
         const pageTitle = document.querySelector('h1')?.textContent || 'Example';
         const snippetIndex = this.snippetId + 1;
-        const defaultName = `${pageTitle} Example ${snippetIndex}`;
+        const defaultName = this.trifleName || `${pageTitle} Example ${snippetIndex}`;
 
         // Show modal to get name and description
         const modal = document.createElement('div');
@@ -435,7 +534,7 @@ class CodeSnippet {
                 <form id="createTrifleForm">
                     <div class="form-group">
                         <label for="trifleName">Name:</label>
-                        <input type="text" id="trifleName" required autofocus value="${defaultName}">
+                        <input type="text" id="trifleName" required autofocus>
                     </div>
                     <div class="form-group">
                         <label for="trifleDesc">Description (optional):</label>
@@ -455,6 +554,7 @@ class CodeSnippet {
         const cancelBtn = modal.querySelector('#cancelBtn');
         const nameInput = modal.querySelector('#trifleName');
 
+        nameInput.value = defaultName;  // Set as a property, never parsed as HTML
         nameInput.select();  // Select the text so user can type to replace
 
         // Escape to cancel
@@ -518,7 +618,16 @@ class CodeSnippet {
 function initSnippets() {
     const snippets = document.querySelectorAll('.runnable-snippet');
     snippets.forEach(container => {
-        new CodeSnippet(container);
+        const snippet = new CodeSnippet(container);
+        if (snippet.cumulative) {
+            cumulativeSnippets.push(snippet);
+        }
+    });
+
+    // Copy-only snippets just need their copy button wired up
+    document.querySelectorAll('.copy-snippet').forEach(container => {
+        const copyBtn = container.querySelector('.copy-btn');
+        copyBtn.addEventListener('click', () => copyWithFeedback(copyBtn, container.dataset.code));
     });
 }
 
@@ -142,8 +142,9 @@ async function handleLoadFiles({ files, ownerId, trifleId, availableTrifles }) {
     }
 }
 
-// Run Python code
-async function handleRun({ mainFile }) {
+// Run Python code. Code run with a session name shares one namespace with
+// everything else run under that name; otherwise it runs in the globals.
+async function handleRun({ mainFile, session = null }) {
     if (isRunning) {
         send('error', { message: 'Code is already running' });
         return;
@@ -160,15 +161,25 @@ if hasattr(turtle, '_reset_turtle_counter'):
 `);
 
         // Execute main.py
+        pyodide.globals.set('_trifle_session', session);
         await pyodide.runPythonAsync(`
 import traceback
 import sys
 
+# A session starts as a copy of the globals, so it has input, etc., and
+# then keeps its own definitions between runs
+if '_trifle_sessions' not in globals():
+    _trifle_sessions = {}
+if _trifle_session is None:
+    _trifle_namespace = globals()
+else:
+    _trifle_namespace = _trifle_sessions.setdefault(_trifle_session, dict(globals()))
+
 try:
     with open('${mainFile}', 'r') as f:
         # Execute in global namespace so user code has access to input, etc.
         code = f.read()
-        exec(code, globals())
+        exec(code, _trifle_namespace)
 except Exception as e:
     traceback.print_exc()
 finally:
@@ -1,5 +1,5 @@
 // Trifling Service Worker - Enables offline functionality
-const CACHE_VERSION = 'v164';
+const CACHE_VERSION = 'v188';
 const CACHE_NAME = `trifling-${CACHE_VERSION}`;
 
 // Resources to cache on install
@@ -27,14 +27,15 @@ const STATIC_CACHE = [
     '/js/turtle.js',
     '/js/terminal.js',
     '/js/sync-kv.js',
-    '/js/snippet-runner.js',
-    '/static/docs/intro.html',
-    '/static/docs/turtle.html',
-    '/static/docs/canvas.html',
-    '/static/docs/imports.html'
+    '/js/snippet-runner.js'
 ];
 
-// CDN resources to cache (Ace Editor and Pyodide)
+// Generated by docgen: every doc page and the scripts and stylesheets it
+// loads, with content hashes, see precacheDocs
+const DOCS_MANIFEST = '/static/docs/asset-manifest.json';
+
+// CDN resources to cache (Ace Editor and Pyodide). KaTeX isn't here: only doc
+// pages with math load it, and it's cached when they're first visited.
 const CDN_CACHE = [
     'https://cdnjs.cloudflare.com/ajax/libs/ace/1.32.2/ace.min.js',
     'https://cdnjs.cloudflare.com/ajax/libs/ace/1.32.2/mode-python.min.js',
@@ -46,6 +47,13 @@ const CDN_CACHE = [
     'https://cdn.jsdelivr.net/pyodide/v0.28.3/full/pyodide-lock.json'
 ];
 
+// Paths that always go to the network: the API; every KV route, including
+// /kv/limits, /kvimports/, /kvgraph, /kvexport and the admin's /kvstats and
+// /kvaudit; sign-in and the sessions list, so revoked sessions disappear;
+// publicly shared trifles, so unsharing takes effect immediately; the admin
+// dashboard; and the health checks
+const NETWORK_ONLY = ['/api/', '/kv', '/auth/', '/public/', '/admin', '/healthz', '/readyz'];
+
 // Install event - cache all resources
 self.addEventListener('install', (event) => {
     console.log('[Service Worker] Installing...');
@@ -64,7 +72,12 @@ self.addEventListener('install', (event) => {
                 })
             );
 
-            return Promise.all([staticPromise, ...cdnPromises]);
+            // A missing manifest mustn't stop the app working offline
+            const docsPromise = precacheDocs().catch((err) => {
+                console.warn('[Service Worker] Failed to precache docs:', err);
+            });
+
+            return Promise.all([staticPromise, ...cdnPromises, docsPromise]);
         }).then(() => {
             console.log('[Service Worker] Installation complete');
             // Skip waiting to activate immediately
@@ -73,6 +86,52 @@ self.addEventListener('install', (event) => {
     );
 });
 
+// Precache what the docs manifest lists, fetching only what's new or whose
+// hash changed since the manifest cached last time, and dropping what's gone.
+// The manifest is only cached once everything in it is, so an interrupted run
+// is finished next time.
+let docsPrecache = null;
+function precacheDocs() {
+    if (!docsPrecache) {
+        docsPrecache = updateDocsCache().finally(() => {
+            docsPrecache = null;
+        });
+    }
+    return docsPrecache;
+}
+
+async function updateDocsCache() {
+    const response = await fetch(DOCS_MANIFEST, { cache: 'no-store' });
+    if (!response.ok) {
+        throw new Error(`${DOCS_MANIFEST}: ${response.status}`);
+    }
+    const manifest = await response.clone().json();
+
+    const cache = await caches.open(CACHE_NAME);
+    const previous = await cache.match(DOCS_MANIFEST).then((cached) => cached ? cached.json() : null);
+    if (previous && previous.version === manifest.version) {
+        return;
+    }
+
+    const hashes = (m) => new Map([...m.pages, ...m.assets].map((file) => [file.url, file.hash]));
+    const wanted = hashes(manifest);
+    const cached = previous ? hashes(previous) : new Map();
+
+    await Promise.all([...wanted].map(async ([url, hash]) => {
+        if (hash && cached.get(url) === hash && await cache.match(url)) {
+            return;
+        }
+        // Bypass the HTTP cache, which may still hold the old version
+        await cache.add(new Request(url, { cache: 'reload' }));
+    }));
+    await Promise.all([...cached.keys()]
+        .filter((url) => !wanted.has(url) && !STATIC_CACHE.includes(url))
+        .map((url) => cache.delete(url)));
+
+    await cache.put(DOCS_MANIFEST, response);
+    console.log(`[Service Worker] Precached docs version ${manifest.version}`);
+}
+
 // Activate event - clean up old caches
 self.addEventListener('activate', (event) => {
     console.log('[Service Worker] Activating...');
@@ -104,8 +163,8 @@ self.addEventListener('fetch', (event) => {
 
     const url = new URL(event.request.url);
 
-    // NEVER cache API endpoints - they need fresh data
-    if (url.pathname.startsWith('/api/')) {
+    // NEVER cache dynamic endpoints - they need fresh data
+    if (NETWORK_ONLY.some((prefix) => url.pathname.startsWith(prefix))) {
         return; // Let it go to network
     }
 
@@ -175,4 +234,10 @@ self.addEventListener('message', (event) => {
     if (event.data && event.data.type === 'SKIP_WAITING') {
         self.skipWaiting();
     }
+    // Sent by doc pages, so docs added since install are cached offline
+    if (event.data && event.data.type === 'PRECACHE_DOCS') {
+        event.waitUntil(precacheDocs().catch((err) => {
+            console.warn('[Service Worker] Failed to precache docs:', err);
+        }));
+    }
 });